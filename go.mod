@@ -2,7 +2,10 @@ module claude-code-lb
 
 go 1.23.0
 
-require github.com/gin-gonic/gin v1.9.1
+require (
+	github.com/gin-gonic/gin v1.9.1
+	golang.org/x/sync v0.16.0
+)
 
 require (
 	github.com/bytedance/sonic v1.9.1 // indirect
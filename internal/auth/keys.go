@@ -0,0 +1,35 @@
+package auth
+
+import "sync/atomic"
+
+// KeySet 允许访问的客户端密钥集合，可在运行时原子替换（重新加载配置时无需重启，也不会中断在途连接）
+type KeySet struct {
+	keys atomic.Pointer[map[string]struct{}]
+}
+
+// NewKeySet 创建包含 keys 的密钥集合
+func NewKeySet(keys []string) *KeySet {
+	s := &KeySet{}
+	s.Store(keys)
+	return s
+}
+
+// Store 用 keys 整体替换当前的密钥集合，之后的请求立即使用新集合
+func (s *KeySet) Store(keys []string) {
+	set := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		set[key] = struct{}{}
+	}
+	s.keys.Store(&set)
+}
+
+// Contains 判断 key 是否在当前的密钥集合中
+func (s *KeySet) Contains(key string) bool {
+	_, ok := (*s.keys.Load())[key]
+	return ok
+}
+
+// Len 返回当前的密钥数量
+func (s *KeySet) Len() int {
+	return len(*s.keys.Load())
+}
@@ -1,7 +1,6 @@
 package auth
 
 import (
-	"slices"
 	"strings"
 
 	"claude-code-lb/internal/logger"
@@ -25,8 +24,27 @@ func max(a, b int) int {
 	return b
 }
 
-// Middleware 鉴权中间件
+// 日志中显示密钥时保留的首尾字符数，以及完整隐藏密钥的最小长度
+const (
+	redactVisibleChars = 4
+	redactMinLength    = 12
+)
+
+// RedactKey 返回用于日志的脱敏密钥：只显示首尾各 4 个字符，短于 12 个字符的密钥完全隐藏
+func RedactKey(key string) string {
+	if len(key) < redactMinLength {
+		return "****"
+	}
+	return key[:redactVisibleChars] + "..." + key[len(key)-redactVisibleChars:]
+}
+
+// Middleware 鉴权中间件，允许的密钥固定为 config.AuthKeys
 func Middleware(config types.Config) gin.HandlerFunc {
+	return MiddlewareWithKeys(config, NewKeySet(config.AuthKeys))
+}
+
+// MiddlewareWithKeys 使用共享密钥集合的鉴权中间件，keys 被替换后立即对新请求生效
+func MiddlewareWithKeys(config types.Config, keys *KeySet) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// 如果未启用鉴权，直接通过
 		if !config.Auth {
@@ -34,41 +52,55 @@ func Middleware(config types.Config) gin.HandlerFunc {
 			return
 		}
 
-		// 检查 Authorization header
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			logger.Auth(false, "Missing Authorization header from %s", c.ClientIP())
-			c.JSON(401, gin.H{"error": "Missing Authorization header"})
-			c.Abort()
+		if !authorize(c, keys, "API key") {
 			return
 		}
+		c.Next()
+	}
+}
 
-		// 提取 Bearer token
-		const bearerPrefix = "Bearer "
-		if !strings.HasPrefix(authHeader, bearerPrefix) {
-			logger.Auth(false, "Invalid header format from %s", c.ClientIP())
-			c.JSON(401, gin.H{"error": "Invalid Authorization header format"})
-			c.Abort()
+// AdminMiddleware 管理接口鉴权中间件，只接受 admin_keys 中的密钥（与 auth 是否启用无关，客户端密钥无效）
+func AdminMiddleware(keys []string) gin.HandlerFunc {
+	adminKeys := NewKeySet(keys)
+	return func(c *gin.Context) {
+		if !authorize(c, adminKeys, "admin key") {
 			return
 		}
+		c.Next()
+	}
+}
 
-		token := authHeader[len(bearerPrefix):]
+// authorize 校验 Authorization 头中的 Bearer 密钥是否在 keys 中，校验失败时返回 401 并中止请求
+// kind 为日志和错误信息中的密钥类型（如 "API key"、"admin key"）
+func authorize(c *gin.Context, keys *KeySet, kind string) bool {
+	// 检查 Authorization header
+	authHeader := c.GetHeader("Authorization")
+	if authHeader == "" {
+		logger.Auth(false, "Missing Authorization header from %s", c.ClientIP())
+		c.JSON(401, gin.H{"error": "Missing Authorization header"})
+		c.Abort()
+		return false
+	}
 
-		// 检查 token 是否在允许的列表中
-		if !slices.Contains(config.AuthKeys, token) {
-			logger.Auth(false, "Invalid API key %s...%s from %s",
-				token[:min(8, len(token))],
-				token[max(0, len(token)-8):],
-				c.ClientIP())
-			c.JSON(401, gin.H{"error": "Invalid API key"})
-			c.Abort()
-			return
-		}
+	// 提取 Bearer token
+	const bearerPrefix = "Bearer "
+	if !strings.HasPrefix(authHeader, bearerPrefix) {
+		logger.Auth(false, "Invalid header format from %s", c.ClientIP())
+		c.JSON(401, gin.H{"error": "Invalid Authorization header format"})
+		c.Abort()
+		return false
+	}
 
-		logger.Auth(true, "Valid API key %s...%s from %s",
-			token[:min(8, len(token))],
-			token[max(0, len(token)-8):],
-			c.ClientIP())
-		c.Next()
+	token := authHeader[len(bearerPrefix):]
+
+	// 检查 token 是否在允许的列表中
+	if !keys.Contains(token) {
+		logger.Auth(false, "Invalid %s %s from %s", kind, RedactKey(token), c.ClientIP())
+		c.JSON(401, gin.H{"error": "Invalid " + kind})
+		c.Abort()
+		return false
 	}
+
+	logger.Auth(true, "Valid %s %s from %s", kind, RedactKey(token), c.ClientIP())
+	return true
 }
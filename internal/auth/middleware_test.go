@@ -3,6 +3,8 @@ package auth
 import (
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 
 	"claude-code-lb/pkg/types"
@@ -270,3 +272,116 @@ func TestEmptyAuthKeys(t *testing.T) {
 		t.Errorf("Expected status 401, got %d", w.Code)
 	}
 }
+
+func TestMiddlewareWithKeysReload(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	keys := NewKeySet([]string{"old-key"})
+	router := gin.New()
+	router.Use(MiddlewareWithKeys(types.Config{Auth: true}, keys))
+	router.GET("/test", func(c *gin.Context) {
+		c.String(http.StatusOK, "success")
+	})
+
+	status := func(key string) int {
+		req, _ := http.NewRequest("GET", "/test", nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if status("old-key") != http.StatusOK || status("new-key") != http.StatusUnauthorized {
+		t.Fatal("Expected only the initial key to be accepted")
+	}
+
+	// 并发请求期间替换密钥集合（配合 -race 检查数据竞争）
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				status("old-key")
+			}
+		}()
+	}
+	keys.Store([]string{"new-key", "another-key"})
+	wg.Wait()
+
+	// 替换后立即使用新集合：新增的密钥生效，撤销的密钥被拒绝
+	if got := status("new-key"); got != http.StatusOK {
+		t.Errorf("Expected added key to be accepted, got %d", got)
+	}
+	if got := status("old-key"); got != http.StatusUnauthorized {
+		t.Errorf("Expected revoked key to be rejected, got %d", got)
+	}
+	if keys.Len() != 2 {
+		t.Errorf("Expected 2 keys after reload, got %d", keys.Len())
+	}
+}
+
+func TestAdminMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// 客户端鉴权关闭时管理接口仍然要求管理密钥，客户端密钥无效
+	config := types.Config{Auth: false, AuthKeys: []string{"client-key"}}
+	router := gin.New()
+	router.POST("/admin/test", Middleware(config), AdminMiddleware([]string{"admin-key"}), func(c *gin.Context) {
+		c.String(http.StatusOK, "success")
+	})
+
+	tests := []struct {
+		name   string
+		header string
+		want   int
+	}{
+		{"missing header", "", http.StatusUnauthorized},
+		{"client key", "Bearer client-key", http.StatusUnauthorized},
+		{"invalid format", "admin-key", http.StatusUnauthorized},
+		{"admin key", "Bearer admin-key", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", "/admin/test", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, w.Code)
+			}
+		})
+	}
+}
+
+func TestRedactKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		key      string
+		expected string
+	}{
+		{"empty", "", "****"},
+		{"short", "sk-abc", "****"},
+		{"ten chars", "0123456789", "****"},
+		{"just below minimum", "01234567890", "****"},
+		{"minimum length", "0123456789ab", "0123...89ab"},
+		{"long", "sk-ant-REDACTED", "sk-a...wxyz"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := RedactKey(tt.key)
+			if got != tt.expected {
+				t.Errorf("RedactKey(%q) = %q, want %q", tt.key, got, tt.expected)
+			}
+
+			// 显示的字符数不超过首尾各 4 个
+			visible := len(strings.ReplaceAll(strings.ReplaceAll(got, "...", ""), "****", ""))
+			if visible > 2*redactVisibleChars {
+				t.Errorf("RedactKey(%q) reveals %d characters", tt.key, visible)
+			}
+		})
+	}
+}
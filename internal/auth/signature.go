@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"claude-code-lb/internal/logger"
+	"claude-code-lb/pkg/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultSignatureMaxSkew 请求时间戳与服务器时间允许的最大偏差
+const DefaultSignatureMaxSkew = 5 * time.Minute
+
+// 签名相关请求头
+const (
+	SignatureHeader = "X-Signature" // hex(HMAC-SHA256(secret, timestamp + body))
+	TimestampHeader = "X-Timestamp" // Unix 时间戳（秒）
+)
+
+// SignatureMiddleware HMAC 签名校验中间件（未配置 hmac_secret 时直接放行）
+// 校验通过后恢复请求体，后续处理器可以再次读取；请求体最多读取 max_request_body_bytes，超过时返回 413
+// 签名不包含随机数（nonce），截获的请求在 hmac_max_skew_seconds 时间窗口内可以被原样重放
+func SignatureMiddleware(config types.Config) gin.HandlerFunc {
+	maxSkew := DefaultSignatureMaxSkew
+	if config.HMACMaxSkewSeconds > 0 {
+		maxSkew = time.Duration(config.HMACMaxSkewSeconds) * time.Second
+	}
+
+	return func(c *gin.Context) {
+		if config.HMACSecret == "" {
+			c.Next()
+			return
+		}
+
+		timestamp := c.GetHeader(TimestampHeader)
+		signature := c.GetHeader(SignatureHeader)
+		if timestamp == "" || signature == "" {
+			rejectSignature(c, "Missing signature headers")
+			return
+		}
+
+		if !timestampWithinSkew(timestamp, time.Now(), maxSkew) {
+			rejectSignature(c, "Expired or invalid timestamp")
+			return
+		}
+
+		var body []byte
+		if c.Request.Body != nil {
+			reader := c.Request.Body
+			if config.MaxRequestBodyBytes > 0 {
+				reader = http.MaxBytesReader(c.Writer, reader, config.MaxRequestBodyBytes)
+			}
+			var err error
+			body, err = io.ReadAll(reader)
+			if tooLarge := new(http.MaxBytesError); errors.As(err, &tooLarge) {
+				logger.Auth(false, "Request body exceeds %d bytes from %s", tooLarge.Limit, c.ClientIP())
+				c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body too large"})
+				c.Abort()
+				return
+			}
+			if err != nil {
+				rejectSignature(c, "Failed to read request body")
+				return
+			}
+			c.Request.Body.Close()
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		if !validSignature(config.HMACSecret, timestamp, body, signature) {
+			rejectSignature(c, "Invalid signature")
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// rejectSignature 记录日志并以 401 拒绝请求
+func rejectSignature(c *gin.Context, reason string) {
+	logger.Auth(false, "%s from %s", reason, c.ClientIP())
+	c.JSON(401, gin.H{"error": reason})
+	c.Abort()
+}
+
+// timestampWithinSkew 判断 Unix 时间戳与当前时间的偏差是否在允许范围内
+func timestampWithinSkew(timestamp string, now time.Time, maxSkew time.Duration) bool {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	skew := now.Sub(time.Unix(seconds, 0))
+	return skew <= maxSkew && skew >= -maxSkew
+}
+
+// Sign 计算请求签名：hex(HMAC-SHA256(secret, timestamp + body))
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// validSignature 以常量时间比较签名
+func validSignature(secret string, timestamp string, body []byte, signature string) bool {
+	expected := Sign(secret, timestamp, body)
+	return hmac.Equal([]byte(expected), []byte(strings.ToLower(signature)))
+}
@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"claude-code-lb/pkg/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSignatureMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	const secret = "test-secret"
+	const body = `{"model":"claude"}`
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	expired := strconv.FormatInt(time.Now().Add(-10*time.Minute).Unix(), 10)
+
+	tests := []struct {
+		name           string
+		secret         string
+		timestamp      string
+		signature      string
+		body           string
+		maxBodyBytes   int64
+		expectedStatus int
+	}{
+		{
+			name:           "disabled without secret",
+			body:           body,
+			expectedStatus: 200,
+		},
+		{
+			name:           "valid signature",
+			secret:         secret,
+			timestamp:      now,
+			signature:      Sign(secret, now, []byte(body)),
+			body:           body,
+			expectedStatus: 200,
+		},
+		{
+			name:           "tampered body",
+			secret:         secret,
+			timestamp:      now,
+			signature:      Sign(secret, now, []byte(body)),
+			body:           `{"model":"claude-opus"}`,
+			expectedStatus: 401,
+		},
+		{
+			name:           "wrong secret",
+			secret:         secret,
+			timestamp:      now,
+			signature:      Sign("other-secret", now, []byte(body)),
+			body:           body,
+			expectedStatus: 401,
+		},
+		{
+			name:           "expired timestamp",
+			secret:         secret,
+			timestamp:      expired,
+			signature:      Sign(secret, expired, []byte(body)),
+			body:           body,
+			expectedStatus: 401,
+		},
+		{
+			name:           "body within limit",
+			secret:         secret,
+			timestamp:      now,
+			signature:      Sign(secret, now, []byte(body)),
+			body:           body,
+			maxBodyBytes:   int64(len(body)),
+			expectedStatus: 200,
+		},
+		{
+			name:           "body over limit",
+			secret:         secret,
+			timestamp:      now,
+			signature:      Sign(secret, now, []byte(body)),
+			body:           body,
+			maxBodyBytes:   int64(len(body) - 1),
+			expectedStatus: 413,
+		},
+		{
+			name:           "missing headers",
+			secret:         secret,
+			body:           body,
+			expectedStatus: 401,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := types.Config{HMACSecret: tt.secret, MaxRequestBodyBytes: tt.maxBodyBytes}
+
+			var received string
+			router := gin.New()
+			router.POST("/v1/messages", SignatureMiddleware(config), func(c *gin.Context) {
+				// 校验后请求体仍可被后续处理器读取
+				data, _ := io.ReadAll(c.Request.Body)
+				received = string(data)
+				c.Status(200)
+			})
+
+			req, _ := http.NewRequest("POST", "/v1/messages", strings.NewReader(tt.body))
+			if tt.timestamp != "" {
+				req.Header.Set(TimestampHeader, tt.timestamp)
+			}
+			if tt.signature != "" {
+				req.Header.Set(SignatureHeader, tt.signature)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if tt.expectedStatus == 200 && received != tt.body {
+				t.Errorf("Expected handler to receive body %q, got %q", tt.body, received)
+			}
+		})
+	}
+}
+
+func TestTimestampWithinSkew(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name      string
+		timestamp string
+		expected  bool
+	}{
+		{"exact", "1700000000", true},
+		{"slightly behind", "1699999800", true},
+		{"too old", "1699999000", false},
+		{"too far ahead", "1700001000", false},
+		{"not a number", "yesterday", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := timestampWithinSkew(tt.timestamp, now, DefaultSignatureMaxSkew); got != tt.expected {
+				t.Errorf("timestampWithinSkew(%q) = %t, want %t", tt.timestamp, got, tt.expected)
+			}
+		})
+	}
+}
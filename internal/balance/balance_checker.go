@@ -2,7 +2,11 @@ package balance
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
 	"os/exec"
+	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
@@ -16,14 +20,33 @@ import (
 const (
 	// DefaultCommandTimeout 默认命令超时时间
 	DefaultCommandTimeout = 30 * time.Second
+
+	// DefaultCheckInterval 默认余额查询间隔
+	DefaultCheckInterval = 300 * time.Second
+
+	// StaleIntervals 余额信息超过多少个查询间隔没有更新时视为过期
+	StaleIntervals = 3
+
+	// DefaultMaxConcurrentChecks 默认同时执行的余额查询命令数量上限
+	DefaultMaxConcurrentChecks = 4
+
+	// maxStderrLength 错误信息中保留的 stderr 最大字符数
+	maxStderrLength = 200
+)
+
+// 余额阈值比较方式
+const (
+	BalanceComparisonLTE = "lte" // balance <= threshold 时标记为不可用（默认）
+	BalanceComparisonLT  = "lt"  // balance < threshold 时标记为不可用
 )
 
 // BalanceInfo 余额信息
 type BalanceInfo struct {
 	Balance     float64   `json:"balance"`
 	LastChecked time.Time `json:"last_checked"`
-	Status      string    `json:"status"` // "success", "error", "unknown"
+	Status      string    `json:"status"` // "success", "error", "unknown", "stale"
 	Error       string    `json:"error,omitempty"`
+	Low         bool      `json:"low,omitempty"` // 余额低于 balance_warn_threshold（仍然可用）
 }
 
 // BalanceChecker 余额查询器
@@ -33,10 +56,25 @@ type BalanceChecker struct {
 	mutex           sync.RWMutex
 	stopChan        chan struct{}
 	commandTimeout  time.Duration
-	serverTimers    map[string]*time.Ticker // 每个服务器的定时器
-	balancer        BalancerInterface       // 负载均衡器接口
-	commandExecutor CommandExecutor         // 命令执行器接口
-	stopOnce        sync.Once               // 确保Stop只执行一次
+	serverTimers    map[string]*time.Ticker                    // 每个服务器的定时器
+	balancer        BalancerInterface                          // 负载均衡器接口
+	commandExecutor CommandExecutor                            // 命令执行器接口
+	stopOnce        sync.Once                                  // 确保Stop只执行一次
+	initialDelay    func(interval time.Duration) time.Duration // 首次查询前的随机延迟（错开各服务器的查询）
+	metrics         MetricsRecorder                            // 余额查询结果的统计记录（可选）
+	checkSlots      chan struct{}                              // 限制同时执行的查询命令数量
+	lowBalance      LowBalanceListener                         // 余额进入预警区间时的回调（可选）
+	lowWarned       map[string]bool                            // 余额已低于预警阈值的服务器（避免重复通知）
+	delayed         map[string]bool                            // 首次查询仍在随机延迟中的服务器
+}
+
+// LowBalanceListener 余额低于 balance_warn_threshold（但仍高于 balance_threshold）时的回调，不应阻塞
+type LowBalanceListener func(url string, balance float64, threshold float64)
+
+// MetricsRecorder 记录余额查询结果，用于统计和监控
+type MetricsRecorder interface {
+	// RecordBalanceCheck 记录一次余额查询结果，success 为 false 时 balance 无意义
+	RecordBalanceCheck(serverURL string, balance float64, success bool)
 }
 
 // BalancerInterface 负载均衡器接口（用于解耦）
@@ -44,19 +82,60 @@ type BalancerInterface interface {
 	MarkServerDown(url string)
 }
 
+// BalanceStateMarker 可选接口：按余额原因标记和恢复服务器
+// 负载均衡器实现该接口时，余额不足的服务器不会被被动健康检查按冷却时间恢复，只在余额充足后由余额查询恢复
+type BalanceStateMarker interface {
+	// MarkServerLowBalance 因余额不足标记服务器为不可用
+	MarkServerLowBalance(url string)
+
+	// RecoverServerBalance 恢复因余额不足被标记为不可用的服务器（其他原因不可用时忽略）
+	RecoverServerBalance(url string)
+}
+
 // CommandExecutor 命令执行器接口
 type CommandExecutor interface {
 	ExecuteCommand(command string) (float64, error)
 }
 
+// OutputCommandExecutor 可选接口：返回命令的原始输出，用于按 balance_check_regex 提取余额
+type OutputCommandExecutor interface {
+	ExecuteCommandOutput(command string, timeout time.Duration) (string, error)
+}
+
+// TimeoutCommandExecutor 可选接口：支持为单次命令指定超时时间
+type TimeoutCommandExecutor interface {
+	ExecuteCommandWithTimeout(command string, timeout time.Duration) (float64, error)
+}
+
+// ErrBalanceCheckNotConfigured 服务器不存在或没有配置余额查询命令
+var ErrBalanceCheckNotConfigured = errors.New("balance check not configured for server")
+
 // DefaultCommandExecutor 默认命令执行器
 type DefaultCommandExecutor struct {
 	Timeout time.Duration
 }
 
-// ExecuteCommand 执行系统命令
+// ExecuteCommand 执行系统命令（使用执行器的默认超时）
 func (e *DefaultCommandExecutor) ExecuteCommand(command string) (float64, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), e.Timeout)
+	return e.ExecuteCommandWithTimeout(command, e.Timeout)
+}
+
+// ExecuteCommandWithTimeout 使用指定超时执行系统命令，并将完整输出解析为余额
+func (e *DefaultCommandExecutor) ExecuteCommandWithTimeout(command string, timeout time.Duration) (float64, error) {
+	output, err := e.ExecuteCommandOutput(command, timeout)
+	if err != nil {
+		return 0, err
+	}
+	return parseBalanceOutput(output, nil)
+}
+
+// ExecuteCommandOutput 使用指定超时执行系统命令并返回原始输出（timeout <= 0 时使用 DefaultCommandTimeout）
+func (e *DefaultCommandExecutor) ExecuteCommandOutput(command string, timeout time.Duration) (string, error) {
+	if timeout <= 0 {
+		timeout = DefaultCommandTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
 	// 使用跨平台的 shell：Windows 使用 cmd，其他系统使用 sh
@@ -67,19 +146,52 @@ func (e *DefaultCommandExecutor) ExecuteCommand(command string) (float64, error)
 		cmd = exec.CommandContext(ctx, "sh", "-c", command)
 	}
 
+	// 超时后 shell 的子进程可能仍持有输出管道，限制等待时间
+	cmd.WaitDelay = time.Second
+
 	output, err := cmd.Output()
 	if err != nil {
-		return 0, err
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return "", fmt.Errorf("command timed out after %v", timeout)
+		}
+		// cmd.Output 会把 stderr 保存在 ExitError 中，附加到错误信息便于排查
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			if stderr := truncateStderr(exitErr.Stderr); stderr != "" {
+				return "", fmt.Errorf("%w: %s", err, stderr)
+			}
+		}
+		return "", err
 	}
 
-	// 解析输出为数字
-	balanceStr := strings.TrimSpace(string(output))
-	balance, err := strconv.ParseFloat(balanceStr, 64)
-	if err != nil {
-		return 0, err
+	return string(output), nil
+}
+
+// parseBalanceOutput 将命令输出解析为余额
+// pattern 为空时解析完整输出；否则解析第一个匹配的第一个捕获组（没有捕获组时使用整个匹配）
+func parseBalanceOutput(output string, pattern *regexp.Regexp) (float64, error) {
+	balanceStr := output
+	if pattern != nil {
+		match := pattern.FindStringSubmatch(output)
+		if match == nil {
+			return 0, fmt.Errorf("balance_check_regex %q did not match command output", pattern.String())
+		}
+		balanceStr = match[0]
+		if len(match) > 1 {
+			balanceStr = match[1]
+		}
 	}
 
-	return balance, nil
+	return strconv.ParseFloat(strings.TrimSpace(balanceStr), 64)
+}
+
+// truncateStderr 清理 stderr 输出并截断到 maxStderrLength 个字符
+func truncateStderr(stderr []byte) string {
+	text := strings.TrimSpace(string(stderr))
+	if runes := []rune(text); len(runes) > maxStderrLength {
+		text = string(runes[:maxStderrLength]) + "..."
+	}
+	return text
 }
 
 // NewBalanceChecker 创建新的余额查询器
@@ -92,6 +204,10 @@ func NewBalanceChecker(config types.Config, balancer BalancerInterface) *Balance
 		serverTimers:    make(map[string]*time.Ticker),
 		balancer:        balancer,
 		commandExecutor: &DefaultCommandExecutor{Timeout: DefaultCommandTimeout},
+		initialDelay:    randomInitialDelay,
+		checkSlots:      make(chan struct{}, maxConcurrentChecks(config)),
+		lowWarned:       make(map[string]bool),
+		delayed:         make(map[string]bool),
 	}
 }
 
@@ -105,7 +221,30 @@ func NewBalanceCheckerWithExecutor(config types.Config, balancer BalancerInterfa
 		serverTimers:    make(map[string]*time.Ticker),
 		balancer:        balancer,
 		commandExecutor: executor,
+		initialDelay:    randomInitialDelay,
+		checkSlots:      make(chan struct{}, maxConcurrentChecks(config)),
+		lowWarned:       make(map[string]bool),
+		delayed:         make(map[string]bool),
+	}
+}
+
+// maxConcurrentChecks 返回同时执行的余额查询命令数量上限（未配置时使用 DefaultMaxConcurrentChecks）
+func maxConcurrentChecks(config types.Config) int {
+	if config.MaxConcurrentBalanceChecks > 0 {
+		return config.MaxConcurrentBalanceChecks
 	}
+	return DefaultMaxConcurrentChecks
+}
+
+// SetMetricsRecorder 设置余额查询结果的统计记录（需在 Start 前调用）
+func (bc *BalanceChecker) SetMetricsRecorder(recorder MetricsRecorder) {
+	bc.metrics = recorder
+}
+
+// SetLowBalanceListener 设置余额进入预警区间时的回调（需在 Start 前调用）
+// 每次进入预警区间只回调一次，余额回升到预警阈值以上后重新计算
+func (bc *BalanceChecker) SetLowBalanceListener(listener LowBalanceListener) {
+	bc.lowBalance = listener
 }
 
 // Start 启动余额查询
@@ -155,17 +294,43 @@ func (bc *BalanceChecker) startServerBalanceCheck(server types.UpstreamServer) {
 	// 如果没有设置间隔，使用默认300秒（5分钟）
 	interval := server.BalanceCheckInterval
 	if interval <= 0 {
-		interval = 300
+		interval = int(DefaultCheckInterval / time.Second)
 	}
 
 	logger.Info("MONEY", "Starting balance check for %s: interval %d seconds", server.URL, interval)
 
-	// 立即执行一次查询
-	go bc.checkServerBalance(server)
+	period := time.Duration(interval) * time.Second
+	// 随机延迟，避免相同间隔的服务器同时执行查询命令
+	delay := bc.initialDelay(period)
+
+	// 在 Start 返回前登记，/ready 不会因等待随机延迟的首次查询而长时间未就绪
+	if !bc.config.BalanceCheckImmediate && delay > 0 {
+		bc.mutex.Lock()
+		bc.delayed[server.URL] = true
+		bc.mutex.Unlock()
+	}
+
+	go func(s types.UpstreamServer) {
+		if bc.config.BalanceCheckImmediate {
+			// 立即执行一次查询，之后的定时查询仍然错开
+			go bc.checkServerBalance(s)
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-bc.stopChan:
+			return
+		}
+
+		if !bc.config.BalanceCheckImmediate {
+			bc.checkServerBalance(s)
+			bc.mutex.Lock()
+			delete(bc.delayed, s.URL)
+			bc.mutex.Unlock()
+		}
 
-	// 启动定时器
-	go func(s types.UpstreamServer, intervalSeconds int) {
-		ticker := time.NewTicker(time.Duration(intervalSeconds) * time.Second)
+		// 启动定时器
+		ticker := time.NewTicker(period)
 		defer ticker.Stop()
 
 		// 将ticker存储到map中
@@ -181,14 +346,36 @@ func (bc *BalanceChecker) startServerBalanceCheck(server types.UpstreamServer) {
 				return
 			}
 		}
-	}(server, interval)
+	}(server)
+}
+
+// InitialCheckDelayed 返回服务器的首次余额查询是否仍在随机延迟中（未启用 balance_check_immediate 时）
+func (bc *BalanceChecker) InitialCheckDelayed(serverURL string) bool {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+	return bc.delayed[serverURL]
+}
+
+// randomInitialDelay 返回 [0, interval) 内的随机延迟
+func randomInitialDelay(interval time.Duration) time.Duration {
+	if interval <= 0 {
+		return 0
+	}
+	return rand.N(interval)
 }
 
 // checkServerBalance 检查单个服务器的余额
 func (bc *BalanceChecker) checkServerBalance(server types.UpstreamServer) {
 	startTime := time.Now()
 
-	balance, err := bc.commandExecutor.ExecuteCommand(server.BalanceCheck)
+	balance, err := bc.executeBalanceCheck(server)
+	// 按 balance_scale 统一余额单位（如分转换为元）
+	if err == nil && server.BalanceScale != 0 {
+		balance *= server.BalanceScale
+	}
+	if bc.metrics != nil {
+		bc.metrics.RecordBalanceCheck(server.URL, balance, err == nil)
+	}
 
 	bc.mutex.Lock()
 	defer bc.mutex.Unlock()
@@ -210,36 +397,115 @@ func (bc *BalanceChecker) checkServerBalance(server types.UpstreamServer) {
 		threshold := server.BalanceThreshold
 		// 注意：Go中float64零值就是0，所以这里不需要额外处理
 
-		// 检查余额是否低于或等于阈值
-		if balance <= threshold {
-			logger.Warning("MONEY", "Balance insufficient for %s: %.2f <= %.2f (marking as down)",
-				server.URL, balance, threshold)
+		// 按配置的比较方式检查余额是否不足
+		if balanceInsufficient(balance, threshold, server.BalanceComparison) {
+			logger.Warning("MONEY", "Balance insufficient for %s: %.2f %s %.2f (marking as down)",
+				server.URL, balance, comparisonSymbol(server.BalanceComparison), threshold)
 			// 标记服务器为不可用
-			if bc.balancer != nil {
+			if marker, ok := bc.balancer.(BalanceStateMarker); ok {
+				marker.MarkServerLowBalance(server.URL)
+			} else if bc.balancer != nil {
 				bc.balancer.MarkServerDown(server.URL)
 			}
+		} else if server.BalanceWarnThreshold != 0 && balance < server.BalanceWarnThreshold {
+			// 预警区间：只记录和通知，不标记服务器为不可用
+			balanceInfo.Low = true
+			logger.Warning("MONEY", "Balance low for %s: %.2f < %.2f (warning threshold, server remains available)",
+				server.URL, balance, server.BalanceWarnThreshold)
+			if !bc.lowWarned[server.URL] && bc.lowBalance != nil {
+				bc.lowBalance(server.URL, balance, server.BalanceWarnThreshold)
+			}
+			bc.recoverServerBalance(server.URL)
 		} else {
 			logger.Success("MONEY", "Balance for %s: %.2f (checked in %dms)",
 				server.URL, balance, time.Since(startTime).Milliseconds())
+			bc.recoverServerBalance(server.URL)
 		}
+		bc.lowWarned[server.URL] = server.BalanceWarnThreshold != 0 && balance < server.BalanceWarnThreshold
 	}
 
 	bc.balances[server.URL] = balanceInfo
 }
 
+// recoverServerBalance 余额恢复充足时恢复之前因余额不足下线的服务器
+func (bc *BalanceChecker) recoverServerBalance(url string) {
+	if marker, ok := bc.balancer.(BalanceStateMarker); ok {
+		marker.RecoverServerBalance(url)
+	}
+}
+
+// executeBalanceCheck 执行服务器的余额查询命令，服务器配置的超时优先于默认超时
+// 同时执行的命令数量受 max_concurrent_balance_checks 限制
+func (bc *BalanceChecker) executeBalanceCheck(server types.UpstreamServer) (float64, error) {
+	timeout := bc.commandTimeout
+	if server.BalanceCheckTimeoutSeconds > 0 {
+		timeout = time.Duration(server.BalanceCheckTimeoutSeconds) * time.Second
+	}
+
+	// 达到并发上限时排队等待，避免大量服务器同时启动子进程
+	select {
+	case bc.checkSlots <- struct{}{}:
+		defer func() { <-bc.checkSlots }()
+	case <-bc.stopChan:
+		return 0, errors.New("balance checker stopped")
+	}
+
+	// 配置了提取规则时从原始输出中提取余额
+	if server.BalanceCheckRegex != "" {
+		if executor, ok := bc.commandExecutor.(OutputCommandExecutor); ok {
+			pattern, err := regexp.Compile(server.BalanceCheckRegex)
+			if err != nil {
+				return 0, fmt.Errorf("invalid balance_check_regex: %w", err)
+			}
+			output, err := executor.ExecuteCommandOutput(server.BalanceCheck, timeout)
+			if err != nil {
+				return 0, err
+			}
+			return parseBalanceOutput(output, pattern)
+		}
+	}
+
+	if executor, ok := bc.commandExecutor.(TimeoutCommandExecutor); ok {
+		return executor.ExecuteCommandWithTimeout(server.BalanceCheck, timeout)
+	}
+	return bc.commandExecutor.ExecuteCommand(server.BalanceCheck)
+}
+
+// balanceInsufficient 判断余额是否不足："lt" 为 balance < threshold，其他（默认 "lte"）为 balance <= threshold
+func balanceInsufficient(balance, threshold float64, comparison string) bool {
+	if comparison == BalanceComparisonLT {
+		return balance < threshold
+	}
+	return balance <= threshold
+}
+
+// comparisonSymbol 返回比较方式对应的符号（用于日志）
+func comparisonSymbol(comparison string) string {
+	if comparison == BalanceComparisonLT {
+		return "<"
+	}
+	return "<="
+}
+
+// CheckNow 立即执行一次服务器的余额查询（不影响定时查询），返回最新的余额信息
+// 服务器不存在或没有配置余额查询命令时返回 ErrBalanceCheckNotConfigured
+func (bc *BalanceChecker) CheckNow(serverURL string) (*BalanceInfo, error) {
+	for _, server := range bc.config.Servers {
+		if server.URL == serverURL && server.BalanceCheck != "" {
+			bc.checkServerBalance(server)
+			return bc.GetBalance(serverURL), nil
+		}
+	}
+	return nil, ErrBalanceCheckNotConfigured
+}
+
 // GetBalance 获取服务器余额信息
 func (bc *BalanceChecker) GetBalance(serverURL string) *BalanceInfo {
 	bc.mutex.RLock()
 	defer bc.mutex.RUnlock()
 
 	if info, exists := bc.balances[serverURL]; exists {
-		// 创建副本避免并发问题
-		return &BalanceInfo{
-			Balance:     info.Balance,
-			LastChecked: info.LastChecked,
-			Status:      info.Status,
-			Error:       info.Error,
-		}
+		return bc.snapshot(serverURL, info, time.Now())
 	}
 
 	return &BalanceInfo{
@@ -247,19 +513,52 @@ func (bc *BalanceChecker) GetBalance(serverURL string) *BalanceInfo {
 	}
 }
 
+// snapshot 创建余额信息的副本避免并发问题
+// 超过 StaleIntervals 个查询间隔没有更新时状态为 "stale"（查询命令或定时器可能已停止工作）
+func (bc *BalanceChecker) snapshot(serverURL string, info *BalanceInfo, now time.Time) *BalanceInfo {
+	copied := &BalanceInfo{
+		Balance:     info.Balance,
+		LastChecked: info.LastChecked,
+		Status:      info.Status,
+		Error:       info.Error,
+		Low:         info.Low,
+	}
+	if now.Sub(info.LastChecked) > StaleIntervals*bc.checkInterval(serverURL) {
+		copied.Status = "stale"
+	}
+	return copied
+}
+
+// checkInterval 返回服务器的余额查询间隔（未配置时为 DefaultCheckInterval）
+func (bc *BalanceChecker) checkInterval(serverURL string) time.Duration {
+	for _, server := range bc.config.Servers {
+		if server.URL == serverURL && server.BalanceCheckInterval > 0 {
+			return time.Duration(server.BalanceCheckInterval) * time.Second
+		}
+	}
+	return DefaultCheckInterval
+}
+
+// LatestBalance 返回服务器最近一次查询成功的余额（实现 selector.BalanceProvider）
+func (bc *BalanceChecker) LatestBalance(serverURL string) (float64, bool) {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+
+	if info, exists := bc.balances[serverURL]; exists && info.Status == "success" {
+		return info.Balance, true
+	}
+	return 0, false
+}
+
 // GetAllBalances 获取所有服务器的余额信息
 func (bc *BalanceChecker) GetAllBalances() map[string]*BalanceInfo {
 	bc.mutex.RLock()
 	defer bc.mutex.RUnlock()
 
+	now := time.Now()
 	result := make(map[string]*BalanceInfo)
 	for url, info := range bc.balances {
-		result[url] = &BalanceInfo{
-			Balance:     info.Balance,
-			LastChecked: info.LastChecked,
-			Status:      info.Status,
-			Error:       info.Error,
-		}
+		result[url] = bc.snapshot(url, info, now)
 	}
 
 	return result
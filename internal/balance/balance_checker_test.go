@@ -2,8 +2,12 @@ package balance
 
 import (
 	"errors"
+	"fmt"
 	"os/exec"
+	"regexp"
 	"runtime"
+	"slices"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -558,6 +562,7 @@ func TestEndToEndFunctionality(t *testing.T) {
 	}
 
 	config := types.Config{
+		BalanceCheckImmediate: true,
 		Servers: []types.UpstreamServer{
 			{
 				URL:                  testutil.API1ExampleURL,
@@ -613,6 +618,7 @@ func TestBalanceThresholdTrigger(t *testing.T) {
 		name                  string
 		balance               float64
 		threshold             float64
+		comparison            string
 		expectedMarkDownCalls int
 	}{
 		{
@@ -639,11 +645,33 @@ func TestBalanceThresholdTrigger(t *testing.T) {
 			threshold:             0.0,
 			expectedMarkDownCalls: 1,
 		},
+		{
+			name:                  "lte comparison at threshold",
+			balance:               10.0,
+			threshold:             10.0,
+			comparison:            BalanceComparisonLTE,
+			expectedMarkDownCalls: 1,
+		},
+		{
+			name:                  "lt comparison at threshold",
+			balance:               10.0,
+			threshold:             10.0,
+			comparison:            BalanceComparisonLT,
+			expectedMarkDownCalls: 0,
+		},
+		{
+			name:                  "lt comparison below threshold",
+			balance:               9.99,
+			threshold:             10.0,
+			comparison:            BalanceComparisonLT,
+			expectedMarkDownCalls: 1,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			config := types.Config{
+				BalanceCheckImmediate: true,
 				Servers: []types.UpstreamServer{
 					{
 						URL:                  testutil.API1ExampleURL,
@@ -651,6 +679,7 @@ func TestBalanceThresholdTrigger(t *testing.T) {
 						BalanceCheck:         "check_balance_cmd", // 使用特定命令
 						BalanceCheckInterval: 1,                   // 1秒间隔，便于测试
 						BalanceThreshold:     tt.threshold,
+						BalanceComparison:    tt.comparison,
 					},
 				},
 			}
@@ -708,3 +737,516 @@ func TestCompleteAPIFunctionality(t *testing.T) {
 	// 4. Stop (should not panic)
 	checker.Stop()
 }
+
+// TestBalanceCheckerStaggeredStart 测试首次查询按随机延迟错开执行
+func TestBalanceCheckerStaggeredStart(t *testing.T) {
+	config := types.Config{
+		Servers: []types.UpstreamServer{
+			{URL: testutil.API1ExampleURL, Token: testutil.TestToken1, BalanceCheck: "echo 100", BalanceCheckInterval: 60},
+			{URL: testutil.API2ExampleURL, Token: testutil.TestToken2, BalanceCheck: "echo 150.75", BalanceCheckInterval: 60},
+		},
+	}
+
+	mockExecutor := testutil.NewMockCommandExecutor()
+	checker := NewBalanceCheckerWithExecutor(config, testutil.NewMockBalancer(), mockExecutor)
+
+	// 使用确定的延迟代替随机延迟：第一个服务器不延迟，第二个延迟 300ms
+	var calls int
+	var seenInterval time.Duration
+	var callsMutex sync.Mutex
+	checker.initialDelay = func(interval time.Duration) time.Duration {
+		callsMutex.Lock()
+		defer callsMutex.Unlock()
+		seenInterval = interval
+		calls++
+		if calls == 1 {
+			return 0
+		}
+		return 300 * time.Millisecond
+	}
+
+	checker.Start()
+	defer checker.Stop()
+
+	// 第一个服务器立即查询，第二个服务器仍在等待
+	time.Sleep(100 * time.Millisecond)
+	first := mockExecutor.GetCallCount("echo 100") + mockExecutor.GetCallCount("echo 150.75")
+	if first != 1 {
+		t.Errorf("Expected exactly one check before the staggered delay, got %d", first)
+	}
+	if checker.InitialCheckDelayed(testutil.API1ExampleURL) || !checker.InitialCheckDelayed(testutil.API2ExampleURL) {
+		t.Errorf("Expected only the second server to be waiting for its initial delay")
+	}
+
+	// 延迟结束后两个服务器都完成首次查询
+	time.Sleep(400 * time.Millisecond)
+	if mockExecutor.GetCallCount("echo 100") != 1 || mockExecutor.GetCallCount("echo 150.75") != 1 {
+		t.Errorf("Expected both servers checked once, got %d and %d",
+			mockExecutor.GetCallCount("echo 100"), mockExecutor.GetCallCount("echo 150.75"))
+	}
+	if checker.InitialCheckDelayed(testutil.API2ExampleURL) {
+		t.Errorf("Expected the delay flag to clear after the first check")
+	}
+
+	callsMutex.Lock()
+	defer callsMutex.Unlock()
+	if seenInterval != 60*time.Second {
+		t.Errorf("Expected delay to be drawn from the check interval, got %v", seenInterval)
+	}
+}
+
+// TestBalanceCheckerImmediateFirstCheck 测试启用立即查询时不等待随机延迟
+func TestBalanceCheckerImmediateFirstCheck(t *testing.T) {
+	config := types.Config{
+		BalanceCheckImmediate: true,
+		Servers: []types.UpstreamServer{
+			{URL: testutil.API1ExampleURL, Token: testutil.TestToken1, BalanceCheck: "echo 100", BalanceCheckInterval: 60},
+		},
+	}
+
+	mockExecutor := testutil.NewMockCommandExecutor()
+	checker := NewBalanceCheckerWithExecutor(config, testutil.NewMockBalancer(), mockExecutor)
+	checker.initialDelay = func(interval time.Duration) time.Duration {
+		return time.Hour
+	}
+
+	checker.Start()
+	defer checker.Stop()
+
+	time.Sleep(50 * time.Millisecond)
+	if count := mockExecutor.GetCallCount("echo 100"); count != 1 {
+		t.Errorf("Expected immediate first check, got %d calls", count)
+	}
+}
+
+func TestRandomInitialDelay(t *testing.T) {
+	if delay := randomInitialDelay(0); delay != 0 {
+		t.Errorf("Expected zero delay for zero interval, got %v", delay)
+	}
+
+	for i := 0; i < 100; i++ {
+		delay := randomInitialDelay(time.Second)
+		if delay < 0 || delay >= time.Second {
+			t.Fatalf("Delay %v out of range [0, 1s)", delay)
+		}
+	}
+}
+
+// TestBalanceCheckTimeout 测试服务器配置的余额查询超时
+func TestBalanceCheckTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("sleep command not available on windows")
+	}
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found; skipping shell-dependent timeout test")
+	}
+
+	config := types.Config{
+		Servers: []types.UpstreamServer{
+			{
+				URL:                        testutil.API1ExampleURL,
+				Token:                      testutil.TestToken1,
+				BalanceCheck:               "sleep 5; echo 100",
+				BalanceCheckTimeoutSeconds: 1,
+			},
+		},
+	}
+
+	checker := NewBalanceChecker(config, testutil.NewMockBalancer())
+
+	start := time.Now()
+	checker.checkServerBalance(config.Servers[0])
+	elapsed := time.Since(start)
+
+	if elapsed >= 4*time.Second {
+		t.Errorf("Expected command to be cut off by the 1s timeout, took %v", elapsed)
+	}
+
+	balance := checker.GetBalance(testutil.API1ExampleURL)
+	if balance.Status != "error" {
+		t.Errorf("Expected status error, got %s", balance.Status)
+	}
+	if !strings.Contains(balance.Error, "timed out after 1s") {
+		t.Errorf("Expected timeout error, got %q", balance.Error)
+	}
+}
+
+// TestBalanceCheckStderr 测试命令失败时错误信息包含 stderr
+func TestBalanceCheckStderr(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell redirection syntax differs on windows")
+	}
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found; skipping shell-dependent stderr test")
+	}
+
+	config := types.Config{
+		Servers: []types.UpstreamServer{
+			{URL: testutil.API1ExampleURL, Token: testutil.TestToken1, BalanceCheck: "echo 'curl: auth failed' >&2; exit 1"},
+			{URL: testutil.API2ExampleURL, Token: testutil.TestToken2, BalanceCheck: "printf 'x%.0s' $(seq 1 500) >&2; exit 2"},
+		},
+	}
+
+	checker := NewBalanceChecker(config, testutil.NewMockBalancer())
+	for _, server := range config.Servers {
+		checker.checkServerBalance(server)
+	}
+
+	balance := checker.GetBalance(testutil.API1ExampleURL)
+	if balance.Status != "error" || !strings.Contains(balance.Error, "curl: auth failed") {
+		t.Errorf("Expected stderr in error, got %q", balance.Error)
+	}
+	if !strings.Contains(balance.Error, "exit status 1") {
+		t.Errorf("Expected exit status in error, got %q", balance.Error)
+	}
+
+	// 过长的 stderr 被截断
+	balance = checker.GetBalance(testutil.API2ExampleURL)
+	if !strings.HasSuffix(balance.Error, strings.Repeat("x", maxStderrLength)+"...") {
+		t.Errorf("Expected truncated stderr, got %q", balance.Error)
+	}
+}
+
+func TestTruncateStderr(t *testing.T) {
+	tests := []struct {
+		name     string
+		stderr   string
+		expected string
+	}{
+		{"empty", "", ""},
+		{"whitespace only", "  \n", ""},
+		{"short", "error: denied\n", "error: denied"},
+		{"long", strings.Repeat("a", maxStderrLength+10), strings.Repeat("a", maxStderrLength) + "..."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := truncateStderr([]byte(tt.stderr)); got != tt.expected {
+				t.Errorf("truncateStderr() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+// recordedBalanceCheck 测试用的余额查询结果记录
+type recordedBalanceCheck struct {
+	url     string
+	balance float64
+	success bool
+}
+
+// fakeMetricsRecorder 记录所有余额查询结果的测试实现
+type fakeMetricsRecorder struct {
+	mutex   sync.Mutex
+	records []recordedBalanceCheck
+}
+
+func (r *fakeMetricsRecorder) RecordBalanceCheck(serverURL string, balance float64, success bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.records = append(r.records, recordedBalanceCheck{url: serverURL, balance: balance, success: success})
+}
+
+func TestBalanceCheckerMetricsRecorder(t *testing.T) {
+	config := types.Config{
+		Servers: []types.UpstreamServer{
+			{URL: testutil.API1ExampleURL, Token: testutil.TestToken1, BalanceCheck: "ok_cmd"},
+			{URL: testutil.API2ExampleURL, Token: testutil.TestToken2, BalanceCheck: "failing_cmd"},
+		},
+	}
+
+	mockExecutor := testutil.NewMockCommandExecutor()
+	mockExecutor.SetResult("ok_cmd", 25.5)
+	mockExecutor.SetError("failing_cmd", errors.New("command failed"))
+
+	checker := NewBalanceCheckerWithExecutor(config, testutil.NewMockBalancer(), mockExecutor)
+	recorder := &fakeMetricsRecorder{}
+	checker.SetMetricsRecorder(recorder)
+
+	for _, server := range config.Servers {
+		checker.checkServerBalance(server)
+	}
+
+	expected := []recordedBalanceCheck{
+		{url: testutil.API1ExampleURL, balance: 25.5, success: true},
+		{url: testutil.API2ExampleURL, balance: 0, success: false},
+	}
+	if !slices.Equal(recorder.records, expected) {
+		t.Errorf("Expected recorded checks %v, got %v", expected, recorder.records)
+	}
+}
+
+// concurrencyTrackingExecutor 记录同时执行的命令数量峰值的测试实现
+type concurrencyTrackingExecutor struct {
+	mutex   sync.Mutex
+	running int
+	peak    int
+	calls   int
+}
+
+func (e *concurrencyTrackingExecutor) ExecuteCommand(command string) (float64, error) {
+	e.mutex.Lock()
+	e.running++
+	e.calls++
+	e.peak = max(e.peak, e.running)
+	e.mutex.Unlock()
+
+	time.Sleep(20 * time.Millisecond)
+
+	e.mutex.Lock()
+	e.running--
+	e.mutex.Unlock()
+	return 100, nil
+}
+
+func TestBalanceCheckerConcurrencyLimit(t *testing.T) {
+	config := types.Config{MaxConcurrentBalanceChecks: 2}
+	for i := range 10 {
+		config.Servers = append(config.Servers, types.UpstreamServer{
+			URL:          fmt.Sprintf("https://api%d.example.com", i),
+			Token:        testutil.TestToken1,
+			BalanceCheck: "check",
+		})
+	}
+
+	executor := &concurrencyTrackingExecutor{}
+	checker := NewBalanceCheckerWithExecutor(config, testutil.NewMockBalancer(), executor)
+
+	var wg sync.WaitGroup
+	for _, server := range config.Servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			checker.checkServerBalance(server)
+		}()
+	}
+	wg.Wait()
+
+	if executor.calls != len(config.Servers) {
+		t.Errorf("Expected %d commands to run, got %d", len(config.Servers), executor.calls)
+	}
+	if executor.peak > 2 {
+		t.Errorf("Expected at most 2 concurrent commands, got %d", executor.peak)
+	}
+}
+
+func TestBalanceCheckerCheckNow(t *testing.T) {
+	config := types.Config{
+		Servers: []types.UpstreamServer{
+			{URL: testutil.API1ExampleURL, Token: testutil.TestToken1, BalanceCheck: "check"},
+			{URL: testutil.API2ExampleURL, Token: testutil.TestToken2},
+		},
+	}
+
+	mockExecutor := testutil.NewMockCommandExecutor()
+	mockExecutor.SetResult("check", 12.5)
+	checker := NewBalanceCheckerWithExecutor(config, testutil.NewMockBalancer(), mockExecutor)
+
+	info, err := checker.CheckNow(testutil.API1ExampleURL)
+	if err != nil {
+		t.Fatalf("CheckNow failed: %v", err)
+	}
+	if info.Status != "success" || info.Balance != 12.5 {
+		t.Errorf("Unexpected balance info: %+v", info)
+	}
+
+	for _, url := range []string{testutil.API2ExampleURL, "https://unknown.example.com"} {
+		if _, err := checker.CheckNow(url); !errors.Is(err, ErrBalanceCheckNotConfigured) {
+			t.Errorf("Expected ErrBalanceCheckNotConfigured for %s, got %v", url, err)
+		}
+	}
+}
+
+func TestParseBalanceOutput(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		pattern  string
+		expected float64
+		wantErr  bool
+	}{
+		{name: "whole output", output: "100.50\n", expected: 100.50},
+		{name: "whole output with text", output: "Balance: 100.50 USD", wantErr: true},
+		{name: "capture group", output: "Balance: 100.50 USD\n", pattern: `Balance:\s*([0-9.]+)`, expected: 100.50},
+		{name: "first match only", output: "used 3.5, remaining 42", pattern: `remaining (\d+)`, expected: 42},
+		{name: "multiline noise", output: "fetching...\n{\"balance\": -7.25}\ndone", pattern: `"balance":\s*(-?[0-9.]+)`, expected: -7.25},
+		{name: "whole match without group", output: "total=12.5", pattern: `[0-9.]+`, expected: 12.5},
+		{name: "no match", output: "error: unauthorized", pattern: `Balance: ([0-9.]+)`, wantErr: true},
+		{name: "captured text not a number", output: "Balance: N/A", pattern: `Balance: (\S+)`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var pattern *regexp.Regexp
+			if tt.pattern != "" {
+				pattern = regexp.MustCompile(tt.pattern)
+			}
+
+			balance, err := parseBalanceOutput(tt.output, pattern)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected error, got balance %v", balance)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if balance != tt.expected {
+				t.Errorf("Expected balance %v, got %v", tt.expected, balance)
+			}
+		})
+	}
+}
+
+func TestBalanceCheckRegex(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping shell output test on Windows")
+	}
+
+	server := types.UpstreamServer{
+		URL:               testutil.API1ExampleURL,
+		Token:             testutil.TestToken1,
+		BalanceCheck:      "echo 'Balance: 100.50 USD'",
+		BalanceCheckRegex: `Balance: ([0-9.]+)`,
+	}
+	checker := NewBalanceChecker(types.Config{Servers: []types.UpstreamServer{server}}, testutil.NewMockBalancer())
+
+	checker.checkServerBalance(server)
+	info := checker.GetBalance(server.URL)
+	if info.Status != "success" || info.Balance != 100.50 {
+		t.Errorf("Expected extracted balance 100.50, got %+v", info)
+	}
+}
+
+func TestBalanceScale(t *testing.T) {
+	tests := []struct {
+		name        string
+		rawBalance  float64
+		scale       float64
+		expected    float64
+		expectsDown bool
+	}{
+		{name: "no scale", rawBalance: 500, expected: 500},
+		{name: "cents above threshold", rawBalance: 1500, scale: 0.01, expected: 15},
+		{name: "cents below threshold", rawBalance: 500, scale: 0.01, expected: 5, expectsDown: true},
+		{name: "scale up", rawBalance: 2, scale: 100, expected: 200},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := types.UpstreamServer{
+				URL:              testutil.API1ExampleURL,
+				Token:            testutil.TestToken1,
+				BalanceCheck:     "check",
+				BalanceScale:     tt.scale,
+				BalanceThreshold: 10,
+			}
+
+			mockExecutor := testutil.NewMockCommandExecutor()
+			mockExecutor.SetResult("check", tt.rawBalance)
+			mockBalancer := testutil.NewMockBalancer()
+			checker := NewBalanceCheckerWithExecutor(types.Config{Servers: []types.UpstreamServer{server}}, mockBalancer, mockExecutor)
+
+			checker.checkServerBalance(server)
+
+			if balance := checker.GetBalance(server.URL).Balance; balance != tt.expected {
+				t.Errorf("Expected stored balance %v, got %v", tt.expected, balance)
+			}
+			if down := mockBalancer.GetMarkDownCallCount(server.URL) > 0; down != tt.expectsDown {
+				t.Errorf("Expected marked down %v, got %v", tt.expectsDown, down)
+			}
+		})
+	}
+}
+
+func TestBalanceWarnThreshold(t *testing.T) {
+	server := types.UpstreamServer{
+		URL:                  testutil.API1ExampleURL,
+		Token:                testutil.TestToken1,
+		BalanceCheck:         "check",
+		BalanceThreshold:     10,
+		BalanceWarnThreshold: 50,
+	}
+
+	mockExecutor := testutil.NewMockCommandExecutor()
+	mockBalancer := testutil.NewMockBalancer()
+	checker := NewBalanceCheckerWithExecutor(types.Config{Servers: []types.UpstreamServer{server}}, mockBalancer, mockExecutor)
+
+	var alerts []float64
+	checker.SetLowBalanceListener(func(url string, balance float64, threshold float64) {
+		if url != server.URL || threshold != 50 {
+			t.Errorf("Unexpected alert for %s with threshold %v", url, threshold)
+		}
+		alerts = append(alerts, balance)
+	})
+
+	steps := []struct {
+		balance    float64
+		expectLow  bool
+		expectDown int
+	}{
+		{balance: 100, expectLow: false, expectDown: 0}, // 正常
+		{balance: 30, expectLow: true, expectDown: 0},   // 进入预警区间：通知但不下线
+		{balance: 20, expectLow: true, expectDown: 0},   // 仍在预警区间：不重复通知
+		{balance: 5, expectLow: false, expectDown: 1},   // 低于下线阈值
+		{balance: 80, expectLow: false, expectDown: 1},  // 恢复
+		{balance: 40, expectLow: true, expectDown: 1},   // 再次进入预警区间
+	}
+	for i, step := range steps {
+		mockExecutor.SetResult("check", step.balance)
+		checker.checkServerBalance(server)
+
+		if low := checker.GetBalance(server.URL).Low; low != step.expectLow {
+			t.Errorf("Step %d (balance %v): expected low %v, got %v", i+1, step.balance, step.expectLow, low)
+		}
+		if down := mockBalancer.GetMarkDownCallCount(server.URL); down != step.expectDown {
+			t.Errorf("Step %d (balance %v): expected %d mark down calls, got %d", i+1, step.balance, step.expectDown, down)
+		}
+	}
+
+	if !slices.Equal(alerts, []float64{30, 40}) {
+		t.Errorf("Expected alerts for 30 and 40, got %v", alerts)
+	}
+}
+
+func TestBalanceCheckerStaleBalance(t *testing.T) {
+	config := types.Config{
+		Servers: []types.UpstreamServer{
+			{URL: testutil.API1ExampleURL, Token: testutil.TestToken1, BalanceCheck: "check", BalanceCheckInterval: 60},
+			{URL: testutil.API2ExampleURL, Token: testutil.TestToken2, BalanceCheck: "check"},
+		},
+	}
+
+	mockExecutor := testutil.NewMockCommandExecutor()
+	mockExecutor.SetResult("check", 100)
+	checker := NewBalanceCheckerWithExecutor(config, testutil.NewMockBalancer(), mockExecutor)
+	for _, server := range config.Servers {
+		checker.checkServerBalance(server)
+	}
+
+	if status := checker.GetBalance(testutil.API1ExampleURL).Status; status != "success" {
+		t.Fatalf("Expected fresh balance to be success, got %s", status)
+	}
+
+	// 模拟查询停止工作：第一个服务器超过 3 个间隔（180s）未更新，第二个仍在默认间隔（300s）的范围内
+	checker.mutex.Lock()
+	for _, info := range checker.balances {
+		info.LastChecked = time.Now().Add(-200 * time.Second)
+	}
+	checker.mutex.Unlock()
+
+	stale := checker.GetBalance(testutil.API1ExampleURL)
+	if stale.Status != "stale" || stale.Balance != 100 {
+		t.Errorf("Expected stale status with last known balance, got %+v", stale)
+	}
+	all := checker.GetAllBalances()
+	if all[testutil.API1ExampleURL].Status != "stale" || all[testutil.API2ExampleURL].Status != "success" {
+		t.Errorf("Unexpected statuses: %s, %s", all[testutil.API1ExampleURL].Status, all[testutil.API2ExampleURL].Status)
+	}
+
+	// LatestBalance 仍返回最后一次成功的余额
+	if balance, ok := checker.LatestBalance(testutil.API1ExampleURL); !ok || balance != 100 {
+		t.Errorf("Expected latest balance 100, got %v (%v)", balance, ok)
+	}
+}
@@ -1,6 +1,10 @@
 package balance
 
 import (
+	"context"
+	"sync"
+	"time"
+
 	"claude-code-lb/internal/logger"
 	"claude-code-lb/internal/selector"
 	"claude-code-lb/pkg/types"
@@ -8,8 +12,13 @@ import (
 
 // Balancer 负载均衡器（现在是选择器的包装器）
 type Balancer struct {
-	config   types.Config
-	selector selector.ServerSelector
+	config     types.Config
+	selector   selector.ServerSelector
+	errorRates *errorRateTracker // 每个服务器最近一段时间的错误率
+	outages    *outageDetector   // 识别所有服务器同时失败的本地网络故障
+
+	recoveryMutex sync.Mutex
+	recovered     chan struct{} // 有服务器恢复时关闭并替换，用于唤醒等待可用服务器的请求
 }
 
 // New 创建新的负载均衡器
@@ -26,8 +35,11 @@ func New(config types.Config) *Balancer {
 	logger.Info("LOAD", "Balancer initialized with: %s", selectorType)
 
 	return &Balancer{
-		config:   config,
-		selector: sel,
+		config:     config,
+		selector:   sel,
+		errorRates: newErrorRateTracker(DefaultErrorRateWindow),
+		outages:    newOutageDetector(config.Servers, networkOutageWindow(config)),
+		recovered:  make(chan struct{}),
 	}
 }
 
@@ -36,6 +48,55 @@ func (b *Balancer) GetNextServer() (*types.UpstreamServer, error) {
 	return b.selector.SelectServer()
 }
 
+// GetNextServerWithOptions 按单个请求的参数（模型、算法、区域）获取下一个服务器
+// 选择器不支持按请求参数选择时等同于 GetNextServer
+func (b *Balancer) GetNextServerWithOptions(opts selector.SelectOptions) (*types.UpstreamServer, error) {
+	if optionsSelector, ok := b.selector.(selector.OptionsSelector); ok && opts != (selector.SelectOptions{}) {
+		return optionsSelector.SelectServerWithOptions(opts)
+	}
+	return b.selector.SelectServer()
+}
+
+// WaitForServer 在没有可用服务器时等待服务器恢复，最多等待 timeout
+// 每次有服务器恢复时按 opts 重新选择；超时返回最后一次选择的错误，ctx 取消时返回 ctx 的错误
+// 返回的服务器已占用一个在途连接（同 SelectAndAcquire），调用方在请求结束后调用 ReleaseConnection
+func (b *Balancer) WaitForServer(ctx context.Context, opts selector.SelectOptions, timeout time.Duration) (*types.UpstreamServer, error) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for {
+		// 先取通知通道再选择，避免错过选择与等待之间发生的恢复
+		recovered := b.recoveredChan()
+		server, err := b.SelectAndAcquire(opts)
+		if err == nil {
+			return server, nil
+		}
+
+		select {
+		case <-recovered:
+		case <-deadline.C:
+			return nil, err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// recoveredChan 返回当前的恢复通知通道
+func (b *Balancer) recoveredChan() <-chan struct{} {
+	b.recoveryMutex.Lock()
+	defer b.recoveryMutex.Unlock()
+	return b.recovered
+}
+
+// notifyRecovered 唤醒所有等待可用服务器的请求
+func (b *Balancer) notifyRecovered() {
+	b.recoveryMutex.Lock()
+	defer b.recoveryMutex.Unlock()
+	close(b.recovered)
+	b.recovered = make(chan struct{})
+}
+
 // GetNextServerWithFallback 获取下一个服务器（向后兼容方法）
 func (b *Balancer) GetNextServerWithFallback(useFallback bool) (*types.UpstreamServer, error) {
 	// 在新的架构中，fallback逻辑由选择器内部处理
@@ -45,7 +106,109 @@ func (b *Balancer) GetNextServerWithFallback(useFallback bool) (*types.UpstreamS
 
 // MarkServerDown 标记服务器为不可用
 func (b *Balancer) MarkServerDown(url string) {
-	b.selector.MarkServerDown(url)
+	b.MarkServerDownFor(url, 0)
+}
+
+// MarkServerDownFor 标记服务器为不可用，并使用指定的冷却时间（<=0 时使用选择器的默认冷却）
+func (b *Balancer) MarkServerDownFor(url string, duration time.Duration) {
+	b.MarkServerDownWithReasonFor(url, selector.DownReasonFailure, duration)
+}
+
+// MarkServerDownWithReason 按指定原因标记服务器为不可用
+func (b *Balancer) MarkServerDownWithReason(url string, reason selector.DownReason) {
+	b.MarkServerDownWithReasonFor(url, reason, 0)
+}
+
+// MarkServerDownWithReasonFor 按指定原因标记服务器为不可用，并使用指定的冷却时间（<=0 时使用选择器的默认冷却）
+// 请求失败类原因计入错误率：错误率超过阈值时，冷却时间延长为 ErrorRateTripCooldown；
+// 所有服务器都在网络故障判定窗口内失败时判定为本地网络故障：这次失败只使用基础冷却时间且不计入失败次数，
+// 窗口内先前计入的其他服务器的失败次数（及其退避、max_failures 禁用）一并撤销
+// 余额不足等其他原因不计入错误率和失败次数（选择器不支持记录原因时按请求失败处理）
+func (b *Balancer) MarkServerDownWithReasonFor(url string, reason selector.DownReason, duration time.Duration) {
+	tracker, hasTracker := b.selector.(selector.DownReasonTracker)
+	if !reason.IsFailure() && hasTracker {
+		tracker.MarkServerDownWithReasonFor(url, reason, duration)
+		return
+	}
+
+	b.errorRates.record(url, false)
+
+	if outage, forgiven := b.outages.recordFailure(url); outage {
+		if marker, ok := b.selector.(selector.TransientDownMarker); ok {
+			baseCooldown := time.Duration(b.config.Cooldown) * time.Second
+			if duration <= 0 {
+				duration = baseCooldown
+			}
+			logger.Warning("LOAD", "All %d servers failed within %v, suspecting local network outage: %s down for %v without backoff",
+				len(b.config.Servers), b.outages.window, url, duration)
+			// 保留实际的失败原因（连接错误、5xx、429 等），/servers 和状态通知才能反映真实情况
+			marker.MarkServerDownTransientWithReason(url, reason, duration)
+			for server, count := range forgiven {
+				marker.ForgiveFailures(server, count, baseCooldown)
+			}
+			return
+		}
+	}
+
+	if b.errorRateTripped(url) && duration < ErrorRateTripCooldown {
+		duration = ErrorRateTripCooldown
+	}
+
+	switch {
+	case hasTracker:
+		tracker.MarkServerDownWithReasonFor(url, reason, duration)
+	case duration <= 0:
+		b.selector.MarkServerDown(url)
+	default:
+		b.selector.MarkServerDownFor(url, duration)
+	}
+}
+
+// GetDownReason 获取服务器不可用的原因（服务器可用或选择器不支持时返回空字符串）
+func (b *Balancer) GetDownReason(url string) selector.DownReason {
+	if tracker, ok := b.selector.(selector.DownReasonTracker); ok {
+		return tracker.GetDownReason(url)
+	}
+	return ""
+}
+
+// MarkServerLowBalance 因余额不足标记服务器为不可用，只能由 RecoverServerBalance 恢复（实现 BalanceStateMarker）
+func (b *Balancer) MarkServerLowBalance(url string) {
+	b.MarkServerDownWithReason(url, selector.DownReasonBalance)
+}
+
+// RecoverServerBalance 余额查询确认余额充足时，恢复因余额不足被标记为不可用的服务器（实现 BalanceStateMarker）
+func (b *Balancer) RecoverServerBalance(url string) {
+	if b.GetDownReason(url) == selector.DownReasonBalance {
+		logger.Info("LOAD", "Server %s balance is sufficient again, recovering", url)
+		b.RecoverServer(url)
+	}
+}
+
+// errorRateTripped 判断服务器的窗口错误率是否超过熔断阈值（未配置阈值时始终为 false）
+func (b *Balancer) errorRateTripped(url string) bool {
+	if b.config.ErrorRateThreshold <= 0 {
+		return false
+	}
+
+	minRequests := int64(b.config.ErrorRateMinRequests)
+	if minRequests <= 0 {
+		minRequests = DefaultErrorRateMinRequests
+	}
+
+	rate := b.errorRates.errorRate(url)
+	if rate.Requests < minRequests || rate.Rate < b.config.ErrorRateThreshold {
+		return false
+	}
+
+	logger.Warning("LOAD", "Server %s error rate %.0f%% over last %v exceeds threshold %.0f%%, tripping for %v",
+		url, rate.Rate*100, DefaultErrorRateWindow, b.config.ErrorRateThreshold*100, ErrorRateTripCooldown)
+	return true
+}
+
+// GetErrorRate 获取服务器在最近时间窗口内的错误率
+func (b *Balancer) GetErrorRate(url string) ErrorRate {
+	return b.errorRates.errorRate(url)
 }
 
 // GetAvailableServers 获取所有可用服务器
@@ -58,12 +221,117 @@ func (b *Balancer) GetServerStatus() map[string]bool {
 	return b.selector.GetServerStatus()
 }
 
+// DescribeRouting 返回解析配置后实际生效的路由计划
+func (b *Balancer) DescribeRouting() selector.RoutingPlan {
+	return b.selector.DescribeRouting()
+}
+
+// GetServerDownUntil 获取服务器的冷却结束时间
+func (b *Balancer) GetServerDownUntil(url string) time.Time {
+	return b.selector.GetServerDownUntil(url)
+}
+
+// IsServerAvailable 判断服务器当前是否可用
+func (b *Balancer) IsServerAvailable(url string) bool {
+	return b.selector.IsServerAvailable(url)
+}
+
+// GetFailureCount 获取服务器当前的失败次数（选择器不支持时始终为 0）
+func (b *Balancer) GetFailureCount(url string) int64 {
+	if marker, ok := b.selector.(selector.TransientDownMarker); ok {
+		return marker.GetFailureCount(url)
+	}
+	return 0
+}
+
+// IsServerDisabled 判断服务器是否已被永久禁用（选择器不支持时始终为 false）
+func (b *Balancer) IsServerDisabled(url string) bool {
+	if disabler, ok := b.selector.(selector.ServerDisabler); ok {
+		return disabler.IsServerDisabled(url)
+	}
+	return false
+}
+
+// EnableServer 重新启用被禁用的服务器（选择器不支持时忽略）
+func (b *Balancer) EnableServer(url string) {
+	if disabler, ok := b.selector.(selector.ServerDisabler); ok {
+		disabler.EnableServer(url)
+		b.notifyRecovered()
+	}
+}
+
 // RecoverServer 恢复服务器
 func (b *Balancer) RecoverServer(url string) {
 	b.selector.RecoverServer(url)
+	b.notifyRecovered()
 }
 
 // MarkServerHealthy 标记服务器为健康
 func (b *Balancer) MarkServerHealthy(url string) {
+	b.errorRates.record(url, true)
+	b.outages.recordSuccess(url)
+
+	// 只在服务器从不可用变为可用时唤醒等待者，避免每个成功请求都发送通知
+	wasAvailable := b.selector.IsServerAvailable(url)
 	b.selector.MarkServerHealthy(url)
+	if !wasAvailable {
+		b.notifyRecovered()
+	}
+}
+
+// AcquireConnection 记录一个新的在途连接（选择器不支持时忽略）
+func (b *Balancer) AcquireConnection(url string) {
+	if tracker, ok := b.selector.(selector.ConnectionTracker); ok {
+		tracker.AcquireConnection(url)
+	}
+}
+
+// SelectAndAcquire 按 opts 选择服务器并记录一个在途连接，调用方在请求结束后调用 ReleaseConnection
+// 选择器支持时选择和占用在同一把锁内完成（保证 max_concurrent），否则先选择再占用
+func (b *Balancer) SelectAndAcquire(opts selector.SelectOptions) (*types.UpstreamServer, error) {
+	if reserver, ok := b.selector.(selector.ConnectionReserver); ok {
+		return reserver.SelectAndAcquire(opts)
+	}
+	server, err := b.GetNextServerWithOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	b.AcquireConnection(server.URL)
+	return server, nil
+}
+
+// ReleaseConnection 释放一个在途连接（选择器不支持时忽略）
+func (b *Balancer) ReleaseConnection(url string) {
+	if tracker, ok := b.selector.(selector.ConnectionTracker); ok {
+		tracker.ReleaseConnection(url)
+	}
+}
+
+// GetActiveConnections 获取服务器当前的在途连接数（选择器不支持时返回 0）
+func (b *Balancer) GetActiveConnections(url string) int64 {
+	if tracker, ok := b.selector.(selector.ConnectionTracker); ok {
+		return tracker.GetActiveConnections(url)
+	}
+	return 0
+}
+
+// SetStateListener 设置服务器状态变化监听器（选择器不支持时忽略）
+func (b *Balancer) SetStateListener(listener selector.StateListener) {
+	if notifier, ok := b.selector.(selector.StateNotifier); ok {
+		notifier.SetStateListener(listener)
+	}
+}
+
+// SetRandomSource 设置 random 算法的随机数来源（选择器不支持时忽略）
+func (b *Balancer) SetRandomSource(source selector.RandomSource) {
+	if aware, ok := b.selector.(selector.RandomAware); ok {
+		aware.SetRandomSource(source)
+	}
+}
+
+// SetBalanceProvider 设置余额数据来源（选择器不支持时忽略）
+func (b *Balancer) SetBalanceProvider(provider selector.BalanceProvider) {
+	if aware, ok := b.selector.(selector.BalanceAware); ok {
+		aware.SetBalanceProvider(provider)
+	}
 }
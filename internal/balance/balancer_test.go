@@ -1,9 +1,12 @@
 package balance
 
 import (
+	"context"
 	"slices"
 	"testing"
+	"time"
 
+	"claude-code-lb/internal/selector"
 	"claude-code-lb/internal/testutil"
 	"claude-code-lb/pkg/types"
 )
@@ -285,6 +288,98 @@ func TestBalancerRecoverServer(t *testing.T) {
 	}
 }
 
+func TestBalancerWaitForServer(t *testing.T) {
+	config := types.Config{
+		Mode:      "load_balance",
+		Algorithm: "round_robin",
+		Cooldown:  60,
+		Servers: []types.UpstreamServer{
+			{URL: testutil.API1ExampleURL, Token: testutil.TestToken1},
+		},
+	}
+
+	t.Run("returns immediately when available", func(t *testing.T) {
+		balancer := New(config)
+		server, err := balancer.WaitForServer(context.Background(), selector.SelectOptions{}, time.Second)
+		if err != nil || server.URL != testutil.API1ExampleURL {
+			t.Fatalf("Expected %s, got %v (err: %v)", testutil.API1ExampleURL, server, err)
+		}
+	})
+
+	t.Run("times out when nothing recovers", func(t *testing.T) {
+		balancer := New(config)
+		balancer.MarkServerDown(testutil.API1ExampleURL)
+
+		start := time.Now()
+		if _, err := balancer.WaitForServer(context.Background(), selector.SelectOptions{}, 50*time.Millisecond); err == nil {
+			t.Fatal("Expected error after timeout")
+		}
+		if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+			t.Errorf("Expected to wait for the full timeout, returned after %v", elapsed)
+		}
+	})
+
+	t.Run("wakes up on recovery", func(t *testing.T) {
+		balancer := New(config)
+		balancer.MarkServerDown(testutil.API1ExampleURL)
+
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			balancer.RecoverServer(testutil.API1ExampleURL)
+		}()
+
+		start := time.Now()
+		server, err := balancer.WaitForServer(context.Background(), selector.SelectOptions{}, 5*time.Second)
+		if err != nil {
+			t.Fatalf("Expected server after recovery, got error: %v", err)
+		}
+		if server.URL != testutil.API1ExampleURL {
+			t.Errorf("Expected %s, got %s", testutil.API1ExampleURL, server.URL)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("Expected to wake up shortly after recovery, waited %v", elapsed)
+		}
+	})
+
+	t.Run("keeps select options after recovery", func(t *testing.T) {
+		regionConfig := config
+		regionConfig.Servers = []types.UpstreamServer{
+			{URL: testutil.API1ExampleURL, Token: testutil.TestToken1, Region: "eu"},
+			{URL: testutil.API2ExampleURL, Token: testutil.TestToken2, Region: "us"},
+		}
+		balancer := New(regionConfig)
+		balancer.MarkServerDown(testutil.API1ExampleURL)
+		balancer.MarkServerDown(testutil.API2ExampleURL)
+
+		// 两个服务器同时恢复后只唤醒一次，轮询会选择 us 区域的服务器，按区域选择应返回 eu 区域的服务器
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			balancer.selector.RecoverServer(testutil.API1ExampleURL)
+			balancer.selector.RecoverServer(testutil.API2ExampleURL)
+			balancer.notifyRecovered()
+		}()
+
+		server, err := balancer.WaitForServer(context.Background(), selector.SelectOptions{Region: "eu"}, 5*time.Second)
+		if err != nil {
+			t.Fatalf("Expected server after recovery, got error: %v", err)
+		}
+		if server.URL != testutil.API1ExampleURL {
+			t.Errorf("Expected server in requested region %s, got %s", testutil.API1ExampleURL, server.URL)
+		}
+	})
+
+	t.Run("stops when context is cancelled", func(t *testing.T) {
+		balancer := New(config)
+		balancer.MarkServerDown(testutil.API1ExampleURL)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := balancer.WaitForServer(ctx, selector.SelectOptions{}, 5*time.Second); err != context.Canceled {
+			t.Errorf("Expected context.Canceled, got %v", err)
+		}
+	})
+}
+
 func TestBalancerIntegration(t *testing.T) {
 	config := types.Config{
 		Mode:      "load_balance",
@@ -327,3 +422,198 @@ func TestBalancerIntegration(t *testing.T) {
 		t.Fatalf("GetNextServer failed after recovery: %v", err)
 	}
 }
+
+func TestErrorRateTracker(t *testing.T) {
+	tracker := newErrorRateTracker(DefaultErrorRateWindow)
+	now := time.Unix(1700000000, 0)
+	tracker.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		tracker.record(testutil.API1ExampleURL, true)
+	}
+	tracker.record(testutil.API1ExampleURL, false)
+
+	rate := tracker.errorRate(testutil.API1ExampleURL)
+	if rate.Requests != 4 || rate.Failures != 1 || rate.Rate != 0.25 {
+		t.Errorf("Unexpected error rate: %+v", rate)
+	}
+
+	// 窗口内的旧样本仍然计入
+	now = now.Add(30 * time.Second)
+	tracker.record(testutil.API1ExampleURL, false)
+	rate = tracker.errorRate(testutil.API1ExampleURL)
+	if rate.Requests != 5 || rate.Failures != 2 {
+		t.Errorf("Unexpected error rate after 30s: %+v", rate)
+	}
+
+	// 超出窗口的样本被丢弃
+	now = now.Add(45 * time.Second)
+	rate = tracker.errorRate(testutil.API1ExampleURL)
+	if rate.Requests != 1 || rate.Failures != 1 || rate.Rate != 1 {
+		t.Errorf("Unexpected error rate after window slides: %+v", rate)
+	}
+
+	// 未记录过的服务器返回零值
+	if rate := tracker.errorRate(testutil.API2ExampleURL); rate.Requests != 0 || rate.Rate != 0 {
+		t.Errorf("Expected empty error rate, got %+v", rate)
+	}
+}
+
+func TestBalancerErrorRateTrip(t *testing.T) {
+	config := types.Config{
+		Mode:                 "load_balance",
+		Algorithm:            "round_robin",
+		Cooldown:             1,
+		ErrorRateThreshold:   0.5,
+		ErrorRateMinRequests: 4,
+		Servers: []types.UpstreamServer{
+			{URL: testutil.API1ExampleURL, Token: testutil.TestToken1},
+		},
+	}
+	balancer := New(config)
+
+	// 请求数不足时使用普通冷却
+	balancer.MarkServerHealthy(testutil.API1ExampleURL)
+	balancer.MarkServerDown(testutil.API1ExampleURL)
+	if remaining := time.Until(balancer.GetServerDownUntil(testutil.API1ExampleURL)); remaining > 5*time.Second {
+		t.Errorf("Expected normal cooldown before min requests, got %v", remaining)
+	}
+
+	// 达到最少请求数且错误率超过阈值时熔断
+	balancer.MarkServerHealthy(testutil.API1ExampleURL)
+	balancer.MarkServerDown(testutil.API1ExampleURL)
+
+	rate := balancer.GetErrorRate(testutil.API1ExampleURL)
+	if rate.Requests != 4 || rate.Failures != 2 {
+		t.Errorf("Unexpected error rate: %+v", rate)
+	}
+	if remaining := time.Until(balancer.GetServerDownUntil(testutil.API1ExampleURL)); remaining < ErrorRateTripCooldown-time.Second {
+		t.Errorf("Expected trip cooldown %v, got %v", ErrorRateTripCooldown, remaining)
+	}
+}
+
+func TestBalancerNetworkOutage(t *testing.T) {
+	config := types.Config{
+		Mode:      "load_balance",
+		Algorithm: "round_robin",
+		Cooldown:  60,
+		Servers: []types.UpstreamServer{
+			{URL: testutil.API1ExampleURL, Token: testutil.TestToken1},
+			{URL: testutil.API2ExampleURL, Token: testutil.TestToken2},
+			{URL: testutil.API3ExampleURL, Token: testutil.TestToken3},
+		},
+	}
+	balancer := New(config)
+
+	// 所有服务器在短时间内相继失败：最后一个失败时判定为本地网络故障，
+	// 不计入这次失败，并撤销此前已计入的失败，所有服务器的失败次数保持不变
+	for _, server := range config.Servers {
+		balancer.MarkServerDown(server.URL)
+	}
+	for _, server := range config.Servers {
+		if failures := balancer.GetFailureCount(server.URL); failures != 0 {
+			t.Errorf("Server %s: expected failure count 0 after suspected outage, got %d", server.URL, failures)
+		}
+		if remaining := time.Until(balancer.GetServerDownUntil(server.URL)); remaining > 61*time.Second {
+			t.Errorf("Server %s: expected base cooldown during suspected outage, got %v", server.URL, remaining)
+		}
+	}
+
+	// 网络恢复后服务器再次失败，不应因网络故障期间的失败而延长冷却时间
+	for _, server := range config.Servers {
+		balancer.RecoverServer(server.URL)
+	}
+	balancer.MarkServerDown(testutil.API1ExampleURL)
+	if remaining := time.Until(balancer.GetServerDownUntil(testutil.API1ExampleURL)); remaining > 61*time.Second {
+		t.Errorf("Expected base cooldown during suspected outage, got %v", remaining)
+	}
+
+	// 成功请求说明网络正常，此后的失败正常计入失败次数并退避
+	balancer.RecoverServer(testutil.API1ExampleURL)
+	balancer.MarkServerHealthy(testutil.API2ExampleURL)
+	balancer.MarkServerDown(testutil.API1ExampleURL)
+	balancer.MarkServerDown(testutil.API1ExampleURL)
+	if failures := balancer.GetFailureCount(testutil.API1ExampleURL); failures != 2 {
+		t.Errorf("Expected failures to count once the network is proven up, got %d", failures)
+	}
+	if remaining := time.Until(balancer.GetServerDownUntil(testutil.API1ExampleURL)); remaining < 119*time.Second {
+		t.Errorf("Expected backoff cooldown once the network is proven up, got %v", remaining)
+	}
+}
+
+func TestBalancerNetworkOutageUndoesMaxFailures(t *testing.T) {
+	config := types.Config{
+		Mode:        "load_balance",
+		Algorithm:   "round_robin",
+		Cooldown:    60,
+		MaxFailures: 1,
+		Servers: []types.UpstreamServer{
+			{URL: testutil.API1ExampleURL, Token: testutil.TestToken1},
+			{URL: testutil.API2ExampleURL, Token: testutil.TestToken2},
+		},
+	}
+	balancer := New(config)
+
+	// 第一个服务器连续失败超过 max_failures 被禁用，随后第二个服务器也失败：
+	// 判定为本地网络故障，撤销第一个服务器的失败次数和禁用
+	balancer.MarkServerDown(testutil.API1ExampleURL)
+	balancer.MarkServerDown(testutil.API1ExampleURL)
+	if !balancer.IsServerDisabled(testutil.API1ExampleURL) {
+		t.Fatal("Expected server to be disabled after exceeding max_failures")
+	}
+	balancer.MarkServerDown(testutil.API2ExampleURL)
+
+	if balancer.IsServerDisabled(testutil.API1ExampleURL) {
+		t.Error("Expected max_failures disable to be undone during suspected outage")
+	}
+	for _, server := range config.Servers {
+		if failures := balancer.GetFailureCount(server.URL); failures != 0 {
+			t.Errorf("Server %s: expected failure count 0, got %d", server.URL, failures)
+		}
+	}
+	if remaining := time.Until(balancer.GetServerDownUntil(testutil.API1ExampleURL)); remaining > 61*time.Second {
+		t.Errorf("Expected backoff to be undone, got %v", remaining)
+	}
+}
+
+func TestBalancerNetworkOutageKeepsReason(t *testing.T) {
+	config := types.Config{
+		Mode:      "load_balance",
+		Algorithm: "round_robin",
+		Cooldown:  60,
+		Servers: []types.UpstreamServer{
+			{URL: testutil.API1ExampleURL, Token: testutil.TestToken1},
+			{URL: testutil.API2ExampleURL, Token: testutil.TestToken2},
+		},
+	}
+	balancer := New(config)
+
+	// 判定为本地网络故障时不计入失败次数，但仍记录实际的失败原因
+	balancer.MarkServerDownWithReasonFor(testutil.API1ExampleURL, selector.DownReasonConnection, 0)
+	balancer.MarkServerDownWithReasonFor(testutil.API2ExampleURL, selector.DownReasonRateLimited, 5*time.Second)
+	if reason := balancer.GetDownReason(testutil.API2ExampleURL); reason != selector.DownReasonRateLimited {
+		t.Errorf("Expected rate_limited reason during suspected outage, got %q", reason)
+	}
+	if remaining := time.Until(balancer.GetServerDownUntil(testutil.API2ExampleURL)); remaining > 5*time.Second {
+		t.Errorf("Expected requested cooldown of at most 5s, got %v", remaining)
+	}
+}
+
+func TestBalancerSingleServerNoOutageDetection(t *testing.T) {
+	config := types.Config{
+		Mode:      "load_balance",
+		Algorithm: "round_robin",
+		Cooldown:  60,
+		Servers: []types.UpstreamServer{
+			{URL: testutil.API1ExampleURL, Token: testutil.TestToken1},
+		},
+	}
+	balancer := New(config)
+
+	// 只有一个服务器时无法区分网络故障和服务器故障，按正常退避处理
+	balancer.MarkServerDown(testutil.API1ExampleURL)
+	balancer.MarkServerDown(testutil.API1ExampleURL)
+	if remaining := time.Until(balancer.GetServerDownUntil(testutil.API1ExampleURL)); remaining < 119*time.Second {
+		t.Errorf("Expected backoff cooldown with a single server, got %v", remaining)
+	}
+}
@@ -0,0 +1,106 @@
+package balance
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultErrorRateWindow 错误率统计的时间窗口
+	DefaultErrorRateWindow = 60 * time.Second
+	// DefaultErrorRateMinRequests 触发错误率熔断所需的最少请求数
+	DefaultErrorRateMinRequests = 10
+	// ErrorRateTripCooldown 错误率熔断后服务器的冷却时间
+	ErrorRateTripCooldown = 5 * time.Minute
+
+	// errorRateBucketCount 时间窗口划分的桶数（默认窗口下每桶 1 秒）
+	errorRateBucketCount = 60
+)
+
+// ErrorRate 服务器在时间窗口内的请求结果统计
+type ErrorRate struct {
+	Requests int64   `json:"requests"`
+	Failures int64   `json:"failures"`
+	Rate     float64 `json:"rate"` // 失败率，0~1
+}
+
+// errorRateBucket 一个时间桶内的成功/失败计数
+type errorRateBucket struct {
+	slot      int64 // 桶对应的时间片序号（用于判断桶是否过期）
+	successes int64
+	failures  int64
+}
+
+// errorRateTracker 按服务器统计滑动时间窗口内的成功/失败次数
+// 每个服务器使用固定数量的环形时间桶，内存占用与请求量无关
+type errorRateTracker struct {
+	bucketWidth time.Duration
+	buckets     map[string]*[errorRateBucketCount]errorRateBucket
+	mutex       sync.Mutex
+	now         func() time.Time
+}
+
+func newErrorRateTracker(window time.Duration) *errorRateTracker {
+	if window <= 0 {
+		window = DefaultErrorRateWindow
+	}
+	return &errorRateTracker{
+		bucketWidth: window / errorRateBucketCount,
+		buckets:     make(map[string]*[errorRateBucketCount]errorRateBucket),
+		now:         time.Now,
+	}
+}
+
+// slot 返回当前时间对应的桶序号
+func (t *errorRateTracker) slot(now time.Time) int64 {
+	return now.UnixNano() / int64(t.bucketWidth)
+}
+
+// record 记录一次请求结果
+func (t *errorRateTracker) record(url string, success bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	ring, exists := t.buckets[url]
+	if !exists {
+		ring = &[errorRateBucketCount]errorRateBucket{}
+		t.buckets[url] = ring
+	}
+
+	slot := t.slot(t.now())
+	bucket := &ring[slot%errorRateBucketCount]
+	if bucket.slot != slot {
+		// 桶已过期，重新开始计数
+		*bucket = errorRateBucket{slot: slot}
+	}
+	if success {
+		bucket.successes++
+	} else {
+		bucket.failures++
+	}
+}
+
+// errorRate 返回服务器在时间窗口内的错误率
+func (t *errorRateTracker) errorRate(url string) ErrorRate {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var result ErrorRate
+	ring, exists := t.buckets[url]
+	if !exists {
+		return result
+	}
+
+	current := t.slot(t.now())
+	for _, bucket := range ring {
+		if current-bucket.slot >= errorRateBucketCount {
+			continue
+		}
+		result.Requests += bucket.successes + bucket.failures
+		result.Failures += bucket.failures
+	}
+	if result.Requests > 0 {
+		result.Rate = float64(result.Failures) / float64(result.Requests)
+	}
+	return result
+}
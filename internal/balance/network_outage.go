@@ -0,0 +1,94 @@
+package balance
+
+import (
+	"sync"
+	"time"
+
+	"claude-code-lb/pkg/types"
+)
+
+// DefaultNetworkOutageWindow 所有服务器都在此时间窗口内失败时，判定为本地网络故障而不是服务器故障
+// 基础冷却时间更长时使用冷却时间（见 networkOutageWindow）
+const DefaultNetworkOutageWindow = 10 * time.Second
+
+// networkOutageWindow 返回网络故障的判定窗口：不短于基础冷却时间
+// 先失败的服务器在冷却期内不会收到请求、也不会再次失败，窗口短于冷却时间时可能永远无法判定
+func networkOutageWindow(config types.Config) time.Duration {
+	return max(DefaultNetworkOutageWindow, time.Duration(config.Cooldown)*time.Second)
+}
+
+// outageDetector 记录每个服务器最近一次失败的时间，识别"所有服务器同时失败"的本地网络故障
+// 只统计 servers 中的服务器（不含应急服务器），少于 2 个服务器时无法区分，始终不判定
+type outageDetector struct {
+	servers     []string
+	window      time.Duration
+	lastFailure map[string]time.Time
+	counted     map[string][]time.Time // 计入了失败次数的失败时间，判定为网络故障时撤销窗口内的这些失败
+	mutex       sync.Mutex
+	now         func() time.Time
+}
+
+func newOutageDetector(servers []types.UpstreamServer, window time.Duration) *outageDetector {
+	if window <= 0 {
+		window = DefaultNetworkOutageWindow
+	}
+	urls := make([]string, 0, len(servers))
+	for _, server := range servers {
+		urls = append(urls, server.URL)
+	}
+	return &outageDetector{
+		servers:     urls,
+		window:      window,
+		lastFailure: make(map[string]time.Time),
+		counted:     make(map[string][]time.Time),
+		now:         time.Now,
+	}
+}
+
+// recordFailure 记录一次失败，返回是否所有服务器都在时间窗口内失败过
+// 未判定为网络故障时这次失败会计入失败次数，记录下来以便之后撤销；
+// 判定为网络故障时返回各服务器在窗口内已计入的失败数量（需要撤销），并清空这些记录
+func (d *outageDetector) recordFailure(url string) (bool, map[string]int) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	now := d.now()
+	d.lastFailure[url] = now
+
+	if len(d.servers) < 2 {
+		return false, nil
+	}
+	for _, server := range d.servers {
+		failedAt, exists := d.lastFailure[server]
+		if !exists || now.Sub(failedAt) > d.window {
+			d.counted[url] = append(d.withinWindow(url, now), now)
+			return false, nil
+		}
+	}
+
+	forgiven := make(map[string]int)
+	for server := range d.counted {
+		if count := len(d.withinWindow(server, now)); count > 0 {
+			forgiven[server] = count
+		}
+	}
+	clear(d.counted)
+	return true, forgiven
+}
+
+// withinWindow 返回服务器在时间窗口内计入的失败时间（调用方需持有 mutex）
+func (d *outageDetector) withinWindow(url string, now time.Time) []time.Time {
+	times := d.counted[url]
+	for len(times) > 0 && now.Sub(times[0]) > d.window {
+		times = times[1:]
+	}
+	return times
+}
+
+// recordSuccess 记录一次成功：请求能到达该服务器说明本地网络正常，清除其失败记录
+func (d *outageDetector) recordSuccess(url string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	delete(d.lastFailure, url)
+	delete(d.counted, url)
+}
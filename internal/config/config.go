@@ -1,18 +1,55 @@
 package config
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"net"
+	"net/http"
 	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
 
 	"claude-code-lb/pkg/types"
 )
 
+// DefaultRemoteConfigTimeout 获取远程配置的超时时间
+const DefaultRemoteConfigTimeout = 10 * time.Second
+
+// 覆盖配置文件的环境变量
+const (
+	EnvAuthKeys = "AUTH_KEYS" // 逗号分隔的客户端 API Key
+	EnvServers  = "SERVERS"   // JSON 数组格式的服务器列表
+)
+
+// DefaultMaxRequestBodyBytes 未配置 max_request_body_bytes 时请求体的最大字节数（与 Anthropic API 的请求大小上限一致）
+const DefaultMaxRequestBodyBytes = 32 << 20
+
+// DefaultTrustedProxies 未配置 trusted_proxies 时信任的代理网段（常见内网地址）
+var DefaultTrustedProxies = []string{
+	"127.0.0.0/8",    // 回环地址段
+	"172.16.0.0/12",  // Docker默认网段
+	"10.0.0.0/8",     // 私有网段A类
+	"192.168.0.0/16", // 私有网段C类
+}
+
+// Load 从默认位置加载配置，出错时直接退出进程
 func Load() types.Config {
-	return LoadWithPath("")
+	config, err := LoadWithPath("")
+	if err != nil {
+		log.Fatal(err)
+	}
+	return config
 }
 
-func LoadWithPath(configPath string) types.Config {
+// LoadWithPath 从指定路径或 http(s):// 地址加载配置并应用默认值
+func LoadWithPath(configPath string) (types.Config, error) {
 	var configFile string
 	if configPath != "" {
 		configFile = configPath
@@ -20,33 +57,125 @@ func LoadWithPath(configPath string) types.Config {
 		configFile = getEnv("CONFIG_FILE", "config.json")
 	}
 
-	if _, err := os.Stat(configFile); err != nil {
-		log.Fatalf("Config file %s not found. Please create it based on config.example.json", configFile)
+	var config types.Config
+	data, err := readConfigSource(configFile)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &config); err != nil {
+			return types.Config{}, fmt.Errorf("failed to parse config file: %w", err)
+		}
+	case os.Getenv(EnvServers) != "" && errors.Is(err, os.ErrNotExist):
+		// 通过环境变量提供服务器列表时，配置文件可以不存在
+		log.Printf("Config file %s not found, using environment variables only", configFile)
+	default:
+		return types.Config{}, err
 	}
 
-	data, err := os.ReadFile(configFile)
+	// 环境变量优先于配置文件
+	config, err = applyEnvOverrides(config)
 	if err != nil {
-		log.Fatalf("Failed to read config file: %v", err)
-	}
-
-	var config types.Config
-	if err := json.Unmarshal(data, &config); err != nil {
-		log.Fatalf("Failed to parse config file: %v", err)
+		return types.Config{}, err
 	}
 
 	log.Printf("Loading configuration format")
-	config = applyDefaults(config)
+	config, err = applyDefaults(config)
+	if err != nil {
+		return types.Config{}, err
+	}
 
 	// 设置日志 debug 模式
 	if config.Debug {
 		log.Printf("Debug mode enabled")
 	}
 
-	return config
+	return config, nil
+}
+
+// applyEnvOverrides 使用环境变量覆盖配置文件中的对应字段
+//   - AUTH_KEYS: 逗号分隔的客户端 API Key，设置后替换 auth_keys 并启用鉴权
+//   - SERVERS: JSON 数组格式的服务器列表，设置后替换 servers
+func applyEnvOverrides(config types.Config) (types.Config, error) {
+	if value := getEnv(EnvAuthKeys, ""); value != "" {
+		config.AuthKeys = splitCommaList(value)
+		config.Auth = len(config.AuthKeys) > 0
+	}
+
+	if value := getEnv(EnvServers, ""); value != "" {
+		var servers []types.UpstreamServer
+		if err := json.Unmarshal([]byte(value), &servers); err != nil {
+			return config, fmt.Errorf("failed to parse %s: %w", EnvServers, err)
+		}
+		config.Servers = servers
+	}
+
+	return config, nil
+}
+
+// splitCommaList 拆分逗号分隔的列表，忽略空白项
+func splitCommaList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// readConfigSource 读取配置内容：http(s):// 地址通过 HTTP 获取，其他视为本地文件路径
+func readConfigSource(source string) ([]byte, error) {
+	if isRemoteConfig(source) {
+		return fetchRemoteConfig(source, os.Getenv("CONFIG_TOKEN"))
+	}
+
+	if _, err := os.Stat(source); err != nil {
+		return nil, fmt.Errorf("config file %s not found. Please create it based on config.example.json: %w", source, err)
+	}
+
+	data, err := os.ReadFile(source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	return data, nil
+}
+
+// isRemoteConfig 判断配置来源是否为 HTTP(S) 地址
+func isRemoteConfig(source string) bool {
+	lower := strings.ToLower(source)
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://")
+}
+
+// fetchRemoteConfig 从配置服务器获取配置，token 非空时使用 Bearer 鉴权
+func fetchRemoteConfig(url string, token string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid remote config url %s: %w", url, err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	client := &http.Client{Timeout: DefaultRemoteConfigTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch remote config from %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch remote config from %s: unexpected status %d", url, resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read remote config from %s: %w", url, err)
+	}
+	return data, nil
 }
 
 // applyDefaults 应用默认值并验证配置
-func applyDefaults(config types.Config) types.Config {
+func applyDefaults(config types.Config) (types.Config, error) {
 	// 设置默认值
 	if config.Port == "" {
 		config.Port = "3000"
@@ -57,6 +186,48 @@ func applyDefaults(config types.Config) types.Config {
 	if config.Cooldown == 0 {
 		config.Cooldown = 60 // 默认1分钟冷却时间
 	}
+	if config.RequestTimeoutSeconds == 0 {
+		config.RequestTimeoutSeconds = 60 // 默认60秒请求超时
+	}
+	if config.WebhookFormat == "" {
+		config.WebhookFormat = "json"
+	}
+	if config.AccessLogFormat == "" {
+		config.AccessLogFormat = "text"
+	}
+	if config.MaxRequestBodyBytes == 0 {
+		config.MaxRequestBodyBytes = DefaultMaxRequestBodyBytes
+	}
+	if len(config.ForwardHeaderPrefixes) == 0 {
+		config.ForwardHeaderPrefixes = []string{"anthropic-ratelimit-", "x-ratelimit-", "retry-after"}
+	}
+	// 未设置权重的服务器使用 default_weight
+	if config.DefaultWeight > 0 {
+		config.Servers = slices.Clone(config.Servers)
+		for i := range config.Servers {
+			if config.Servers[i].Weight == 0 {
+				config.Servers[i].Weight = config.DefaultWeight
+			}
+		}
+	}
+	// 未设置 token 的服务器（包括应急和溢出服务器）使用 default_token，basic 鉴权的服务器不受影响
+	if config.DefaultToken != "" {
+		config.Servers = applyDefaultToken(config.Servers, config.DefaultToken)
+		config.EmergencyServers = applyDefaultToken(config.EmergencyServers, config.DefaultToken)
+		config.OverflowServers = applyDefaultToken(config.OverflowServers, config.DefaultToken)
+		if config.ShadowServer != nil {
+			shadow := applyDefaultToken([]types.UpstreamServer{*config.ShadowServer}, config.DefaultToken)[0]
+			config.ShadowServer = &shadow
+		}
+	}
+	// 未配置时信任内网代理；显式配置为 [] 时不信任任何代理
+	if config.TrustedProxies == nil {
+		config.TrustedProxies = slices.Clone(DefaultTrustedProxies)
+	}
+	if config.BackoffEnabled == nil {
+		backoffEnabled := true
+		config.BackoffEnabled = &backoffEnabled
+	}
 
 	// 处理模式配置（向后兼容）
 	if config.Mode == "" {
@@ -71,60 +242,396 @@ func applyDefaults(config types.Config) types.Config {
 	validateConfigConsistency(config)
 
 	// 验证配置
+	if err := Validate(config); err != nil {
+		return config, err
+	}
+	// 状态码范围在加载时解析一次，处理响应时直接匹配
+	config.SuccessStatusMatcher, _ = types.ParseStatusCodes(config.SuccessStatusCodes)
+
+	// 服务器配置的非致命提示
+	missingTokens := 0
+	for i, server := range config.Servers {
+		if server.Token == "" && server.AuthType != "basic" {
+			missingTokens++
+			log.Printf("WARNING: Server %d (%s): No token specified", i+1, server.URL)
+		}
+		if server.Weight <= 0 && (config.Algorithm == "weighted_round_robin" || config.Algorithm == "weighted_least_connections") {
+			log.Printf("WARNING: Server %d (%s): Weight should be > 0 for %s", i+1, server.URL, config.Algorithm)
+		}
+		if config.Algorithm == "weighted_balance" && server.BalanceCheck == "" {
+			log.Printf("INFO: Server %d (%s): No balance_check, weighted_balance will use static weight", i+1, server.URL)
+		}
+		// fallback模式下的优先级验证
+		if config.Mode == "fallback" && server.Priority == 0 {
+			log.Printf("INFO: Server %d (%s): Priority not set, will use weight-based priority", i+1, server.URL)
+		}
+	}
+
+	if config.InsecureSkipVerify {
+		log.Printf("WARNING: insecure_skip_verify is enabled, upstream TLS certificates will NOT be verified (development/internal use only)")
+	}
+	if config.MinTLSVersion == "1.0" || config.MinTLSVersion == "1.1" {
+		log.Printf("WARNING: min_tls_version %s is deprecated and insecure, use only for legacy upstreams", config.MinTLSVersion)
+	}
+
+	if config.DefaultRegion != "" && !slices.ContainsFunc(config.Servers, func(server types.UpstreamServer) bool {
+		return strings.EqualFold(server.Region, config.DefaultRegion)
+	}) {
+		log.Printf("WARNING: No server is in default_region %s, requests without a region will use all servers", config.DefaultRegion)
+	}
+
+	if missingTokens > 0 && missingTokens == len(config.Servers) && !config.TripOnAuthError {
+		log.Printf("WARNING: No server has a token, upstream 401/403 responses will not mark servers down unless trip_on_auth_error is enabled")
+	}
+
+	log.Printf("Configuration loaded: mode=%s, algorithm=%s, debug=%t", config.Mode, config.Algorithm, config.Debug)
+	return config, nil
+}
+
+// applyDefaultToken 返回为未设置 token 的服务器填充默认 token 的副本
+func applyDefaultToken(servers []types.UpstreamServer, token string) []types.UpstreamServer {
+	servers = slices.Clone(servers)
+	for i := range servers {
+		if servers[i].Token == "" && len(servers[i].Tokens) == 0 && servers[i].AuthType != "basic" {
+			servers[i].Token = token
+		}
+	}
+	return servers
+}
+
+// validateClientCert 校验双向 TLS 客户端证书：证书和私钥必须同时配置且能够加载
+func validateClientCert(prefix, certFile, keyFile string) error {
+	if certFile == "" && keyFile == "" {
+		return nil
+	}
+	if certFile == "" || keyFile == "" {
+		return fmt.Errorf("%sclient_cert_file and client_key_file must be set together", prefix)
+	}
+	if _, err := tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+		return fmt.Errorf("%sfailed to load client certificate: %w", prefix, err)
+	}
+	return nil
+}
+
+// Validate 验证配置，返回第一个发现的错误
+func Validate(config types.Config) error {
 	if len(config.Servers) == 0 {
-		log.Fatal("At least one upstream server is required")
+		return errors.New("at least one upstream server is required")
 	}
 
 	// 验证模式
 	validModes := []string{"load_balance", "fallback"}
-	isValidMode := false
-	for _, mode := range validModes {
-		if config.Mode == mode {
-			isValidMode = true
-			break
+	if !slices.Contains(validModes, config.Mode) {
+		return fmt.Errorf("invalid mode '%s'. Valid options: %v", config.Mode, validModes)
+	}
+
+	// 验证算法类型
+	if !slices.Contains(types.Algorithms, config.Algorithm) {
+		return fmt.Errorf("invalid algorithm '%s'. Valid options: %v", config.Algorithm, types.Algorithms)
+	}
+	for _, algorithm := range config.AlgorithmOverrides {
+		if !slices.Contains(types.Algorithms, algorithm) {
+			return fmt.Errorf("invalid algorithm_overrides entry '%s'. Valid options: %v", algorithm, types.Algorithms)
 		}
 	}
-	if !isValidMode {
-		log.Fatalf("Invalid mode '%s'. Valid options: %v", config.Mode, validModes)
+
+	// 验证 webhook 格式（空值等同于 json）
+	validWebhookFormats := []string{"json", "slack", "discord"}
+	if config.WebhookFormat != "" && !slices.Contains(validWebhookFormats, config.WebhookFormat) {
+		return fmt.Errorf("invalid webhook_format '%s'. Valid options: %v", config.WebhookFormat, validWebhookFormats)
 	}
 
-	// 验证算法类型
-	validAlgorithms := []string{"round_robin", "weighted_round_robin", "random"}
-	isValidAlgorithm := false
-	for _, algo := range validAlgorithms {
-		if config.Algorithm == algo {
-			isValidAlgorithm = true
-			break
+	// 验证随机数来源（空值等同于 crypto）
+	validRandomSources := []string{"crypto", "math"}
+	if config.RandomSource != "" && !slices.Contains(validRandomSources, config.RandomSource) {
+		return fmt.Errorf("invalid random_source '%s'. Valid options: %v", config.RandomSource, validRandomSources)
+	}
+
+	// 验证访问日志格式（空值等同于 text）
+	validAccessLogFormats := []string{"text", "json"}
+	if config.AccessLogFormat != "" && !slices.Contains(validAccessLogFormats, config.AccessLogFormat) {
+		return fmt.Errorf("invalid access_log_format '%s'. Valid options: %v", config.AccessLogFormat, validAccessLogFormats)
+	}
+
+	if config.LatencyEWMAAlpha < 0 || config.LatencyEWMAAlpha > 1 {
+		return fmt.Errorf("latency_ewma_alpha must be between 0 and 1, got %g", config.LatencyEWMAAlpha)
+	}
+
+	if _, err := types.ParseStatusCodes(config.SuccessStatusCodes); err != nil {
+		return fmt.Errorf("success_status_codes: %v", err)
+	}
+
+	if config.MaxRequestHeaders < 0 {
+		return fmt.Errorf("max_request_headers must be >= 0, got %d", config.MaxRequestHeaders)
+	}
+
+	if config.MaxRequestHeaderBytes < 0 {
+		return fmt.Errorf("max_request_header_bytes must be >= 0, got %d", config.MaxRequestHeaderBytes)
+	}
+
+	if config.MaxClientTimeoutMs < 0 {
+		return fmt.Errorf("max_client_timeout_ms must be >= 0, got %d", config.MaxClientTimeoutMs)
+	}
+
+	if config.StatsResetIntervalSeconds < 0 {
+		return fmt.Errorf("stats_reset_interval_seconds must be >= 0, got %d", config.StatsResetIntervalSeconds)
+	}
+
+	if config.IdleTimeoutSeconds < 0 {
+		return fmt.Errorf("idle_timeout_seconds must be >= 0, got %d", config.IdleTimeoutSeconds)
+	}
+
+	if config.ErrorBodyLogBytes < 0 {
+		return fmt.Errorf("error_body_log_bytes must be >= 0, got %d", config.ErrorBodyLogBytes)
+	}
+
+	if config.WarmupGraceSeconds < 0 {
+		return fmt.Errorf("warmup_grace_seconds must be >= 0, got %d", config.WarmupGraceSeconds)
+	}
+
+	if config.RequestTimeoutSeconds < 0 {
+		return fmt.Errorf("request_timeout_seconds must be >= 0, got %d", config.RequestTimeoutSeconds)
+	}
+
+	if config.ErrorRateThreshold < 0 || config.ErrorRateThreshold > 1 {
+		return fmt.Errorf("error_rate_threshold must be between 0 and 1, got %g", config.ErrorRateThreshold)
+	}
+
+	if config.ErrorRateMinRequests < 0 {
+		return fmt.Errorf("error_rate_min_requests must be >= 0, got %d", config.ErrorRateMinRequests)
+	}
+
+	if config.MaxFailures < 0 {
+		return fmt.Errorf("max_failures must be >= 0, got %d", config.MaxFailures)
+	}
+
+	if config.HMACMaxSkewSeconds < 0 {
+		return fmt.Errorf("hmac_max_skew_seconds must be >= 0, got %d", config.HMACMaxSkewSeconds)
+	}
+
+	if config.HealthCheckIntervalSeconds < 0 {
+		return fmt.Errorf("health_check_interval_seconds must be >= 0, got %d", config.HealthCheckIntervalSeconds)
+	}
+
+	if config.RateLimitCooldownSeconds < 0 {
+		return fmt.Errorf("rate_limit_cooldown_seconds must be >= 0, got %d", config.RateLimitCooldownSeconds)
+	}
+
+	if config.HealthCheckConcurrency < 0 {
+		return fmt.Errorf("health_check_concurrency must be >= 0, got %d", config.HealthCheckConcurrency)
+	}
+
+	if config.HealthCheckTimeoutSeconds < 0 {
+		return fmt.Errorf("health_check_timeout_seconds must be >= 0, got %d", config.HealthCheckTimeoutSeconds)
+	}
+
+	if config.RecoveryBatchSize < 0 {
+		return fmt.Errorf("recovery_batch_size must be >= 0, got %d", config.RecoveryBatchSize)
+	}
+
+	if config.EmergencyPriorityWindowSeconds < 0 {
+		return fmt.Errorf("emergency_priority_window_seconds must be >= 0, got %d", config.EmergencyPriorityWindowSeconds)
+	}
+
+	if config.FailbackDelaySeconds < 0 {
+		return fmt.Errorf("failback_delay_seconds must be >= 0, got %d", config.FailbackDelaySeconds)
+	}
+
+	if config.RetryBudgetPerSecond < 0 {
+		return fmt.Errorf("retry_budget_per_second must be >= 0, got %g", config.RetryBudgetPerSecond)
+	}
+
+	if config.RetryBudgetBurst < 0 {
+		return fmt.Errorf("retry_budget_burst must be >= 0, got %d", config.RetryBudgetBurst)
+	}
+
+	if config.ShadowPercent < 0 || config.ShadowPercent > 100 {
+		return fmt.Errorf("shadow_percent must be between 0 and 100, got %g", config.ShadowPercent)
+	}
+
+	if config.ShadowPercent > 0 && (config.ShadowServer == nil || config.ShadowServer.URL == "") {
+		return fmt.Errorf("shadow_server with a URL is required when shadow_percent > 0")
+	}
+
+	if config.CanaryPercent < 0 || config.CanaryPercent > 100 {
+		return fmt.Errorf("canary_percent must be between 0 and 100, got %g", config.CanaryPercent)
+	}
+
+	if config.CanaryPercent > 0 && config.Canary == "" {
+		return fmt.Errorf("canary is required when canary_percent > 0")
+	}
+
+	if config.Canary != "" {
+		if config.Mode != "load_balance" {
+			return fmt.Errorf("canary is only supported in load_balance mode")
+		}
+		if !slices.ContainsFunc(config.Servers, func(server types.UpstreamServer) bool { return server.URL == config.Canary }) {
+			return fmt.Errorf("canary '%s' must be the URL of a server in servers", config.Canary)
+		}
+	}
+
+	if config.SlowRequestThresholdMs < 0 {
+		return fmt.Errorf("slow_request_threshold_ms must be >= 0, got %d", config.SlowRequestThresholdMs)
+	}
+
+	if config.QueueWaitSeconds < 0 {
+		return fmt.Errorf("queue_wait_seconds must be >= 0, got %d", config.QueueWaitSeconds)
+	}
+
+	for _, endpoint := range config.CoalesceEndpoints {
+		if !strings.HasPrefix(endpoint, "/") {
+			return fmt.Errorf("coalesce_endpoints entries must start with '/', got '%s'", endpoint)
 		}
 	}
-	if !isValidAlgorithm {
-		log.Fatalf("Invalid algorithm '%s'. Valid options: %v", config.Algorithm, validAlgorithms)
+
+	validTLSVersions := []string{"1.0", "1.1", "1.2", "1.3"}
+	if config.MinTLSVersion != "" && !slices.Contains(validTLSVersions, config.MinTLSVersion) {
+		return fmt.Errorf("invalid min_tls_version '%s'. Valid options: %v", config.MinTLSVersion, validTLSVersions)
+	}
+
+	if config.CACertFile != "" {
+		pem, err := os.ReadFile(config.CACertFile)
+		if err != nil {
+			return fmt.Errorf("failed to read ca_cert_file: %w", err)
+		}
+		if !x509.NewCertPool().AppendCertsFromPEM(pem) {
+			return fmt.Errorf("ca_cert_file %s contains no PEM certificates", config.CACertFile)
+		}
+	}
+
+	if err := validateClientCert("", config.ClientCertFile, config.ClientKeyFile); err != nil {
+		return err
+	}
+	for i, server := range slices.Concat(config.Servers, config.EmergencyServers, config.OverflowServers) {
+		if err := validateClientCert(fmt.Sprintf("server %d (%s): ", i+1, server.URL), server.ClientCertFile, server.ClientKeyFile); err != nil {
+			return err
+		}
+	}
+
+	if config.IdleConnTimeoutSeconds < 0 {
+		return fmt.Errorf("idle_conn_timeout_seconds must be >= 0, got %d", config.IdleConnTimeoutSeconds)
+	}
+
+	if config.MaxConnsPerHost < 0 {
+		return fmt.Errorf("max_conns_per_host must be >= 0, got %d", config.MaxConnsPerHost)
+	}
+
+	if config.MaxRequestBodyBytes < 0 {
+		return fmt.Errorf("max_request_body_bytes must be >= 0, got %d", config.MaxRequestBodyBytes)
+	}
+
+	if config.MaxBufferResponseBytes < 0 {
+		return fmt.Errorf("max_buffer_response_bytes must be >= 0, got %d", config.MaxBufferResponseBytes)
+	}
+
+	if config.MaxConcurrentBalanceChecks < 0 {
+		return fmt.Errorf("max_concurrent_balance_checks must be >= 0, got %d", config.MaxConcurrentBalanceChecks)
+	}
+
+	if config.DefaultWeight < 0 {
+		return fmt.Errorf("default_weight must be >= 0, got %d", config.DefaultWeight)
+	}
+
+	if config.MaxStreamDurationSeconds < 0 {
+		return fmt.Errorf("max_stream_duration_seconds must be >= 0, got %d", config.MaxStreamDurationSeconds)
+	}
+
+	for _, proxy := range config.TrustedProxies {
+		if !isValidTrustedProxy(proxy) {
+			return fmt.Errorf("invalid trusted_proxies entry '%s', must be an IP or CIDR", proxy)
+		}
 	}
 
 	// 验证服务器配置
 	for i, server := range config.Servers {
 		if server.URL == "" {
-			log.Fatalf("Server %d: URL is required", i+1)
+			return fmt.Errorf("server %d: URL is required", i+1)
 		}
-		if server.Token == "" {
-			log.Printf("WARNING: Server %d (%s): No token specified", i+1, server.URL)
+		if server.RequestTimeoutSeconds < 0 {
+			return fmt.Errorf("server %d (%s): request_timeout_seconds must be >= 0, got %d", i+1, server.URL, server.RequestTimeoutSeconds)
 		}
-		if server.Weight <= 0 && config.Algorithm == "weighted_round_robin" {
-			log.Printf("WARNING: Server %d (%s): Weight should be > 0 for weighted_round_robin", i+1, server.URL)
+		if server.MaxConcurrent < 0 {
+			return fmt.Errorf("server %d (%s): max_concurrent must be >= 0, got %d", i+1, server.URL, server.MaxConcurrent)
 		}
-		// fallback模式下的优先级验证
-		if config.Mode == "fallback" && server.Priority == 0 {
-			log.Printf("INFO: Server %d (%s): Priority not set, will use weight-based priority", i+1, server.URL)
+		if server.BalanceCheckTimeoutSeconds < 0 {
+			return fmt.Errorf("server %d (%s): balance_check_timeout_seconds must be >= 0, got %d", i+1, server.URL, server.BalanceCheckTimeoutSeconds)
+		}
+		if server.BalanceScale < 0 {
+			return fmt.Errorf("server %d (%s): balance_scale must be >= 0, got %g", i+1, server.URL, server.BalanceScale)
+		}
+		if server.BalanceWarnThreshold != 0 && server.BalanceWarnThreshold <= server.BalanceThreshold {
+			return fmt.Errorf("server %d (%s): balance_warn_threshold (%g) must be greater than balance_threshold (%g)", i+1, server.URL, server.BalanceWarnThreshold, server.BalanceThreshold)
+		}
+		if server.BalanceCheckRegex != "" {
+			if _, err := regexp.Compile(server.BalanceCheckRegex); err != nil {
+				return fmt.Errorf("server %d (%s): invalid balance_check_regex: %v", i+1, server.URL, err)
+			}
+		}
+		if server.BalanceComparison != "" && !slices.Contains([]string{"lte", "lt"}, server.BalanceComparison) {
+			return fmt.Errorf("server %d (%s): invalid balance_comparison '%s', must be 'lte' or 'lt'", i+1, server.URL, server.BalanceComparison)
+		}
+		if server.AuthType != "" && !slices.Contains([]string{"bearer", "basic"}, server.AuthType) {
+			return fmt.Errorf("server %d (%s): invalid auth_type '%s', must be 'bearer' or 'basic'", i+1, server.URL, server.AuthType)
+		}
+		if server.AuthType == "basic" && server.Username == "" {
+			return fmt.Errorf("server %d (%s): username is required for basic auth", i+1, server.URL)
+		}
+		for model, weight := range server.ModelWeights {
+			if weight < 0 {
+				return fmt.Errorf("server %d (%s): model_weights[%s] must be >= 0, got %d", i+1, server.URL, model, weight)
+			}
+		}
+	}
+
+	// 验证应急服务器配置（不能与 servers 重复，否则两者会共享同一份状态）
+	for i, server := range config.EmergencyServers {
+		if server.URL == "" {
+			return fmt.Errorf("emergency server %d: URL is required", i+1)
+		}
+		if slices.ContainsFunc(config.Servers, func(s types.UpstreamServer) bool { return s.URL == server.URL }) {
+			return fmt.Errorf("emergency server %d (%s): URL is already listed in servers", i+1, server.URL)
+		}
+	}
+
+	// 验证溢出服务器配置（只在负载均衡模式下使用，不能与 servers 或 emergency_servers 重复）
+	if len(config.OverflowServers) > 0 && config.Mode != "load_balance" {
+		return errors.New("overflow_servers is only supported in load_balance mode")
+	}
+	for i, server := range config.OverflowServers {
+		if server.URL == "" {
+			return fmt.Errorf("overflow server %d: URL is required", i+1)
+		}
+		if slices.ContainsFunc(slices.Concat(config.Servers, config.EmergencyServers), func(s types.UpstreamServer) bool { return s.URL == server.URL }) {
+			return fmt.Errorf("overflow server %d (%s): URL is already listed in servers or emergency_servers", i+1, server.URL)
+		}
+		if server.MaxConcurrent < 0 {
+			return fmt.Errorf("overflow server %d (%s): max_concurrent must be >= 0, got %d", i+1, server.URL, server.MaxConcurrent)
 		}
 	}
 
 	// 验证认证配置
 	if config.Auth && len(config.AuthKeys) == 0 {
-		log.Fatal("Authentication enabled but no auth_keys specified")
+		return errors.New("authentication enabled but no auth_keys specified")
+	}
+	for i, key := range config.AdminKeys {
+		if key == "" {
+			return fmt.Errorf("admin_keys[%d] must not be empty", i)
+		}
+		// 客户端密钥不能同时作为管理密钥，否则任何客户端都能调用管理接口
+		if slices.Contains(config.AuthKeys, key) {
+			return fmt.Errorf("admin_keys[%d] is also listed in auth_keys", i)
+		}
 	}
 
-	log.Printf("Configuration loaded: mode=%s, algorithm=%s, debug=%t", config.Mode, config.Algorithm, config.Debug)
-	return config
+	return nil
+}
+
+// isValidTrustedProxy 判断是否为合法的 IP 地址或 CIDR 网段
+func isValidTrustedProxy(proxy string) bool {
+	if _, _, err := net.ParseCIDR(proxy); err == nil {
+		return true
+	}
+	return net.ParseIP(proxy) != nil
 }
 
 func getEnv(key, defaultValue string) string {
@@ -1,9 +1,14 @@
 package config
 
 import (
+	"bytes"
 	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 
@@ -140,7 +145,10 @@ func TestApplyDefaults(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := applyDefaults(tt.input)
+			result, err := applyDefaults(tt.input)
+			if err != nil {
+				t.Fatalf("applyDefaults() unexpected error: %v", err)
+			}
 
 			if result.Port != tt.expected.Port {
 				t.Errorf("Port = %v, want %v", result.Port, tt.expected.Port)
@@ -160,6 +168,15 @@ func TestApplyDefaults(t *testing.T) {
 			if result.Fallback != tt.expected.Fallback {
 				t.Errorf("Fallback = %v, want %v", result.Fallback, tt.expected.Fallback)
 			}
+			if result.BackoffEnabled == nil || !*result.BackoffEnabled {
+				t.Errorf("BackoffEnabled should default to true")
+			}
+			if !slices.Equal(result.TrustedProxies, DefaultTrustedProxies) {
+				t.Errorf("TrustedProxies = %v, want %v", result.TrustedProxies, DefaultTrustedProxies)
+			}
+			if result.MaxRequestBodyBytes != DefaultMaxRequestBodyBytes {
+				t.Errorf("MaxRequestBodyBytes = %d, want %d", result.MaxRequestBodyBytes, DefaultMaxRequestBodyBytes)
+			}
 			if len(result.Servers) != len(tt.expected.Servers) {
 				t.Fatalf("Servers length = %d, want %d", len(result.Servers), len(tt.expected.Servers))
 			}
@@ -209,7 +226,10 @@ func TestLoadWithPath(t *testing.T) {
 	}
 
 	// Test loading valid config
-	result := LoadWithPath(validConfigFile)
+	result, err := LoadWithPath(validConfigFile)
+	if err != nil {
+		t.Fatalf("LoadWithPath() unexpected error: %v", err)
+	}
 	if result.Port != "3000" {
 		t.Errorf("Expected port 3000, got %s", result.Port)
 	}
@@ -227,14 +247,138 @@ func TestLoadWithPath(t *testing.T) {
 		t.Fatalf("Failed to write invalid config file: %v", err)
 	}
 
-	// Test invalid JSON - this should cause the program to exit/panic
-	// We need to test this in a subprocess to avoid killing the test runner
-	// For now, just document that this path exists but can't be easily tested
-	// in unit tests due to log.Fatalf behavior
+	if _, err := LoadWithPath(invalidConfigFile); err == nil {
+		t.Error("Expected error for invalid JSON")
+	}
+
+	// Test missing file
+	if _, err := LoadWithPath(filepath.Join(tempDir, "missing.json")); err == nil {
+		t.Error("Expected error for missing config file")
+	}
+
+	// Test valid JSON that fails validation
+	noServersFile := filepath.Join(tempDir, "no_servers.json")
+	err = os.WriteFile(noServersFile, []byte(`{"port": "3000"}`), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write no-servers config file: %v", err)
+	}
+	if _, err := LoadWithPath(noServersFile); err == nil {
+		t.Error("Expected error for config without servers")
+	}
+}
+
+func TestLoadWithPathRemote(t *testing.T) {
+	validConfig := `{"servers": [{"url": "http://test-anthropic-api.local", "token": "test-token"}]}`
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/config.json":
+			w.Write([]byte(validConfig))
+		case "/secure.json":
+			if r.Header.Get("Authorization") != "Bearer config-secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(validConfig))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	// 从 URL 加载配置并应用默认值
+	result, err := LoadWithPath(server.URL + "/config.json")
+	if err != nil {
+		t.Fatalf("LoadWithPath() unexpected error: %v", err)
+	}
+	if len(result.Servers) != 1 || result.Port != "3000" {
+		t.Errorf("Unexpected remote config: %+v", result)
+	}
+
+	// 缺少 token 时返回错误
+	t.Setenv("CONFIG_TOKEN", "")
+	if _, err := LoadWithPath(server.URL + "/secure.json"); err == nil || !strings.Contains(err.Error(), "unexpected status 401") {
+		t.Errorf("Expected 401 error, got %v", err)
+	}
+
+	// 使用 Bearer token 鉴权
+	t.Setenv("CONFIG_TOKEN", "config-secret")
+	if _, err := LoadWithPath(server.URL + "/secure.json"); err != nil {
+		t.Errorf("LoadWithPath() with token unexpected error: %v", err)
+	}
+
+	// 通过环境变量指定 URL
+	t.Setenv("CONFIG_FILE", server.URL+"/config.json")
+	if _, err := LoadWithPath(""); err != nil {
+		t.Errorf("LoadWithPath() from CONFIG_FILE unexpected error: %v", err)
+	}
+
+	// 获取失败时返回错误
+	if _, err := LoadWithPath(server.URL + "/missing.json"); err == nil {
+		t.Error("Expected error for missing remote config")
+	}
+}
+
+func TestLoadWithPathEnv(t *testing.T) {
+	tempDir := t.TempDir()
+	missingFile := filepath.Join(tempDir, "missing.json")
+
+	// 只通过环境变量配置，配置文件不存在
+	t.Setenv(EnvServers, `[{"url": "http://env-api.local", "token": "env-token", "weight": 3}]`)
+	t.Setenv(EnvAuthKeys, "key1, key2,,")
+
+	result, err := LoadWithPath(missingFile)
+	if err != nil {
+		t.Fatalf("LoadWithPath() env-only unexpected error: %v", err)
+	}
+	if len(result.Servers) != 1 || result.Servers[0].URL != "http://env-api.local" || result.Servers[0].Weight != 3 {
+		t.Errorf("Unexpected servers from env: %+v", result.Servers)
+	}
+	if !result.Auth || !slices.Equal(result.AuthKeys, []string{"key1", "key2"}) {
+		t.Errorf("Expected auth enabled with keys [key1 key2], got auth=%t keys=%v", result.Auth, result.AuthKeys)
+	}
+	if result.Port != "3000" || result.Mode != "load_balance" {
+		t.Errorf("Expected defaults applied, got port=%s mode=%s", result.Port, result.Mode)
+	}
+
+	// 环境变量优先于配置文件
+	configFile := filepath.Join(tempDir, "config.json")
+	fileConfig := `{"port": "8080", "auth": true, "auth_keys": ["file-key"], "servers": [{"url": "http://file-api.local"}]}`
+	if err := os.WriteFile(configFile, []byte(fileConfig), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	result, err = LoadWithPath(configFile)
+	if err != nil {
+		t.Fatalf("LoadWithPath() unexpected error: %v", err)
+	}
+	if result.Port != "8080" {
+		t.Errorf("Expected port from file, got %s", result.Port)
+	}
+	if result.Servers[0].URL != "http://env-api.local" || !slices.Equal(result.AuthKeys, []string{"key1", "key2"}) {
+		t.Errorf("Expected env to override file, got servers=%+v keys=%v", result.Servers, result.AuthKeys)
+	}
+
+	// 只设置 AUTH_KEYS 时服务器仍来自配置文件
+	t.Setenv(EnvServers, "")
+	result, err = LoadWithPath(configFile)
+	if err != nil {
+		t.Fatalf("LoadWithPath() unexpected error: %v", err)
+	}
+	if result.Servers[0].URL != "http://file-api.local" {
+		t.Errorf("Expected servers from file, got %+v", result.Servers)
+	}
+
+	// 没有 SERVERS 时配置文件仍然是必需的
+	if _, err := LoadWithPath(missingFile); err == nil {
+		t.Error("Expected error for missing config file without SERVERS")
+	}
 
-	// Note: LoadWithPath calls log.Fatalf on invalid JSON, which calls os.Exit()
-	// This cannot be caught with recover() and would terminate the test process
-	// In a real scenario, this would exit the program with an error message
+	// SERVERS 格式错误
+	t.Setenv(EnvServers, "http://not-json.local")
+	if _, err := LoadWithPath(missingFile); err == nil || !strings.Contains(err.Error(), "failed to parse SERVERS") {
+		t.Errorf("Expected SERVERS parse error, got %v", err)
+	}
 }
 
 func TestGenerateExampleConfig(t *testing.T) {
@@ -262,14 +406,17 @@ func TestGenerateExampleConfig(t *testing.T) {
 	}
 
 	// Test that applying defaults doesn't fail
-	result := applyDefaults(config)
+	result, err := applyDefaults(config)
+	if err != nil {
+		t.Fatalf("applyDefaults() unexpected error: %v", err)
+	}
 	if result.Port == "" {
 		t.Error("Applied defaults should set a port")
 	}
 }
 
 func TestApplyDefaultsValidation(t *testing.T) {
-	// Test valid configurations (these should not cause log.Fatal)
+	// Test valid configurations
 	validTests := []struct {
 		name        string
 		config      types.Config
@@ -304,6 +451,16 @@ func TestApplyDefaultsValidation(t *testing.T) {
 			},
 			description: "Should not fail with valid random algorithm",
 		},
+		{
+			name: "valid weighted least connections algorithm",
+			config: types.Config{
+				Algorithm: "weighted_least_connections",
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token", Weight: 3},
+				},
+			},
+			description: "Should not fail with valid weighted least connections algorithm",
+		},
 		{
 			name: "valid fallback mode",
 			config: types.Config{
@@ -329,7 +486,10 @@ func TestApplyDefaultsValidation(t *testing.T) {
 
 	for _, tt := range validTests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := applyDefaults(tt.config)
+			result, err := applyDefaults(tt.config)
+			if err != nil {
+				t.Fatalf("applyDefaults() unexpected error: %v", err)
+			}
 
 			// Basic validation that defaults were applied
 			if result.Port == "" {
@@ -354,12 +514,763 @@ func TestApplyDefaultsValidation(t *testing.T) {
 		})
 	}
 
-	// Note: Invalid configurations like no servers, invalid algorithms, etc.
-	// cause log.Fatal which calls os.Exit(1) and cannot be tested in unit tests.
-	// These would need to be tested with subprocess testing or integration tests.
-	// Examples of configurations that would cause log.Fatal:
-	// - config.Servers empty
-	// - invalid mode (not "load_balance" or "fallback")
-	// - invalid algorithm (not in validAlgorithms list)
-	// - auth=true but authKeys empty
+	invalidTests := []struct {
+		name    string
+		config  types.Config
+		wantErr string
+	}{
+		{
+			name:    "no servers",
+			config:  types.Config{},
+			wantErr: "at least one upstream server is required",
+		},
+		{
+			name: "invalid mode",
+			config: types.Config{
+				Mode: "active_active",
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token"},
+				},
+			},
+			wantErr: "invalid mode 'active_active'",
+		},
+		{
+			name: "invalid algorithm",
+			config: types.Config{
+				Algorithm: "least_latency_magic",
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token"},
+				},
+			},
+			wantErr: "invalid algorithm 'least_latency_magic'",
+		},
+		{
+			name: "server without URL",
+			config: types.Config{
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token"},
+					{Token: "test-token"},
+				},
+			},
+			wantErr: "server 2: URL is required",
+		},
+		{
+			name: "negative idle conn timeout",
+			config: types.Config{
+				IdleConnTimeoutSeconds: -1,
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token"},
+				},
+			},
+			wantErr: "idle_conn_timeout_seconds must be >= 0",
+		},
+		{
+			name: "negative max conns per host",
+			config: types.Config{
+				MaxConnsPerHost: -1,
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token"},
+				},
+			},
+			wantErr: "max_conns_per_host must be >= 0",
+		},
+		{
+			name: "negative max request body bytes",
+			config: types.Config{
+				MaxRequestBodyBytes: -1,
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token"},
+				},
+			},
+			wantErr: "max_request_body_bytes must be >= 0",
+		},
+		{
+			name: "negative max buffer response bytes",
+			config: types.Config{
+				MaxBufferResponseBytes: -1,
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token"},
+				},
+			},
+			wantErr: "max_buffer_response_bytes must be >= 0",
+		},
+		{
+			name: "negative max concurrent balance checks",
+			config: types.Config{
+				MaxConcurrentBalanceChecks: -1,
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token"},
+				},
+			},
+			wantErr: "max_concurrent_balance_checks must be >= 0",
+		},
+		{
+			name: "invalid algorithm override",
+			config: types.Config{
+				AllowAlgorithmOverride: true,
+				AlgorithmOverrides:     []string{"random", "fastest"},
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token"},
+				},
+			},
+			wantErr: "invalid algorithm_overrides entry 'fastest'",
+		},
+		{
+			name: "negative emergency priority window",
+			config: types.Config{
+				EmergencyPriorityWindowSeconds: -1,
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token"},
+				},
+			},
+			wantErr: "emergency_priority_window_seconds must be >= 0",
+		},
+		{
+			name: "negative failback delay",
+			config: types.Config{
+				FailbackDelaySeconds: -1,
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token"},
+				},
+			},
+			wantErr: "failback_delay_seconds must be >= 0",
+		},
+		{
+			name: "negative retry budget",
+			config: types.Config{
+				RetryBudgetPerSecond: -1,
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token"},
+				},
+			},
+			wantErr: "retry_budget_per_second must be >= 0",
+		},
+		{
+			name: "canary not in servers",
+			config: types.Config{
+				Mode:          "load_balance",
+				Canary:        "http://test-canary.local",
+				CanaryPercent: 5,
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token"},
+				},
+			},
+			wantErr: "must be the URL of a server in servers",
+		},
+		{
+			name: "canary in fallback mode",
+			config: types.Config{
+				Mode:          "fallback",
+				Canary:        "http://test-anthropic-api.local",
+				CanaryPercent: 5,
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token", Priority: 1},
+				},
+			},
+			wantErr: "canary is only supported in load_balance mode",
+		},
+		{
+			name: "shadow percent without shadow server",
+			config: types.Config{
+				ShadowPercent: 10,
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token"},
+				},
+			},
+			wantErr: "shadow_server with a URL is required",
+		},
+		{
+			name: "shadow percent above 100",
+			config: types.Config{
+				ShadowServer:  &types.UpstreamServer{URL: "http://test-shadow.local"},
+				ShadowPercent: 150,
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token"},
+				},
+			},
+			wantErr: "shadow_percent must be between 0 and 100",
+		},
+		{
+			name: "negative retry budget burst",
+			config: types.Config{
+				RetryBudgetBurst: -1,
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token"},
+				},
+			},
+			wantErr: "retry_budget_burst must be >= 0",
+		},
+		{
+			name: "coalesce endpoint without leading slash",
+			config: types.Config{
+				CoalesceRequests:  true,
+				CoalesceEndpoints: []string{"v1/models"},
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token"},
+				},
+			},
+			wantErr: "coalesce_endpoints entries must start with '/'",
+		},
+		{
+			name: "invalid min tls version",
+			config: types.Config{
+				MinTLSVersion: "1.4",
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token"},
+				},
+			},
+			wantErr: "invalid min_tls_version '1.4'",
+		},
+		{
+			name: "missing ca cert file",
+			config: types.Config{
+				CACertFile: "/nonexistent/ca.pem",
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token"},
+				},
+			},
+			wantErr: "failed to read ca_cert_file",
+		},
+		{
+			name: "client cert without key",
+			config: types.Config{
+				ClientCertFile: "/etc/lb/client.pem",
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token"},
+				},
+			},
+			wantErr: "client_cert_file and client_key_file must be set together",
+		},
+		{
+			name: "missing server client cert",
+			config: types.Config{
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token", ClientCertFile: "/nonexistent/client.pem", ClientKeyFile: "/nonexistent/client.key"},
+				},
+			},
+			wantErr: "server 1 (http://test-anthropic-api.local): failed to load client certificate",
+		},
+		{
+			name: "negative rate limit cooldown",
+			config: types.Config{
+				RateLimitCooldownSeconds: -1,
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token"},
+				},
+			},
+			wantErr: "rate_limit_cooldown_seconds must be >= 0",
+		},
+		{
+			name: "negative health check concurrency",
+			config: types.Config{
+				HealthCheckConcurrency: -1,
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token"},
+				},
+			},
+			wantErr: "health_check_concurrency must be >= 0",
+		},
+		{
+			name: "invalid random source",
+			config: types.Config{
+				RandomSource: "dev-urandom",
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token"},
+				},
+			},
+			wantErr: "invalid random_source 'dev-urandom'",
+		},
+		{
+			name: "negative max request headers",
+			config: types.Config{
+				MaxRequestHeaders: -1,
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token"},
+				},
+			},
+			wantErr: "max_request_headers must be >= 0",
+		},
+		{
+			name: "negative max client timeout",
+			config: types.Config{
+				MaxClientTimeoutMs: -1,
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token"},
+				},
+			},
+			wantErr: "max_client_timeout_ms must be >= 0",
+		},
+		{
+			name: "invalid success status code",
+			config: types.Config{
+				SuccessStatusCodes: []string{"200-299", "2xx"},
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token"},
+				},
+			},
+			wantErr: "success_status_codes: invalid status code '2xx'",
+		},
+		{
+			name: "negative warmup grace",
+			config: types.Config{
+				WarmupGraceSeconds: -1,
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token"},
+				},
+			},
+			wantErr: "warmup_grace_seconds must be >= 0",
+		},
+		{
+			name: "negative error body log bytes",
+			config: types.Config{
+				ErrorBodyLogBytes: -1,
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token"},
+				},
+			},
+			wantErr: "error_body_log_bytes must be >= 0",
+		},
+		{
+			name: "negative idle timeout",
+			config: types.Config{
+				IdleTimeoutSeconds: -1,
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token"},
+				},
+			},
+			wantErr: "idle_timeout_seconds must be >= 0",
+		},
+		{
+			name: "negative stats reset interval",
+			config: types.Config{
+				StatsResetIntervalSeconds: -1,
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token"},
+				},
+			},
+			wantErr: "stats_reset_interval_seconds must be >= 0",
+		},
+		{
+			name: "latency ewma alpha above 1",
+			config: types.Config{
+				LatencyEWMAAlpha: 1.5,
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token"},
+				},
+			},
+			wantErr: "latency_ewma_alpha must be between 0 and 1",
+		},
+		{
+			name: "negative slow request threshold",
+			config: types.Config{
+				SlowRequestThresholdMs: -1,
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token"},
+				},
+			},
+			wantErr: "slow_request_threshold_ms must be >= 0",
+		},
+		{
+			name: "negative queue wait",
+			config: types.Config{
+				QueueWaitSeconds: -1,
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token"},
+				},
+			},
+			wantErr: "queue_wait_seconds must be >= 0",
+		},
+		{
+			name: "negative global request timeout",
+			config: types.Config{
+				RequestTimeoutSeconds: -1,
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token"},
+				},
+			},
+			wantErr: "request_timeout_seconds must be >= 0",
+		},
+		{
+			name: "negative server request timeout",
+			config: types.Config{
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token", RequestTimeoutSeconds: -5},
+				},
+			},
+			wantErr: "server 1 (http://test-anthropic-api.local): request_timeout_seconds must be >= 0",
+		},
+		{
+			name: "error rate threshold out of range",
+			config: types.Config{
+				ErrorRateThreshold: 1.5,
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token"},
+				},
+			},
+			wantErr: "error_rate_threshold must be between 0 and 1",
+		},
+		{
+			name: "negative balance check timeout",
+			config: types.Config{
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token", BalanceCheckTimeoutSeconds: -1},
+				},
+			},
+			wantErr: "server 1 (http://test-anthropic-api.local): balance_check_timeout_seconds must be >= 0",
+		},
+		{
+			name: "invalid balance comparison",
+			config: types.Config{
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token", BalanceComparison: "gte"},
+				},
+			},
+			wantErr: "server 1 (http://test-anthropic-api.local): invalid balance_comparison 'gte'",
+		},
+		{
+			name: "negative balance scale",
+			config: types.Config{
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token", BalanceScale: -0.01},
+				},
+			},
+			wantErr: "server 1 (http://test-anthropic-api.local): balance_scale must be >= 0",
+		},
+		{
+			name: "balance warn threshold not above balance threshold",
+			config: types.Config{
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token", BalanceThreshold: 10, BalanceWarnThreshold: 5},
+				},
+			},
+			wantErr: "server 1 (http://test-anthropic-api.local): balance_warn_threshold (5) must be greater than balance_threshold (10)",
+		},
+		{
+			name: "invalid balance check regex",
+			config: types.Config{
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token", BalanceCheckRegex: "([0-9"},
+				},
+			},
+			wantErr: "server 1 (http://test-anthropic-api.local): invalid balance_check_regex",
+		},
+		{
+			name: "invalid auth type",
+			config: types.Config{
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", AuthType: "digest"},
+				},
+			},
+			wantErr: "server 1 (http://test-anthropic-api.local): invalid auth_type 'digest'",
+		},
+		{
+			name: "basic auth without username",
+			config: types.Config{
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", AuthType: "basic", Password: "secret"},
+				},
+			},
+			wantErr: "server 1 (http://test-anthropic-api.local): username is required for basic auth",
+		},
+		{
+			name: "negative model weight",
+			config: types.Config{
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token", ModelWeights: map[string]int{"claude-3-haiku": -1}},
+				},
+			},
+			wantErr: "server 1 (http://test-anthropic-api.local): model_weights[claude-3-haiku] must be >= 0",
+		},
+		{
+			name: "negative default weight",
+			config: types.Config{
+				DefaultWeight: -1,
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token"},
+				},
+			},
+			wantErr: "default_weight must be >= 0",
+		},
+		{
+			name: "invalid trusted proxy",
+			config: types.Config{
+				TrustedProxies: []string{"10.0.0.0/8", "proxy.local"},
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token"},
+				},
+			},
+			wantErr: "invalid trusted_proxies entry 'proxy.local'",
+		},
+		{
+			name: "invalid access log format",
+			config: types.Config{
+				AccessLogFormat: "xml",
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token"},
+				},
+			},
+			wantErr: "invalid access_log_format 'xml'",
+		},
+		{
+			name: "negative recovery batch size",
+			config: types.Config{
+				RecoveryBatchSize: -1,
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token"},
+				},
+			},
+			wantErr: "recovery_batch_size must be >= 0",
+		},
+		{
+			name: "negative health check interval",
+			config: types.Config{
+				HealthCheckIntervalSeconds: -1,
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token"},
+				},
+			},
+			wantErr: "health_check_interval_seconds must be >= 0",
+		},
+		{
+			name: "negative hmac max skew",
+			config: types.Config{
+				HMACSecret:         "secret",
+				HMACMaxSkewSeconds: -1,
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token"},
+				},
+			},
+			wantErr: "hmac_max_skew_seconds must be >= 0",
+		},
+		{
+			name: "admin key reused as client key",
+			config: types.Config{
+				Mode:      "load_balance",
+				Auth:      true,
+				AuthKeys:  []string{"shared-key"},
+				AdminKeys: []string{"shared-key"},
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token"},
+				},
+			},
+			wantErr: "admin_keys[0] is also listed in auth_keys",
+		},
+		{
+			name: "negative max concurrent",
+			config: types.Config{
+				Mode: "load_balance",
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token", MaxConcurrent: -1},
+				},
+			},
+			wantErr: "max_concurrent must be >= 0",
+		},
+		{
+			name: "overflow servers in fallback mode",
+			config: types.Config{
+				Mode: "fallback",
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token", Priority: 1},
+				},
+				OverflowServers: []types.UpstreamServer{
+					{URL: "http://test-overflow.local", Token: "overflow-token"},
+				},
+			},
+			wantErr: "overflow_servers is only supported in load_balance mode",
+		},
+		{
+			name: "overflow server duplicates primary",
+			config: types.Config{
+				Mode: "load_balance",
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token"},
+				},
+				OverflowServers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "overflow-token"},
+				},
+			},
+			wantErr: "URL is already listed in servers or emergency_servers",
+		},
+		{
+			name: "emergency server duplicates primary",
+			config: types.Config{
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token"},
+				},
+				EmergencyServers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "backup-token"},
+				},
+			},
+			wantErr: "URL is already listed in servers",
+		},
+		{
+			name: "emergency server without url",
+			config: types.Config{
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token"},
+				},
+				EmergencyServers: []types.UpstreamServer{{Token: "backup-token"}},
+			},
+			wantErr: "emergency server 1: URL is required",
+		},
+		{
+			name: "invalid webhook format",
+			config: types.Config{
+				WebhookURL:    "http://hooks.test.local",
+				WebhookFormat: "teams",
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token"},
+				},
+			},
+			wantErr: "invalid webhook_format 'teams'",
+		},
+		{
+			name: "auth enabled without keys",
+			config: types.Config{
+				Auth: true,
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-anthropic-api.local", Token: "test-token"},
+				},
+			},
+			wantErr: "authentication enabled but no auth_keys specified",
+		},
+	}
+
+	for _, tt := range invalidTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := applyDefaults(tt.config)
+			if err == nil {
+				t.Fatalf("applyDefaults() expected error containing %q, got nil", tt.wantErr)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("applyDefaults() error = %q, want it to contain %q", err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestApplyDefaultsTrustedProxies(t *testing.T) {
+	servers := []types.UpstreamServer{{URL: "http://test-anthropic-api.local", Token: "test-token"}}
+
+	tests := []struct {
+		name     string
+		input    []string
+		expected []string
+	}{
+		{name: "unset uses default", input: nil, expected: DefaultTrustedProxies},
+		{name: "empty trusts none", input: []string{}, expected: []string{}},
+		{name: "custom list", input: []string{"10.0.0.5", "192.168.1.0/24"}, expected: []string{"10.0.0.5", "192.168.1.0/24"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := applyDefaults(types.Config{TrustedProxies: tt.input, Servers: servers})
+			if err != nil {
+				t.Fatalf("applyDefaults() unexpected error: %v", err)
+			}
+			if !slices.Equal(result.TrustedProxies, tt.expected) {
+				t.Errorf("TrustedProxies = %v, want %v", result.TrustedProxies, tt.expected)
+			}
+		})
+	}
+}
+
+func TestApplyDefaultsSuccessStatusMatcher(t *testing.T) {
+	result, err := applyDefaults(types.Config{
+		SuccessStatusCodes: []string{"200", "204"},
+		Servers:            []types.UpstreamServer{{URL: "http://test-anthropic-api.local", Token: "test-token"}},
+	})
+	if err != nil {
+		t.Fatalf("applyDefaults() unexpected error: %v", err)
+	}
+	if !result.SuccessStatusMatcher.Match(204) || result.SuccessStatusMatcher.Match(206) {
+		t.Errorf("SuccessStatusMatcher = %v, want 200 and 204 only", result.SuccessStatusMatcher)
+	}
+}
+
+func TestApplyDefaultsDefaultWeight(t *testing.T) {
+	input := types.Config{
+		DefaultWeight: 5,
+		Servers: []types.UpstreamServer{
+			{URL: "http://api1.test.local", Token: "test-token"},
+			{URL: "http://api2.test.local", Token: "test-token", Weight: 2},
+		},
+	}
+
+	result, err := applyDefaults(input)
+	if err != nil {
+		t.Fatalf("applyDefaults() unexpected error: %v", err)
+	}
+
+	if result.Servers[0].Weight != 5 {
+		t.Errorf("Servers[0].Weight = %d, want 5 (default_weight)", result.Servers[0].Weight)
+	}
+	if result.Servers[1].Weight != 2 {
+		t.Errorf("Servers[1].Weight = %d, want 2 (explicit weight preserved)", result.Servers[1].Weight)
+	}
+	if input.Servers[0].Weight != 0 {
+		t.Errorf("applyDefaults() should not modify the input servers")
+	}
+}
+
+func TestApplyDefaultsDefaultToken(t *testing.T) {
+	input := types.Config{
+		DefaultToken: "default-token",
+		Servers: []types.UpstreamServer{
+			{URL: "http://api1.test.local"},
+			{URL: "http://api2.test.local", Token: "own-token"},
+			{URL: "http://api3.test.local", Tokens: []string{"backup-token"}},
+			{URL: "http://api4.test.local", AuthType: "basic", Username: "user", Password: "password"},
+		},
+		EmergencyServers: []types.UpstreamServer{
+			{URL: "http://emergency.test.local"},
+		},
+	}
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	result, err := applyDefaults(input)
+	if err != nil {
+		t.Fatalf("applyDefaults() unexpected error: %v", err)
+	}
+
+	expected := []string{"default-token", "own-token", "", ""}
+	for i, token := range expected {
+		if result.Servers[i].Token != token {
+			t.Errorf("Servers[%d].Token = %q, want %q", i, result.Servers[i].Token, token)
+		}
+	}
+	if result.EmergencyServers[0].Token != "default-token" {
+		t.Errorf("EmergencyServers[0].Token = %q, want default token", result.EmergencyServers[0].Token)
+	}
+	if input.Servers[0].Token != "" {
+		t.Errorf("applyDefaults() should not modify the input servers")
+	}
+
+	// 继承默认 token 的服务器不再提示缺少 token
+	if strings.Contains(logs.String(), "http://api1.test.local): No token specified") {
+		t.Errorf("Expected no missing-token warning for server inheriting default_token, got: %s", logs.String())
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := types.Config{
+		Mode:      "load_balance",
+		Algorithm: "round_robin",
+		Servers: []types.UpstreamServer{
+			{URL: "http://test-anthropic-api.local", Token: "test-token"},
+		},
+	}
+	if err := Validate(valid); err != nil {
+		t.Errorf("Validate() unexpected error for valid config: %v", err)
+	}
+
+	// Validate 不应用默认值，空 mode 应视为无效
+	noMode := valid
+	noMode.Mode = ""
+	if err := Validate(noMode); err == nil {
+		t.Error("Validate() expected error for empty mode")
+	}
 }
@@ -1,41 +1,158 @@
 package health
 
 import (
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
 	"time"
 
 	"claude-code-lb/internal/balance"
 	"claude-code-lb/internal/logger"
+	"claude-code-lb/internal/proxy"
+	"claude-code-lb/internal/selector"
 	"claude-code-lb/pkg/types"
 )
 
+// DefaultHealthCheckInterval 未配置 health_check_interval_seconds 时的检查间隔
+const DefaultHealthCheckInterval = 5 * time.Second
+
+// DefaultHealthCheckConcurrency 未配置 health_check_concurrency 时同时进行的主动探测数量
+const DefaultHealthCheckConcurrency = 4
+
 type Checker struct {
-	config   types.Config
-	balancer *balance.Balancer
+	config       types.Config
+	balancer     *balance.Balancer
+	interval     time.Duration                       // 检查间隔（与冷却时间无关，保证冷却到期后及时恢复）
+	clientFor    func(serverURL string) *http.Client // 主动探测使用的上游客户端（与代理请求的 TLS 配置相同）
+	probeTimeout time.Duration                       // 单次主动探测的超时时间（health_check_timeout_seconds）
+	concurrency  int                                 // 同时进行的主动探测数量
+	stopChan     chan struct{}
+	stopOnce     sync.Once
 }
 
 func NewChecker(config types.Config, balancer *balance.Balancer) *Checker {
+	interval := DefaultHealthCheckInterval
+	if config.HealthCheckIntervalSeconds > 0 {
+		interval = time.Duration(config.HealthCheckIntervalSeconds) * time.Second
+	}
+
+	probeTimeout := DefaultProbeTimeout
+	if config.HealthCheckTimeoutSeconds > 0 {
+		probeTimeout = time.Duration(config.HealthCheckTimeoutSeconds) * time.Second
+	}
+	concurrency := DefaultHealthCheckConcurrency
+	if config.HealthCheckConcurrency > 0 {
+		concurrency = config.HealthCheckConcurrency
+	}
+
 	return &Checker{
-		config:   config,
-		balancer: balancer,
+		config:       config,
+		balancer:     balancer,
+		interval:     interval,
+		clientFor:    proxy.NewClientResolver(config),
+		probeTimeout: probeTimeout,
+		concurrency:  concurrency,
+		stopChan:     make(chan struct{}),
 	}
 }
 
 // PassiveHealthCheck 被动健康检查：定期检查冷却时间到期的服务器，将其标记为可用
 func (h *Checker) PassiveHealthCheck() {
-	ticker := time.NewTicker(time.Duration(h.config.Cooldown) * time.Second)
+	ticker := time.NewTicker(h.interval)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		now := time.Now()
-		serverStatus := h.balancer.GetServerStatus()
+	for {
+		select {
+		case <-ticker.C:
+			h.recoverExpired(time.Now())
+			if h.config.ActiveHealthCheck {
+				h.probeDown(time.Now())
+			}
+		case <-h.stopChan:
+			return
+		}
+	}
+}
+
+// Interval 返回被动健康检查的间隔
+func (h *Checker) Interval() time.Duration {
+	return h.interval
+}
+
+// Stop 停止被动健康检查
+func (h *Checker) Stop() {
+	h.stopOnce.Do(func() {
+		close(h.stopChan)
+	})
+}
+
+// recoverExpired 恢复冷却时间已到期的服务器，返回本轮恢复的数量
+// 配置 recovery_batch_size 时每轮最多恢复该数量的服务器，其余留到下一轮，避免同时涌入流量
+func (h *Checker) recoverExpired(now time.Time) int {
+	serverStatus := h.balancer.GetServerStatus()
+	batchSize := h.config.RecoveryBatchSize
+
+	recovered := 0
+	for _, server := range slices.Concat(h.config.Servers, h.config.OverflowServers, h.config.EmergencyServers) {
+		// 跳过可用、永久禁用和仍在冷却期的服务器
+		if serverStatus[server.URL] || h.balancer.IsServerDisabled(server.URL) || !now.After(h.balancer.GetServerDownUntil(server.URL)) {
+			continue
+		}
+
+		// 余额不足、手动下线等非请求失败原因的服务器需要满足特定条件才能恢复
+		if !h.balancer.GetDownReason(server.URL).IsFailure() {
+			continue
+		}
+
+		if batchSize > 0 && recovered >= batchSize {
+			logger.Info("HEAL", "Recovery batch limit reached (%d), deferring %s to next check", batchSize, server.URL)
+			continue
+		}
+
+		// 冷却时间已到期，重新标记为可用
+		h.balancer.RecoverServer(server.URL)
+		recovered++
+		logger.Success("HEAL", "Server recovered: %s (cooldown expired)", server.URL)
+	}
+	return recovered
+}
+
+// probeDown 主动探测仍在冷却期内的故障服务器，探测成功的服务器立即恢复，返回本轮恢复的数量
+// 探测并发进行，同时进行的数量受 health_check_concurrency 限制，单次探测超时为 health_check_timeout_seconds
+func (h *Checker) probeDown(now time.Time) int {
+	serverStatus := h.balancer.GetServerStatus()
+
+	var urls []string
+	for _, server := range slices.Concat(h.config.Servers, h.config.OverflowServers, h.config.EmergencyServers) {
+		// 只探测因请求失败而冷却中的服务器；冷却已到期的由 recoverExpired 处理
+		if serverStatus[server.URL] || h.balancer.IsServerDisabled(server.URL) || now.After(h.balancer.GetServerDownUntil(server.URL)) {
+			continue
+		}
+		// 限流和认证失败的服务器探测也会成功（探测不携带请求的令牌、不消耗配额），冷却到期后再恢复
+		reason := h.balancer.GetDownReason(server.URL)
+		if !reason.IsFailure() || reason == selector.DownReasonRateLimited || reason == selector.DownReasonAuth {
+			continue
+		}
+		urls = append(urls, server.URL)
+	}
+	if len(urls) == 0 {
+		return 0
+	}
 
-		for _, server := range h.config.Servers {
-			// 检查冷却时间是否已到期
-			if !serverStatus[server.URL] && now.After(server.DownUntil) {
-				// 冷却时间已到期，重新标记为可用
-				h.balancer.RecoverServer(server.URL)
-				logger.Success("HEAL", "Server recovered: %s (cooldown expired)", server.URL)
+	recovered := 0
+	for _, result := range probeURLs(h.clientFor, h.probeTimeout, urls, h.config.StartupProbePath, h.concurrency) {
+		if !result.Healthy() {
+			reason := result.Error
+			if reason == "" {
+				reason = fmt.Sprintf("status %d", result.StatusCode)
 			}
+			logger.Info("HEAL", "Active probe failed: %s (%s)", result.URL, reason)
+			continue
 		}
+		h.balancer.RecoverServer(result.URL)
+		recovered++
+		logger.Success("HEAL", "Server recovered: %s (active probe, status: %d, %dms)", result.URL, result.StatusCode, result.Latency.Milliseconds())
 	}
+	return recovered
 }
@@ -1,10 +1,14 @@
 package health
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"claude-code-lb/internal/balance"
+	"claude-code-lb/internal/selector"
 	"claude-code-lb/internal/testutil"
 	"claude-code-lb/pkg/types"
 )
@@ -291,3 +295,293 @@ func TestCheckerWithVariousDowntimes(t *testing.T) {
 		}
 	}
 }
+
+func TestRecoverExpired(t *testing.T) {
+	servers := []types.UpstreamServer{
+		{URL: testutil.API1ExampleURL, Token: testutil.TestToken1},
+		{URL: testutil.API2ExampleURL, Token: testutil.TestToken2},
+		{URL: testutil.API3ExampleURL, Token: testutil.TestToken3},
+		{URL: testutil.TestServer1URL, Token: testutil.TestToken1},
+		{URL: testutil.TestServer2URL, Token: testutil.TestToken2},
+	}
+
+	tests := []struct {
+		name      string
+		batchSize int
+		expected  []int // 每轮恢复的数量
+	}{
+		{name: "unlimited recovers all at once", batchSize: 0, expected: []int{5, 0}},
+		{name: "batch of two staggers recovery", batchSize: 2, expected: []int{2, 2, 1, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := types.Config{
+				Cooldown:          60,
+				RecoveryBatchSize: tt.batchSize,
+				Servers:           servers,
+			}
+			balancer := balance.New(config)
+			for _, server := range servers {
+				balancer.MarkServerDownFor(server.URL, time.Millisecond)
+			}
+
+			checker := NewChecker(config, balancer)
+			now := time.Now().Add(time.Second)
+			for round, expected := range tt.expected {
+				if recovered := checker.recoverExpired(now); recovered != expected {
+					t.Errorf("Round %d: expected %d recovered, got %d", round+1, expected, recovered)
+				}
+			}
+
+			if available := len(balancer.GetAvailableServers()); available != len(servers) {
+				t.Errorf("Expected all %d servers recovered, got %d", len(servers), available)
+			}
+		})
+	}
+}
+
+func TestRecoverExpiredRespectsCooldown(t *testing.T) {
+	config := types.Config{
+		Cooldown: 60,
+		Servers: []types.UpstreamServer{
+			{URL: testutil.API1ExampleURL, Token: testutil.TestToken1},
+			{URL: testutil.API2ExampleURL, Token: testutil.TestToken2},
+		},
+	}
+	balancer := balance.New(config)
+	balancer.MarkServerDownFor(testutil.API1ExampleURL, time.Millisecond)
+	balancer.MarkServerDownFor(testutil.API2ExampleURL, time.Hour)
+
+	checker := NewChecker(config, balancer)
+	if recovered := checker.recoverExpired(time.Now().Add(time.Second)); recovered != 1 {
+		t.Fatalf("Expected only the expired server to recover, got %d", recovered)
+	}
+
+	status := balancer.GetServerStatus()
+	if !status[testutil.API1ExampleURL] || status[testutil.API2ExampleURL] {
+		t.Errorf("Unexpected server status after recovery: %v", status)
+	}
+}
+
+func TestRecoverExpiredSkipsLowBalanceServers(t *testing.T) {
+	config := types.Config{
+		Cooldown: 1,
+		Servers: []types.UpstreamServer{
+			{URL: testutil.API1ExampleURL, Token: testutil.TestToken1},
+			{URL: testutil.API2ExampleURL, Token: testutil.TestToken2},
+		},
+	}
+	balancer := balance.New(config)
+	balancer.MarkServerLowBalance(testutil.API1ExampleURL)
+	balancer.MarkServerDown(testutil.API2ExampleURL)
+
+	checker := NewChecker(config, balancer)
+	if recovered := checker.recoverExpired(time.Now().Add(time.Hour)); recovered != 1 {
+		t.Fatalf("Expected only the failed server to recover, got %d", recovered)
+	}
+
+	status := balancer.GetServerStatus()
+	if status[testutil.API1ExampleURL] {
+		t.Error("Low balance server should stay down until the balance checker recovers it")
+	}
+	if !status[testutil.API2ExampleURL] {
+		t.Error("Failed server should recover after cooldown expired")
+	}
+
+	balancer.RecoverServerBalance(testutil.API1ExampleURL)
+	if !balancer.GetServerStatus()[testutil.API1ExampleURL] {
+		t.Error("Low balance server should recover once the balance is sufficient")
+	}
+}
+
+func TestRecoverExpiredEmergencyServers(t *testing.T) {
+	config := types.Config{
+		Cooldown:         60,
+		Servers:          []types.UpstreamServer{{URL: testutil.API1ExampleURL, Token: testutil.TestToken1}},
+		EmergencyServers: []types.UpstreamServer{{URL: testutil.API2ExampleURL, Token: testutil.TestToken2}},
+	}
+	balancer := balance.New(config)
+	balancer.MarkServerDownFor(testutil.API2ExampleURL, time.Millisecond)
+
+	checker := NewChecker(config, balancer)
+	if recovered := checker.recoverExpired(time.Now().Add(time.Second)); recovered != 1 {
+		t.Fatalf("Expected the emergency server to recover, got %d", recovered)
+	}
+	if !balancer.GetServerStatus()[testutil.API2ExampleURL] {
+		t.Error("Emergency server should be available after cooldown expired")
+	}
+}
+
+func TestNewCheckerInterval(t *testing.T) {
+	servers := []types.UpstreamServer{{URL: testutil.API1ExampleURL, Token: testutil.TestToken1}}
+
+	tests := []struct {
+		name     string
+		seconds  int
+		expected time.Duration
+	}{
+		{name: "default independent of cooldown", seconds: 0, expected: DefaultHealthCheckInterval},
+		{name: "configured", seconds: 2, expected: 2 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := types.Config{Cooldown: 600, HealthCheckIntervalSeconds: tt.seconds, Servers: servers}
+			checker := NewChecker(config, balance.New(config))
+			if checker.Interval() != tt.expected {
+				t.Errorf("Expected interval %v, got %v", tt.expected, checker.Interval())
+			}
+		})
+	}
+}
+
+func TestPassiveHealthCheckRecoversWithinInterval(t *testing.T) {
+	config := types.Config{
+		Cooldown: 600,
+		Servers: []types.UpstreamServer{
+			{URL: testutil.API1ExampleURL, Token: testutil.TestToken1},
+		},
+	}
+	balancer := balance.New(config)
+	balancer.MarkServerDownFor(testutil.API1ExampleURL, 20*time.Millisecond)
+
+	checker := NewChecker(config, balancer)
+	checker.interval = 10 * time.Millisecond
+	go checker.PassiveHealthCheck()
+	defer checker.Stop()
+
+	// 冷却 20ms 到期后应在一个检查间隔内恢复，而不是等待 600 秒的冷却周期
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if balancer.GetServerStatus()[testutil.API1ExampleURL] {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("Server with expired cooldown was not recovered within the check interval")
+}
+
+func TestProbeDown(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	track := func(handler http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			current := inFlight.Add(1)
+			defer inFlight.Add(-1)
+			for {
+				peak := maxInFlight.Load()
+				if current <= peak || maxInFlight.CompareAndSwap(peak, current) {
+					break
+				}
+			}
+			time.Sleep(50 * time.Millisecond)
+			handler(w, r)
+		}
+	}
+
+	healthy := httptest.NewServer(track(func(w http.ResponseWriter, r *http.Request) {}))
+	defer healthy.Close()
+	clientError := httptest.NewServer(track(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer clientError.Close()
+	serverError := httptest.NewServer(track(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer serverError.Close()
+	hanging := httptest.NewServer(track(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer hanging.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closedURL := closed.URL
+	closed.Close()
+	lowBalance := httptest.NewServer(track(func(w http.ResponseWriter, r *http.Request) {}))
+	defer lowBalance.Close()
+	rateLimited := httptest.NewServer(track(func(w http.ResponseWriter, r *http.Request) {}))
+	defer rateLimited.Close()
+	authError := httptest.NewServer(track(func(w http.ResponseWriter, r *http.Request) {}))
+	defer authError.Close()
+
+	config := types.Config{
+		Cooldown:                  60,
+		ActiveHealthCheck:         true,
+		HealthCheckConcurrency:    2,
+		HealthCheckTimeoutSeconds: 1,
+		Servers: []types.UpstreamServer{
+			{URL: healthy.URL},
+			{URL: clientError.URL},
+			{URL: serverError.URL},
+			{URL: hanging.URL},
+			{URL: closedURL},
+			{URL: lowBalance.URL},
+			{URL: rateLimited.URL},
+			{URL: authError.URL},
+		},
+	}
+	balancer := balance.New(config)
+	for _, server := range config.Servers[:5] {
+		balancer.MarkServerDown(server.URL)
+	}
+	// 余额不足的服务器不应被探测恢复
+	balancer.MarkServerDownWithReason(lowBalance.URL, selector.DownReasonBalance)
+	// 限流和认证失败的服务器探测总会成功，只能等冷却到期
+	balancer.MarkServerDownWithReason(rateLimited.URL, selector.DownReasonRateLimited)
+	balancer.MarkServerDownWithReason(authError.URL, selector.DownReasonAuth)
+
+	checker := NewChecker(config, balancer)
+	start := time.Now()
+	recovered := checker.probeDown(time.Now())
+	elapsed := time.Since(start)
+
+	if recovered != 1 {
+		t.Errorf("Expected 1 server recovered, got %d", recovered)
+	}
+	status := balancer.GetServerStatus()
+	expected := map[string]bool{
+		healthy.URL:     true,
+		clientError.URL: false,
+		serverError.URL: false,
+		hanging.URL:     false,
+		closedURL:       false,
+		lowBalance.URL:  false,
+		rateLimited.URL: false,
+		authError.URL:   false,
+	}
+	for url, up := range expected {
+		if status[url] != up {
+			t.Errorf("Server %s: expected available=%v, got %v", url, up, status[url])
+		}
+	}
+
+	// 挂起的探测在超时后放弃，不会拖慢整轮检查
+	if elapsed > 3*time.Second {
+		t.Errorf("Expected probes to finish within the timeout, took %v", elapsed)
+	}
+	if peak := maxInFlight.Load(); peak > 2 {
+		t.Errorf("Expected at most 2 concurrent probes, got %d", peak)
+	}
+}
+
+func TestProbeDownUsesUpstreamTLSConfig(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	// 自签名证书的上游：只有使用代理的 TLS 配置（insecure_skip_verify）时探测才能成功
+	config := types.Config{
+		Cooldown:           60,
+		ActiveHealthCheck:  true,
+		InsecureSkipVerify: true,
+		Servers:            []types.UpstreamServer{{URL: upstream.URL}},
+	}
+	balancer := balance.New(config)
+	balancer.MarkServerDown(upstream.URL)
+
+	checker := NewChecker(config, balancer)
+	if recovered := checker.probeDown(time.Now()); recovered != 1 {
+		t.Fatalf("Expected the TLS server to recover via active probe, got %d", recovered)
+	}
+}
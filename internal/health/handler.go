@@ -1,6 +1,7 @@
 package health
 
 import (
+	"sync"
 	"time"
 
 	"claude-code-lb/internal/balance"
@@ -9,6 +10,8 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// Handler 存活探针（liveness）：只要进程在运行就返回 200
+// 上游服务器是否可用由 /ready 判断，这里的服务器统计仅供参考
 func Handler(config types.Config, balancer *balance.Balancer) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		availableServers := balancer.GetAvailableServers()
@@ -23,9 +26,6 @@ func Handler(config types.Config, balancer *balance.Balancer) gin.HandlerFunc {
 			}
 		}
 
-		// TODO: 考虑添加失败机制 - 当所有服务器都不可用时返回503状态码
-		// 目前总是返回200状态码，即使没有可用服务器也显示"ok"
-		// 需要收集更多样本数据后再决定是否修改
 		c.JSON(200, gin.H{
 			"status":            "ok",
 			"total_servers":     len(config.Servers),
@@ -38,3 +38,230 @@ func Handler(config types.Config, balancer *balance.Balancer) gin.HandlerFunc {
 		})
 	}
 }
+
+// ReadyHandler 就绪探针（readiness）：可以接收流量时返回 200，否则返回 503
+// 就绪条件：至少有一个可用的上游服务器，且每个配置了余额查询的服务器都已完成至少一次查询
+// 首次查询仍在随机延迟中的服务器不等待（延迟可能长达一个查询间隔）
+func ReadyHandler(config types.Config, balancer *balance.Balancer, balanceChecker *balance.BalanceChecker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		availableServers := len(balancer.GetAvailableServers())
+
+		// 统计尚未完成首次余额查询的服务器
+		var pendingBalanceChecks []string
+		if balanceChecker != nil {
+			for _, server := range config.Servers {
+				if server.BalanceCheck != "" && balanceChecker.GetBalance(server.URL).Status == "unknown" && !balanceChecker.InitialCheckDelayed(server.URL) {
+					pendingBalanceChecks = append(pendingBalanceChecks, server.URL)
+				}
+			}
+		}
+
+		ready := availableServers > 0 && len(pendingBalanceChecks) == 0
+
+		status := "ready"
+		code := 200
+		if !ready {
+			status = "not_ready"
+			code = 503
+		}
+
+		c.JSON(code, gin.H{
+			"status":                 status,
+			"available_servers":      availableServers,
+			"pending_balance_checks": len(pendingBalanceChecks),
+			"time":                   time.Now().Format(time.RFC3339),
+		})
+	}
+}
+
+// ServersHandler 返回每个上游服务器的状态和最近时间窗口内的错误率
+// 包括溢出和应急服务器，role 区分服务器的角色："primary"、"overflow" 或 "emergency"
+func ServersHandler(config types.Config, balancer *balance.Balancer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		serverStatus := balancer.GetServerStatus()
+		now := time.Now()
+
+		groups := []struct {
+			role    string
+			servers []types.UpstreamServer
+		}{
+			{"primary", config.Servers},
+			{"overflow", config.OverflowServers},
+			{"emergency", config.EmergencyServers},
+		}
+
+		servers := make([]gin.H, 0, len(config.Servers)+len(config.OverflowServers)+len(config.EmergencyServers))
+		for _, group := range groups {
+			for _, server := range group.servers {
+				disabled := balancer.IsServerDisabled(server.URL)
+				entry := gin.H{
+					"url":        server.URL,
+					"role":       group.role,
+					"available":  serverStatus[server.URL] && !disabled,
+					"state":      serverState(serverStatus[server.URL], disabled),
+					"error_rate": balancer.GetErrorRate(server.URL),
+				}
+				if downUntil := balancer.GetServerDownUntil(server.URL); !disabled && now.Before(downUntil) {
+					entry["down_until"] = downUntil.Format(time.RFC3339)
+				}
+				if reason := balancer.GetDownReason(server.URL); reason != "" {
+					entry["down_reason"] = reason
+				}
+				servers = append(servers, entry)
+			}
+		}
+
+		c.JSON(200, gin.H{
+			"servers":                   servers,
+			"error_rate_window_seconds": int(balance.DefaultErrorRateWindow.Seconds()),
+			"time":                      now.Format(time.RFC3339),
+		})
+	}
+}
+
+// serverState 返回服务器状态："available"、"cooldown"（临时不可用）或 "disabled"（永久禁用）
+func serverState(up bool, disabled bool) string {
+	switch {
+	case disabled:
+		return "disabled"
+	case !up:
+		return "cooldown"
+	default:
+		return "available"
+	}
+}
+
+// BalancesHandler 返回每个配置了余额查询的服务器的最新余额信息
+// 尚未完成首次查询的服务器状态为 "unknown"
+func BalancesHandler(config types.Config, balanceChecker *balance.BalanceChecker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		balances := balanceChecker.GetAllBalances()
+		for _, server := range config.Servers {
+			if _, exists := balances[server.URL]; server.BalanceCheck != "" && !exists {
+				balances[server.URL] = balanceChecker.GetBalance(server.URL)
+			}
+		}
+
+		c.JSON(200, gin.H{
+			"balances": balances,
+			"time":     time.Now().Format(time.RFC3339),
+		})
+	}
+}
+
+// BalanceCheckHandler 立即对服务器执行余额查询并返回最新的余额信息（用于充值后无需等待查询间隔）
+// 可以通过 ?server= 指定单个服务器，未指定时查询所有配置了余额查询的服务器
+func BalanceCheckHandler(config types.Config, balanceChecker *balance.BalanceChecker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var urls []string
+		if server := c.Query("server"); server != "" {
+			urls = append(urls, server)
+		} else {
+			for _, server := range config.Servers {
+				if server.BalanceCheck != "" {
+					urls = append(urls, server.URL)
+				}
+			}
+		}
+
+		balances := make(map[string]*balance.BalanceInfo, len(urls))
+		var mutex sync.Mutex
+		var wg sync.WaitGroup
+		for _, url := range urls {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				info, err := balanceChecker.CheckNow(url)
+				if err != nil {
+					return
+				}
+				mutex.Lock()
+				balances[url] = info
+				mutex.Unlock()
+			}()
+		}
+		wg.Wait()
+
+		if len(balances) == 0 && c.Query("server") != "" {
+			c.JSON(404, gin.H{"error": "Balance check not configured for server"})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"balances": balances,
+			"time":     time.Now().Format(time.RFC3339),
+		})
+	}
+}
+
+// redactedValue 替换敏感配置的占位符
+const redactedValue = "***redacted***"
+
+// DebugConfigHandler 返回应用默认值后的实际运行配置，用于排查配置是否按预期加载
+// token、密码、鉴权密钥等敏感信息会被替换为占位符
+func DebugConfigHandler(config types.Config) gin.HandlerFunc {
+	redacted := redactConfig(config)
+	return func(c *gin.Context) {
+		c.JSON(200, redacted)
+	}
+}
+
+// RoutingHandler 返回选择器解析配置后实际生效的路由计划（服务器顺序、权重、优先级和预期流量占比）
+func RoutingHandler(balancer *balance.Balancer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(200, balancer.DescribeRouting())
+	}
+}
+
+// redactConfig 返回替换了敏感信息的配置副本
+// 余额查询命令和 webhook 地址中通常也包含凭据，一并替换
+func redactConfig(config types.Config) types.Config {
+	config.AuthKeys = redactStrings(config.AuthKeys)
+	config.AdminKeys = redactStrings(config.AdminKeys)
+	config.HMACSecret = redactString(config.HMACSecret)
+	config.WebhookURL = redactString(config.WebhookURL)
+	config.DefaultToken = redactString(config.DefaultToken)
+	config.Servers = redactServers(config.Servers)
+	config.OverflowServers = redactServers(config.OverflowServers)
+	config.EmergencyServers = redactServers(config.EmergencyServers)
+	if config.ShadowServer != nil {
+		config.ShadowServer = &redactServers([]types.UpstreamServer{*config.ShadowServer})[0]
+	}
+	return config
+}
+
+// redactServers 返回替换了敏感信息的服务器配置副本
+func redactServers(servers []types.UpstreamServer) []types.UpstreamServer {
+	if servers == nil {
+		return nil
+	}
+	redacted := make([]types.UpstreamServer, len(servers))
+	for i, server := range servers {
+		server.Token = redactString(server.Token)
+		server.Tokens = redactStrings(server.Tokens)
+		server.Password = redactString(server.Password)
+		server.BalanceCheck = redactString(server.BalanceCheck)
+		redacted[i] = server
+	}
+	return redacted
+}
+
+// redactString 非空时返回占位符（空值保持为空，便于确认是否已配置）
+func redactString(value string) string {
+	if value == "" {
+		return ""
+	}
+	return redactedValue
+}
+
+// redactStrings 将列表中的每一项替换为占位符（保留数量）
+func redactStrings(values []string) []string {
+	if values == nil {
+		return nil
+	}
+	redacted := make([]string, len(values))
+	for i, value := range values {
+		redacted[i] = redactString(value)
+	}
+	return redacted
+}
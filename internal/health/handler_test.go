@@ -0,0 +1,496 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"claude-code-lb/internal/balance"
+	"claude-code-lb/internal/selector"
+	"claude-code-lb/internal/testutil"
+	"claude-code-lb/pkg/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+func performGet(handler gin.HandlerFunc, path string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET(path, handler)
+
+	req, _ := http.NewRequest("GET", path, nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestHandlerLiveness(t *testing.T) {
+	config := types.Config{
+		Cooldown: 60,
+		Servers: []types.UpstreamServer{
+			{URL: testutil.API1ExampleURL, Token: testutil.TestToken1},
+		},
+	}
+	balancer := balance.New(config)
+
+	// 即使所有服务器都不可用，存活探针也返回 200
+	balancer.MarkServerDown(testutil.API1ExampleURL)
+
+	w := performGet(Handler(config, balancer), "/health")
+	if w.Code != 200 {
+		t.Errorf("Expected status 200, got %d", w.Code)
+	}
+}
+
+func TestReadyHandler(t *testing.T) {
+	config := types.Config{
+		Cooldown: 60,
+		Servers: []types.UpstreamServer{
+			{URL: testutil.API1ExampleURL, Token: testutil.TestToken1},
+			{URL: testutil.API2ExampleURL, Token: testutil.TestToken2},
+		},
+	}
+
+	t.Run("ready with available servers", func(t *testing.T) {
+		balancer := balance.New(config)
+		w := performGet(ReadyHandler(config, balancer, nil), "/ready")
+		if w.Code != 200 {
+			t.Errorf("Expected status 200, got %d", w.Code)
+		}
+
+		var body map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if body["status"] != "ready" {
+			t.Errorf("Expected status ready, got %v", body["status"])
+		}
+	})
+
+	t.Run("not ready when all servers down", func(t *testing.T) {
+		balancer := balance.New(config)
+		balancer.MarkServerDown(testutil.API1ExampleURL)
+		balancer.MarkServerDown(testutil.API2ExampleURL)
+
+		w := performGet(ReadyHandler(config, balancer, nil), "/ready")
+		if w.Code != 503 {
+			t.Errorf("Expected status 503, got %d", w.Code)
+		}
+	})
+}
+
+func TestReadyHandlerWaitsForBalanceChecks(t *testing.T) {
+	config := types.Config{
+		Cooldown:              60,
+		BalanceCheckImmediate: true,
+		Servers: []types.UpstreamServer{
+			{URL: testutil.API1ExampleURL, Token: testutil.TestToken1, BalanceCheck: "echo 100", BalanceCheckInterval: 3600},
+			{URL: testutil.API2ExampleURL, Token: testutil.TestToken2},
+		},
+	}
+
+	balancer := balance.New(config)
+	executor := testutil.NewMockCommandExecutor()
+	checker := balance.NewBalanceCheckerWithExecutor(config, balancer, executor)
+
+	// 余额查询尚未完成，不应就绪
+	w := performGet(ReadyHandler(config, balancer, checker), "/ready")
+	if w.Code != 503 {
+		t.Errorf("Expected status 503 before first balance check, got %d", w.Code)
+	}
+
+	checker.Start()
+	defer checker.Stop()
+
+	deadline := time.Now().Add(time.Second)
+	for checker.GetBalance(testutil.API1ExampleURL).Status == "unknown" && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	w = performGet(ReadyHandler(config, balancer, checker), "/ready")
+	if w.Code != 200 {
+		t.Errorf("Expected status 200 after balance check, got %d", w.Code)
+	}
+}
+
+func TestReadyHandlerDoesNotWaitForDelayedBalanceChecks(t *testing.T) {
+	// 未启用 balance_check_immediate：首次查询在 0~3600 秒内随机延迟
+	config := types.Config{
+		Cooldown: 60,
+		Servers: []types.UpstreamServer{
+			{URL: testutil.API1ExampleURL, Token: testutil.TestToken1, BalanceCheck: "echo 100", BalanceCheckInterval: 3600},
+			{URL: testutil.API2ExampleURL, Token: testutil.TestToken2},
+		},
+	}
+
+	balancer := balance.New(config)
+	executor := testutil.NewMockCommandExecutor()
+	checker := balance.NewBalanceCheckerWithExecutor(config, balancer, executor)
+	checker.Start()
+	defer checker.Stop()
+
+	if !checker.InitialCheckDelayed(testutil.API1ExampleURL) {
+		t.Skip("Random initial delay was zero")
+	}
+	w := performGet(ReadyHandler(config, balancer, checker), "/ready")
+	if w.Code != 200 {
+		t.Errorf("Expected status 200 while the first balance check is delayed, got %d", w.Code)
+	}
+	if executor.GetCallCount("echo 100") != 0 {
+		t.Errorf("Expected the first balance check to still be delayed")
+	}
+}
+
+func TestServersHandler(t *testing.T) {
+	config := types.Config{
+		Cooldown: 60,
+		Servers: []types.UpstreamServer{
+			{URL: testutil.API1ExampleURL, Token: testutil.TestToken1},
+			{URL: testutil.API2ExampleURL, Token: testutil.TestToken2},
+		},
+	}
+	balancer := balance.New(config)
+	balancer.MarkServerHealthy(testutil.API1ExampleURL)
+	balancer.MarkServerDown(testutil.API1ExampleURL)
+
+	w := performGet(ServersHandler(config, balancer), "/servers")
+	if w.Code != 200 {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	var body struct {
+		Servers []struct {
+			URL       string            `json:"url"`
+			Available bool              `json:"available"`
+			DownUntil string            `json:"down_until"`
+			ErrorRate balance.ErrorRate `json:"error_rate"`
+		} `json:"servers"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(body.Servers) != 2 {
+		t.Fatalf("Expected 2 servers, got %d", len(body.Servers))
+	}
+
+	first := body.Servers[0]
+	if first.Available || first.DownUntil == "" {
+		t.Errorf("Expected first server to be down, got %+v", first)
+	}
+	if first.ErrorRate.Requests != 2 || first.ErrorRate.Rate != 0.5 {
+		t.Errorf("Unexpected error rate: %+v", first.ErrorRate)
+	}
+
+	second := body.Servers[1]
+	if !second.Available || second.ErrorRate.Requests != 0 {
+		t.Errorf("Expected second server healthy with no requests, got %+v", second)
+	}
+}
+
+func TestServersHandlerRoles(t *testing.T) {
+	config := types.Config{
+		Mode:             "load_balance",
+		Cooldown:         60,
+		Servers:          []types.UpstreamServer{{URL: testutil.API1ExampleURL, Token: testutil.TestToken1}},
+		OverflowServers:  []types.UpstreamServer{{URL: testutil.API2ExampleURL, Token: testutil.TestToken2}},
+		EmergencyServers: []types.UpstreamServer{{URL: testutil.API3ExampleURL, Token: testutil.TestToken3}},
+	}
+	balancer := balance.New(config)
+	balancer.MarkServerDown(testutil.API3ExampleURL)
+
+	w := performGet(ServersHandler(config, balancer), "/servers")
+	var body struct {
+		Servers []struct {
+			URL       string `json:"url"`
+			Role      string `json:"role"`
+			Available bool   `json:"available"`
+		} `json:"servers"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	expected := []struct {
+		url       string
+		role      string
+		available bool
+	}{
+		{testutil.API1ExampleURL, "primary", true},
+		{testutil.API2ExampleURL, "overflow", true},
+		{testutil.API3ExampleURL, "emergency", false},
+	}
+	if len(body.Servers) != len(expected) {
+		t.Fatalf("Expected %d servers, got %d", len(expected), len(body.Servers))
+	}
+	for i, want := range expected {
+		got := body.Servers[i]
+		if got.URL != want.url || got.Role != want.role || got.Available != want.available {
+			t.Errorf("Server %d: expected %s (%s, available=%v), got %+v", i+1, want.url, want.role, want.available, got)
+		}
+	}
+}
+
+func TestServersHandlerState(t *testing.T) {
+	config := types.Config{
+		Cooldown:    60,
+		MaxFailures: 1,
+		Servers: []types.UpstreamServer{
+			{URL: testutil.API1ExampleURL, Token: testutil.TestToken1},
+			{URL: testutil.API2ExampleURL, Token: testutil.TestToken2},
+			{URL: testutil.API3ExampleURL, Token: "token3"},
+		},
+	}
+	balancer := balance.New(config)
+	balancer.MarkServerDown(testutil.API1ExampleURL)
+	balancer.MarkServerDown(testutil.API1ExampleURL)
+	balancer.MarkServerDown(testutil.API2ExampleURL)
+
+	w := performGet(ServersHandler(config, balancer), "/servers")
+
+	var body struct {
+		Servers []struct {
+			URL       string `json:"url"`
+			Available bool   `json:"available"`
+			State     string `json:"state"`
+			DownUntil string `json:"down_until"`
+		} `json:"servers"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	expected := []string{"disabled", "cooldown", "available"}
+	for i, state := range expected {
+		if body.Servers[i].State != state {
+			t.Errorf("Server %d: expected state %q, got %q", i+1, state, body.Servers[i].State)
+		}
+	}
+	if body.Servers[0].Available || body.Servers[0].DownUntil != "" {
+		t.Errorf("Disabled server should be unavailable without down_until, got %+v", body.Servers[0])
+	}
+}
+
+func TestServersHandlerDownReason(t *testing.T) {
+	config := types.Config{
+		Cooldown: 60,
+		Servers: []types.UpstreamServer{
+			{URL: testutil.API1ExampleURL, Token: testutil.TestToken1},
+			{URL: testutil.API2ExampleURL, Token: testutil.TestToken2},
+			{URL: testutil.API3ExampleURL, Token: "token3"},
+		},
+	}
+	balancer := balance.New(config)
+	balancer.MarkServerDownWithReason(testutil.API1ExampleURL, selector.DownReasonRateLimited)
+	balancer.MarkServerLowBalance(testutil.API2ExampleURL)
+
+	w := performGet(ServersHandler(config, balancer), "/servers")
+
+	var body struct {
+		Servers []struct {
+			URL        string `json:"url"`
+			DownReason string `json:"down_reason"`
+		} `json:"servers"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	expected := []string{"rate_limited", "balance", ""}
+	for i, reason := range expected {
+		if body.Servers[i].DownReason != reason {
+			t.Errorf("Server %d: expected down_reason %q, got %q", i+1, reason, body.Servers[i].DownReason)
+		}
+	}
+}
+
+func TestBalancesHandler(t *testing.T) {
+	config := types.Config{
+		BalanceCheckImmediate: true,
+		Servers: []types.UpstreamServer{
+			{URL: testutil.API1ExampleURL, Token: testutil.TestToken1, BalanceCheck: "echo 100"},
+			{URL: testutil.API2ExampleURL, Token: testutil.TestToken2, BalanceCheck: "failing_cmd"},
+			{URL: testutil.API3ExampleURL, Token: "token3"},
+		},
+	}
+
+	balancer := balance.New(config)
+	executor := testutil.NewMockCommandExecutor()
+	executor.SetError("failing_cmd", errors.New("auth failed"))
+	checker := balance.NewBalanceCheckerWithExecutor(config, balancer, executor)
+
+	// 尚未查询的服务器返回 unknown
+	w := performGet(BalancesHandler(config, checker), "/balances")
+	var body struct {
+		Balances map[string]balance.BalanceInfo `json:"balances"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(body.Balances) != 2 || body.Balances[testutil.API1ExampleURL].Status != "unknown" {
+		t.Errorf("Expected two unknown balances, got %+v", body.Balances)
+	}
+
+	checker.Start()
+	defer checker.Stop()
+
+	deadline := time.Now().Add(time.Second)
+	for len(checker.GetAllBalances()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	w = performGet(BalancesHandler(config, checker), "/balances")
+	if w.Code != 200 {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	body.Balances = nil
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	first := body.Balances[testutil.API1ExampleURL]
+	if first.Status != "success" || first.Balance != 100 || first.LastChecked.IsZero() {
+		t.Errorf("Unexpected balance for first server: %+v", first)
+	}
+	second := body.Balances[testutil.API2ExampleURL]
+	if second.Status != "error" || second.Error != "auth failed" {
+		t.Errorf("Unexpected balance for second server: %+v", second)
+	}
+	if _, exists := body.Balances[testutil.API3ExampleURL]; exists {
+		t.Error("Servers without balance_check should not be listed")
+	}
+}
+
+func TestBalanceCheckHandler(t *testing.T) {
+	config := types.Config{
+		Servers: []types.UpstreamServer{
+			{URL: testutil.API1ExampleURL, Token: testutil.TestToken1, BalanceCheck: "check1"},
+			{URL: testutil.API2ExampleURL, Token: testutil.TestToken2, BalanceCheck: "check2"},
+			{URL: testutil.API3ExampleURL, Token: "token3"},
+		},
+	}
+
+	balancer := balance.New(config)
+	executor := testutil.NewMockCommandExecutor()
+	executor.SetResult("check1", 42)
+	executor.SetResult("check2", 7)
+	checker := balance.NewBalanceCheckerWithExecutor(config, balancer, executor)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/admin/balances/check", BalanceCheckHandler(config, checker))
+
+	perform := func(query string) (*httptest.ResponseRecorder, map[string]balance.BalanceInfo) {
+		req, _ := http.NewRequest("POST", "/admin/balances/check"+query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var body struct {
+			Balances map[string]balance.BalanceInfo `json:"balances"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		return w, body.Balances
+	}
+
+	// 指定服务器时只查询该服务器
+	w, balances := perform("?server=" + testutil.API1ExampleURL)
+	if w.Code != 200 {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if len(balances) != 1 || balances[testutil.API1ExampleURL].Balance != 42 {
+		t.Errorf("Expected fresh balance for the first server only, got %+v", balances)
+	}
+	if checker.GetBalance(testutil.API2ExampleURL).Status != "unknown" {
+		t.Error("Unfiltered server should not be checked")
+	}
+
+	// 未指定时查询所有配置了余额查询的服务器
+	w, balances = perform("")
+	if w.Code != 200 || len(balances) != 2 || balances[testutil.API2ExampleURL].Balance != 7 {
+		t.Errorf("Expected fresh balances for both servers, got %d %+v", w.Code, balances)
+	}
+
+	// 没有配置余额查询的服务器返回 404
+	if w, _ = perform("?server=" + testutil.API3ExampleURL); w.Code != 404 {
+		t.Errorf("Expected status 404 for server without balance_check, got %d", w.Code)
+	}
+}
+
+func TestRoutingHandler(t *testing.T) {
+	config := types.Config{
+		Mode:      "load_balance",
+		Algorithm: "weighted_round_robin",
+		Servers: []types.UpstreamServer{
+			{URL: testutil.API1ExampleURL, Token: testutil.TestToken1, Weight: 3},
+			{URL: testutil.API2ExampleURL, Token: testutil.TestToken2, Weight: 1},
+		},
+	}
+
+	w := performGet(RoutingHandler(balance.New(config)), "/debug/routing")
+	if w.Code != 200 {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if strings.Contains(w.Body.String(), testutil.TestToken1) {
+		t.Errorf("Routing plan leaks server token: %s", w.Body.String())
+	}
+
+	var plan selector.RoutingPlan
+	if err := json.Unmarshal(w.Body.Bytes(), &plan); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if plan.Algorithm != "weighted_round_robin" || len(plan.Servers) != 2 {
+		t.Fatalf("Unexpected routing plan: %+v", plan)
+	}
+	if plan.Servers[0].Share != 0.75 || plan.Servers[1].Share != 0.25 {
+		t.Errorf("Expected 75%%/25%% shares, got %+v", plan.Servers)
+	}
+}
+
+func TestDebugConfigHandler(t *testing.T) {
+	config := types.Config{
+		Auth:         true,
+		AuthKeys:     []string{"client-key-1", "client-key-2"},
+		AdminKeys:    []string{"admin-key"},
+		HMACSecret:   "hmac-secret",
+		DefaultToken: "default-token",
+		Servers: []types.UpstreamServer{
+			{URL: testutil.API1ExampleURL, Token: testutil.TestToken1, Tokens: []string{"backup-token"}, BalanceCheck: "curl -H 'Authorization: Bearer secret'"},
+			{URL: testutil.API2ExampleURL, AuthType: "basic", Username: "user", Password: "basic-password"},
+		},
+	}
+
+	w := performGet(DebugConfigHandler(config), "/debug/config")
+	if w.Code != 200 {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	body := w.Body.String()
+	for _, secret := range []string{"client-key-1", "admin-key", "hmac-secret", "default-token", testutil.TestToken1, "backup-token", "basic-password", "Bearer secret"} {
+		if strings.Contains(body, secret) {
+			t.Errorf("Response leaks secret %q: %s", secret, body)
+		}
+	}
+
+	var decoded types.Config
+	if err := json.Unmarshal(w.Body.Bytes(), &decoded); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(decoded.AuthKeys) != 2 || decoded.AuthKeys[0] != redactedValue {
+		t.Errorf("Expected two redacted auth keys, got %v", decoded.AuthKeys)
+	}
+	first, second := decoded.Servers[0], decoded.Servers[1]
+	if first.URL != testutil.API1ExampleURL || first.Token != redactedValue || len(first.Tokens) != 1 {
+		t.Errorf("Unexpected first server: %+v", first)
+	}
+	if second.Token != "" || second.Username != "user" || second.Password != redactedValue {
+		t.Errorf("Unexpected second server: %+v", second)
+	}
+
+	// 原始配置不被修改
+	if config.Servers[0].Token != testutil.TestToken1 || config.AuthKeys[0] != "client-key-1" {
+		t.Error("Redaction must not modify the running config")
+	}
+}
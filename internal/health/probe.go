@@ -0,0 +1,124 @@
+package health
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"claude-code-lb/internal/logger"
+	"claude-code-lb/internal/proxy"
+	"claude-code-lb/pkg/types"
+)
+
+// DefaultProbeTimeout 启动探测单个服务器的超时时间
+const DefaultProbeTimeout = 5 * time.Second
+
+// ProbeResult 单个服务器的探测结果
+type ProbeResult struct {
+	URL        string
+	Reachable  bool
+	StatusCode int
+	Latency    time.Duration
+	Error      string
+}
+
+// StartupProbe 启动时并发探测所有上游服务器，返回可达的服务器数量
+// 只检查网络连通性：收到任何 HTTP 响应（包括 4xx/5xx）都视为可达
+func StartupProbe(config types.Config, timeout time.Duration) int {
+	results := ProbeServers(config, timeout)
+
+	reachable := 0
+	for _, result := range results {
+		if result.Reachable {
+			reachable++
+			logger.Success("PROBE", "Upstream reachable: %s (status: %d, %dms)", result.URL, result.StatusCode, result.Latency.Milliseconds())
+		} else {
+			logger.Warning("PROBE", "Upstream unreachable: %s (%s)", result.URL, result.Error)
+		}
+	}
+
+	if reachable == 0 {
+		logger.Error("PROBE", "!!! No upstream servers are reachable (0/%d) - requests will fail until one recovers !!!", len(results))
+	} else {
+		logger.Info("PROBE", "Startup probe finished: %d/%d upstream servers reachable", reachable, len(results))
+	}
+
+	return reachable
+}
+
+// ProbeServers 并发探测所有上游服务器
+func ProbeServers(config types.Config, timeout time.Duration) []ProbeResult {
+	urls := make([]string, len(config.Servers))
+	for i, server := range config.Servers {
+		urls[i] = server.URL
+	}
+	return probeURLs(proxy.NewClientResolver(config), timeout, urls, config.StartupProbePath, 0)
+}
+
+// probeURLs 并发探测多个服务器，结果顺序与 urls 一致
+// clientFor 返回发往指定服务器使用的客户端，timeout 为单次探测的超时时间
+// concurrency 限制同时进行的探测数量，不大于 0 时全部同时探测
+func probeURLs(clientFor func(serverURL string) *http.Client, timeout time.Duration, urls []string, path string, concurrency int) []ProbeResult {
+	if concurrency <= 0 {
+		concurrency = len(urls)
+	}
+	results := make([]ProbeResult, len(urls))
+	slots := make(chan struct{}, max(concurrency, 1))
+
+	var wg sync.WaitGroup
+	for i, url := range urls {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			results[i] = probeServer(clientFor(url), timeout, url, path)
+		}()
+	}
+	wg.Wait()
+
+	return results
+}
+
+// Healthy 判断探测结果是否说明服务器已恢复：可达且返回 2xx
+// 404、401 等 4xx 只能说明网络可达，不代表服务器能正常处理请求
+func (r ProbeResult) Healthy() bool {
+	return r.Reachable && r.StatusCode >= 200 && r.StatusCode < 300
+}
+
+// probeServer 探测单个服务器：配置了路径时发送 GET，否则对根地址发送 HEAD
+// 上游客户端不设置 Client.Timeout，超时由请求的 context 控制
+func probeServer(client *http.Client, timeout time.Duration, serverURL string, path string) ProbeResult {
+	result := ProbeResult{URL: serverURL}
+
+	method := http.MethodHead
+	target := serverURL
+	if path != "" {
+		method = http.MethodGet
+		target = strings.TrimRight(serverURL, "/") + "/" + strings.TrimLeft(path, "/")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, target, nil)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	result.Latency = time.Since(start)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	resp.Body.Close()
+
+	result.Reachable = true
+	result.StatusCode = resp.StatusCode
+	return result
+}
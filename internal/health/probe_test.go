@@ -0,0 +1,58 @@
+package health
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"claude-code-lb/pkg/types"
+)
+
+func TestProbeServers(t *testing.T) {
+	var gotMethod, gotPath string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMethod = r.Method
+		gotPath = r.URL.Path
+		// 4xx 也说明服务器可达
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer upstream.Close()
+
+	// 关闭的服务器地址，模拟不可达
+	closed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	closedURL := closed.URL
+	closed.Close()
+
+	config := types.Config{
+		Servers: []types.UpstreamServer{
+			{URL: upstream.URL},
+			{URL: closedURL},
+		},
+	}
+
+	results := ProbeServers(config, time.Second)
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 results, got %d", len(results))
+	}
+	if !results[0].Reachable || results[0].StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected first server reachable with 401, got %+v", results[0])
+	}
+	if gotMethod != http.MethodHead {
+		t.Errorf("Expected HEAD probe without path, got %s", gotMethod)
+	}
+	if results[1].Reachable || results[1].Error == "" {
+		t.Errorf("Expected second server unreachable with error, got %+v", results[1])
+	}
+
+	if reachable := StartupProbe(config, time.Second); reachable != 1 {
+		t.Errorf("Expected 1 reachable server, got %d", reachable)
+	}
+
+	// 配置了探测路径时使用 GET
+	config.StartupProbePath = "/v1/models"
+	ProbeServers(config, time.Second)
+	if gotMethod != http.MethodGet || gotPath != "/v1/models" {
+		t.Errorf("Expected GET /v1/models, got %s %s", gotMethod, gotPath)
+	}
+}
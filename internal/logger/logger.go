@@ -133,3 +133,16 @@ func DebugJSON(category string, title string, jsonBytes []byte) {
 	formattedJSON := FormatJSON(jsonBytes)
 	DebugMultiline(category, fmt.Sprintf("%s (%d bytes)", title, len(jsonBytes)), formattedJSON)
 }
+
+// Record 输出一行 JSON 格式的日志记录（不带颜色和时间前缀，便于日志系统解析）
+func Record(record any) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		Error("LOG", "Failed to marshal log record: %v", err)
+		return
+	}
+
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	log.Writer().Write(append(data, '\n'))
+}
@@ -205,6 +205,16 @@ func TestDebug(t *testing.T) {
 	}
 }
 
+func TestRecord(t *testing.T) {
+	output := captureLogOutput(func() {
+		Record(map[string]any{"method": "GET", "status": 200})
+	})
+
+	if output != `{"method":"GET","status":200}`+"\n" {
+		t.Errorf("Expected a bare JSON line, got: %q", output)
+	}
+}
+
 func TestColorConstants(t *testing.T) {
 	expectedColors := map[string]string{
 		"ColorReset":  "\033[0m",
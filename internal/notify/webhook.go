@@ -0,0 +1,266 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"claude-code-lb/internal/logger"
+)
+
+const (
+	// DefaultDebounce 同一服务器状态变化的防抖时间
+	DefaultDebounce = 5 * time.Second
+	// DefaultTimeout webhook 请求超时时间
+	DefaultTimeout = 5 * time.Second
+)
+
+// 支持的 webhook 负载格式
+const (
+	FormatJSON    = "json"
+	FormatSlack   = "slack"
+	FormatDiscord = "discord"
+)
+
+// 通知颜色（Slack 使用十六进制字符串，Discord 使用整数）
+const (
+	colorDown = 0xE01E5A
+	colorUp   = 0x2EB67D
+	colorWarn = 0xECB22E
+)
+
+// StateLowBalance 余额低于预警阈值的事件状态
+const StateLowBalance = "low_balance"
+
+// StateIdle 代理空闲（超过 idle_timeout_seconds 没有请求）的事件状态，与具体服务器无关
+const StateIdle = "idle"
+
+// Event 服务器状态变化事件
+type Event struct {
+	Server       string    `json:"server"`
+	State        string    `json:"state"` // "up"、"down"、"low_balance" 或 "idle"
+	FailureCount int64     `json:"failure_count"`
+	Balance      *float64  `json:"balance,omitempty"`      // 当前余额（仅 low_balance）
+	Threshold    *float64  `json:"threshold,omitempty"`    // 预警阈值（仅 low_balance）
+	IdleSeconds  int64     `json:"idle_seconds,omitempty"` // 距最后一次请求的秒数（仅 idle）
+	Timestamp    time.Time `json:"timestamp"`
+}
+
+// WebhookNotifier 将服务器状态变化以 JSON POST 到 webhook
+type WebhookNotifier struct {
+	url      string
+	format   string
+	client   *http.Client
+	debounce time.Duration
+	mutex    sync.Mutex
+	pending  map[string]*time.Timer // 每个服务器的防抖定时器
+	latest   map[string]Event       // 防抖窗口内最新的事件
+	lastSent map[string]string      // 每个服务器最后一次发送的状态
+}
+
+// NewWebhookNotifier 创建新的 webhook 通知器，format 为空时使用原始 JSON
+func NewWebhookNotifier(url string, format string) *WebhookNotifier {
+	if format == "" {
+		format = FormatJSON
+	}
+	return &WebhookNotifier{
+		url:      url,
+		format:   format,
+		client:   &http.Client{Timeout: DefaultTimeout},
+		debounce: DefaultDebounce,
+		pending:  make(map[string]*time.Timer),
+		latest:   make(map[string]Event),
+		lastSent: make(map[string]string),
+	}
+}
+
+// Notify 记录状态变化，防抖窗口结束后异步发送（签名与 selector.StateListener 一致）
+func (n *WebhookNotifier) Notify(url string, up bool, failures int64) {
+	state := "down"
+	if up {
+		state = "up"
+	}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	n.latest[url] = Event{
+		Server:       url,
+		State:        state,
+		FailureCount: failures,
+		Timestamp:    time.Now(),
+	}
+
+	// 窗口内的新事件重置定时器，只发送最终状态
+	if timer, exists := n.pending[url]; exists {
+		timer.Stop()
+	}
+	n.pending[url] = time.AfterFunc(n.debounce, func() {
+		n.flush(url)
+	})
+}
+
+// NotifyLowBalance 异步发送余额低于预警阈值的通知（签名与 balance.LowBalanceListener 一致）
+// 余额查询每次进入预警区间只回调一次，因此不做防抖
+func (n *WebhookNotifier) NotifyLowBalance(url string, balance float64, threshold float64) {
+	go n.send(Event{
+		Server:    url,
+		State:     StateLowBalance,
+		Balance:   &balance,
+		Threshold: &threshold,
+		Timestamp: time.Now(),
+	})
+}
+
+// NotifyIdle 异步发送代理空闲的通知（签名与 stats.IdleListener 一致），供外部自动扩缩容使用
+// 空闲监控每次进入空闲只回调一次，因此不做防抖
+func (n *WebhookNotifier) NotifyIdle(idle time.Duration) {
+	go n.send(Event{
+		State:       StateIdle,
+		IdleSeconds: int64(idle.Seconds()),
+		Timestamp:   time.Now(),
+	})
+}
+
+// flush 发送防抖窗口内的最终状态（与上次发送相同时跳过）
+func (n *WebhookNotifier) flush(url string) {
+	n.mutex.Lock()
+	event, exists := n.latest[url]
+	delete(n.latest, url)
+	delete(n.pending, url)
+
+	// 服务器初始状态为 up
+	lastState := n.lastSent[url]
+	if lastState == "" {
+		lastState = "up"
+	}
+	if !exists || event.State == lastState {
+		n.mutex.Unlock()
+		return
+	}
+	n.lastSent[url] = event.State
+	n.mutex.Unlock()
+
+	n.send(event)
+}
+
+// send 发送 webhook 请求
+func (n *WebhookNotifier) send(event Event) {
+	payload, err := buildPayload(event, n.format)
+	if err != nil {
+		logger.Error("HOOK", "Failed to encode webhook payload: %v", err)
+		return
+	}
+
+	resp, err := n.client.Post(n.url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		logger.Error("HOOK", "Webhook delivery failed for %s (%s): %v", event.Server, event.State, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		logger.Warning("HOOK", "Webhook returned status %d for %s (%s)", resp.StatusCode, event.Server, event.State)
+		return
+	}
+
+	logger.Info("HOOK", "Webhook sent: %s is %s (failures: %d)", event.Server, event.State, event.FailureCount)
+}
+
+// buildPayload 按格式构造 webhook 请求体
+func buildPayload(event Event, format string) ([]byte, error) {
+	switch format {
+	case FormatSlack:
+		return json.Marshal(slackPayload(event))
+	case FormatDiscord:
+		return json.Marshal(discordPayload(event))
+	default:
+		return json.Marshal(event)
+	}
+}
+
+// formatMessage 生成人类可读的状态变化消息
+func formatMessage(event Event) string {
+	switch event.State {
+	case "down":
+		return fmt.Sprintf("[DOWN] Upstream %s marked down (failures: %d)", event.Server, event.FailureCount)
+	case StateLowBalance:
+		return fmt.Sprintf("[LOW BALANCE] Upstream %s balance %.2f is below warning threshold %.2f",
+			event.Server, *event.Balance, *event.Threshold)
+	case StateIdle:
+		return fmt.Sprintf("[IDLE] No requests served for %ds", event.IdleSeconds)
+	default:
+		return fmt.Sprintf("[UP] Upstream %s recovered", event.Server)
+	}
+}
+
+// eventColor 返回事件的严重程度颜色
+func eventColor(event Event) int {
+	switch event.State {
+	case "down":
+		return colorDown
+	case StateLowBalance, StateIdle:
+		return colorWarn
+	default:
+		return colorUp
+	}
+}
+
+// eventDetail 返回事件附加信息的名称和值（低余额事件为余额，空闲事件为空闲时长，其他为失败次数）
+func eventDetail(event Event) (string, string) {
+	if event.State == StateLowBalance {
+		return "Balance", fmt.Sprintf("%.2f", *event.Balance)
+	}
+	if event.State == StateIdle {
+		return "Idle", fmt.Sprintf("%ds", event.IdleSeconds)
+	}
+	return "Failures", fmt.Sprintf("%d", event.FailureCount)
+}
+
+// slackPayload 构造 Slack incoming webhook 消息（附件带严重程度颜色）
+func slackPayload(event Event) map[string]any {
+	color := eventColor(event)
+	message := formatMessage(event)
+	detailName, detailValue := eventDetail(event)
+
+	return map[string]any{
+		"text": message,
+		"attachments": []map[string]any{
+			{
+				"color":    fmt.Sprintf("#%06X", color),
+				"fallback": message,
+				"fields": []map[string]any{
+					{"title": "Server", "value": event.Server, "short": false},
+					{"title": "State", "value": event.State, "short": true},
+					{"title": detailName, "value": detailValue, "short": true},
+				},
+				"ts": event.Timestamp.Unix(),
+			},
+		},
+	}
+}
+
+// discordPayload 构造 Discord webhook 消息（embed 带严重程度颜色）
+func discordPayload(event Event) map[string]any {
+	color := eventColor(event)
+	detailName, detailValue := eventDetail(event)
+
+	return map[string]any{
+		"content": formatMessage(event),
+		"embeds": []map[string]any{
+			{
+				"title": "Upstream state change",
+				"color": color,
+				"fields": []map[string]any{
+					{"name": "Server", "value": event.Server, "inline": false},
+					{"name": "State", "value": event.State, "inline": true},
+					{"name": detailName, "value": detailValue, "inline": true},
+				},
+				"timestamp": event.Timestamp.Format(time.RFC3339),
+			},
+		},
+	}
+}
@@ -0,0 +1,217 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"claude-code-lb/internal/testutil"
+)
+
+// newTestNotifier 创建指向本地测试服务器的通知器，返回接收到的事件通道
+func newTestNotifier(t *testing.T) (*WebhookNotifier, chan Event) {
+	t.Helper()
+
+	events := make(chan Event, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("Expected POST, got %s", r.Method)
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Expected application/json content type, got %s", ct)
+		}
+		var event Event
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("Failed to decode payload: %v", err)
+		}
+		events <- event
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(server.Close)
+
+	notifier := NewWebhookNotifier(server.URL, "")
+	notifier.debounce = 20 * time.Millisecond
+	return notifier, events
+}
+
+func TestWebhookNotifierSendsPayload(t *testing.T) {
+	notifier, events := newTestNotifier(t)
+
+	before := time.Now()
+	notifier.Notify(testutil.API1ExampleURL, false, 3)
+
+	select {
+	case event := <-events:
+		if event.Server != testutil.API1ExampleURL {
+			t.Errorf("Expected server %s, got %s", testutil.API1ExampleURL, event.Server)
+		}
+		if event.State != "down" {
+			t.Errorf("Expected state down, got %s", event.State)
+		}
+		if event.FailureCount != 3 {
+			t.Errorf("Expected failure count 3, got %d", event.FailureCount)
+		}
+		if event.Timestamp.Before(before.Add(-time.Second)) {
+			t.Errorf("Unexpected timestamp %v", event.Timestamp)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for webhook")
+	}
+
+	// 恢复事件也应发送
+	notifier.Notify(testutil.API1ExampleURL, true, 0)
+	select {
+	case event := <-events:
+		if event.State != "up" {
+			t.Errorf("Expected state up, got %s", event.State)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for recovery webhook")
+	}
+}
+
+func TestWebhookNotifierDebouncesFlaps(t *testing.T) {
+	notifier, events := newTestNotifier(t)
+
+	// 窗口内 down -> up，最终状态与初始状态相同，不应发送
+	notifier.Notify(testutil.API1ExampleURL, false, 1)
+	notifier.Notify(testutil.API1ExampleURL, true, 0)
+
+	// 窗口内 down -> up -> down，只发送最终的 down
+	notifier.Notify(testutil.API2ExampleURL, false, 1)
+	notifier.Notify(testutil.API2ExampleURL, true, 0)
+	notifier.Notify(testutil.API2ExampleURL, false, 2)
+
+	select {
+	case event := <-events:
+		if event.Server != testutil.API2ExampleURL || event.State != "down" || event.FailureCount != 2 {
+			t.Errorf("Unexpected event: %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for webhook")
+	}
+
+	select {
+	case event := <-events:
+		t.Errorf("Expected no further webhooks, got %+v", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestWebhookNotifierLowBalance(t *testing.T) {
+	notifier, events := newTestNotifier(t)
+
+	notifier.NotifyLowBalance(testutil.API1ExampleURL, 8.5, 20)
+
+	select {
+	case event := <-events:
+		if event.Server != testutil.API1ExampleURL || event.State != StateLowBalance {
+			t.Errorf("Unexpected event: %+v", event)
+		}
+		if event.Balance == nil || *event.Balance != 8.5 || event.Threshold == nil || *event.Threshold != 20 {
+			t.Errorf("Expected balance 8.5 and threshold 20, got %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for low balance webhook")
+	}
+
+	balance, threshold := 8.5, 20.0
+	data, err := buildPayload(Event{Server: testutil.API1ExampleURL, State: StateLowBalance, Balance: &balance, Threshold: &threshold}, FormatSlack)
+	if err != nil {
+		t.Fatalf("buildPayload failed: %v", err)
+	}
+	if text := string(data); !strings.Contains(text, "[LOW BALANCE]") || !strings.Contains(text, "#ECB22E") {
+		t.Errorf("Unexpected Slack payload: %s", text)
+	}
+}
+
+func TestWebhookNotifierIdle(t *testing.T) {
+	notifier, events := newTestNotifier(t)
+
+	notifier.NotifyIdle(15 * time.Minute)
+
+	select {
+	case event := <-events:
+		if event.State != StateIdle || event.IdleSeconds != 900 || event.Server != "" {
+			t.Errorf("Unexpected event: %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for idle webhook")
+	}
+}
+
+func TestBuildPayload(t *testing.T) {
+	event := Event{
+		Server:       testutil.API1ExampleURL,
+		State:        "down",
+		FailureCount: 2,
+		Timestamp:    time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+
+	t.Run("json", func(t *testing.T) {
+		data, err := buildPayload(event, FormatJSON)
+		if err != nil {
+			t.Fatalf("buildPayload failed: %v", err)
+		}
+		var decoded Event
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatalf("Failed to decode payload: %v", err)
+		}
+		if decoded.Server != event.Server || decoded.State != "down" || decoded.FailureCount != 2 {
+			t.Errorf("Unexpected JSON payload: %s", data)
+		}
+	})
+
+	t.Run("slack", func(t *testing.T) {
+		data, err := buildPayload(event, FormatSlack)
+		if err != nil {
+			t.Fatalf("buildPayload failed: %v", err)
+		}
+		var decoded struct {
+			Text        string `json:"text"`
+			Attachments []struct {
+				Color string `json:"color"`
+			} `json:"attachments"`
+		}
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatalf("Failed to decode payload: %v", err)
+		}
+		if !strings.Contains(decoded.Text, "[DOWN]") || !strings.Contains(decoded.Text, testutil.API1ExampleURL) {
+			t.Errorf("Unexpected Slack text: %s", decoded.Text)
+		}
+		if len(decoded.Attachments) != 1 || decoded.Attachments[0].Color != "#E01E5A" {
+			t.Errorf("Expected one red attachment, got %+v", decoded.Attachments)
+		}
+	})
+
+	t.Run("discord", func(t *testing.T) {
+		up := event
+		up.State = "up"
+		data, err := buildPayload(up, FormatDiscord)
+		if err != nil {
+			t.Fatalf("buildPayload failed: %v", err)
+		}
+		var decoded struct {
+			Content string `json:"content"`
+			Embeds  []struct {
+				Color     int    `json:"color"`
+				Timestamp string `json:"timestamp"`
+			} `json:"embeds"`
+		}
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatalf("Failed to decode payload: %v", err)
+		}
+		if !strings.Contains(decoded.Content, "[UP]") {
+			t.Errorf("Unexpected Discord content: %s", decoded.Content)
+		}
+		if len(decoded.Embeds) != 1 || decoded.Embeds[0].Color != colorUp {
+			t.Errorf("Expected one green embed, got %+v", decoded.Embeds)
+		}
+		if decoded.Embeds[0].Timestamp != "2025-01-02T03:04:05Z" {
+			t.Errorf("Unexpected embed timestamp: %s", decoded.Embeds[0].Timestamp)
+		}
+	})
+}
@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"slices"
+	"strings"
+
+	"claude-code-lb/internal/logger"
+	"claude-code-lb/pkg/types"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/singleflight"
+)
+
+// DefaultCoalesceEndpoints 启用 coalesce_requests 但未配置 coalesce_endpoints 时合并的端点（幂等、可缓存）
+var DefaultCoalesceEndpoints = []string{"/v1/models", "/v1/messages/count_tokens"}
+
+// coalescer 合并并发的相同请求：相同方法、路径和请求体的请求同时到达时只转发一次，共享上游响应
+type coalescer struct {
+	group     singleflight.Group
+	endpoints []string
+}
+
+// coalescedResponse 被合并的请求共享的响应（只读）
+type coalescedResponse struct {
+	status int
+	header map[string][]string
+	body   []byte
+}
+
+// teeWriter 正常写入客户端的同时保存响应体，供合并的请求共享
+type teeWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *teeWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *teeWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// newCoalescer 根据配置创建请求合并器，未启用 coalesce_requests 时返回 nil
+func newCoalescer(config types.Config) *coalescer {
+	if !config.CoalesceRequests {
+		return nil
+	}
+	endpoints := config.CoalesceEndpoints
+	if len(endpoints) == 0 {
+		endpoints = DefaultCoalesceEndpoints
+	}
+	return &coalescer{endpoints: endpoints}
+}
+
+// key 返回请求的合并键（方法 + 路径 + 查询参数 + 请求体哈希），请求不可合并时返回 false
+// 只合并配置的端点，流式请求永远不合并
+func (co *coalescer) key(c *gin.Context) (string, bool) {
+	if co == nil || !slices.Contains(co.endpoints, c.Request.URL.Path) {
+		return "", false
+	}
+	if strings.Contains(strings.ToLower(c.Request.Header.Get("Accept")), "text/event-stream") {
+		return "", false
+	}
+
+	var body []byte
+	if c.Request.Body != nil {
+		var err error
+		body, err = io.ReadAll(c.Request.Body)
+		c.Request.Body.Close()
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		if err != nil {
+			return "", false
+		}
+	}
+
+	if len(body) > 0 {
+		var request struct {
+			Stream bool `json:"stream"`
+		}
+		if json.Unmarshal(body, &request) == nil && request.Stream {
+			return "", false
+		}
+	}
+
+	sum := sha256.Sum256(body)
+	return c.Request.Method + " " + c.Request.URL.RequestURI() + " " + hex.EncodeToString(sum[:]), true
+}
+
+// serve 转发请求；相同请求正在转发时等待其完成并返回同一个响应
+func (co *coalescer) serve(c *gin.Context, key string, forward gin.HandlerFunc) {
+	leader := false
+	result, _, _ := co.group.Do(key, func() (any, error) {
+		leader = true
+		tee := &teeWriter{ResponseWriter: c.Writer}
+		c.Writer = tee
+		forward(c)
+		c.Writer = tee.ResponseWriter
+		return &coalescedResponse{status: tee.Status(), header: tee.Header().Clone(), body: tee.body.Bytes()}, nil
+	})
+	if leader {
+		return
+	}
+
+	response := result.(*coalescedResponse)
+	if response.status == 499 {
+		// 首个请求被其客户端取消，没有可共享的响应，自行转发
+		forward(c)
+		return
+	}
+
+	logger.Info("PROXY", "Coalesced duplicate request: %s %s | Status: %d", c.Request.Method, c.Request.URL.Path, response.status)
+	header := c.Writer.Header()
+	for key, values := range response.header {
+		header[key] = slices.Clone(values)
+	}
+	c.Status(response.status)
+	c.Writer.Write(response.body)
+}
@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"claude-code-lb/internal/balance"
+	"claude-code-lb/internal/stats"
+	"claude-code-lb/pkg/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestHandlerCoalesceRequests(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name         string
+		coalesce     bool
+		method       string
+		path         string
+		body         string
+		expectedHits int64
+	}{
+		{name: "concurrent identical GETs share one response", coalesce: true, method: "GET", path: "/v1/models", expectedHits: 1},
+		{name: "disabled", coalesce: false, method: "GET", path: "/v1/models", expectedHits: 5},
+		{name: "endpoint not configured", coalesce: true, method: "GET", path: "/v1/other", expectedHits: 5},
+		{name: "streaming request", coalesce: true, method: "POST", path: "/v1/models", body: `{"stream":true}`, expectedHits: 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hits atomic.Int64
+			release := make(chan struct{})
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				hits.Add(1)
+				<-release
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("X-Upstream", "yes")
+				w.Write([]byte(`{"data":[{"id":"claude"}]}`))
+			}))
+			defer upstream.Close()
+
+			config := types.Config{
+				Mode:             "load_balance",
+				Algorithm:        "round_robin",
+				Cooldown:         60,
+				CoalesceRequests: tt.coalesce,
+				Servers:          []types.UpstreamServer{{URL: upstream.URL, Token: "test-token"}},
+			}
+
+			router := gin.New()
+			router.Any("/*path", Handler(config, balance.New(config), stats.New()))
+
+			const concurrency = 5
+			recorders := make([]*httptest.ResponseRecorder, concurrency)
+			var wg sync.WaitGroup
+			for i := range recorders {
+				recorders[i] = httptest.NewRecorder()
+				wg.Add(1)
+				go func(w *httptest.ResponseRecorder) {
+					defer wg.Done()
+					req, _ := http.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+					router.ServeHTTP(w, req)
+				}(recorders[i])
+			}
+
+			// 等待所有请求到达后再让上游返回
+			time.Sleep(100 * time.Millisecond)
+			close(release)
+			wg.Wait()
+
+			if got := hits.Load(); got != tt.expectedHits {
+				t.Errorf("Expected %d upstream requests, got %d", tt.expectedHits, got)
+			}
+			for i, w := range recorders {
+				if w.Code != 200 || w.Body.String() != `{"data":[{"id":"claude"}]}` || w.Header().Get("X-Upstream") != "yes" {
+					t.Errorf("Request %d: unexpected response %d %q (headers %v)", i, w.Code, w.Body.String(), w.Header())
+				}
+			}
+		})
+	}
+}
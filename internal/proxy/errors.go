@@ -0,0 +1,33 @@
+package proxy
+
+import (
+	"maps"
+
+	"claude-code-lb/pkg/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Anthropic API 的错误类型（启用 anthropic_error_format 时使用）
+const (
+	errorTypeInvalidRequest = "invalid_request_error"
+	errorTypeRateLimit      = "rate_limit_error"
+	errorTypeAPI            = "api_error"
+	errorTypeOverloaded     = "overloaded_error"
+	errorTypeTooLarge       = "request_too_large"
+)
+
+// errorResponse 构造代理自身产生的错误响应，fields 为附加字段（可为 nil）
+// 默认格式为 {"error": message}；启用 anthropic_error_format 时使用 Anthropic API 的错误格式
+// {"type": "error", "error": {"type": errorType, "message": message}}，附加字段放在顶层
+func errorResponse(config types.Config, errorType, message string, fields gin.H) gin.H {
+	response := gin.H{}
+	maps.Copy(response, fields)
+	if config.AnthropicErrorFormat {
+		response["type"] = "error"
+		response["error"] = gin.H{"type": errorType, "message": message}
+	} else {
+		response["error"] = message
+	}
+	return response
+}
@@ -2,46 +2,90 @@ package proxy
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
+	"math"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"claude-code-lb/internal/balance"
 	"claude-code-lb/internal/logger"
+	"claude-code-lb/internal/selector"
 	"claude-code-lb/internal/stats"
 	"claude-code-lb/pkg/types"
 
 	"github.com/gin-gonic/gin"
 )
 
-// getHopByHopHeaders 返回hop-by-hop头集合，包括Connection头中指定的自定义头
-func getHopByHopHeaders(connectionHeader string) map[string]bool {
-	hopByHopHeaders := map[string]bool{
-		"connection":          true,
-		"keep-alive":          true,
-		"proxy-authenticate":  true,
-		"proxy-authorization": true,
-		"te":                  true,
-		"trailers":            true,
-		"transfer-encoding":   true,
-		"upgrade":             true,
+// hopByHopHeaders 固定的hop-by-hop头集合 (RFC 2616)，只读
+var hopByHopHeaders = map[string]bool{
+	"connection":          true,
+	"keep-alive":          true,
+	"proxy-authenticate":  true,
+	"proxy-authorization": true,
+	"te":                  true,
+	"trailers":            true,
+	"transfer-encoding":   true,
+	"upgrade":             true,
+}
+
+// requestSkipHeaders 转发请求时不复制的固定头：hop-by-hop头、host（单独设置）
+// 以及 content-length（请求体已完整读取，长度由 req.ContentLength 决定），只读
+var requestSkipHeaders = func() map[string]bool {
+	headers := maps.Clone(hopByHopHeaders)
+	headers["host"] = true
+	headers["content-length"] = true
+	return headers
+}()
+
+// headerFilter 判断头是否需要过滤：固定集合、配置中要求移除的头，以及 Connection 头中指定的自定义头
+// 每个请求都会创建，因此不分配新的集合，按需解析 Connection 头
+type headerFilter struct {
+	static     map[string]bool // 固定集合（只读，键为小写）
+	strip      []string        // 配置中要求移除的头（不区分大小写）
+	connection string          // Connection 头的原始值
+}
+
+// newHeaderFilter 创建头过滤器，static 必须只读
+func newHeaderFilter(static map[string]bool, connectionHeader string, strip []string) headerFilter {
+	return headerFilter{static: static, strip: strip, connection: connectionHeader}
+}
+
+// getHopByHopHeaders 返回hop-by-hop头过滤器，包括Connection头中指定的自定义头
+func getHopByHopHeaders(connectionHeader string) headerFilter {
+	return newHeaderFilter(hopByHopHeaders, connectionHeader, nil)
+}
+
+// contains 判断头（小写）是否需要过滤
+func (f headerFilter) contains(lowerKey string) bool {
+	if f.static[lowerKey] {
+		return true
+	}
+	for _, name := range f.strip {
+		if strings.EqualFold(strings.TrimSpace(name), lowerKey) {
+			return true
+		}
 	}
 
 	// 解析Connection头中指定的额外hop-by-hop头
-	if connectionHeader != "" {
-		connectionTokens := strings.Split(connectionHeader, ",")
-		for _, token := range connectionTokens {
-			token = strings.ToLower(strings.TrimSpace(token))
-			if token != "" && token != "close" && token != "keep-alive" {
-				hopByHopHeaders[token] = true
-			}
+	for rest := f.connection; rest != ""; {
+		var token string
+		token, rest, _ = strings.Cut(rest, ",")
+		token = strings.TrimSpace(token)
+		if token != "" && !strings.EqualFold(token, "close") && strings.EqualFold(token, lowerKey) {
+			return true
 		}
 	}
-
-	return hopByHopHeaders
+	return false
 }
 
 // formatRequestURL 格式化完整的请求URL用于日志
@@ -59,17 +103,47 @@ func formatRequestURL(method, serverURL, path, query string) string {
 	return fmt.Sprintf("%s %s", method, fullURL)
 }
 
+// normalizePathPrefix 规范化路径前缀：保证以 / 开头、不以 / 结尾，空或 "/" 返回空字符串
+func normalizePathPrefix(prefix string) string {
+	prefix = strings.Trim(strings.TrimSpace(prefix), "/")
+	if prefix == "" {
+		return ""
+	}
+	return "/" + prefix
+}
+
+// rewritePath 移除 stripPrefix（仅在路径段边界匹配时）并添加 addPrefix
+func rewritePath(path, stripPrefix, addPrefix string) string {
+	if strip := normalizePathPrefix(stripPrefix); strip != "" {
+		if path == strip {
+			path = "/"
+		} else if strings.HasPrefix(path, strip+"/") {
+			path = path[len(strip):]
+		}
+	}
+
+	if add := normalizePathPrefix(addPrefix); add != "" {
+		path = add + path
+	}
+
+	return path
+}
+
+// canonicalModelName 按 model_aliases 将上游返回的模型名映射为统一名称
+func canonicalModelName(aliases map[string]string, model string) string {
+	if canonical, ok := aliases[model]; ok && canonical != "" {
+		return canonical
+	}
+	return model
+}
+
 // parseUsageInfo 解析响应体中的 usage 信息
 func parseUsageInfo(responseBody []byte, contentType string) (model string, usage types.ClaudeUsage, success bool) {
 	contentTypeLower := strings.ToLower(contentType)
 
 	// 处理 JSON 格式响应
 	if strings.Contains(contentTypeLower, "application/json") {
-		var response types.ClaudeResponse
-		if err := json.Unmarshal(responseBody, &response); err != nil {
-			return "", types.ClaudeUsage{}, false
-		}
-		return response.Model, response.Usage, true
+		return parseJSONUsageInfo(responseBody)
 	}
 
 	// 处理 Server-Sent Events (SSE) 格式响应
@@ -77,9 +151,32 @@ func parseUsageInfo(responseBody []byte, contentType string) (model string, usag
 		return parseSSEUsageInfo(responseBody)
 	}
 
+	// 内容类型缺失或过于宽泛（如未设置 Content-Type 的 chunked 响应）时，按内容判断是否为 JSON
+	if looksLikeJSONObject(responseBody) {
+		return parseJSONUsageInfo(responseBody)
+	}
+
 	return "", types.ClaudeUsage{}, false
 }
 
+// parseJSONUsageInfo 解析 JSON 响应体中的 model 和 usage 信息
+func parseJSONUsageInfo(responseBody []byte) (model string, usage types.ClaudeUsage, success bool) {
+	var response struct {
+		Model string         `json:"model"`
+		Usage map[string]any `json:"usage"`
+	}
+	if err := json.Unmarshal(responseBody, &response); err != nil {
+		return "", types.ClaudeUsage{}, false
+	}
+	return response.Model, parseUsageMap(response.Usage), true
+}
+
+// looksLikeJSONObject 判断响应体（忽略前导空白）是否以 { 开头
+func looksLikeJSONObject(responseBody []byte) bool {
+	trimmed := bytes.TrimLeft(responseBody, " \t\r\n")
+	return len(trimmed) > 0 && trimmed[0] == '{'
+}
+
 // parseSSEUsageInfo 解析 SSE 格式响应中的 usage 信息
 func parseSSEUsageInfo(responseBody []byte) (model string, usage types.ClaudeUsage, success bool) {
 	lines := strings.Split(string(responseBody), "\n")
@@ -102,6 +199,14 @@ func parseSSEUsageInfo(responseBody []byte) (model string, usage types.ClaudeUsa
 			// 检查是否是 message_start 或 message_delta 事件
 			eventType, ok := eventData["type"].(string)
 			if !ok {
+				// OpenAI 兼容的 chat.completion.chunk 没有 type 字段，usage 出现在最后一个数据块中
+				if modelValue, ok := eventData["model"].(string); ok && modelValue != "" {
+					model = modelValue
+				}
+				if usageData, ok := eventData["usage"].(map[string]any); ok {
+					usage = parseUsageMap(usageData)
+					success = true
+				}
 				continue
 			}
 
@@ -129,6 +234,35 @@ func parseSSEUsageInfo(responseBody []byte) (model string, usage types.ClaudeUsa
 	return model, usage, success
 }
 
+// parseUsageMap 根据出现的字段选择 Anthropic 或 OpenAI 兼容的 usage 解析方式
+func parseUsageMap(usageData map[string]any) types.ClaudeUsage {
+	_, hasPrompt := usageData["prompt_tokens"]
+	_, hasCompletion := usageData["completion_tokens"]
+	if hasPrompt || hasCompletion {
+		return parseOpenAIUsageFromMap(usageData)
+	}
+	return parseUsageFromMap(usageData)
+}
+
+// parseOpenAIUsageFromMap 从 OpenAI 兼容（/v1/chat/completions）的 usage 中解析 token 数量
+func parseOpenAIUsageFromMap(usageData map[string]any) types.ClaudeUsage {
+	var usage types.ClaudeUsage
+
+	if promptTokens, ok := usageData["prompt_tokens"].(float64); ok {
+		usage.InputTokens = int(promptTokens)
+	}
+	if completionTokens, ok := usageData["completion_tokens"].(float64); ok {
+		usage.OutputTokens = int(completionTokens)
+	}
+	if details, ok := usageData["prompt_tokens_details"].(map[string]any); ok {
+		if cachedTokens, ok := details["cached_tokens"].(float64); ok {
+			usage.CacheReadInputTokens = int(cachedTokens)
+		}
+	}
+
+	return usage
+}
+
 // parseUsageFromMap 从 map 中解析 usage 信息
 func parseUsageFromMap(usageData map[string]any) types.ClaudeUsage {
 	var usage types.ClaudeUsage
@@ -149,31 +283,690 @@ func parseUsageFromMap(usageData map[string]any) types.ClaudeUsage {
 	return usage
 }
 
-func Handler(balancer *balance.Balancer, statsReporter *stats.Reporter, debugMode bool) gin.HandlerFunc {
-	return func(c *gin.Context) {
+// requestModel 读取请求体中的 model 字段（读取后恢复请求体），无法解析时返回空字符串
+func requestModel(c *gin.Context) string {
+	if c.Request.Body == nil {
+		return ""
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	c.Request.Body.Close()
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil || len(body) == 0 {
+		return ""
+	}
+
+	var request struct {
+		Model string `json:"model"`
+	}
+	if err := json.Unmarshal(body, &request); err != nil {
+		return ""
+	}
+	return request.Model
+}
+
+// bufferRequestBody 将请求体读入内存（最多 max_request_body_bytes 字节，未配置时不读取）并恢复
+// 超过上限时返回 413，读取失败时返回 400；返回 false 表示已经响应客户端
+func bufferRequestBody(c *gin.Context, config types.Config) bool {
+	if c.Request.Body == nil || config.MaxRequestBodyBytes <= 0 {
+		return true
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, config.MaxRequestBodyBytes))
+	c.Request.Body.Close()
+	if tooLarge := new(http.MaxBytesError); errors.As(err, &tooLarge) {
+		logger.Warning("PROXY", "Rejected request with body over %d bytes: %s %s from %s", tooLarge.Limit, c.Request.Method, c.Request.URL.Path, c.ClientIP())
+		c.JSON(http.StatusRequestEntityTooLarge, errorResponse(config, errorTypeTooLarge, "Request body too large", nil))
+		return false
+	}
+	if err != nil {
+		logger.Warning("PROXY", "Failed to read request body: %v", err)
+		c.JSON(http.StatusBadRequest, errorResponse(config, errorTypeInvalidRequest, "Failed to read request body", nil))
+		return false
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	return true
+}
+
+// isJSONContentType 判断 Content-Type 是否为 JSON（application/json 或 +json 后缀）
+func isJSONContentType(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// invalidJSONBody 检查 JSON 请求的请求体是否为合法 JSON（读取后恢复请求体），不合法时返回 true
+// 非 JSON 的 Content-Type 和长度未知的流式上传不检查
+func invalidJSONBody(c *gin.Context) bool {
+	if c.Request.Body == nil || c.Request.ContentLength <= 0 || !isJSONContentType(c.Request.Header.Get("Content-Type")) {
+		return false
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	c.Request.Body.Close()
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		// 读取失败留给转发时处理
+		return false
+	}
+	return !json.Valid(body)
+}
+
+// requestHeadersTooLarge 检查请求头数量和总字节数是否超过 max_request_headers / max_request_header_bytes
+// 超过时返回描述原因的字符串，用于日志
+func requestHeadersTooLarge(header http.Header, config types.Config) (string, bool) {
+	if config.MaxRequestHeaders <= 0 && config.MaxRequestHeaderBytes <= 0 {
+		return "", false
+	}
+
+	count, size := 0, 0
+	for key, values := range header {
+		count += len(values)
+		for _, value := range values {
+			size += len(key) + len(value)
+		}
+	}
+
+	if config.MaxRequestHeaders > 0 && count > config.MaxRequestHeaders {
+		return fmt.Sprintf("%d headers, limit %d", count, config.MaxRequestHeaders), true
+	}
+	if config.MaxRequestHeaderBytes > 0 && size > config.MaxRequestHeaderBytes {
+		return fmt.Sprintf("%d bytes, limit %d", size, config.MaxRequestHeaderBytes), true
+	}
+	return "", false
+}
+
+// AlgorithmOverrideHeader 启用 allow_algorithm_override 时，客户端为单个请求指定选择算法的请求头
+const AlgorithmOverrideHeader = "X-LB-Algorithm"
+
+// requestAlgorithm 返回请求通过 X-LB-Algorithm 头指定的算法，未启用、未指定或不在允许列表中时返回空字符串
+// 启用时该头只供代理使用，转发前从请求中移除
+func requestAlgorithm(c *gin.Context, config types.Config) string {
+	if !config.AllowAlgorithmOverride {
+		return ""
+	}
+
+	algorithm := strings.TrimSpace(c.Request.Header.Get(AlgorithmOverrideHeader))
+	c.Request.Header.Del(AlgorithmOverrideHeader)
+	if algorithm == "" {
+		return ""
+	}
+
+	allowed := config.AlgorithmOverrides
+	if len(allowed) == 0 {
+		allowed = types.Algorithms
+	}
+	if !slices.Contains(allowed, algorithm) {
+		logger.Warning("PROXY", "Ignoring %s: %q is not an allowed algorithm", AlgorithmOverrideHeader, algorithm)
+		return ""
+	}
+	return algorithm
+}
+
+// RequestTimeoutHeader 配置 max_client_timeout_ms 时，客户端为单个请求指定超时（毫秒）的请求头
+const RequestTimeoutHeader = "X-Request-Timeout-Ms"
+
+// contextKeyClientTimeout 在 gin.Context 中保存客户端指定的超时，换服务器重试时沿用
+const contextKeyClientTimeout = "lb.client_timeout"
+
+// clientTimeout 返回客户端通过 X-Request-Timeout-Ms 头指定的超时，超过 max_client_timeout_ms 时截断为上限
+// 未启用、未指定或取值无效时返回 0；启用时该头只供代理使用，转发前从请求中移除
+func clientTimeout(c *gin.Context, config types.Config) time.Duration {
+	if config.MaxClientTimeoutMs <= 0 {
+		return 0
+	}
+
+	value := strings.TrimSpace(c.Request.Header.Get(RequestTimeoutHeader))
+	c.Request.Header.Del(RequestTimeoutHeader)
+	if value == "" {
+		return 0
+	}
+
+	ms, err := strconv.Atoi(value)
+	if err != nil || ms <= 0 {
+		logger.Warning("PROXY", "Ignoring %s: %q is not a positive integer", RequestTimeoutHeader, value)
+		return 0
+	}
+	if ms > config.MaxClientTimeoutMs {
+		ms = config.MaxClientTimeoutMs
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// DefaultRegionHeader 未配置 region_header 时携带客户端区域的请求头
+const DefaultRegionHeader = "X-LB-Region"
+
+// requestRegion 返回请求优先使用的区域：请求头中的区域，未携带时使用 default_region
+func requestRegion(c *gin.Context, config types.Config) string {
+	header := config.RegionHeader
+	if header == "" {
+		header = DefaultRegionHeader
+	}
+	if region := strings.TrimSpace(c.Request.Header.Get(header)); region != "" {
+		return region
+	}
+	return config.DefaultRegion
+}
+
+// hasModelWeights 判断是否有服务器配置了 model_weights
+func hasModelWeights(servers []types.UpstreamServer) bool {
+	for _, server := range servers {
+		if len(server.ModelWeights) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func Handler(config types.Config, balancer *balance.Balancer, statsReporter *stats.Reporter) gin.HandlerFunc {
+	// 只有配置了 model_weights 时才需要在选择服务器前解析请求体
+	modelRouting := hasModelWeights(config.Servers)
+	// 所有请求共享连接池（配置了独立客户端证书的服务器除外）
+	clients := newUpstreamClients(config)
+	// 所有请求共享重试预算
+	retries := newRetryBudget(config)
+	if retries != nil {
+		statsReporter.SetRetryBudget(retries.snapshot)
+	}
+	// 启用 coalesce_requests 时合并并发的相同请求
+	coalesce := newCoalescer(config)
+	// 配置了 shadow_percent 时按比例将请求复制到影子服务器
+	shadow := newShadowMirror(config, clients)
+	if shadow != nil {
+		statsReporter.SetShadow(shadow.snapshot)
+	}
+
+	forward := func(c *gin.Context) {
 		startTime := time.Now()
-		statsReporter.IncrementRequestCount()
+
+		var model string
+		if modelRouting {
+			model = requestModel(c)
+		}
+		opts := selector.SelectOptions{
+			Model:     model,
+			Algorithm: requestAlgorithm(c, config),
+			Region:    requestRegion(c, config),
+		}
+		if timeout := clientTimeout(c, config); timeout > 0 {
+			c.Set(contextKeyClientTimeout, timeout)
+		}
 
 		// 获取可用服务器
-		server, err := balancer.GetNextServer()
+		server, err := selectAvailableServer(balancer, opts)
+		if err != nil && config.QueueWaitSeconds > 0 {
+			// 所有服务器都在冷却时排队等待恢复，而不是立即失败
+			logger.Warning("PROXY", "No available servers, waiting up to %ds for recovery", config.QueueWaitSeconds)
+			server, err = balancer.WaitForServer(c.Request.Context(), opts, time.Duration(config.QueueWaitSeconds)*time.Second)
+		}
 		if err != nil {
+			// 所有服务器都在冷却中时立即失败，并提示最早恢复的时间，客户端无需盲目重试
+			if remaining, ok := minCooldownRemaining(balancer, time.Now()); ok {
+				retryAfter := int(math.Ceil(remaining.Seconds()))
+				logger.Error("PROXY", "No available servers: all upstreams in cooldown, earliest recovery in %ds", retryAfter)
+				c.Header("Retry-After", strconv.Itoa(retryAfter))
+				c.JSON(http.StatusServiceUnavailable, errorResponse(config, errorTypeOverloaded,
+					fmt.Sprintf("All upstreams in cooldown, retry in %ds", retryAfter), gin.H{"retry_after_seconds": retryAfter}))
+				return
+			}
 			logger.Error("PROXY", "No available servers: %v", err)
-			c.JSON(502, gin.H{"error": "No available servers"})
+			c.JSON(http.StatusServiceUnavailable, errorResponse(config, errorTypeOverloaded, "No available servers", nil))
 			return
 		}
 
-		// 转发请求到选定的服务器
-		success := forwardRequest(c, server, balancer, statsReporter, startTime, debugMode)
-		if !success {
-			statsReporter.IncrementErrorCount()
-			c.JSON(502, gin.H{"error": "Request failed"})
+		// 启用 try_all_servers 时，失败后依次尝试其他可用服务器（每个服务器最多一次）
+		attempted := make(map[string]bool)
+		var lastErr *upstreamError
+		for server != nil {
+			attempted[server.URL] = true
+
+			lastErr = forwardWithTracking(c, config, clients.forServer(server.URL), server, balancer, statsReporter, startTime)
+			if lastErr == nil {
+				return
+			}
+			if lastErr.Canceled {
+				// 客户端已断开，不计入错误，也不再尝试其他服务器（499 沿用 nginx 的约定，仅用于访问日志）
+				c.Status(499)
+				return
+			}
+			// 客户端指定的超时已到期，换服务器也来不及
+			if lastErr.ClientDeadline {
+				break
+			}
+			// 金丝雀服务器失败时总是再按正常选择尝试一次，避免金丝雀故障直接影响客户端
+			if !config.TryAllServers && server.URL != config.Canary {
+				break
+			}
+
+			server = nextUntriedServer(balancer, opts, attempted)
+			if server == nil {
+				break
+			}
+			if !retries.allow() {
+				balancer.ReleaseConnection(server.URL)
+				logger.Warning("PROXY", "Attempt %d failed, retry budget exhausted, not retrying", len(attempted))
+				break
+			}
+			logger.Warning("PROXY", "Attempt %d failed, trying next server: %s", len(attempted), server.URL)
+		}
+
+		statsReporter.IncrementErrorCount()
+		if config.TryAllServers {
+			logger.Error("PROXY", "All %d attempted servers failed", len(attempted))
+		}
+		c.JSON(lastErr.status(), failureResponse(config, lastErr, len(attempted)))
+	}
+
+	return func(c *gin.Context) {
+		statsReporter.IncrementRequestCount()
+
+		// 请求头过多或过大时直接返回 431，避免在复制请求头时消耗过多资源
+		if reason, tooLarge := requestHeadersTooLarge(c.Request.Header, config); tooLarge {
+			logger.Warning("PROXY", "Rejected request with oversized headers (%s): %s %s from %s", reason, c.Request.Method, c.Request.URL.Path, c.ClientIP())
+			c.JSON(http.StatusRequestHeaderFieldsTooLarge, errorResponse(config, errorTypeInvalidRequest, "Request header fields too large", nil))
+			return
+		}
+
+		// 请求体超过 max_request_body_bytes 时直接返回 413，之后的校验和转发都读取缓冲的请求体
+		if !bufferRequestBody(c, config) {
+			return
+		}
+
+		// 请求体不是合法 JSON 时直接返回 400，不再浪费一次上游请求
+		if config.ValidateJSONBody && invalidJSONBody(c) {
+			logger.Warning("PROXY", "Rejected request with malformed JSON body: %s %s", c.Request.Method, c.Request.URL.Path)
+			c.JSON(400, errorResponse(config, errorTypeInvalidRequest, "Invalid JSON request body", nil))
+			return
+		}
+
+		// 影子请求与主请求并行发出，主请求结束后再比较状态码和延迟
+		mirrored := shadow.start(c)
+		defer func() { mirrored.finish(c.Writer.Status()) }()
+
+		if key, ok := coalesce.key(c); ok {
+			coalesce.serve(c, key, forward)
+			return
+		}
+		forward(c)
+	}
+}
+
+// failureResponse 构造转发失败时返回给客户端的错误响应
+// 默认只返回通用错误信息；启用 expose_upstream_errors 时附带最后一个上游的状态码、错误信息和服务器地址
+func failureResponse(config types.Config, lastErr *upstreamError, attempts int) gin.H {
+	message, errorType := "Request failed", errorTypeAPI
+	if lastErr.status() == http.StatusGatewayTimeout {
+		message = "Upstream request timed out"
+	}
+	if lastErr != nil && lastErr.StatusCode == http.StatusTooManyRequests {
+		errorType = errorTypeRateLimit
+	}
+
+	response := gin.H{}
+	if config.TryAllServers {
+		response["attempts"] = attempts
+	}
+
+	if config.ExposeUpstreamErrors && lastErr != nil {
+		upstream := gin.H{
+			"server":  redactServerURL(lastErr.Server),
+			"message": lastErr.Message,
+		}
+		if lastErr.StatusCode > 0 {
+			upstream["status"] = lastErr.StatusCode
+		}
+		response["upstream"] = upstream
+	}
+
+	return errorResponse(config, errorType, message, response)
+}
+
+// redactServerURL 只保留服务器地址的协议和主机部分，去掉路径和认证信息
+func redactServerURL(serverURL string) string {
+	parsed, err := url.Parse(serverURL)
+	if err != nil || parsed.Host == "" {
+		return "(redacted)"
+	}
+	return parsed.Scheme + "://" + parsed.Host
+}
+
+// maxReselectAttempts 选中的服务器在转发前已被其他请求标记为不可用时，最多重新选择的次数
+const maxReselectAttempts = 3
+
+// selectAvailableServer 选择服务器并占用一个在途连接，并在转发前再次确认其仍然可用
+// 选择与转发之间并发请求可能已将该服务器标记为不可用，此时释放连接并重新选择
+func selectAvailableServer(balancer *balance.Balancer, opts selector.SelectOptions) (*types.UpstreamServer, error) {
+	server, err := balancer.SelectAndAcquire(opts)
+	for attempt := 0; err == nil && attempt < maxReselectAttempts; attempt++ {
+		if balancer.IsServerAvailable(server.URL) {
+			return server, nil
+		}
+		logger.Debug("PROXY", "Server %s became unavailable before forwarding, reselecting", server.URL)
+		balancer.ReleaseConnection(server.URL)
+		server, err = balancer.SelectAndAcquire(opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// 多次重新选择后仍使用最后一次选择的结果，避免服务器状态频繁变化时无限重试
+	return server, nil
+}
+
+// forwardWithTracking 转发请求，结束后释放选择服务器时占用的在途连接
+func forwardWithTracking(c *gin.Context, config types.Config, client *http.Client, server *types.UpstreamServer, balancer *balance.Balancer, statsReporter *stats.Reporter, startTime time.Time) *upstreamError {
+	defer balancer.ReleaseConnection(server.URL)
+
+	return forwardRequest(c, config, client, server, balancer, statsReporter, startTime)
+}
+
+// nextUntriedServer 选择本次请求中尚未尝试过的下一个可用服务器，返回的服务器已占用一个在途连接
+// 优先使用选择器的结果，选择器返回已尝试的服务器时按配置顺序挑选剩余的可用服务器（优先同区域）
+func nextUntriedServer(balancer *balance.Balancer, opts selector.SelectOptions, attempted map[string]bool) *types.UpstreamServer {
+	if server, err := balancer.SelectAndAcquire(opts); err == nil {
+		if !attempted[server.URL] && balancer.IsServerAvailable(server.URL) {
+			return server
+		}
+		balancer.ReleaseConnection(server.URL)
+	}
+
+	server := untriedServer(balancer, opts, attempted)
+	if server != nil {
+		balancer.AcquireConnection(server.URL)
+	}
+	return server
+}
+
+// untriedServer 按配置顺序返回第一个未尝试过的可用服务器（优先同区域）
+func untriedServer(balancer *balance.Balancer, opts selector.SelectOptions, attempted map[string]bool) *types.UpstreamServer {
+
+	var otherRegion *types.UpstreamServer
+	for _, server := range balancer.GetAvailableServers() {
+		if attempted[server.URL] || !balancer.IsServerAvailable(server.URL) {
+			continue
+		}
+		if opts.Region == "" || strings.EqualFold(server.Region, opts.Region) {
+			return &server
+		}
+		if otherRegion == nil {
+			otherRegion = &server
+		}
+	}
+	return otherRegion
+}
+
+// minCooldownRemaining 返回冷却中的服务器最早恢复的剩余时间
+// 永久禁用的服务器和余额不足、手动下线等不会随冷却到期恢复的服务器不计入；没有这样的服务器时返回 false
+func minCooldownRemaining(balancer *balance.Balancer, now time.Time) (time.Duration, bool) {
+	var minRemaining time.Duration
+	found := false
+	for url, available := range balancer.GetServerStatus() {
+		if available || balancer.IsServerDisabled(url) || !balancer.GetDownReason(url).IsFailure() {
+			continue
+		}
+		remaining := balancer.GetServerDownUntil(url).Sub(now)
+		if remaining <= 0 {
+			continue
+		}
+		if !found || remaining < minRemaining {
+			minRemaining = remaining
+			found = true
+		}
+	}
+	return minRemaining, found
+}
+
+// parseRetryAfter 解析 Retry-After 头（秒数或 HTTP 日期），返回需要等待的时长
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds <= 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if retryAt, err := http.ParseTime(value); err == nil {
+		if wait := retryAt.Sub(now); wait > 0 {
+			return wait, true
+		}
+	}
+
+	return 0, false
+}
+
+// defaultErrorBodyLogBytes 未配置 error_body_log_bytes 时读取上游错误响应体的上限
+const defaultErrorBodyLogBytes = 8 << 10
+
+// errorBodyLimit 返回触发故障转移的错误响应最多读取的字节数（这类响应体只用于日志，不转发给客户端）
+func errorBodyLimit(config types.Config) int64 {
+	if config.ErrorBodyLogBytes > 0 {
+		return int64(config.ErrorBodyLogBytes)
+	}
+	return defaultErrorBodyLogBytes
+}
+
+// summarizeErrorBody 将错误响应体整理为单行并截断，用于日志和错误信息
+func summarizeErrorBody(body []byte) string {
+	errorDetail := strings.TrimSpace(string(body))
+
+	// 清理换行符，使日志更紧凑
+	errorDetail = strings.ReplaceAll(errorDetail, "\n", " ")
+	errorDetail = strings.ReplaceAll(errorDetail, "\r", "")
+	// 限制错误详情长度以避免日志过长
+	if len(errorDetail) > 500 {
+		errorDetail = errorDetail[:500] + "..."
+	}
+	if errorDetail == "" {
+		errorDetail = "(empty response body)"
+	}
+	return errorDetail
+}
+
+// isErrorBody 判断响应体是否为错误格式的 JSON（"type": "error" 或顶层 error 字段）
+func isErrorBody(body []byte) bool {
+	if !looksLikeJSONObject(body) {
+		return false
+	}
+
+	var response struct {
+		Type  string          `json:"type"`
+		Error json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return false
+	}
+	return response.Type == "error" || (len(response.Error) > 0 && string(response.Error) != "null")
+}
+
+// isUpstreamAuthError 判断上游是否拒绝了配置的凭据（401/403）
+func isUpstreamAuthError(statusCode int) bool {
+	return statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden
+}
+
+// copyForwardedHeaders 将匹配前缀的上游响应头复制到客户端响应（跳过 strip 中列出的头）
+func copyForwardedHeaders(c *gin.Context, header http.Header, prefixes []string, strip []string) {
+	stripped := newHeaderFilter(nil, "", strip)
+
+	for key, values := range header {
+		lowerKey := strings.ToLower(key)
+		if stripped.contains(lowerKey) {
+			continue
+		}
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(lowerKey, strings.ToLower(prefix)) {
+				// 多次尝试时以最后一个失败服务器的值为准
+				c.Writer.Header().Del(key)
+				for _, value := range values {
+					c.Writer.Header().Add(key, value)
+				}
+				break
+			}
+		}
+	}
+}
+
+// defaultRequestTimeout 未配置超时时的默认值
+const defaultRequestTimeout = 60 * time.Second
+
+// requestTimeout 返回请求超时时间：服务器配置 > 全局配置 > 默认值
+func requestTimeout(config types.Config, server *types.UpstreamServer) time.Duration {
+	if server.RequestTimeoutSeconds > 0 {
+		return time.Duration(server.RequestTimeoutSeconds) * time.Second
+	}
+	if config.RequestTimeoutSeconds > 0 {
+		return time.Duration(config.RequestTimeoutSeconds) * time.Second
+	}
+	return defaultRequestTimeout
+}
+
+// serverTokens 返回服务器的所有 token（主 token 在前），至少包含一个元素
+func serverTokens(server *types.UpstreamServer) []string {
+	tokens := make([]string, 0, 1+len(server.Tokens))
+	if server.Token != "" {
+		tokens = append(tokens, server.Token)
+	}
+	for _, token := range server.Tokens {
+		if token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+	if len(tokens) == 0 {
+		tokens = append(tokens, "")
+	}
+	return tokens
+}
+
+// newUpstreamRequest 构造发往上游的请求，复制客户端头并替换鉴权 token
+func newUpstreamRequest(ctx context.Context, c *gin.Context, config types.Config, server *types.UpstreamServer, target string, requestBody []byte, token string) (*http.Request, error) {
+	// 没有请求体的 GET/HEAD/DELETE 不发送请求体，避免上游等待
+	var body io.Reader
+	if len(requestBody) > 0 || !isBodylessMethod(c.Request.Method) {
+		body = bytes.NewReader(requestBody)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, c.Request.Method, target, body)
+	if err != nil {
+		return nil, err
+	}
+
+	// 需要过滤的hop-by-hop头 (RFC 2616)、host 等固定头，以及配置中要求移除的客户端请求头
+	skipHeaders := newHeaderFilter(requestSkipHeaders, c.Request.Header.Get("Connection"), config.StripRequestHeaders)
+
+	// basic 鉴权的上游不使用 token，客户端的 Authorization 头在下面整体替换
+	basicAuth := server.AuthType == "basic"
+
+	for key, values := range c.Request.Header {
+		lowerKey := strings.ToLower(key)
+		if lowerKey == "authorization" {
+			if !basicAuth {
+				req.Header.Set(key, "Bearer "+token)
+			}
+		} else if !skipHeaders.contains(lowerKey) {
+			for _, value := range values {
+				req.Header.Add(key, value)
+			}
+		}
+	}
+
+	if basicAuth {
+		req.SetBasicAuth(server.Username, server.Password)
+	}
+
+	// Host 头：服务器配置优先，其次按配置保留客户端的 Host，默认使用上游地址的主机名
+	if host := upstreamHost(c, config, server); host != "" {
+		req.Host = host
+	}
+
+	// 客户端未携带 anthropic-version 时补充默认值，不覆盖客户端的值
+	if req.Header.Get("anthropic-version") == "" {
+		if version := anthropicVersion(config, server); version != "" {
+			req.Header.Set("anthropic-version", version)
+		}
+	}
+
+	return req, nil
+}
+
+// upstreamServerHeader 启用 expose_upstream_header 时返回处理请求的服务器的响应头
+const upstreamServerHeader = "X-Upstream-Server"
+
+// serverLabel 返回服务器的展示名称：配置了 name 时使用 name，否则使用 URL
+func serverLabel(server *types.UpstreamServer) string {
+	if server.Name != "" {
+		return server.Name
+	}
+	return server.URL
+}
+
+// upstreamHost 返回发往上游的 Host 头，返回空时使用上游地址的主机名
+func upstreamHost(c *gin.Context, config types.Config, server *types.UpstreamServer) string {
+	if server.HostHeader != "" {
+		return server.HostHeader
+	}
+	if config.PreserveHost {
+		return c.Request.Host
+	}
+	return ""
+}
+
+// anthropicVersion 返回默认的 anthropic-version 头：服务器配置优先于全局配置，均未配置时返回空
+func anthropicVersion(config types.Config, server *types.UpstreamServer) string {
+	if server.AnthropicVersion != "" {
+		return server.AnthropicVersion
+	}
+	return config.AnthropicVersion
+}
+
+// isBodylessMethod 判断请求方法通常是否不带请求体
+func isBodylessMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodDelete, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// upstreamError 转发失败的详情
+type upstreamError struct {
+	Server         string // 失败的上游服务器
+	StatusCode     int    // 上游返回的状态码，连接错误等情况下为 0
+	Message        string // 截断后的错误信息
+	Canceled       bool   // 客户端取消了请求（与上游无关，不再尝试其他服务器）
+	Timeout        bool   // 上游请求超时
+	ClientDeadline bool   // 客户端通过 X-Request-Timeout-Ms 指定的超时到期（不标记服务器，也不再尝试其他服务器）
+}
+
+// status 返回请求最终失败时响应给客户端的状态码：上游超时返回 504，其他上游故障返回 502
+func (e *upstreamError) status() int {
+	if e != nil && e.Timeout {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
+
+// forwardTrailers 将上游响应的 trailer 写给客户端（需在响应体转发完成后调用），过滤规则与响应头相同
+// 使用 http.TrailerPrefix 写入，上游未预先声明的 trailer 也能转发
+func forwardTrailers(c *gin.Context, trailer http.Header, filter headerFilter) {
+	for key, values := range trailer {
+		if filter.contains(strings.ToLower(key)) {
+			continue
+		}
+		for _, value := range values {
+			c.Writer.Header().Add(http.TrailerPrefix+key, value)
 		}
 	}
 }
 
-// forwardRequest 转发请求到指定服务器
-func forwardRequest(c *gin.Context, server *types.UpstreamServer, balancer *balance.Balancer, statsReporter *stats.Reporter, startTime time.Time, debugMode bool) bool {
-	target := server.URL + c.Request.URL.Path
+// upstreamTarget 返回重写后的请求路径和发往 serverURL 的完整上游地址
+func upstreamTarget(c *gin.Context, config types.Config, serverURL string) (requestPath, target string) {
+	// 在构造上游地址前重写路径前缀
+	requestPath = rewritePath(c.Request.URL.Path, config.PathPrefixStrip, config.PathPrefixAdd)
+
+	target = serverURL + requestPath
 	if c.Request.URL.RawQuery != "" {
 		target += "?" + c.Request.URL.RawQuery
 	}
@@ -183,14 +976,30 @@ func forwardRequest(c *gin.Context, server *types.UpstreamServer, balancer *bala
 	// 但保留协议的 ://
 	target = strings.Replace(target, "http:/", "http://", 1)
 	target = strings.Replace(target, "https:/", "https://", 1)
+	return requestPath, target
+}
+
+// forwardRequest 转发请求到指定服务器，成功时返回 nil
+func forwardRequest(c *gin.Context, config types.Config, client *http.Client, server *types.UpstreamServer, balancer *balance.Balancer, statsReporter *stats.Reporter, startTime time.Time) *upstreamError {
+	debugMode := config.Debug
+	stats.SetRequestServer(c, server.URL)
+
+	requestPath, target := upstreamTarget(c, config, server.URL)
 
 	// 请求日志 - 显示完整URL
-	fullRequestURL := formatRequestURL(c.Request.Method, server.URL, c.Request.URL.Path, c.Request.URL.RawQuery)
+	fullRequestURL := formatRequestURL(c.Request.Method, server.URL, requestPath, c.Request.URL.RawQuery)
 	logger.Info("PROXY", "%s", fullRequestURL)
 
-	client := &http.Client{
-		Timeout: 60 * time.Second,
+	// 请求超时：客户端指定的超时优先，其次服务器配置、全局配置；客户端断开时同时取消上游请求
+	// 客户端指定的超时短于服务器超时时，到期是客户端自身的期限造成的，不能据此判断服务器故障
+	timeout := requestTimeout(config, server)
+	clientDeadline := false
+	if requested := c.GetDuration(contextKeyClientTimeout); requested > 0 {
+		clientDeadline = requested < timeout
+		timeout = requested
 	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+	defer cancel()
 
 	// 读取请求体内容用于调试和转发
 	var requestBody []byte
@@ -199,30 +1008,20 @@ func forwardRequest(c *gin.Context, server *types.UpstreamServer, balancer *bala
 		requestBody, err = io.ReadAll(c.Request.Body)
 		if err != nil {
 			logger.Error("PROXY", "Failed to read request body: %v", err)
-			return false
+			return &upstreamError{Server: server.URL, Message: "failed to read request body"}
 		}
 		c.Request.Body.Close()
+		// 保留请求体，失败后转发到其他服务器时可以再次读取
+		c.Request.Body = io.NopCloser(bytes.NewReader(requestBody))
 	}
 
-	req, err := http.NewRequest(c.Request.Method, target, bytes.NewReader(requestBody))
+	// 服务器的所有 token，429 时依次尝试
+	tokens := serverTokens(server)
+
+	req, err := newUpstreamRequest(ctx, c, config, server, target, requestBody, tokens[0])
 	if err != nil {
 		logger.Error("PROXY", "Failed to create request: %v", err)
-		return false
-	}
-
-	// 获取需要过滤的hop-by-hop头 (RFC 2616)
-	hopByHopHeaders := getHopByHopHeaders(c.Request.Header.Get("Connection"))
-	hopByHopHeaders["host"] = true // 额外添加host头
-
-	for key, values := range c.Request.Header {
-		lowerKey := strings.ToLower(key)
-		if lowerKey == "authorization" {
-			req.Header.Set(key, "Bearer "+server.Token)
-		} else if !hopByHopHeaders[lowerKey] {
-			for _, value := range values {
-				req.Header.Add(key, value)
-			}
-		}
+		return &upstreamError{Server: server.URL, Message: "failed to create upstream request"}
 	}
 
 	// Debug 模式下记录请求详细信息
@@ -259,11 +1058,45 @@ func forwardRequest(c *gin.Context, server *types.UpstreamServer, balancer *bala
 		}
 	}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		logger.Error("PROXY", "Request failed: %s | Error: %v", fullRequestURL, err)
-		balancer.MarkServerDown(server.URL)
-		return false
+	var resp *http.Response
+	for tokenIndex := 0; ; tokenIndex++ {
+		if tokenIndex > 0 {
+			req, err = newUpstreamRequest(ctx, c, config, server, target, requestBody, tokens[tokenIndex])
+			if err != nil {
+				logger.Error("PROXY", "Failed to create request: %v", err)
+				return &upstreamError{Server: server.URL, Message: "failed to create upstream request"}
+			}
+		}
+
+		token := tokens[tokenIndex]
+		resp, err = doWithStaleRetry(client, req, func() (*http.Request, error) {
+			return newUpstreamRequest(ctx, c, config, server, target, requestBody, token)
+		})
+		if err != nil {
+			// 只有上游自身的故障才标记服务器为不可用，客户端取消的请求不影响服务器状态
+			class := classifyRequestError(c.Request.Context(), err)
+			if !class.isUpstreamFailure() {
+				logger.Warning("PROXY", "Request canceled by client: %s | Error: %v", fullRequestURL, err)
+				return &upstreamError{Server: server.URL, Message: "request canceled by client", Canceled: true}
+			}
+			if class == errorClassTimeout && clientDeadline {
+				logger.Warning("PROXY", "Client timeout of %v expired: %s", timeout, fullRequestURL)
+				return &upstreamError{Server: server.URL, Message: "client request timeout expired", Timeout: true, ClientDeadline: true}
+			}
+			logger.Error("PROXY", "Request failed: %s | Error (%s): %v", fullRequestURL, class, err)
+			balancer.MarkServerDownWithReason(server.URL, selector.DownReasonConnection)
+			return &upstreamError{Server: server.URL, Message: err.Error(), Timeout: class == errorClassTimeout}
+		}
+
+		// 429 时如果还有未使用的 token，在同一服务器上换 token 重试，而不是直接熔断
+		if resp.StatusCode == 429 && tokenIndex+1 < len(tokens) {
+			logger.Warning("PROXY", "Rate limited: %s | Token %d/%d exhausted, retrying with next token",
+				fullRequestURL, tokenIndex+1, len(tokens))
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			continue
+		}
+		break
 	}
 	defer resp.Body.Close()
 
@@ -283,21 +1116,43 @@ func forwardRequest(c *gin.Context, server *types.UpstreamServer, balancer *bala
 	var responseBody bytes.Buffer
 	var responseReader io.Reader
 
+	// HEAD 响应没有响应体：不按流式处理，也不解析用量
+	headRequest := c.Request.Method == http.MethodHead
+
 	// 检查是否为流式响应
-	isStreaming := strings.Contains(resp.Header.Get("Content-Type"), "text/event-stream")
+	isStreaming := !headRequest && strings.Contains(resp.Header.Get("Content-Type"), "text/event-stream")
+	// 非流式响应超过 max_buffer_response_bytes 时直接转发，不完整缓冲
+	var oversized bool
+
+	// 5xx、429（以及启用 trip_on_auth_error 时的鉴权失败）触发故障转移，响应体不转发给客户端
+	tripAuthError := config.TripOnAuthError && isUpstreamAuthError(resp.StatusCode)
+	failover := resp.StatusCode >= 500 || resp.StatusCode == 429 || tripAuthError
 
 	if isStreaming {
 		// 流式响应：使用 TeeReader 同时收集数据和传输
 		responseReader = io.TeeReader(resp.Body, &responseBody)
 	} else {
-		// 非流式响应：先读取完整响应体
-		bodyBytes, err := io.ReadAll(resp.Body)
+		// 非流式响应：先读取完整响应体（配置了上限时最多读取上限 + 1 字节）
+		// 触发故障转移的错误响应只读取 error_body_log_bytes 用于日志，避免异常上游返回超大错误响应体耗尽内存
+		limitedBody := io.Reader(resp.Body)
+		if failover {
+			limitedBody = io.LimitReader(resp.Body, errorBodyLimit(config))
+		} else if config.MaxBufferResponseBytes > 0 {
+			limitedBody = io.LimitReader(resp.Body, config.MaxBufferResponseBytes+1)
+		}
+		bodyBytes, err := io.ReadAll(limitedBody)
 		if err != nil {
 			logger.Error("PROXY", "Failed to read response body: %v", err)
-			return false
+			return &upstreamError{Server: server.URL, StatusCode: resp.StatusCode, Message: "failed to read response body"}
 		}
 		responseBody.Write(bodyBytes)
 		responseReader = bytes.NewReader(bodyBytes)
+
+		// 超过上限：已读取的部分只用于错误日志，剩余部分边读边转发
+		if !failover && config.MaxBufferResponseBytes > 0 && int64(len(bodyBytes)) > config.MaxBufferResponseBytes {
+			oversized = true
+			responseReader = io.MultiReader(responseReader, resp.Body)
+		}
 	}
 
 	// Debug 模式下记录完整原始响应（仅限非流式响应）
@@ -315,34 +1170,48 @@ func forwardRequest(c *gin.Context, server *types.UpstreamServer, balancer *bala
 		}
 	}
 
-	// 检查响应状态，如果是5xx错误或429速率限制，标记服务器为不可用
-	if resp.StatusCode >= 500 || resp.StatusCode == 429 {
+	// 检查响应状态，如果是5xx错误或429速率限制（以及启用 trip_on_auth_error 时的鉴权失败），标记服务器为不可用
+	if failover {
+		// 保留上游的速率限制等头，随合成的错误响应返回给客户端
+		copyForwardedHeaders(c, resp.Header, config.ForwardHeaderPrefixes, config.StripResponseHeaders)
+
 		// 对于非流式响应，使用已读取的响应体
-		var errorDetail string
+		errorDetail := "streaming response error"
 		if !isStreaming {
-			errorDetail = strings.TrimSpace(responseBody.String())
-		} else {
-			errorDetail = "streaming response error"
-		}
-
-		// 清理换行符，使日志更紧凑
-		errorDetail = strings.ReplaceAll(errorDetail, "\n", " ")
-		errorDetail = strings.ReplaceAll(errorDetail, "\r", "")
-		// 限制错误详情长度以避免日志过长
-		if len(errorDetail) > 500 {
-			errorDetail = errorDetail[:500] + "..."
-		}
-		if errorDetail == "" {
-			errorDetail = "(empty response body)"
+			errorDetail = summarizeErrorBody(responseBody.Bytes())
 		}
+		failure := &upstreamError{Server: server.URL, StatusCode: resp.StatusCode, Message: errorDetail}
 
 		if resp.StatusCode == 429 {
 			logger.Warning("PROXY", "Rate limited: %s | Status: %d | Response: %s", fullRequestURL, resp.StatusCode, errorDetail)
-		} else {
-			logger.Error("PROXY", "Server error: %s | Status: %d | Response: %s", fullRequestURL, resp.StatusCode, errorDetail)
+
+			// 优先使用上游 Retry-After 指定的冷却时间，其次 rate_limit_cooldown_seconds，都没有时使用默认冷却
+			retryAfter, _ := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+			if retryAfter <= 0 && config.RateLimitCooldownSeconds > 0 {
+				retryAfter = time.Duration(config.RateLimitCooldownSeconds) * time.Second
+			}
+			balancer.MarkServerDownWithReasonFor(server.URL, selector.DownReasonRateLimited, retryAfter)
+			return failure
 		}
-		balancer.MarkServerDown(server.URL)
-		return false
+		if tripAuthError {
+			// 鉴权持续失败说明 token 配置错误，重试没有意义
+			logger.Error("PROXY", "Upstream auth error: %s | Status: %d | Response: %s (check the server token configuration)",
+				fullRequestURL, resp.StatusCode, errorDetail)
+			balancer.MarkServerDownWithReason(server.URL, selector.DownReasonAuth)
+			return failure
+		}
+		logger.Error("PROXY", "Server error: %s | Status: %d | Response: %s", fullRequestURL, resp.StatusCode, errorDetail)
+		balancer.MarkServerDownWithReason(server.URL, selector.DownReasonServerError)
+		return failure
+	}
+
+	// 部分网关返回 200 但响应体是错误信息，启用 treat_error_body_as_failure 时按上游故障处理
+	// 未启用时不解析响应体，避免每个非流式响应多一次 JSON 解码
+	if config.TreatErrorBodyAsFailure && resp.StatusCode == 200 && !isStreaming && !oversized && isErrorBody(responseBody.Bytes()) {
+		errorDetail := summarizeErrorBody(responseBody.Bytes())
+		logger.Error("PROXY", "Error body with status 200: %s | Response: %s", fullRequestURL, errorDetail)
+		balancer.MarkServerDownWithReason(server.URL, selector.DownReasonServerError)
+		return &upstreamError{Server: server.URL, StatusCode: resp.StatusCode, Message: errorDetail}
 	}
 
 	// 记录响应时间和统计
@@ -353,14 +1222,30 @@ func forwardRequest(c *gin.Context, server *types.UpstreamServer, balancer *bala
 	// 标记服务器为健康（重置失败计数）
 	balancer.MarkServerHealthy(server.URL)
 
-	// 记录响应日志
-	if resp.StatusCode == 200 {
+	// 响应时间超过 slow_request_threshold_ms 时以警告级别记录成功响应，便于在上游开始出错前发现性能下降
+	slow := config.SlowRequestThresholdMs > 0 && responseTime.Milliseconds() > int64(config.SlowRequestThresholdMs)
+	logSuccess, successLabel := logger.Success, "Success"
+	if slow {
+		logSuccess = logger.Warning
+		successLabel = fmt.Sprintf("Slow response (threshold %dms)", config.SlowRequestThresholdMs)
+	}
+
+	// 记录响应日志：状态码属于 success_status_codes 的响应记为成功（与上面的熔断判断无关）
+	success := config.SuccessStatusMatcher.Match(resp.StatusCode)
+	if success {
+		statsReporter.IncrementSuccessCount()
+	}
+	if resp.StatusCode == 200 && success && !headRequest {
 		// 对于非流式响应，直接解析统计信息
 		var model string
 		var usage types.ClaudeUsage
 		var parseSuccess bool
 
-		if !isStreaming {
+		if oversized {
+			// 超过缓冲上限的响应不解析统计信息
+			logger.Info("PROXY", "Response exceeds max_buffer_response_bytes (%d), forwarding without usage parsing: %s",
+				config.MaxBufferResponseBytes, fullRequestURL)
+		} else if !isStreaming {
 			model, usage, parseSuccess = parseUsageInfo(responseBody.Bytes(), resp.Header.Get("Content-Type"))
 		} else {
 			// 流式响应的统计会在后续处理
@@ -368,31 +1253,54 @@ func forwardRequest(c *gin.Context, server *types.UpstreamServer, balancer *bala
 		}
 
 		if parseSuccess && model != "" {
-			logger.Success("PROXY", "Success: %s | Status: %d (%dms) | Model: %s | Input: %d | Output: %d | Cache Create: %d | Cache Read: %d",
-				fullRequestURL, resp.StatusCode, responseTime.Milliseconds(),
+			model = canonicalModelName(config.ModelAliases, model)
+			statsReporter.AddModelStats(model)
+			stats.SetRequestUsage(c, model, usage)
+			logSuccess("PROXY", "%s: %s | Status: %d (%dms) | Model: %s | Input: %d | Output: %d | Cache Create: %d | Cache Read: %d",
+				successLabel, fullRequestURL, resp.StatusCode, responseTime.Milliseconds(),
 				model, usage.InputTokens, usage.OutputTokens, usage.CacheCreationInputTokens, usage.CacheReadInputTokens)
 		} else {
-			logger.Success("PROXY", "Success: %s | Status: %d (%dms)", fullRequestURL, resp.StatusCode, responseTime.Milliseconds())
+			logSuccess("PROXY", "%s: %s | Status: %d (%dms)", successLabel, fullRequestURL, resp.StatusCode, responseTime.Milliseconds())
 		}
+	} else if success {
+		logSuccess("PROXY", "%s: %s | Status: %d (%dms)", successLabel, fullRequestURL, resp.StatusCode, responseTime.Milliseconds())
 	} else if resp.StatusCode < 400 {
 		logger.Info("PROXY", "Response: %s | Status: %d (%dms)", fullRequestURL, resp.StatusCode, responseTime.Milliseconds())
+	} else if isUpstreamAuthError(resp.StatusCode) {
+		// 上游鉴权失败通常是服务器 token 配置错误，而不是客户端请求的问题
+		logger.Error("PROXY", "Upstream auth error: %s | Status: %d (%dms) (check the server token configuration)",
+			fullRequestURL, resp.StatusCode, responseTime.Milliseconds())
 	} else {
 		// 对于客户端错误，只记录状态码（因为响应体会被转发给客户端）
 		logger.Warning("PROXY", "Client error: %s | Status: %d (%dms)", fullRequestURL, resp.StatusCode, responseTime.Milliseconds())
 	}
 
 	// 复制响应头，但要过滤hop-by-hop头
-	responseHopByHopHeaders := getHopByHopHeaders(resp.Header.Get("Connection"))
+	responseHopByHopHeaders := newHeaderFilter(hopByHopHeaders, resp.Header.Get("Connection"), config.StripResponseHeaders)
 
 	for key, values := range resp.Header {
 		lowerKey := strings.ToLower(key)
-		if !responseHopByHopHeaders[lowerKey] {
+		if !responseHopByHopHeaders.contains(lowerKey) {
 			for _, value := range values {
 				c.Header(key, value)
 			}
 		}
 	}
 
+	// 标明处理请求的上游服务器，便于排查路由问题（默认关闭，避免泄露内部地址）
+	if config.ExposeUpstreamHeader {
+		c.Header(upstreamServerHeader, serverLabel(server))
+	}
+
+	// 流式响应预先声明上游的 trailer，值在响应体转发完成后写入
+	if isStreaming {
+		for key := range resp.Trailer {
+			if !responseHopByHopHeaders.contains(strings.ToLower(key)) {
+				c.Writer.Header().Add("Trailer", key)
+			}
+		}
+	}
+
 	c.Status(resp.StatusCode)
 
 	// 处理响应转发
@@ -402,11 +1310,24 @@ func forwardRequest(c *gin.Context, server *types.UpstreamServer, balancer *bala
 		c.Header("Cache-Control", "no-cache")
 		c.Header("Connection", "keep-alive")
 
+		// 流式时长上限：超时后关闭上游响应体以中断阻塞的读取
+		var streamCapped atomic.Bool
+		maxStreamDuration := time.Duration(config.MaxStreamDurationSeconds) * time.Second
+		if maxStreamDuration > 0 {
+			timer := time.AfterFunc(maxStreamDuration, func() {
+				streamCapped.Store(true)
+				resp.Body.Close()
+			})
+			defer timer.Stop()
+		}
+
 		// 流式转发数据，同时收集统计信息
 		buffer := make([]byte, 1024)
+		var streamedBytes int
 		for {
 			n, err := responseReader.Read(buffer)
 			if n > 0 {
+				streamedBytes += n
 				// DEBUG 模式下记录每个数据块
 				if debugMode {
 					chunkData := strings.TrimSpace(string(buffer[:n]))
@@ -422,6 +1343,14 @@ func forwardRequest(c *gin.Context, server *types.UpstreamServer, balancer *bala
 			}
 		}
 
+		if streamCapped.Load() {
+			logger.Warning("PROXY", "Stream exceeded max duration: %s | Limit: %v | Terminated after %d bytes",
+				fullRequestURL, maxStreamDuration, streamedBytes)
+		}
+
+		// 上游的 trailer 在响应体读取完成后才可用
+		forwardTrailers(c, resp.Trailer, responseHopByHopHeaders)
+
 		// 流式响应完成后解析统计信息
 		if responseBody.Len() > 0 {
 			// DEBUG 模式下输出完整流式响应体
@@ -434,15 +1363,24 @@ func forwardRequest(c *gin.Context, server *types.UpstreamServer, balancer *bala
 
 			model, usage, parseSuccess := parseUsageInfo(responseBody.Bytes(), resp.Header.Get("Content-Type"))
 			if parseSuccess && model != "" {
+				model = canonicalModelName(config.ModelAliases, model)
+				statsReporter.AddModelStats(model)
+				stats.SetRequestUsage(c, model, usage)
 				logger.Success("PROXY", "Streaming Success: %s | Status: %d (%dms) | Model: %s | Input: %d | Output: %d | Cache Create: %d | Cache Read: %d",
 					fullRequestURL, resp.StatusCode, responseTime.Milliseconds(),
 					model, usage.InputTokens, usage.OutputTokens, usage.CacheCreationInputTokens, usage.CacheReadInputTokens)
 			}
 		}
+	} else if oversized {
+		// 超过缓冲上限的非流式响应：已读取的部分和剩余部分依次转发
+		c.Header("Content-Type", resp.Header.Get("Content-Type"))
+		if _, err := io.Copy(c.Writer, responseReader); err != nil {
+			logger.Warning("PROXY", "Failed to forward response body: %s | Error: %v", fullRequestURL, err)
+		}
 	} else {
 		// 对于非流式响应，使用已读取的响应体
 		c.Data(resp.StatusCode, resp.Header.Get("Content-Type"), responseBody.Bytes())
 	}
 
-	return true // 请求成功
+	return nil // 请求成功
 }
@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"claude-code-lb/internal/stats"
+	"claude-code-lb/pkg/types"
+)
+
+// retryBudget 所有请求共享的重试令牌桶，防止上游大面积故障时每个请求都重试全部服务器造成重试风暴
+// 首次尝试不消耗令牌，只有换服务器重试时才消耗
+type retryBudget struct {
+	perSecond float64
+	burst     float64
+	tokens    float64
+	last      time.Time
+	retries   int64 // 已放行的重试次数
+	throttled int64 // 因预算耗尽被拒绝的重试次数
+	now       func() time.Time
+	mutex     sync.Mutex
+}
+
+// newRetryBudget 根据配置创建重试预算，未配置 retry_budget_per_second 时返回 nil（不限制）
+func newRetryBudget(config types.Config) *retryBudget {
+	if config.RetryBudgetPerSecond <= 0 {
+		return nil
+	}
+
+	burst := float64(config.RetryBudgetBurst)
+	if burst <= 0 {
+		burst = math.Max(1, math.Ceil(config.RetryBudgetPerSecond))
+	}
+
+	b := &retryBudget{
+		perSecond: config.RetryBudgetPerSecond,
+		burst:     burst,
+		tokens:    burst,
+		now:       time.Now,
+	}
+	b.last = b.now()
+	return b
+}
+
+// refill 按经过的时间补充令牌（调用方持有锁）
+func (b *retryBudget) refill() {
+	now := b.now()
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed*b.perSecond)
+	}
+	b.last = now
+}
+
+// allow 尝试消耗一个重试令牌，预算耗尽时返回 false；nil 表示不限制
+func (b *retryBudget) allow() bool {
+	if b == nil {
+		return true
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.refill()
+	if b.tokens < 1 {
+		b.throttled++
+		return false
+	}
+	b.tokens--
+	b.retries++
+	return true
+}
+
+// snapshot 返回重试预算的配置和当前消耗情况（用于 /stats）
+func (b *retryBudget) snapshot() stats.RetryBudgetSnapshot {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.refill()
+	return stats.RetryBudgetSnapshot{
+		PerSecond: b.perSecond,
+		Burst:     int(b.burst),
+		Available: math.Floor(b.tokens*100) / 100,
+		Retries:   b.retries,
+		Throttled: b.throttled,
+	}
+}
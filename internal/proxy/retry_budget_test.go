@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"claude-code-lb/internal/balance"
+	"claude-code-lb/internal/stats"
+	"claude-code-lb/pkg/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRetryBudget(t *testing.T) {
+	if newRetryBudget(types.Config{}) != nil {
+		t.Fatal("Expected nil budget when retry_budget_per_second is not set")
+	}
+	var unlimited *retryBudget
+	if !unlimited.allow() {
+		t.Fatal("Expected nil budget to allow retries")
+	}
+
+	now := time.Unix(0, 0)
+	budget := newRetryBudget(types.Config{RetryBudgetPerSecond: 2, RetryBudgetBurst: 3})
+	budget.now = func() time.Time { return now }
+	budget.last = now
+
+	// 突发容量用尽后拒绝重试
+	for i := 0; i < 3; i++ {
+		if !budget.allow() {
+			t.Fatalf("Expected retry %d to be allowed", i+1)
+		}
+	}
+	if budget.allow() {
+		t.Fatal("Expected retry to be throttled after burst is used up")
+	}
+
+	// 0.5 秒补充 1 个令牌
+	now = now.Add(500 * time.Millisecond)
+	if !budget.allow() {
+		t.Fatal("Expected retry to be allowed after refill")
+	}
+	if budget.allow() {
+		t.Fatal("Expected retry to be throttled again")
+	}
+
+	// 补充不超过突发容量
+	now = now.Add(time.Minute)
+	snapshot := budget.snapshot()
+	if snapshot.Available != 3 || snapshot.Burst != 3 || snapshot.PerSecond != 2 {
+		t.Errorf("Unexpected snapshot config: %+v", snapshot)
+	}
+	if snapshot.Retries != 4 || snapshot.Throttled != 2 {
+		t.Errorf("Expected 4 retries and 2 throttled, got %+v", snapshot)
+	}
+
+	// 默认突发容量为每秒次数向上取整，至少 1
+	if burst := newRetryBudget(types.Config{RetryBudgetPerSecond: 2.5}).burst; burst != 3 {
+		t.Errorf("Expected default burst 3, got %g", burst)
+	}
+	if burst := newRetryBudget(types.Config{RetryBudgetPerSecond: 0.1}).burst; burst != 1 {
+		t.Errorf("Expected default burst 1, got %g", burst)
+	}
+}
+
+func TestHandlerRetryBudget(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var attempts atomic.Int64
+	var servers []types.UpstreamServer
+	for i := 0; i < 4; i++ {
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts.Add(1)
+			w.WriteHeader(500)
+		}))
+		defer upstream.Close()
+		servers = append(servers, types.UpstreamServer{URL: upstream.URL, Token: "test-token"})
+	}
+
+	config := types.Config{
+		Mode:                 "load_balance",
+		Algorithm:            "round_robin",
+		Cooldown:             60,
+		TryAllServers:        true,
+		RetryBudgetPerSecond: 0.001,
+		RetryBudgetBurst:     1,
+		Servers:              servers,
+	}
+
+	reporter := stats.New()
+	balancer := balance.New(config)
+	router := gin.New()
+	router.Any("/*path", Handler(config, balancer, reporter))
+
+	// 第一个请求用掉唯一的重试令牌，第二个请求只尝试一次
+	for i, expectedAttempts := range []int64{2, 3} {
+		req, _ := http.NewRequest("POST", "/v1/messages", strings.NewReader(`{}`))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != 502 {
+			t.Fatalf("Request %d: expected status 502, got %d", i+1, w.Code)
+		}
+		if got := attempts.Load(); got != expectedAttempts {
+			t.Errorf("Request %d: expected %d total upstream attempts, got %d", i+1, expectedAttempts, got)
+		}
+	}
+
+	snapshot := reporter.Snapshot().RetryBudget
+	if snapshot == nil {
+		t.Fatal("Expected retry budget in stats snapshot")
+	}
+	if snapshot.Retries != 1 || snapshot.Throttled != 2 {
+		t.Errorf("Expected 1 retry and 2 throttled, got %+v", *snapshot)
+	}
+	if snapshot.PerSecond != 0.001 || snapshot.Burst != 1 {
+		t.Errorf("Expected budget config in snapshot, got %+v", *snapshot)
+	}
+
+	// 预算耗尽时已为下一次尝试选出的服务器不转发，占用的在途连接也要释放
+	for _, server := range servers {
+		if conns := balancer.GetActiveConnections(server.URL); conns != 0 {
+			t.Errorf("Expected no leaked connections on %s, got %d", server.URL, conns)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+
+	"claude-code-lb/internal/logger"
+	"claude-code-lb/internal/stats"
+	"claude-code-lb/pkg/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// shadowMirror 将一定比例的请求异步复制到影子服务器，比较状态码和延迟后丢弃影子响应
+// 影子请求独立于客户端请求的生命周期，失败、超时或变慢都不会影响返回给客户端的响应
+type shadowMirror struct {
+	config  types.Config
+	server  *types.UpstreamServer
+	client  *http.Client
+	percent float64
+	sample  func() float64 // 返回 [0, 100) 的随机数，用于按比例抽样
+
+	requests         int64
+	errors           int64
+	mismatches       int64
+	totalLatency     time.Duration
+	totalPrimaryTime time.Duration
+	mutex            sync.Mutex
+}
+
+// shadowRequest 一次已发出的影子请求，等待主请求完成后进行比较
+type shadowRequest struct {
+	mirror  *shadowMirror
+	started time.Time
+	primary chan primaryResult
+}
+
+// primaryResult 主请求的响应状态码和耗时
+type primaryResult struct {
+	status  int
+	latency time.Duration
+}
+
+// newShadowMirror 根据配置创建影子流量复制器，未配置 shadow_percent 时返回 nil（不复制）
+func newShadowMirror(config types.Config, clients upstreamClients) *shadowMirror {
+	if config.ShadowPercent <= 0 || config.ShadowServer == nil {
+		return nil
+	}
+	return &shadowMirror{
+		config:  config,
+		server:  config.ShadowServer,
+		client:  clients.forServer(config.ShadowServer.URL),
+		percent: config.ShadowPercent,
+		sample:  func() float64 { return rand.Float64() * 100 },
+	}
+}
+
+// start 按比例抽样并向影子服务器发出请求副本，未抽中或构造请求失败时返回 nil
+// 请求体在此同步读取并还原，之后不再访问 gin.Context（上下文在处理结束后会被复用）
+func (m *shadowMirror) start(c *gin.Context) *shadowRequest {
+	if m == nil || m.sample() >= m.percent {
+		return nil
+	}
+
+	var body []byte
+	if c.Request.Body != nil {
+		var err error
+		body, err = io.ReadAll(c.Request.Body)
+		if err != nil {
+			logger.Warning("SHADOW", "Failed to read request body for mirroring: %v", err)
+			return nil
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout(m.config, m.server))
+	_, target := upstreamTarget(c, m.config, m.server.URL)
+	req, err := newUpstreamRequest(ctx, c, m.config, m.server, target, body, serverTokens(m.server)[0])
+	if err != nil {
+		cancel()
+		logger.Warning("SHADOW", "Failed to create shadow request: %v", err)
+		return nil
+	}
+
+	r := &shadowRequest{mirror: m, started: time.Now(), primary: make(chan primaryResult, 1)}
+	go r.run(req, cancel)
+	return r
+}
+
+// run 发送影子请求并丢弃响应体，等主请求完成后记录比较结果
+func (r *shadowRequest) run(req *http.Request, cancel context.CancelFunc) {
+	defer cancel()
+
+	status := 0
+	resp, err := r.mirror.client.Do(req)
+	if err == nil {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		status = resp.StatusCode
+	}
+	latency := time.Since(r.started)
+
+	r.mirror.record(req.Method, req.URL.Path, <-r.primary, status, latency, err)
+}
+
+// finish 报告主请求的响应状态码，nil 表示本次请求未复制
+func (r *shadowRequest) finish(primaryStatus int) {
+	if r == nil {
+		return
+	}
+	r.primary <- primaryResult{status: primaryStatus, latency: time.Since(r.started)}
+}
+
+// record 记录一次影子请求与主请求的比较结果
+func (m *shadowMirror) record(method, path string, primary primaryResult, shadowStatus int, latency time.Duration, err error) {
+	m.mutex.Lock()
+	m.requests++
+	m.totalLatency += latency
+	m.totalPrimaryTime += primary.latency
+	switch {
+	case err != nil:
+		m.errors++
+	case shadowStatus != primary.status:
+		m.mismatches++
+	}
+	m.mutex.Unlock()
+
+	switch {
+	case err != nil:
+		logger.Warning("SHADOW", "%s %s | primary %d (%dms) | shadow failed after %dms: %v", method, path, primary.status, primary.latency.Milliseconds(), latency.Milliseconds(), err)
+	case shadowStatus != primary.status:
+		logger.Warning("SHADOW", "%s %s | status mismatch: primary %d (%dms), shadow %d (%dms)", method, path, primary.status, primary.latency.Milliseconds(), shadowStatus, latency.Milliseconds())
+	default:
+		logger.Debug("SHADOW", "%s %s | primary %d (%dms), shadow %d (%dms)", method, path, primary.status, primary.latency.Milliseconds(), shadowStatus, latency.Milliseconds())
+	}
+}
+
+// snapshot 返回影子流量的复制和比较结果（用于 /stats）
+func (m *shadowMirror) snapshot() stats.ShadowSnapshot {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	snapshot := stats.ShadowSnapshot{
+		Server:           m.server.URL,
+		Percent:          m.percent,
+		Requests:         m.requests,
+		Errors:           m.errors,
+		StatusMismatches: m.mismatches,
+	}
+	if m.requests > 0 {
+		snapshot.AvgLatencyMs = m.totalLatency.Milliseconds() / m.requests
+		snapshot.AvgPrimaryLatencyMs = m.totalPrimaryTime.Milliseconds() / m.requests
+	}
+	return snapshot
+}
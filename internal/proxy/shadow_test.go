@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"claude-code-lb/internal/balance"
+	"claude-code-lb/internal/stats"
+	"claude-code-lb/pkg/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestShadowMirrorSampling(t *testing.T) {
+	if newShadowMirror(types.Config{}, upstreamClients{}) != nil {
+		t.Fatal("Expected nil mirror when shadow_percent is not set")
+	}
+
+	config := types.Config{
+		ShadowServer:  &types.UpstreamServer{URL: "http://test-shadow.local"},
+		ShadowPercent: 10,
+	}
+	mirror := newShadowMirror(config, upstreamClients{shared: http.DefaultClient})
+	mirror.sample = func() float64 { return 50 }
+
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request, _ = http.NewRequest("POST", "/v1/messages", strings.NewReader(`{}`))
+	if mirror.start(c) != nil {
+		t.Error("Expected request outside shadow_percent not to be mirrored")
+	}
+}
+
+func TestHandlerShadowTraffic(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"from":"primary"}`))
+	}))
+	defer primary.Close()
+
+	// 影子服务器响应缓慢且返回错误，不应影响客户端收到的主响应
+	type mirrored struct {
+		path  string
+		auth  string
+		body  string
+		query string
+	}
+	received := make(chan mirrored, 1)
+	shadowDelay := 300 * time.Millisecond
+	shadowServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- mirrored{path: r.URL.Path, auth: r.Header.Get("Authorization"), body: string(body), query: r.URL.RawQuery}
+		time.Sleep(shadowDelay)
+		w.WriteHeader(500)
+	}))
+	defer shadowServer.Close()
+
+	config := types.Config{
+		Mode:          "load_balance",
+		Algorithm:     "round_robin",
+		Cooldown:      60,
+		Servers:       []types.UpstreamServer{{URL: primary.URL, Token: "primary-token"}},
+		ShadowServer:  &types.UpstreamServer{URL: shadowServer.URL, Token: "shadow-token"},
+		ShadowPercent: 100,
+	}
+	statsReporter := stats.New()
+	router := gin.New()
+	router.Any("/*path", Handler(config, balance.New(config), statsReporter))
+
+	req, _ := http.NewRequest("POST", "/v1/messages?beta=true", strings.NewReader(`{"model":"claude"}`))
+	req.Header.Set("Authorization", "Bearer client-key")
+	w := httptest.NewRecorder()
+	start := time.Now()
+	router.ServeHTTP(w, req)
+	elapsed := time.Since(start)
+
+	if w.Code != 200 || w.Body.String() != `{"from":"primary"}` {
+		t.Fatalf("Expected primary response, got %d %s", w.Code, w.Body.String())
+	}
+	if elapsed >= shadowDelay {
+		t.Errorf("Expected client response not to wait for shadow upstream, took %v", elapsed)
+	}
+
+	select {
+	case got := <-received:
+		if got.path != "/v1/messages" || got.query != "beta=true" {
+			t.Errorf("Expected mirrored request to /v1/messages?beta=true, got %s?%s", got.path, got.query)
+		}
+		if got.auth != "Bearer shadow-token" {
+			t.Errorf("Expected shadow server token, got %q", got.auth)
+		}
+		if got.body != `{"model":"claude"}` {
+			t.Errorf("Expected mirrored request body, got %q", got.body)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected request to be mirrored to shadow server")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	var snapshot *stats.ShadowSnapshot
+	for time.Now().Before(deadline) {
+		if snapshot = statsReporter.Snapshot().Shadow; snapshot != nil && snapshot.Requests > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if snapshot == nil || snapshot.Requests != 1 {
+		t.Fatalf("Expected one compared shadow request, got %+v", snapshot)
+	}
+	if snapshot.StatusMismatches != 1 || snapshot.Errors != 0 {
+		t.Errorf("Expected one status mismatch and no errors, got %+v", snapshot)
+	}
+	if snapshot.AvgLatencyMs < shadowDelay.Milliseconds() {
+		t.Errorf("Expected shadow latency >= %dms, got %dms", shadowDelay.Milliseconds(), snapshot.AvgLatencyMs)
+	}
+}
@@ -0,0 +1,243 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"slices"
+	"time"
+
+	"claude-code-lb/internal/logger"
+	"claude-code-lb/pkg/types"
+)
+
+const (
+	// DefaultIdleConnTimeout 未配置 idle_conn_timeout_seconds 时空闲连接的最长保留时间
+	// 低于常见 NAT/云负载均衡的空闲超时（通常 60~350 秒），避免复用已被中间设备静默关闭的连接
+	DefaultIdleConnTimeout = 30 * time.Second
+
+	// defaultMaxIdleConnsPerHost 每个上游保留的最大空闲连接数
+	defaultMaxIdleConnsPerHost = 32
+
+	// maxStaleConnRetries 复用的空闲连接失效时最多透明重试的次数
+	maxStaleConnRetries = 2
+)
+
+// newUpstreamClient 创建所有上游请求共享的 HTTP 客户端（复用连接池）
+// 请求超时由每个请求的 context 控制，这里不设置 Client.Timeout
+func newUpstreamClient(config types.Config) *http.Client {
+	idleConnTimeout := DefaultIdleConnTimeout
+	if config.IdleConnTimeoutSeconds > 0 {
+		idleConnTimeout = time.Duration(config.IdleConnTimeoutSeconds) * time.Second
+	}
+
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   defaultMaxIdleConnsPerHost,
+		MaxConnsPerHost:       config.MaxConnsPerHost,
+		IdleConnTimeout:       idleConnTimeout,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+
+	transport.TLSClientConfig = newUpstreamTLSConfig(config)
+
+	// 通过 TLS 连接的上游默认协商 HTTP/2（多路复用流式响应）；
+	// disable_http2 时清空 TLSNextProto，只使用 HTTP/1.1
+	if config.DisableHTTP2 {
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+
+	return &http.Client{Transport: transport}
+}
+
+// TLSVersions min_tls_version 支持的取值
+var TLSVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// newUpstreamTLSConfig 创建上游连接的 TLS 配置：默认校验证书且最低 TLS 1.2
+// ca_cert_file 中的 CA 在系统根证书之外额外信任；加载失败时记录错误并只使用系统根证书
+func newUpstreamTLSConfig(config types.Config) *tls.Config {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if version, ok := TLSVersions[config.MinTLSVersion]; ok {
+		tlsConfig.MinVersion = version
+	}
+
+	if config.InsecureSkipVerify {
+		logger.Warning("PROXY", "insecure_skip_verify is enabled: upstream TLS certificates are NOT verified, do not use in production")
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	if config.CACertFile != "" {
+		rootCAs, err := loadCACertPool(config.CACertFile)
+		if err != nil {
+			logger.Error("PROXY", "Failed to load ca_cert_file, using system roots only: %v", err)
+		} else {
+			tlsConfig.RootCAs = rootCAs
+		}
+	}
+
+	// 双向 TLS：上游要求客户端证书时发送（证书文件已在加载配置时校验）
+	if config.ClientCertFile != "" && config.ClientKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.ClientCertFile, config.ClientKeyFile)
+		if err != nil {
+			logger.Error("PROXY", "Failed to load client certificate, connecting without it: %v", err)
+		} else {
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+	}
+
+	return tlsConfig
+}
+
+// upstreamClients 上游 HTTP 客户端：所有服务器默认共享一个连接池，
+// 配置了独立客户端证书的服务器使用自己的连接池（TLS 配置按 transport 生效，无法按请求切换证书）
+type upstreamClients struct {
+	shared   *http.Client
+	byServer map[string]*http.Client
+}
+
+// newUpstreamClients 创建共享客户端，并为配置了 client_cert_file 的服务器创建独立客户端
+func newUpstreamClients(config types.Config) upstreamClients {
+	clients := upstreamClients{shared: newUpstreamClient(config), byServer: make(map[string]*http.Client)}
+	servers := slices.Concat(config.Servers, config.OverflowServers, config.EmergencyServers)
+	if config.ShadowServer != nil {
+		servers = append(servers, *config.ShadowServer)
+	}
+	for _, server := range servers {
+		if server.ClientCertFile == "" {
+			continue
+		}
+		serverConfig := config
+		serverConfig.ClientCertFile = server.ClientCertFile
+		serverConfig.ClientKeyFile = server.ClientKeyFile
+		clients.byServer[server.URL] = newUpstreamClient(serverConfig)
+	}
+	return clients
+}
+
+// forServer 返回发往指定服务器使用的客户端
+func (clients upstreamClients) forServer(serverURL string) *http.Client {
+	if client, ok := clients.byServer[serverURL]; ok {
+		return client
+	}
+	return clients.shared
+}
+
+// NewClientResolver 返回按服务器选择上游客户端的函数，供代理请求之外的上游访问（如主动健康探测）使用，
+// 与代理请求使用相同的 TLS 配置（ca_cert_file、insecure_skip_verify、客户端证书）和连接池设置
+func NewClientResolver(config types.Config) func(serverURL string) *http.Client {
+	return newUpstreamClients(config).forServer
+}
+
+// loadCACertPool 返回系统根证书加上 path 中 PEM 格式 CA 证书的证书池
+func loadCACertPool(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no PEM certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+// doWithStaleRetry 发送上游请求；请求在复用的空闲连接上失败时（连接已被对端或中间设备关闭）
+// 使用 newRequest 重建请求并在其他连接上重试，而不是把服务器标记为不可用
+// 新建连接上的失败或请求超时不会重试
+func doWithStaleRetry(client *http.Client, req *http.Request, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		var reused bool
+		trace := &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) {
+				reused = info.Reused
+			},
+		}
+
+		resp, err := client.Do(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+		if err == nil || !reused || req.Context().Err() != nil || attempt >= maxStaleConnRetries {
+			return resp, err
+		}
+
+		logger.Warning("PROXY", "Stale upstream connection to %s (%v), retrying on a new connection", req.URL.Host, err)
+		req, err = newRequest()
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// requestErrorClass 上游请求错误的分类
+type requestErrorClass string
+
+const (
+	errorClassClientCanceled requestErrorClass = "client_canceled" // 客户端断开连接或自身超时取消了请求
+	errorClassTimeout        requestErrorClass = "timeout"         // 上游请求超时
+	errorClassDNS            requestErrorClass = "dns"             // 域名解析失败
+	errorClassTLS            requestErrorClass = "tls"             // TLS 握手或证书校验失败
+	errorClassConnection     requestErrorClass = "connection"      // 其他连接错误（拒绝连接、连接重置等）
+)
+
+// isUpstreamFailure 判断错误是否由上游引起（需要标记服务器为不可用）
+// 客户端取消的请求与上游状态无关，不标记
+func (class requestErrorClass) isUpstreamFailure() bool {
+	return class != errorClassClientCanceled
+}
+
+// classifyRequestError 对发送上游请求时的错误分类，clientCtx 为客户端请求的 context
+func classifyRequestError(clientCtx context.Context, err error) requestErrorClass {
+	// 客户端 context 已结束（断开连接或客户端自身的超时），上游请求随之被取消
+	if clientCtx.Err() != nil || errors.Is(err, context.Canceled) {
+		return errorClassClientCanceled
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return errorClassDNS
+	}
+
+	var recordErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	var verifyErr *tls.CertificateVerificationError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	if errors.As(err, &recordErr) || errors.As(err, &alertErr) || errors.As(err, &verifyErr) ||
+		errors.As(err, &authorityErr) || errors.As(err, &hostnameErr) || errors.As(err, &invalidErr) {
+		return errorClassTLS
+	}
+	// 对端在握手时发送的 TLS alert（如协议版本不支持）以 "remote error" 操作返回
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "remote error" {
+		return errorClassTLS
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return errorClassTimeout
+	}
+
+	return errorClassConnection
+}
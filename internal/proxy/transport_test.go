@@ -0,0 +1,389 @@
+package proxy
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"claude-code-lb/internal/balance"
+	"claude-code-lb/internal/stats"
+	"claude-code-lb/pkg/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestNewUpstreamClient(t *testing.T) {
+	tests := []struct {
+		name                string
+		config              types.Config
+		expectedIdleTimeout time.Duration
+		expectedMaxConns    int
+	}{
+		{name: "defaults", expectedIdleTimeout: DefaultIdleConnTimeout},
+		{name: "configured", config: types.Config{IdleConnTimeoutSeconds: 10, MaxConnsPerHost: 8}, expectedIdleTimeout: 10 * time.Second, expectedMaxConns: 8},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport, ok := newUpstreamClient(tt.config).Transport.(*http.Transport)
+			if !ok {
+				t.Fatal("Expected *http.Transport")
+			}
+			if transport.IdleConnTimeout != tt.expectedIdleTimeout {
+				t.Errorf("Expected IdleConnTimeout %v, got %v", tt.expectedIdleTimeout, transport.IdleConnTimeout)
+			}
+			if transport.MaxConnsPerHost != tt.expectedMaxConns {
+				t.Errorf("Expected MaxConnsPerHost %d, got %d", tt.expectedMaxConns, transport.MaxConnsPerHost)
+			}
+		})
+	}
+}
+
+func TestUpstreamClientHTTP2(t *testing.T) {
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}))
+	upstream.EnableHTTP2 = true
+	upstream.StartTLS()
+	defer upstream.Close()
+
+	tests := []struct {
+		name          string
+		config        types.Config
+		expectedProto string
+	}{
+		{name: "negotiates h2 by default", expectedProto: "HTTP/2.0"},
+		{name: "disable_http2", config: types.Config{DisableHTTP2: true}, expectedProto: "HTTP/1.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newUpstreamClient(tt.config)
+			defer client.CloseIdleConnections()
+
+			// 信任测试服务器的自签名证书
+			rootCAs := x509.NewCertPool()
+			rootCAs.AddCert(upstream.Certificate())
+			client.Transport.(*http.Transport).TLSClientConfig = &tls.Config{RootCAs: rootCAs}
+
+			resp, err := client.Get(upstream.URL)
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+
+			if resp.Proto != tt.expectedProto || string(body) != tt.expectedProto {
+				t.Errorf("Expected %s, got client %s / server %s", tt.expectedProto, resp.Proto, body)
+			}
+		})
+	}
+}
+
+func TestHandlerRetriesStaleConnection(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// 每个连接只正常响应第一个请求，之后的请求直接关闭连接，模拟被 NAT 静默回收的空闲连接
+	var mutex sync.Mutex
+	served := make(map[string]bool)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		stale := served[r.RemoteAddr]
+		served[r.RemoteAddr] = true
+		mutex.Unlock()
+
+		if stale {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+			return
+		}
+		w.WriteHeader(200)
+	}))
+	defer upstream.Close()
+
+	config := types.Config{
+		Mode:      "load_balance",
+		Algorithm: "round_robin",
+		Cooldown:  60,
+		Servers:   []types.UpstreamServer{{URL: upstream.URL, Token: "test-token"}},
+	}
+	balancer := balance.New(config)
+
+	router := gin.New()
+	router.Any("/*path", Handler(config, balancer, stats.New()))
+
+	for i := 0; i < 3; i++ {
+		req, _ := http.NewRequest("POST", "/v1/messages", strings.NewReader(`{"model":"claude"}`))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != 200 {
+			t.Fatalf("Request %d: expected status 200, got %d: %s", i+1, w.Code, w.Body.String())
+		}
+	}
+
+	if !balancer.IsServerAvailable(upstream.URL) {
+		t.Error("Expected stale connection failures not to mark the server down")
+	}
+}
+
+func TestUpstreamClientTLS(t *testing.T) {
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	upstream.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	upstream.StartTLS()
+	defer upstream.Close()
+
+	// 测试服务器的自签名证书写入 CA 文件
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: upstream.Certificate().Raw})
+	if err := os.WriteFile(caFile, certPEM, 0o600); err != nil {
+		t.Fatalf("Failed to write CA file: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		config    types.Config
+		expectErr bool
+	}{
+		{name: "verifies certificates by default", expectErr: true},
+		{name: "insecure_skip_verify", config: types.Config{InsecureSkipVerify: true}},
+		{name: "ca_cert_file", config: types.Config{CACertFile: caFile}},
+		{name: "min_tls_version above server", config: types.Config{CACertFile: caFile, MinTLSVersion: "1.3"}, expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newUpstreamClient(tt.config)
+			defer client.CloseIdleConnections()
+
+			resp, err := client.Get(upstream.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("Expected error=%v, got %v", tt.expectErr, err)
+			}
+			if err != nil && classifyRequestError(context.Background(), err) != errorClassTLS {
+				t.Errorf("Expected TLS error class, got %s (%v)", classifyRequestError(context.Background(), err), err)
+			}
+		})
+	}
+
+	if version := newUpstreamTLSConfig(types.Config{}).MinVersion; version != tls.VersionTLS12 {
+		t.Errorf("Expected default minimum TLS 1.2, got %x", version)
+	}
+}
+
+// writeClientCert 生成自签名客户端证书并写入临时目录，返回证书、私钥路径和证书本身
+func writeClientCert(t *testing.T) (string, string, *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "lb-client"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "client.pem")
+	keyFile := filepath.Join(dir, "client.key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("Failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	return certFile, keyFile, cert
+}
+
+func TestUpstreamClientCertificate(t *testing.T) {
+	certFile, keyFile, cert := writeClientCert(t)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(cert)
+
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	upstream.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	upstream.StartTLS()
+	defer upstream.Close()
+
+	get := func(client *http.Client) error {
+		resp, err := client.Get(upstream.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	tests := []struct {
+		name      string
+		config    types.Config
+		expectErr bool
+	}{
+		{name: "no client certificate", config: types.Config{InsecureSkipVerify: true}, expectErr: true},
+		{name: "global client certificate", config: types.Config{InsecureSkipVerify: true, ClientCertFile: certFile, ClientKeyFile: keyFile}},
+		{
+			name: "per-server client certificate",
+			config: types.Config{
+				InsecureSkipVerify: true,
+				Servers: []types.UpstreamServer{
+					{URL: upstream.URL, ClientCertFile: certFile, ClientKeyFile: keyFile},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clients := newUpstreamClients(tt.config)
+			client := clients.forServer(upstream.URL)
+			defer client.CloseIdleConnections()
+
+			if err := get(client); (err != nil) != tt.expectErr {
+				t.Fatalf("Expected error=%v, got %v", tt.expectErr, err)
+			}
+		})
+	}
+
+	// 单个服务器的证书不应影响其他服务器使用的共享客户端
+	clients := newUpstreamClients(types.Config{
+		InsecureSkipVerify: true,
+		Servers: []types.UpstreamServer{
+			{URL: upstream.URL, ClientCertFile: certFile, ClientKeyFile: keyFile},
+		},
+	})
+	if err := get(clients.forServer("https://other-api.local")); err == nil {
+		t.Error("Expected shared client without certificate to be rejected")
+	}
+}
+
+func TestClassifyRequestError(t *testing.T) {
+	canceledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	expiredCtx, cancelExpired := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancelExpired()
+
+	urlError := func(err error) error {
+		return &url.Error{Op: "Post", URL: "https://test-api.local/v1/messages", Err: err}
+	}
+
+	tests := []struct {
+		name            string
+		clientCtx       context.Context
+		err             error
+		expected        requestErrorClass
+		upstreamFailure bool
+	}{
+		{name: "client canceled", clientCtx: canceledCtx, err: urlError(context.Canceled), expected: errorClassClientCanceled},
+		{name: "client deadline", clientCtx: expiredCtx, err: urlError(context.DeadlineExceeded), expected: errorClassClientCanceled},
+		{name: "upstream timeout", clientCtx: context.Background(), err: urlError(context.DeadlineExceeded), expected: errorClassTimeout, upstreamFailure: true},
+		{name: "dial timeout", clientCtx: context.Background(), err: urlError(&net.OpError{Op: "dial", Err: timeoutError{}}), expected: errorClassTimeout, upstreamFailure: true},
+		{name: "dns failure", clientCtx: context.Background(), err: urlError(&net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "test-api.local", IsNotFound: true}}), expected: errorClassDNS, upstreamFailure: true},
+		{name: "tls certificate", clientCtx: context.Background(), err: urlError(&tls.CertificateVerificationError{Err: x509.UnknownAuthorityError{}}), expected: errorClassTLS, upstreamFailure: true},
+		{name: "tls record header", clientCtx: context.Background(), err: urlError(tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}), expected: errorClassTLS, upstreamFailure: true},
+		{name: "connection refused", clientCtx: context.Background(), err: urlError(&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}), expected: errorClassConnection, upstreamFailure: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			class := classifyRequestError(tt.clientCtx, tt.err)
+			if class != tt.expected {
+				t.Errorf("classifyRequestError() = %s, want %s", class, tt.expected)
+			}
+			if class.isUpstreamFailure() != tt.upstreamFailure {
+				t.Errorf("isUpstreamFailure() = %v, want %v", class.isUpstreamFailure(), tt.upstreamFailure)
+			}
+		})
+	}
+}
+
+// timeoutError 模拟超时的 net.Error
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestHandlerClientCancelDoesNotMarkDown(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	config := types.Config{
+		Mode:          "load_balance",
+		Algorithm:     "round_robin",
+		Cooldown:      60,
+		TryAllServers: true,
+		Servers:       []types.UpstreamServer{{URL: upstream.URL, Token: "test-token"}},
+	}
+	balancer := balance.New(config)
+	reporter := stats.New()
+	router := gin.New()
+	router.Any("/*path", Handler(config, balancer, reporter))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "POST", "/v1/messages", strings.NewReader(`{}`))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != 499 {
+		t.Errorf("Expected status 499 for canceled request, got %d", w.Code)
+	}
+	if !balancer.IsServerAvailable(upstream.URL) {
+		t.Error("Client cancellation should not mark the server down")
+	}
+	if count := reporter.Snapshot().Errors; count != 0 {
+		t.Errorf("Expected client cancellation not to count as an error, got %d", count)
+	}
+}
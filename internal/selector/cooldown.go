@@ -0,0 +1,41 @@
+package selector
+
+import (
+	"time"
+
+	"claude-code-lb/pkg/types"
+)
+
+// maxCooldown 动态冷却时间的上限
+const maxCooldown = 10 * time.Minute
+
+// calculateCooldown 根据失败次数计算冷却时间
+func calculateCooldown(config types.Config, failures int64) time.Duration {
+	cooldownDuration := time.Duration(config.Cooldown) * time.Second
+
+	// 关闭退避时始终使用固定冷却时间
+	if config.BackoffEnabled != nil && !*config.BackoffEnabled {
+		return cooldownDuration
+	}
+
+	if failures > 1 {
+		// 指数退避，但设置上限
+		dynamicCooldown := cooldownDuration * time.Duration(failures)
+		if dynamicCooldown > maxCooldown {
+			dynamicCooldown = maxCooldown // 最大 10 分钟
+		}
+		cooldownDuration = dynamicCooldown
+	}
+
+	return cooldownDuration
+}
+
+// cooldownFailures 返回计算冷却时间使用的失败次数
+// 服务器进入可用状态（启动或恢复）后的 warmup_grace_seconds 内，冷启动失败只使用基础冷却时间，不按失败次数退避
+func cooldownFailures(config types.Config, failures int64, availableSince, now time.Time) int64 {
+	grace := time.Duration(config.WarmupGraceSeconds) * time.Second
+	if grace > 0 && failures > 1 && now.Sub(availableSince) < grace {
+		return 1
+	}
+	return failures
+}
@@ -0,0 +1,62 @@
+package selector
+
+import (
+	"testing"
+	"time"
+
+	"claude-code-lb/pkg/types"
+)
+
+func TestCalculateCooldown(t *testing.T) {
+	enabled := true
+	disabled := false
+
+	tests := []struct {
+		name     string
+		backoff  *bool
+		failures int64
+		expected time.Duration
+	}{
+		{name: "first failure uses base cooldown", backoff: nil, failures: 1, expected: 30 * time.Second},
+		{name: "backoff unset scales with failures", backoff: nil, failures: 3, expected: 90 * time.Second},
+		{name: "backoff enabled scales with failures", backoff: &enabled, failures: 4, expected: 120 * time.Second},
+		{name: "backoff enabled is capped", backoff: &enabled, failures: 100, expected: maxCooldown},
+		{name: "backoff disabled first failure", backoff: &disabled, failures: 1, expected: 30 * time.Second},
+		{name: "backoff disabled ignores failures", backoff: &disabled, failures: 5, expected: 30 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := types.Config{Cooldown: 30, BackoffEnabled: tt.backoff}
+			if got := calculateCooldown(config, tt.failures); got != tt.expected {
+				t.Errorf("calculateCooldown() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCooldownFailures(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		grace    int
+		failures int64
+		since    time.Duration // 进入可用状态距今的时长
+		expected int64
+	}{
+		{name: "grace disabled", grace: 0, failures: 3, since: time.Second, expected: 3},
+		{name: "failure within grace uses base cooldown", grace: 60, failures: 3, since: 30 * time.Second, expected: 1},
+		{name: "failure after grace escalates", grace: 60, failures: 3, since: 2 * time.Minute, expected: 3},
+		{name: "first failure unchanged", grace: 60, failures: 1, since: time.Second, expected: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := types.Config{WarmupGraceSeconds: tt.grace}
+			if got := cooldownFailures(config, tt.failures, now.Add(-tt.since), now); got != tt.expected {
+				t.Errorf("cooldownFailures() = %d, want %d", got, tt.expected)
+			}
+		})
+	}
+}
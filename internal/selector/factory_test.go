@@ -180,6 +180,10 @@ func TestLoadBalancerImplementsInterface(t *testing.T) {
 
 	// Test that it implements all interface methods
 	var _ ServerSelector = lb
+	var _ ConnectionTracker = lb
+	var _ StateNotifier = lb
+	var _ BalanceAware = lb
+	var _ TransientDownMarker = lb
 
 	// Test individual method calls don't panic
 	_, err := lb.SelectServer()
@@ -217,6 +221,8 @@ func TestFallbackSelectorImplementsInterface(t *testing.T) {
 
 	// Test that it implements all interface methods
 	var _ ServerSelector = fs
+	var _ StateNotifier = fs
+	var _ TransientDownMarker = fs
 
 	// Test individual method calls don't panic
 	_, err := fs.SelectServer()
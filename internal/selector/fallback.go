@@ -17,7 +17,14 @@ type FallbackSelector struct {
 	serverDownUntil map[string]time.Time // 服务器冷却时间
 	statusMutex     sync.RWMutex
 	failureCount    map[string]int64       // 服务器失败次数
+	disabledServers map[string]bool        // 失败次数超过 max_failures 后被永久禁用的服务器
+	downReasons     map[string]DownReason  // 服务器不可用的原因
+	recoveredAt     map[string]time.Time   // 服务器最近一次自动恢复的时间（用于 failback_delay_seconds）
+	availableSince  map[string]time.Time   // 服务器最近一次进入可用状态的时间（用于 warmup_grace_seconds）
 	orderedServers  []types.UpstreamServer // 按优先级排序的服务器列表
+	stateListener   StateListener          // 服务器状态变化监听器
+	tierMutex       sync.Mutex
+	tierWeights     map[string]int // 同优先级服务器的平滑加权轮询当前权重
 }
 
 // NewFallbackSelector 创建新的fallback选择器
@@ -27,11 +34,24 @@ func NewFallbackSelector(config types.Config) *FallbackSelector {
 		serverStatus:    make(map[string]bool),
 		serverDownUntil: make(map[string]time.Time),
 		failureCount:    make(map[string]int64),
+		disabledServers: make(map[string]bool),
+		downReasons:     make(map[string]DownReason),
+		recoveredAt:     make(map[string]time.Time),
+		availableSince:  make(map[string]time.Time),
+		tierWeights:     make(map[string]int),
 	}
 
-	// 初始化服务器状态
+	// 初始化服务器状态（启动即进入可用状态）
+	now := time.Now()
 	for _, server := range config.Servers {
 		fs.serverStatus[server.URL] = true
+		fs.availableSince[server.URL] = now
+		fs.serverDownUntil[server.URL] = time.Time{}
+		fs.failureCount[server.URL] = 0
+	}
+	for _, server := range config.EmergencyServers {
+		fs.serverStatus[server.URL] = true
+		fs.availableSince[server.URL] = now
 		fs.serverDownUntil[server.URL] = time.Time{}
 		fs.failureCount[server.URL] = 0
 	}
@@ -40,8 +60,8 @@ func NewFallbackSelector(config types.Config) *FallbackSelector {
 	fs.orderedServers = make([]types.UpstreamServer, len(config.Servers))
 	copy(fs.orderedServers, config.Servers)
 
-	// 重新设计优先级分配算法，确保唯一性
-	fs.assignUniquePriorities()
+	// 分配优先级（显式配置相同优先级的服务器组成同一层级）
+	fs.assignPriorities()
 
 	// 按优先级排序（priority数字越小优先级越高）
 	sort.Slice(fs.orderedServers, func(i, j int) bool {
@@ -53,8 +73,8 @@ func NewFallbackSelector(config types.Config) *FallbackSelector {
 	})
 
 	logger.Info("LOAD", "Fallback selector initialized with %d servers", len(fs.orderedServers))
-	for i, server := range fs.orderedServers {
-		logger.Info("LOAD", "Priority %d: %s (weight: %d)", i+1, server.URL, server.Weight)
+	for _, server := range fs.orderedServers {
+		logger.Info("LOAD", "Priority %d: %s (weight: %d)", server.Priority, server.URL, server.Weight)
 	}
 
 	return fs
@@ -62,21 +82,48 @@ func NewFallbackSelector(config types.Config) *FallbackSelector {
 
 // SelectServer 按优先级选择一个可用的服务器
 func (fs *FallbackSelector) SelectServer() (*types.UpstreamServer, error) {
+	return fs.SelectServerWithOptions(SelectOptions{})
+}
+
+// SelectServerWithOptions 按优先级选择一个可用的服务器（只使用 opts.Region，fallback 模式不支持指定算法）
+// 指定区域时优先在该区域内按优先级选择，该区域没有可用服务器时使用其他区域
+func (fs *FallbackSelector) SelectServerWithOptions(opts SelectOptions) (*types.UpstreamServer, error) {
 	now := time.Now()
 
 	fs.statusMutex.RLock()
 	defer fs.statusMutex.RUnlock()
 
-	// 按优先级顺序查找可用服务器
-	for i, server := range fs.orderedServers {
-		// 检查服务器是否可用且未在冷却期
-		if fs.serverStatus[server.URL] && now.After(server.DownUntil) {
-			logger.Info("LOAD", "Selected server by priority %d: %s", i+1, server.URL)
-			return &fs.orderedServers[i], nil
+	regions := []string{""}
+	if opts.Region != "" {
+		regions = []string{opts.Region, ""}
+	}
+
+	// 刚恢复的服务器在 failback_delay_seconds 内暂不接收流量，继续使用当前的备用服务器；
+	// 没有其他可用服务器时仍然使用它
+	failbackDelay := time.Duration(fs.config.FailbackDelaySeconds) * time.Second
+	for _, region := range regions {
+		if server := fs.selectByPriority(now, failbackDelay, region); server != nil {
+			return server, nil
+		}
+		if failbackDelay > 0 {
+			if server := fs.selectByPriority(now, 0, region); server != nil {
+				return server, nil
+			}
+		}
+		if region != "" {
+			logger.Warning("LOAD", "No available servers in region %s, falling back to other regions", region)
 		}
 	}
 
-	// 如果所有服务器都不可用，尝试选择冷却时间最短的服务器进行紧急重试
+	// 所有服务器都不可用时，优先使用配置的应急服务器
+	for i, server := range fs.config.EmergencyServers {
+		if fs.isServerAvailable(server.URL, now) {
+			logger.Warning("LOAD", "All servers unavailable, using emergency server: %s", server.URL)
+			return &fs.config.EmergencyServers[i], nil
+		}
+	}
+
+	// 应急服务器也不可用时，尝试选择冷却时间最短的服务器进行紧急重试
 	fallbackServer := fs.getEmergencyFallbackServer()
 	if fallbackServer != nil {
 		logger.Warning("LOAD", "Using emergency fallback server: %s", fallbackServer.URL)
@@ -87,50 +134,199 @@ func (fs *FallbackSelector) SelectServer() (*types.UpstreamServer, error) {
 	return nil, errors.New("no available servers")
 }
 
-// getEmergencyFallbackServer 获取紧急fallback服务器（冷却时间最短的）
+// selectByPriority 按优先级层级查找可用服务器，同一层级内按权重轮询（调用方持有读锁）
+// holdDown > 0 时跳过在此时间内刚恢复的服务器，region 非空时只选择该区域的服务器
+func (fs *FallbackSelector) selectByPriority(now time.Time, holdDown time.Duration, region string) *types.UpstreamServer {
+	for start := 0; start < len(fs.orderedServers); {
+		priority := fs.orderedServers[start].Priority
+		end := start
+		var tier []int
+		for end < len(fs.orderedServers) && fs.orderedServers[end].Priority == priority {
+			server := fs.orderedServers[end]
+			// 检查服务器是否可用且未在冷却期
+			if fs.serverStatus[server.URL] && !fs.disabledServers[server.URL] && now.After(server.DownUntil) &&
+				(holdDown <= 0 || now.Sub(fs.recoveredAt[server.URL]) >= holdDown) &&
+				(region == "" || inRegion(server, region)) {
+				tier = append(tier, end)
+			}
+			end++
+		}
+
+		if len(tier) > 0 {
+			index := fs.selectInTier(tier)
+			logger.Info("LOAD", "Selected server by priority %d: %s", priority, fs.orderedServers[index].URL)
+			return &fs.orderedServers[index]
+		}
+		start = end
+	}
+	return nil
+}
+
+// selectInTier 在同一优先级层级的可用服务器中按平滑加权轮询选择，返回 orderedServers 下标
+func (fs *FallbackSelector) selectInTier(tier []int) int {
+	if len(tier) == 1 {
+		return tier[0]
+	}
+
+	fs.tierMutex.Lock()
+	defer fs.tierMutex.Unlock()
+
+	totalWeight := 0
+	selected := -1
+	maxCurrentWeight := 0
+	for _, index := range tier {
+		server := fs.orderedServers[index]
+		weight := server.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		totalWeight += weight
+
+		fs.tierWeights[server.URL] += weight
+		if selected == -1 || fs.tierWeights[server.URL] > maxCurrentWeight {
+			maxCurrentWeight = fs.tierWeights[server.URL]
+			selected = index
+		}
+	}
+
+	fs.tierWeights[fs.orderedServers[selected].URL] -= totalWeight
+	return selected
+}
+
+// getEmergencyFallbackServer 获取紧急fallback服务器
+// 在剩余冷却时间不超过最短冷却时间 + emergency_priority_window_seconds 的服务器中选择优先级最高的，
+// 窗口为 0 时选择冷却时间最短的（相同时按优先级）
 func (fs *FallbackSelector) getEmergencyFallbackServer() *types.UpstreamServer {
 	now := time.Now()
-	var bestServer *types.UpstreamServer
-	var shortestCooldown time.Duration = time.Hour * 24 // 初始化为很大的值
+	window := time.Duration(fs.config.EmergencyPriorityWindowSeconds) * time.Second
 
-	// 优先考虑按优先级排序的服务器
+	// 先计算最短剩余冷却时间，已过冷却时间的服务器按 0 计算
+	shortestCooldown := time.Duration(-1)
+	for _, server := range fs.orderedServers {
+		// 被禁用的服务器不参与紧急重试
+		if fs.disabledServers[server.URL] {
+			continue
+		}
+		remaining := max(fs.serverDownUntil[server.URL].Sub(now), 0)
+		if shortestCooldown < 0 || remaining < shortestCooldown {
+			shortestCooldown = remaining
+		}
+	}
+	if shortestCooldown < 0 {
+		return nil
+	}
+
+	// orderedServers 已按优先级（同优先级按权重）排序，第一个落在窗口内的即为优先级最高的
 	for i, server := range fs.orderedServers {
-		if now.After(server.DownUntil) {
-			// 如果已经过了冷却时间，直接选择
+		if fs.disabledServers[server.URL] {
+			continue
+		}
+		if fs.serverDownUntil[server.URL].Sub(now) <= shortestCooldown+window {
 			return &fs.orderedServers[i]
 		}
+	}
+	return nil
+}
 
-		// 找到冷却时间最短的服务器
-		cooldownRemaining := server.DownUntil.Sub(now)
-		if cooldownRemaining < shortestCooldown {
-			shortestCooldown = cooldownRemaining
-			bestServer = &fs.orderedServers[i]
+// MarkServerDown 标记服务器为不可用
+func (fs *FallbackSelector) MarkServerDown(url string) {
+	fs.markServerDown(url, 0, true, DownReasonFailure)
+}
+
+// MarkServerDownFor 标记服务器为不可用，并使用指定的冷却时间
+func (fs *FallbackSelector) MarkServerDownFor(url string, duration time.Duration) {
+	fs.markServerDown(url, duration, true, DownReasonFailure)
+}
+
+// MarkServerDownTransient 标记服务器在 duration 内不可用，不增加失败次数
+func (fs *FallbackSelector) MarkServerDownTransient(url string, duration time.Duration) {
+	fs.MarkServerDownTransientWithReason(url, DownReasonFailure, duration)
+}
+
+// MarkServerDownTransientWithReason 按指定原因标记服务器在 duration 内不可用，不增加失败次数
+func (fs *FallbackSelector) MarkServerDownTransientWithReason(url string, reason DownReason, duration time.Duration) {
+	fs.markServerDown(url, duration, false, reason)
+}
+
+// ForgiveFailures 撤销已计入的 count 次失败，撤销因此触发的 max_failures 禁用，冷却时间不超过 duration
+func (fs *FallbackSelector) ForgiveFailures(url string, count int, duration time.Duration) {
+	fs.statusMutex.Lock()
+	defer fs.statusMutex.Unlock()
+
+	before := fs.failureCount[url]
+	fs.failureCount[url] = max(before-int64(count), 0)
+
+	maxFailures := int64(fs.config.MaxFailures)
+	if fs.disabledServers[url] && maxFailures > 0 && before > maxFailures && fs.failureCount[url] <= maxFailures {
+		delete(fs.disabledServers, url)
+		logger.Info("LOAD", "Server %s no longer disabled: failures during suspected network outage forgiven", url)
+	}
+
+	// 只缩短请求失败导致的冷却（余额不足等原因的服务器不受影响）
+	if !fs.serverStatus[url] && fs.downReasons[url].IsFailure() {
+		if downUntil := time.Now().Add(duration); downUntil.Before(fs.serverDownUntil[url]) {
+			fs.serverDownUntil[url] = downUntil
 		}
 	}
 
-	return bestServer
+	logger.Info("LOAD", "Forgave %d failures of %s (failures: %d -> %d)", count, url, before, fs.failureCount[url])
 }
 
-// MarkServerDown 标记服务器为不可用
-func (fs *FallbackSelector) MarkServerDown(url string) {
+// GetFailureCount 获取服务器当前的失败次数
+func (fs *FallbackSelector) GetFailureCount(url string) int64 {
+	fs.statusMutex.RLock()
+	defer fs.statusMutex.RUnlock()
+	return fs.failureCount[url]
+}
+
+// MarkServerDownWithReason 按指定原因标记服务器为不可用
+// 余额不足等非请求失败的原因不计入失败次数，且不会被被动健康检查恢复
+func (fs *FallbackSelector) MarkServerDownWithReason(url string, reason DownReason) {
+	fs.markServerDown(url, 0, reason.IsFailure(), reason)
+}
+
+// MarkServerDownWithReasonFor 按指定原因标记服务器为不可用，并使用指定的冷却时间
+func (fs *FallbackSelector) MarkServerDownWithReasonFor(url string, reason DownReason, duration time.Duration) {
+	fs.markServerDown(url, duration, reason.IsFailure(), reason)
+}
+
+// GetDownReason 获取服务器不可用的原因，服务器可用时返回空字符串
+func (fs *FallbackSelector) GetDownReason(url string) DownReason {
+	fs.statusMutex.RLock()
+	defer fs.statusMutex.RUnlock()
+	return fs.downReasons[url]
+}
+
+// markServerDown 标记服务器为不可用，duration <= 0 时按失败次数计算冷却时间
+// countFailure 为 false 时不增加失败次数，也不会触发 max_failures 禁用
+func (fs *FallbackSelector) markServerDown(url string, duration time.Duration, countFailure bool, reason DownReason) {
 	fs.statusMutex.Lock()
 	defer fs.statusMutex.Unlock()
 
+	wasUp := fs.serverStatus[url]
 	fs.serverStatus[url] = false
+	delete(fs.recoveredAt, url)
+	// 请求失败不覆盖余额不足等需要特定条件才能恢复的原因
+	if wasUp || !reason.IsFailure() || fs.downReasons[url].IsFailure() {
+		fs.downReasons[url] = reason
+	}
 
 	// 增加失败计数
-	fs.failureCount[url]++
+	if countFailure {
+		fs.failureCount[url]++
+	}
 	failures := fs.failureCount[url]
 
+	// 连续失败次数超过上限时永久禁用，不再参与冷却恢复
+	if countFailure && fs.config.MaxFailures > 0 && failures > int64(fs.config.MaxFailures) && !fs.disabledServers[url] {
+		fs.disabledServers[url] = true
+		logger.Error("LOAD", "Server disabled: %s (failures: %d exceeded max_failures: %d)", url, failures, fs.config.MaxFailures)
+	}
+
 	// 动态计算冷却时间
-	cooldownDuration := time.Duration(fs.config.Cooldown) * time.Second
-	if failures > 1 {
-		// 指数退避，但设置上限
-		dynamicCooldown := cooldownDuration * time.Duration(failures)
-		if dynamicCooldown > 10*time.Minute {
-			dynamicCooldown = 10 * time.Minute // 最大 10 分钟
-		}
-		cooldownDuration = dynamicCooldown
+	cooldownDuration := duration
+	if cooldownDuration <= 0 {
+		cooldownDuration = calculateCooldown(fs.config, cooldownFailures(fs.config, failures, fs.availableSince[url], time.Now()))
 	}
 
 	downUntil := time.Now().Add(cooldownDuration)
@@ -138,7 +334,11 @@ func (fs *FallbackSelector) MarkServerDown(url string) {
 	// 记录服务器冷却时间（统一管理，避免重复维护）
 	fs.serverDownUntil[url] = downUntil
 
-	logger.Warning("LOAD", "Server marked down: %s (priority order, failures: %d, cooldown: %v)", url, failures, cooldownDuration)
+	logger.Warning("LOAD", "Server marked down: %s (priority order, reason: %s, failures: %d, cooldown: %v)", url, reason, failures, cooldownDuration)
+
+	if wasUp {
+		fs.notifyStateChange(url, false)
+	}
 }
 
 // GetAvailableServers 获取所有可用服务器（按优先级排序）
@@ -161,7 +361,40 @@ func (fs *FallbackSelector) GetAvailableServers() []types.UpstreamServer {
 // isServerAvailable 统一的服务器可用性判断逻辑
 func (fs *FallbackSelector) isServerAvailable(url string, now time.Time) bool {
 	// 检查服务器状态和冷却时间
-	return fs.serverStatus[url] && now.After(fs.serverDownUntil[url])
+	return fs.serverStatus[url] && !fs.disabledServers[url] && now.After(fs.serverDownUntil[url])
+}
+
+// IsServerDisabled 判断服务器是否已被永久禁用
+func (fs *FallbackSelector) IsServerDisabled(url string) bool {
+	fs.statusMutex.RLock()
+	defer fs.statusMutex.RUnlock()
+	return fs.disabledServers[url]
+}
+
+// EnableServer 重新启用被禁用的服务器，并清零失败次数
+func (fs *FallbackSelector) EnableServer(url string) {
+	fs.statusMutex.Lock()
+	defer fs.statusMutex.Unlock()
+
+	if !fs.disabledServers[url] {
+		return
+	}
+	delete(fs.disabledServers, url)
+	delete(fs.downReasons, url)
+	fs.failureCount[url] = 0
+	fs.serverStatus[url] = true
+	fs.serverDownUntil[url] = time.Time{}
+	fs.availableSince[url] = time.Now()
+
+	logger.Success("LOAD", "Server re-enabled: %s", url)
+	fs.notifyStateChange(url, true)
+}
+
+// IsServerAvailable 判断服务器当前是否可用（加锁读取最新状态）
+func (fs *FallbackSelector) IsServerAvailable(url string) bool {
+	fs.statusMutex.RLock()
+	defer fs.statusMutex.RUnlock()
+	return fs.isServerAvailable(url, time.Now())
 }
 
 // GetServerStatus 获取服务器状态
@@ -176,17 +409,65 @@ func (fs *FallbackSelector) GetServerStatus() map[string]bool {
 	return status
 }
 
+// GetServerDownUntil 获取服务器的冷却结束时间
+func (fs *FallbackSelector) GetServerDownUntil(url string) time.Time {
+	fs.statusMutex.RLock()
+	defer fs.statusMutex.RUnlock()
+	return fs.serverDownUntil[url]
+}
+
+// DescribeRouting 返回 fallback 的路由计划：服务器按解析后的优先级排列，同一层级内按权重分配流量
+func (fs *FallbackSelector) DescribeRouting() RoutingPlan {
+	plan := RoutingPlan{
+		Mode:             "fallback",
+		Servers:          make([]RoutingEntry, len(fs.orderedServers)),
+		EmergencyServers: configRoutingEntries(fs.config.EmergencyServers),
+	}
+
+	tierWeights := make(map[int]int)
+	for i, server := range fs.orderedServers {
+		plan.Servers[i] = newRoutingEntry(0, server)
+		plan.Servers[i].Priority = server.Priority
+		tierWeights[server.Priority] += plan.Servers[i].Weight
+	}
+
+	// 同一优先级层级的服务器选择顺序相同
+	order := 0
+	for i := range plan.Servers {
+		entry := &plan.Servers[i]
+		if i == 0 || entry.Priority != plan.Servers[i-1].Priority {
+			order++
+		}
+		entry.Order = order
+		entry.Share = roundShare(float64(entry.Weight) / float64(tierWeights[entry.Priority]))
+	}
+	return plan
+}
+
 // RecoverServer 恢复服务器
 func (fs *FallbackSelector) RecoverServer(url string) {
 	fs.statusMutex.Lock()
 	defer fs.statusMutex.Unlock()
 
+	// 被禁用的服务器只能通过 EnableServer 恢复
+	if fs.disabledServers[url] {
+		return
+	}
+
+	wasUp := fs.serverStatus[url]
 	fs.serverStatus[url] = true
+	delete(fs.downReasons, url)
 
 	// 清除冷却时间
 	fs.serverDownUntil[url] = time.Time{}
 
 	logger.Success("LOAD", "Server recovered: %s", url)
+
+	if !wasUp {
+		fs.recoveredAt[url] = time.Now()
+		fs.availableSince[url] = fs.recoveredAt[url]
+		fs.notifyStateChange(url, true)
+	}
 }
 
 // MarkServerHealthy 标记服务器为健康
@@ -194,6 +475,16 @@ func (fs *FallbackSelector) MarkServerHealthy(url string) {
 	fs.statusMutex.Lock()
 	defer fs.statusMutex.Unlock()
 
+	// 禁用前已发出的请求成功时不自动恢复
+	if fs.disabledServers[url] {
+		return
+	}
+
+	// 余额不足、手动下线的服务器只能由余额查询或运维恢复，下线前已发出的请求成功时不恢复
+	if !fs.downReasons[url].IsFailure() {
+		return
+	}
+
 	// 重置失败计数
 	if fs.failureCount[url] > 0 {
 		oldFailures := fs.failureCount[url]
@@ -204,14 +495,20 @@ func (fs *FallbackSelector) MarkServerHealthy(url string) {
 	// 确保服务器状态为可用
 	if !fs.serverStatus[url] {
 		fs.serverStatus[url] = true
+		delete(fs.downReasons, url)
 		// 清除冷却时间
 		fs.serverDownUntil[url] = time.Time{}
+		fs.recoveredAt[url] = time.Now()
+		fs.availableSince[url] = fs.recoveredAt[url]
 		logger.Success("LOAD", "Server %s auto-recovered from healthy request", url)
+		fs.notifyStateChange(url, true)
 	}
 }
 
-// assignUniquePriorities 为服务器分配唯一优先级
-func (fs *FallbackSelector) assignUniquePriorities() {
+// assignPriorities 为服务器分配优先级
+// 显式配置的优先级保持不变（相同优先级的服务器组成同一层级，层级内按权重分流），
+// 未配置优先级的服务器排在显式优先级之后并分配唯一优先级
+func (fs *FallbackSelector) assignPriorities() {
 	// 分离已设置优先级和未设置优先级的服务器
 	var explicitPriorityServers []types.UpstreamServer
 	var autoPriorityServers []types.UpstreamServer
@@ -224,8 +521,16 @@ func (fs *FallbackSelector) assignUniquePriorities() {
 		}
 	}
 
-	// 检查显式优先级是否有冲突
-	fs.resolveExplicitPriorityConflicts(explicitPriorityServers)
+	// 记录共享同一优先级的层级
+	tierSizes := make(map[int]int)
+	for _, server := range explicitPriorityServers {
+		tierSizes[server.Priority]++
+	}
+	for priority, size := range tierSizes {
+		if size > 1 {
+			logger.Info("LOAD", "Priority tier %d: %d servers share traffic by weight", priority, size)
+		}
+	}
 
 	// 为没有设置优先级的服务器自动分配优先级
 	fs.assignAutoPriorities(autoPriorityServers, explicitPriorityServers)
@@ -235,34 +540,6 @@ func (fs *FallbackSelector) assignUniquePriorities() {
 	copy(fs.orderedServers[len(explicitPriorityServers):], autoPriorityServers)
 }
 
-// resolveExplicitPriorityConflicts 解决显式优先级冲突
-func (fs *FallbackSelector) resolveExplicitPriorityConflicts(servers []types.UpstreamServer) {
-	// 按优先级分组
-	priorityGroups := make(map[int][]int)
-	for i, server := range servers {
-		priorityGroups[server.Priority] = append(priorityGroups[server.Priority], i)
-	}
-
-	// 解决冲突：对于同一优先级的服务器，按权重重新分配优先级
-	for priority, indices := range priorityGroups {
-		if len(indices) > 1 {
-			// 按权重排序（权重高的优先级更高）
-			sort.Slice(indices, func(i, j int) bool {
-				return servers[indices[i]].Weight > servers[indices[j]].Weight
-			})
-
-			// 重新分配优先级
-			for i, serverIdx := range indices {
-				servers[serverIdx].Priority = priority + i
-				if i > 0 {
-					logger.Warning("LOAD", "Priority conflict resolved: Server %s priority adjusted from %d to %d",
-						servers[serverIdx].URL, priority, priority+i)
-				}
-			}
-		}
-	}
-}
-
 // assignAutoPriorities 为未设置优先级的服务器自动分配优先级
 func (fs *FallbackSelector) assignAutoPriorities(autoPriorityServers []types.UpstreamServer, explicitPriorityServers []types.UpstreamServer) {
 	if len(autoPriorityServers) == 0 {
@@ -293,3 +570,17 @@ func (fs *FallbackSelector) assignAutoPriorities(autoPriorityServers []types.Ups
 			autoPriorityServers[i].Priority, autoPriorityServers[i].URL, autoPriorityServers[i].Weight)
 	}
 }
+
+// SetStateListener 设置服务器状态变化监听器
+func (fs *FallbackSelector) SetStateListener(listener StateListener) {
+	fs.statusMutex.Lock()
+	defer fs.statusMutex.Unlock()
+	fs.stateListener = listener
+}
+
+// notifyStateChange 通知状态变化（调用方需持有 statusMutex）
+func (fs *FallbackSelector) notifyStateChange(url string, up bool) {
+	if fs.stateListener != nil {
+		fs.stateListener(url, up, fs.failureCount[url])
+	}
+}
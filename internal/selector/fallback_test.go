@@ -3,6 +3,7 @@ package selector
 import (
 	"slices"
 	"testing"
+	"time"
 
 	"claude-code-lb/internal/testutil"
 	"claude-code-lb/pkg/types"
@@ -181,6 +182,46 @@ func TestFallbackSelectorMarkServerDown(t *testing.T) {
 	}
 }
 
+func TestFallbackSelectorMarkServerDownBackoff(t *testing.T) {
+	disabled := false
+
+	tests := []struct {
+		name        string
+		backoff     *bool
+		minCooldown time.Duration
+		maxCooldown time.Duration
+	}{
+		{name: "backoff enabled", backoff: nil, minCooldown: 110 * time.Second, maxCooldown: 120 * time.Second},
+		{name: "backoff disabled", backoff: &disabled, minCooldown: 50 * time.Second, maxCooldown: 60 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := types.Config{
+				Mode:           "fallback",
+				Cooldown:       60,
+				BackoffEnabled: tt.backoff,
+				Servers: []types.UpstreamServer{
+					{URL: "http://test-api.local", Token: testutil.TestToken1, Priority: 1},
+				},
+			}
+
+			fs := NewFallbackSelector(config)
+			fs.MarkServerDown("http://test-api.local")
+			fs.MarkServerDown("http://test-api.local")
+
+			if fs.failureCount["http://test-api.local"] != 2 {
+				t.Errorf("Expected failure count 2, got %d", fs.failureCount["http://test-api.local"])
+			}
+
+			remaining := time.Until(fs.serverDownUntil["http://test-api.local"])
+			if remaining < tt.minCooldown || remaining > tt.maxCooldown {
+				t.Errorf("Expected cooldown between %v and %v, got %v", tt.minCooldown, tt.maxCooldown, remaining)
+			}
+		})
+	}
+}
+
 func TestFallbackSelectorMarkServerHealthy(t *testing.T) {
 	config := types.Config{
 		Mode: "fallback",
@@ -202,6 +243,13 @@ func TestFallbackSelectorMarkServerHealthy(t *testing.T) {
 	if !status["http://test-api.local"] {
 		t.Error("Server should be marked as healthy")
 	}
+
+	// 余额不足的服务器只能由余额查询恢复
+	fs.MarkServerDownWithReason("http://test-api.local", DownReasonBalance)
+	fs.MarkServerHealthy("http://test-api.local")
+	if fs.IsServerAvailable("http://test-api.local") || fs.GetDownReason("http://test-api.local") != DownReasonBalance {
+		t.Error("Expected balance-downed server to stay down after a healthy request")
+	}
 }
 
 func TestFallbackSelectorGetAvailableServers(t *testing.T) {
@@ -319,6 +367,202 @@ func TestFallbackSelectorEmergencyFallback(t *testing.T) {
 	}
 }
 
+func TestFallbackSelectorEmergencyPriorityWindow(t *testing.T) {
+	// 优先级 1 的服务器冷却时间最长，优先级 3 的最短
+	cooldowns := map[string]time.Duration{
+		testutil.API1ExampleURL: 30 * time.Second,
+		testutil.API2ExampleURL: 20 * time.Second,
+		testutil.API3ExampleURL: 10 * time.Second,
+	}
+
+	tests := []struct {
+		name     string
+		window   int
+		expected string
+	}{
+		{name: "no window picks shortest cooldown", window: 0, expected: testutil.API3ExampleURL},
+		{name: "window covers second priority", window: 15, expected: testutil.API2ExampleURL},
+		{name: "window covers highest priority", window: 25, expected: testutil.API1ExampleURL},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := NewFallbackSelector(types.Config{
+				Mode:                           "fallback",
+				EmergencyPriorityWindowSeconds: tt.window,
+				Servers: []types.UpstreamServer{
+					{URL: testutil.API1ExampleURL, Token: testutil.TestToken1, Priority: 1},
+					{URL: testutil.API2ExampleURL, Token: testutil.TestToken2, Priority: 2},
+					{URL: testutil.API3ExampleURL, Token: testutil.TestToken3, Priority: 3},
+				},
+			})
+			for url, cooldown := range cooldowns {
+				fs.MarkServerDownFor(url, cooldown)
+			}
+
+			server, err := fs.SelectServer()
+			if err != nil {
+				t.Fatalf("SelectServer() unexpected error: %v", err)
+			}
+			if server.URL != tt.expected {
+				t.Errorf("Expected emergency fallback %s, got %s", tt.expected, server.URL)
+			}
+		})
+	}
+}
+
+func TestFallbackSelectorFailbackDelay(t *testing.T) {
+	newSelector := func(delay int) *FallbackSelector {
+		return NewFallbackSelector(types.Config{
+			Mode:                 "fallback",
+			Cooldown:             60,
+			FailbackDelaySeconds: delay,
+			Servers: []types.UpstreamServer{
+				{URL: testutil.API1ExampleURL, Token: testutil.TestToken1, Priority: 1},
+				{URL: testutil.API2ExampleURL, Token: testutil.TestToken2, Priority: 2},
+			},
+		})
+	}
+	selected := func(t *testing.T, fs *FallbackSelector) string {
+		t.Helper()
+		server, err := fs.SelectServer()
+		if err != nil {
+			t.Fatalf("SelectServer() unexpected error: %v", err)
+		}
+		return server.URL
+	}
+
+	t.Run("without delay fails back immediately", func(t *testing.T) {
+		fs := newSelector(0)
+		fs.MarkServerDown(testutil.API1ExampleURL)
+		fs.RecoverServer(testutil.API1ExampleURL)
+		if url := selected(t, fs); url != testutil.API1ExampleURL {
+			t.Errorf("Expected traffic back on primary, got %s", url)
+		}
+	})
+
+	t.Run("stays on secondary after primary recovers", func(t *testing.T) {
+		fs := newSelector(60)
+		fs.MarkServerDown(testutil.API1ExampleURL)
+		if url := selected(t, fs); url != testutil.API2ExampleURL {
+			t.Fatalf("Expected failover to secondary, got %s", url)
+		}
+
+		fs.RecoverServer(testutil.API1ExampleURL)
+		for i := 0; i < 3; i++ {
+			if url := selected(t, fs); url != testutil.API2ExampleURL {
+				t.Fatalf("Expected traffic to stay on secondary during failback delay, got %s", url)
+			}
+		}
+		if !fs.IsServerAvailable(testutil.API1ExampleURL) {
+			t.Error("Recovered primary should still be reported as available")
+		}
+
+		// 延迟结束后切回主服务器
+		fs.statusMutex.Lock()
+		fs.recoveredAt[testutil.API1ExampleURL] = time.Now().Add(-61 * time.Second)
+		fs.statusMutex.Unlock()
+		if url := selected(t, fs); url != testutil.API1ExampleURL {
+			t.Errorf("Expected failback to primary after delay, got %s", url)
+		}
+	})
+
+	t.Run("uses recovering primary when secondary is down", func(t *testing.T) {
+		fs := newSelector(60)
+		fs.MarkServerDown(testutil.API1ExampleURL)
+		fs.MarkServerHealthy(testutil.API1ExampleURL)
+		fs.MarkServerDown(testutil.API2ExampleURL)
+		if url := selected(t, fs); url != testutil.API1ExampleURL {
+			t.Errorf("Expected recovering primary when no other server is available, got %s", url)
+		}
+	})
+}
+
+func TestFallbackSelectorRegion(t *testing.T) {
+	fs := NewFallbackSelector(types.Config{
+		Mode:     "fallback",
+		Cooldown: 60,
+		Servers: []types.UpstreamServer{
+			{URL: testutil.API1ExampleURL, Token: testutil.TestToken1, Priority: 1, Region: "us-east"},
+			{URL: testutil.API2ExampleURL, Token: testutil.TestToken2, Priority: 2, Region: "eu-west"},
+			{URL: testutil.API3ExampleURL, Token: testutil.TestToken3, Priority: 3, Region: "eu-west"},
+		},
+	})
+
+	tests := []struct {
+		name     string
+		region   string
+		down     []string
+		expected string
+	}{
+		{name: "no region uses priority", expected: testutil.API1ExampleURL},
+		{name: "preferred region by priority", region: "eu-west", expected: testutil.API2ExampleURL},
+		{name: "next server in preferred region", region: "eu-west", down: []string{testutil.API2ExampleURL}, expected: testutil.API3ExampleURL},
+		{name: "cross-region fallback", region: "eu-west", down: []string{testutil.API3ExampleURL}, expected: testutil.API1ExampleURL},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, url := range tt.down {
+				fs.MarkServerDown(url)
+			}
+			server, err := fs.SelectServerWithOptions(SelectOptions{Region: tt.region})
+			if err != nil {
+				t.Fatalf("SelectServerWithOptions() unexpected error: %v", err)
+			}
+			if server.URL != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, server.URL)
+			}
+		})
+	}
+}
+
+func TestFallbackSelectorEmergencyServers(t *testing.T) {
+	config := types.Config{
+		Mode:     "fallback",
+		Cooldown: 60,
+		Servers: []types.UpstreamServer{
+			{URL: testutil.API1ExampleURL, Token: testutil.TestToken1, Priority: 1},
+			{URL: testutil.API2ExampleURL, Token: testutil.TestToken2, Priority: 2},
+		},
+		EmergencyServers: []types.UpstreamServer{
+			{URL: testutil.API3ExampleURL, Token: testutil.TestToken3},
+		},
+	}
+
+	fs := NewFallbackSelector(config)
+
+	// 只要还有主服务器可用，就不使用应急服务器
+	fs.MarkServerDown(testutil.API1ExampleURL)
+	server, err := fs.SelectServer()
+	if err != nil {
+		t.Fatalf("SelectServer failed: %v", err)
+	}
+	if server.URL != testutil.API2ExampleURL {
+		t.Errorf("Expected lower priority server %s, got %s", testutil.API2ExampleURL, server.URL)
+	}
+
+	// 所有主服务器不可用时使用应急服务器，而不是冷却时间最短的主服务器
+	fs.MarkServerDown(testutil.API2ExampleURL)
+	server, err = fs.SelectServer()
+	if err != nil {
+		t.Fatalf("Expected emergency server, got error: %v", err)
+	}
+	if server.URL != testutil.API3ExampleURL {
+		t.Errorf("Expected emergency server %s, got %s", testutil.API3ExampleURL, server.URL)
+	}
+
+	// 主服务器恢复后重新优先使用主服务器
+	fs.RecoverServer(testutil.API1ExampleURL)
+	server, err = fs.SelectServer()
+	if err != nil {
+		t.Fatalf("SelectServer failed: %v", err)
+	}
+	if server.URL != testutil.API1ExampleURL {
+		t.Errorf("Expected recovered primary server %s, got %s", testutil.API1ExampleURL, server.URL)
+	}
+}
+
 func TestFallbackSelectorPriorityConsistency(t *testing.T) {
 	config := types.Config{
 		Mode: "fallback",
@@ -351,3 +595,107 @@ func TestFallbackSelectorPriorityConsistency(t *testing.T) {
 		}
 	}
 }
+
+func TestFallbackSelectorPriorityTier(t *testing.T) {
+	config := types.Config{
+		Mode:     "fallback",
+		Cooldown: 60,
+		Servers: []types.UpstreamServer{
+			{URL: testutil.API1ExampleURL, Token: testutil.TestToken1, Priority: 1, Weight: 2},
+			{URL: testutil.API2ExampleURL, Token: testutil.TestToken2, Priority: 1, Weight: 1},
+			{URL: testutil.API3ExampleURL, Token: "token3", Priority: 2},
+		},
+	}
+
+	fs := NewFallbackSelector(config)
+
+	// 同优先级的两台服务器按 2:1 权重分流，备用层级不接收流量
+	counts := make(map[string]int)
+	for i := 0; i < 30; i++ {
+		server, err := fs.SelectServer()
+		if err != nil {
+			t.Fatalf("SelectServer failed: %v", err)
+		}
+		counts[server.URL]++
+	}
+
+	if counts[testutil.API1ExampleURL] != 20 || counts[testutil.API2ExampleURL] != 10 {
+		t.Errorf("Expected 20/10 split across priority-1 servers, got %v", counts)
+	}
+	if counts[testutil.API3ExampleURL] != 0 {
+		t.Errorf("Priority-2 server should not receive traffic, got %d", counts[testutil.API3ExampleURL])
+	}
+
+	// 层级内一台不可用时，流量全部转到同层级的另一台
+	fs.MarkServerDown(testutil.API1ExampleURL)
+	server, _ := fs.SelectServer()
+	if server.URL != testutil.API2ExampleURL {
+		t.Errorf("Expected remaining priority-1 server, got %s", server.URL)
+	}
+
+	// 整个层级不可用时才降级到下一层级
+	fs.MarkServerDown(testutil.API2ExampleURL)
+	server, _ = fs.SelectServer()
+	if server.URL != testutil.API3ExampleURL {
+		t.Errorf("Expected priority-2 server after tier is down, got %s", server.URL)
+	}
+}
+
+func TestFallbackSelectorMaxFailures(t *testing.T) {
+	config := types.Config{
+		Mode:        "fallback",
+		Algorithm:   "round_robin",
+		Cooldown:    60,
+		MaxFailures: 2,
+		Servers: []types.UpstreamServer{
+			{URL: testutil.API1ExampleURL, Token: testutil.TestToken1, Priority: 1},
+			{URL: testutil.API2ExampleURL, Token: "token2", Priority: 2},
+		},
+	}
+
+	s := NewFallbackSelector(config)
+
+	// 未超过上限时只是临时冷却，可以被恢复
+	for i := 0; i < 2; i++ {
+		s.MarkServerDown(testutil.API1ExampleURL)
+	}
+	if s.IsServerDisabled(testutil.API1ExampleURL) {
+		t.Fatal("Server should not be disabled before exceeding max_failures")
+	}
+	s.RecoverServer(testutil.API1ExampleURL)
+	if !s.IsServerAvailable(testutil.API1ExampleURL) {
+		t.Fatal("Server should be recoverable before exceeding max_failures")
+	}
+
+	// 超过上限后永久禁用，恢复和成功请求都不会重新启用
+	s.MarkServerDown(testutil.API1ExampleURL)
+	if !s.IsServerDisabled(testutil.API1ExampleURL) {
+		t.Fatal("Server should be disabled after exceeding max_failures")
+	}
+	s.RecoverServer(testutil.API1ExampleURL)
+	s.MarkServerHealthy(testutil.API1ExampleURL)
+	if s.IsServerAvailable(testutil.API1ExampleURL) {
+		t.Error("Disabled server should stay unavailable")
+	}
+	for i := 0; i < 5; i++ {
+		server, err := s.SelectServer()
+		if err != nil {
+			t.Fatalf("SelectServer() unexpected error: %v", err)
+		}
+		if server.URL == testutil.API1ExampleURL {
+			t.Fatal("Disabled server should not be selected")
+		}
+	}
+
+	// 所有服务器不可用时，紧急重试也不会选择被禁用的服务器
+	s.MarkServerDown(testutil.API2ExampleURL)
+	if server, err := s.SelectServer(); err == nil && server.URL == testutil.API1ExampleURL {
+		t.Error("Emergency fallback should not select a disabled server")
+	}
+
+	// 手动启用后恢复可用
+	s.EnableServer(testutil.API1ExampleURL)
+	if s.IsServerDisabled(testutil.API1ExampleURL) || !s.IsServerAvailable(testutil.API1ExampleURL) {
+		t.Error("Server should be available after EnableServer")
+	}
+}
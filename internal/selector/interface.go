@@ -1,6 +1,9 @@
 package selector
 
 import (
+	"strings"
+	"time"
+
 	"claude-code-lb/pkg/types"
 )
 
@@ -12,6 +15,9 @@ type ServerSelector interface {
 	// MarkServerDown 标记服务器为不可用
 	MarkServerDown(url string)
 
+	// MarkServerDownFor 标记服务器为不可用，并使用指定的冷却时间
+	MarkServerDownFor(url string, duration time.Duration)
+
 	// MarkServerHealthy 标记服务器为健康
 	MarkServerHealthy(url string)
 
@@ -21,6 +27,142 @@ type ServerSelector interface {
 	// GetServerStatus 获取服务器状态
 	GetServerStatus() map[string]bool
 
+	// GetServerDownUntil 获取服务器的冷却结束时间
+	GetServerDownUntil(url string) time.Time
+
+	// IsServerAvailable 判断服务器当前是否可用（状态正常且不在冷却期）
+	IsServerAvailable(url string) bool
+
 	// RecoverServer 恢复服务器
 	RecoverServer(url string)
+
+	// DescribeRouting 返回解析配置后实际生效的路由计划（服务器顺序、权重、优先级）
+	DescribeRouting() RoutingPlan
+}
+
+// ConnectionTracker 可选接口：跟踪每个服务器的在途连接数
+type ConnectionTracker interface {
+	// AcquireConnection 记录一个新的在途连接
+	AcquireConnection(url string)
+
+	// ReleaseConnection 释放一个在途连接
+	ReleaseConnection(url string)
+
+	// GetActiveConnections 获取服务器当前的在途连接数
+	GetActiveConnections(url string) int64
+}
+
+// ConnectionReserver 可选接口：选择服务器的同时占用一个在途连接
+// 选择和占用在同一把锁内完成，并发请求不会都看到同一个服务器未饱和而超过其 max_concurrent
+type ConnectionReserver interface {
+	// SelectAndAcquire 按 opts 选择一个可用的服务器并记录一个在途连接，调用方在请求结束后调用 ReleaseConnection
+	SelectAndAcquire(opts SelectOptions) (*types.UpstreamServer, error)
+}
+
+// DownReason 服务器被标记为不可用的原因
+type DownReason string
+
+const (
+	DownReasonFailure     DownReason = "failure"          // 未区分类型的请求失败
+	DownReasonConnection  DownReason = "connection_error" // 连接失败、超时等网络错误
+	DownReasonServerError DownReason = "server_error"     // 上游返回 5xx
+	DownReasonRateLimited DownReason = "rate_limited"     // 上游返回 429
+	DownReasonAuth        DownReason = "auth_error"       // 上游返回 401/403（启用 trip_on_auth_error 时）
+	DownReasonBalance     DownReason = "balance"          // 余额不足，只在余额查询确认余额充足后恢复
+	DownReasonManual      DownReason = "manual"           // 运维手动下线，只能手动恢复
+)
+
+// IsFailure 判断是否为请求失败类原因
+// 请求失败计入失败次数，冷却到期后由被动健康检查恢复；其他原因需要满足特定条件才能恢复
+func (r DownReason) IsFailure() bool {
+	return r != DownReasonBalance && r != DownReasonManual
+}
+
+// DownReasonTracker 可选接口：记录服务器被标记为不可用的原因
+type DownReasonTracker interface {
+	// MarkServerDownWithReason 按指定原因标记服务器为不可用（非请求失败的原因不计入失败次数）
+	MarkServerDownWithReason(url string, reason DownReason)
+
+	// MarkServerDownWithReasonFor 按指定原因标记服务器为不可用，并使用指定的冷却时间（<=0 时按失败次数计算）
+	MarkServerDownWithReasonFor(url string, reason DownReason, duration time.Duration)
+
+	// GetDownReason 获取服务器不可用的原因，服务器可用时返回空字符串
+	GetDownReason(url string) DownReason
+}
+
+// TransientDownMarker 可选接口：标记服务器暂时不可用，但不计入失败次数
+type TransientDownMarker interface {
+	// MarkServerDownTransient 标记服务器在 duration 内不可用，不增加失败次数（不影响退避和 max_failures）
+	MarkServerDownTransient(url string, duration time.Duration)
+
+	// MarkServerDownTransientWithReason 按指定原因标记服务器在 duration 内不可用，不增加失败次数
+	MarkServerDownTransientWithReason(url string, reason DownReason, duration time.Duration)
+
+	// ForgiveFailures 撤销已计入的 count 次失败（事后判定为本地网络故障）：失败次数相应减少，
+	// 撤销因此触发的 max_failures 禁用，冷却时间缩短为 duration；服务器保持不可用
+	ForgiveFailures(url string, count int, duration time.Duration)
+
+	// GetFailureCount 获取服务器当前的失败次数
+	GetFailureCount(url string) int64
+}
+
+// ServerDisabler 可选接口：失败次数超过 max_failures 的服务器被永久禁用，直到手动启用
+type ServerDisabler interface {
+	// IsServerDisabled 判断服务器是否已被永久禁用
+	IsServerDisabled(url string) bool
+
+	// EnableServer 重新启用被禁用的服务器，并清零失败次数
+	EnableServer(url string)
+}
+
+// StateListener 服务器状态变化回调（up=true 表示恢复可用）
+// 回调在选择器持有锁时同步调用，实现方不能阻塞或回调选择器
+type StateListener func(url string, up bool, failures int64)
+
+// StateNotifier 可选接口：支持注册服务器状态变化监听器
+type StateNotifier interface {
+	// SetStateListener 设置状态变化监听器
+	SetStateListener(listener StateListener)
+}
+
+// BalanceProvider 提供服务器最近一次成功查询到的余额
+type BalanceProvider interface {
+	// LatestBalance 返回服务器最近一次查询成功的余额，未知时 ok 为 false
+	LatestBalance(url string) (balance float64, ok bool)
+}
+
+// BalanceAware 可选接口：支持注入余额数据（用于按余额加权选择）
+type BalanceAware interface {
+	// SetBalanceProvider 设置余额数据来源
+	SetBalanceProvider(provider BalanceProvider)
+}
+
+// RandomSource random 算法使用的随机数来源
+type RandomSource interface {
+	// Intn 返回 [0, n) 内的随机整数
+	Intn(n int) int
+}
+
+// RandomAware 可选接口：支持替换 random 算法的随机数来源（如测试中使用确定性的来源）
+type RandomAware interface {
+	// SetRandomSource 设置随机数来源
+	SetRandomSource(source RandomSource)
+}
+
+// SelectOptions 单个请求的服务器选择参数，零值等同于 SelectServer
+type SelectOptions struct {
+	Model     string // 请求的模型（加权算法使用服务器的 model_weights）
+	Algorithm string // 覆盖配置的选择算法（为空时使用配置的算法，仅负载均衡模式）
+	Region    string // 优先选择的区域（该区域没有可用服务器时使用其他区域）
+}
+
+// OptionsSelector 可选接口：按单个请求的参数选择服务器（不修改选择器的配置）
+type OptionsSelector interface {
+	// SelectServerWithOptions 按 opts 选择一个可用的服务器
+	SelectServerWithOptions(opts SelectOptions) (*types.UpstreamServer, error)
+}
+
+// inRegion 判断服务器是否属于指定区域（不区分大小写）
+func inRegion(server types.UpstreamServer, region string) bool {
+	return strings.EqualFold(server.Region, region)
 }
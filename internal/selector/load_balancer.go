@@ -4,6 +4,8 @@ import (
 	"crypto/rand"
 	"errors"
 	"math/big"
+	mathrand "math/rand/v2"
+	"slices"
 	"sync"
 	"time"
 
@@ -16,26 +18,71 @@ type LoadBalancer struct {
 	config             types.Config
 	currentServerIndex int64 // 轮询索引
 	serverMutex        sync.Mutex
+	reserveMutex       sync.Mutex // 串行化 SelectAndAcquire 的选择和占用连接
 	serverStatus       map[string]bool
 	serverWeights      map[string]int       // 用于平滑加权轮询
 	serverDownUntil    map[string]time.Time // 服务器冷却时间
 	statusMutex        sync.RWMutex
-	failureCount       map[string]int64 // 服务器失败次数
+	failureCount       map[string]int64          // 服务器失败次数
+	disabledServers    map[string]bool           // 失败次数超过 max_failures 后被永久禁用的服务器
+	downReasons        map[string]DownReason     // 服务器不可用的原因
+	availableSince     map[string]time.Time      // 服务器最近一次进入可用状态的时间（用于 warmup_grace_seconds）
+	activeConnections  map[string]int64          // 服务器在途连接数
+	stateListener      StateListener             // 服务器状态变化监听器
+	balanceProvider    BalanceProvider           // 余额数据来源（weighted_balance 算法使用）
+	balanceWeights     map[string]float64        // 按余额加权的平滑轮询当前权重
+	modelWeights       map[string]map[string]int // 按模型权重的平滑加权轮询当前权重（模型 -> 服务器 -> 权重）
+	randomSource       RandomSource              // random 算法的随机数来源（在 serverMutex 下调用）
+}
+
+// cryptoRandomSource 基于 crypto/rand 的随机数来源（默认），生成失败时返回 -1
+type cryptoRandomSource struct{}
+
+func (cryptoRandomSource) Intn(n int) int {
+	value, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return -1
+	}
+	return int(value.Int64())
+}
+
+// mathRandomSource 基于 math/rand 的随机数来源，开销更低，适用于不需要密码学强度随机数的场景
+type mathRandomSource struct{}
+
+func (mathRandomSource) Intn(n int) int {
+	return mathrand.IntN(n)
+}
+
+// newRandomSource 按 random_source 配置创建随机数来源
+func newRandomSource(name string) RandomSource {
+	if name == "math" {
+		return mathRandomSource{}
+	}
+	return cryptoRandomSource{}
 }
 
 // NewLoadBalancer 创建新的负载均衡选择器
 func NewLoadBalancer(config types.Config) *LoadBalancer {
 	lb := &LoadBalancer{
-		config:          config,
-		serverStatus:    make(map[string]bool),
-		serverWeights:   make(map[string]int),
-		serverDownUntil: make(map[string]time.Time),
-		failureCount:    make(map[string]int64),
+		config:            config,
+		serverStatus:      make(map[string]bool),
+		serverWeights:     make(map[string]int),
+		serverDownUntil:   make(map[string]time.Time),
+		failureCount:      make(map[string]int64),
+		disabledServers:   make(map[string]bool),
+		downReasons:       make(map[string]DownReason),
+		availableSince:    make(map[string]time.Time),
+		activeConnections: make(map[string]int64),
+		balanceWeights:    make(map[string]float64),
+		modelWeights:      make(map[string]map[string]int),
+		randomSource:      newRandomSource(config.RandomSource),
 	}
 
-	// 初始化服务器状态和权重
-	for _, server := range config.Servers {
+	// 初始化服务器状态和权重（启动即进入可用状态）
+	now := time.Now()
+	for _, server := range slices.Concat(config.Servers, config.OverflowServers) {
 		lb.serverStatus[server.URL] = true
+		lb.availableSince[server.URL] = now
 		weight := server.Weight
 		if weight <= 0 {
 			weight = 1
@@ -43,6 +90,13 @@ func NewLoadBalancer(config types.Config) *LoadBalancer {
 		lb.serverWeights[server.URL] = weight
 		lb.serverDownUntil[server.URL] = time.Time{}
 		lb.failureCount[server.URL] = 0
+		lb.activeConnections[server.URL] = 0
+	}
+	for _, server := range config.EmergencyServers {
+		lb.serverStatus[server.URL] = true
+		lb.availableSince[server.URL] = now
+		lb.serverDownUntil[server.URL] = time.Time{}
+		lb.failureCount[server.URL] = 0
 	}
 
 	logger.Info("LOAD", "Load balancer initialized with algorithm: %s", config.Algorithm)
@@ -51,31 +105,133 @@ func NewLoadBalancer(config types.Config) *LoadBalancer {
 
 // SelectServer 选择一个可用的服务器
 func (lb *LoadBalancer) SelectServer() (*types.UpstreamServer, error) {
+	return lb.SelectServerWithOptions(SelectOptions{})
+}
+
+// SelectServerWithOptions 按单个请求的参数选择一个可用的服务器
+// 加权算法优先使用服务器 model_weights 中请求模型的权重，未配置时使用 weight；
+// 指定区域时只在该区域的可用服务器中选择，该区域没有可用服务器时使用其他区域
+func (lb *LoadBalancer) SelectServerWithOptions(opts SelectOptions) (*types.UpstreamServer, error) {
+	algorithm := opts.Algorithm
+	if algorithm == "" {
+		algorithm = lb.config.Algorithm
+	}
+
+	// 没有服务器为该模型配置权重时按默认权重选择，避免为任意模型名保存轮询状态
+	model := opts.Model
+	if !hasModelWeight(slices.Concat(lb.config.Servers, lb.config.OverflowServers), model) {
+		model = ""
+	}
+
+	// 所有 servers 都达到 max_concurrent 上限或不可用时溢出到 overflow_servers；
+	// 没有可用的溢出服务器时饱和的服务器继续接收请求（max_concurrent 不拒绝请求）
 	availableServers := lb.GetAvailableServers()
+	if primaries := lb.unsaturated(availableServers); len(primaries) > 0 {
+		availableServers = primaries
+	} else if overflow := lb.unsaturated(lb.availableServers(lb.config.OverflowServers)); len(overflow) > 0 {
+		logger.Info("LOAD", "All servers saturated or unavailable, overflowing to %d overflow server(s)", len(overflow))
+		availableServers = overflow
+	}
 	if len(availableServers) == 0 {
+		if server := lb.selectEmergencyServer(); server != nil {
+			logger.Warning("LOAD", "All servers unavailable, using emergency server: %s", server.URL)
+			return server, nil
+		}
 		logger.Error("LOAD", "No available servers for load balancing")
 		return nil, errors.New("no available servers")
 	}
 
+	if opts.Region != "" {
+		regionServers := slices.DeleteFunc(slices.Clone(availableServers), func(server types.UpstreamServer) bool {
+			return !inRegion(server, opts.Region)
+		})
+		if len(regionServers) > 0 {
+			availableServers = regionServers
+		} else {
+			logger.Warning("LOAD", "No available servers in region %s, falling back to other regions", opts.Region)
+		}
+	}
+
+	// 金丝雀服务器只接收 canary_percent 比例的请求，其余请求在其他服务器中正常选择
+	if canary := lb.config.Canary; canary != "" {
+		if i := slices.IndexFunc(availableServers, func(server types.UpstreamServer) bool { return server.URL == canary }); i >= 0 {
+			if len(availableServers) == 1 || lb.canarySampled() {
+				logger.Info("LOAD", "Selected canary server: %s (%g%%)", canary, lb.config.CanaryPercent)
+				return &availableServers[i], nil
+			}
+			availableServers = slices.Delete(slices.Clone(availableServers), i, i+1)
+		}
+	}
+
 	var selectedServer *types.UpstreamServer
 
-	switch lb.config.Algorithm {
+	switch algorithm {
 	case "weighted_round_robin":
-		selectedServer = lb.getWeightedServer(availableServers)
+		selectedServer = lb.getWeightedServer(availableServers, model)
 	case "random":
 		selectedServer = lb.getRandomServer(availableServers)
+	case "weighted_least_connections":
+		selectedServer = lb.getWeightedLeastConnectionsServer(availableServers, model)
+	case "weighted_balance":
+		selectedServer = lb.getBalanceWeightedServer(availableServers)
 	default: // round_robin
 		selectedServer = lb.getRoundRobinServer(availableServers)
 	}
 
+	// 加权算法的内部状态异常（如所有当前权重都低于初始哨兵值）时可能选不出服务器，
+	// 此时有可用服务器就回退到轮询，而不是返回错误
+	if selectedServer == nil && algorithm != "round_robin" {
+		logger.Warning("LOAD", "Algorithm %s selected no server, falling back to round_robin", algorithm)
+		algorithm = "round_robin"
+		selectedServer = lb.getRoundRobinServer(availableServers)
+	}
 	if selectedServer == nil {
 		return nil, errors.New("failed to select server")
 	}
 
-	logger.Info("LOAD", "Selected server: %s (algorithm: %s)", selectedServer.URL, lb.config.Algorithm)
+	logger.Info("LOAD", "Selected server: %s (algorithm: %s)", selectedServer.URL, algorithm)
 	return selectedServer, nil
 }
 
+// canarySampled 按 canary_percent 抽样判断本次请求是否发往金丝雀服务器
+func (lb *LoadBalancer) canarySampled() bool {
+	if lb.config.CanaryPercent <= 0 {
+		return false
+	}
+
+	lb.serverMutex.Lock()
+	defer lb.serverMutex.Unlock()
+
+	// 以万分之一为单位抽样，支持 0.01% 精度的比例；随机数生成失败时不发往金丝雀
+	n := lb.randomSource.Intn(10000)
+	return n >= 0 && float64(n) < lb.config.CanaryPercent*100
+}
+
+// unsaturated 返回未达到 max_concurrent 上限的服务器（未配置上限的服务器不会饱和）
+func (lb *LoadBalancer) unsaturated(servers []types.UpstreamServer) []types.UpstreamServer {
+	lb.serverMutex.Lock()
+	defer lb.serverMutex.Unlock()
+
+	return slices.DeleteFunc(slices.Clone(servers), func(server types.UpstreamServer) bool {
+		return server.MaxConcurrent > 0 && lb.activeConnections[server.URL] >= int64(server.MaxConcurrent)
+	})
+}
+
+// selectEmergencyServer 按配置顺序返回第一个可用的应急服务器
+func (lb *LoadBalancer) selectEmergencyServer() *types.UpstreamServer {
+	now := time.Now()
+
+	lb.statusMutex.RLock()
+	defer lb.statusMutex.RUnlock()
+
+	for i, server := range lb.config.EmergencyServers {
+		if lb.isServerAvailable(server.URL, now) {
+			return &lb.config.EmergencyServers[i]
+		}
+	}
+	return nil
+}
+
 // getRoundRobinServer 轮询算法选择服务器
 func (lb *LoadBalancer) getRoundRobinServer(servers []types.UpstreamServer) *types.UpstreamServer {
 	if len(servers) == 0 {
@@ -90,8 +246,34 @@ func (lb *LoadBalancer) getRoundRobinServer(servers []types.UpstreamServer) *typ
 	return &servers[index]
 }
 
+// serverWeight 返回服务器对指定模型的权重：model_weights 中配置的权重优先，其次为 weight，不大于 0 时按 1 处理
+func serverWeight(server types.UpstreamServer, model string) int {
+	weight := server.Weight
+	if modelWeight, ok := server.ModelWeights[model]; ok && model != "" {
+		weight = modelWeight
+	}
+	if weight <= 0 {
+		weight = 1
+	}
+	return weight
+}
+
+// hasModelWeight 判断是否有服务器为指定模型配置了权重
+func hasModelWeight(servers []types.UpstreamServer, model string) bool {
+	if model == "" {
+		return false
+	}
+	for _, server := range servers {
+		if _, ok := server.ModelWeights[model]; ok {
+			return true
+		}
+	}
+	return false
+}
+
 // getWeightedServer 平滑加权轮询算法选择服务器
-func (lb *LoadBalancer) getWeightedServer(servers []types.UpstreamServer) *types.UpstreamServer {
+// 每个模型使用独立的当前权重，保证各模型的流量分布分别符合其权重
+func (lb *LoadBalancer) getWeightedServer(servers []types.UpstreamServer, model string) *types.UpstreamServer {
 	if len(servers) == 0 {
 		return nil
 	}
@@ -103,14 +285,18 @@ func (lb *LoadBalancer) getWeightedServer(servers []types.UpstreamServer) *types
 	lb.serverMutex.Lock()
 	defer lb.serverMutex.Unlock()
 
+	currentWeights := lb.serverWeights
+	if model != "" {
+		if lb.modelWeights[model] == nil {
+			lb.modelWeights[model] = make(map[string]int)
+		}
+		currentWeights = lb.modelWeights[model]
+	}
+
 	// 计算总权重
 	totalWeight := 0
 	for _, server := range servers {
-		weight := server.Weight
-		if weight <= 0 {
-			weight = 1
-		}
-		totalWeight += weight
+		totalWeight += serverWeight(server, model)
 	}
 
 	// 找到当前权重最大的服务器
@@ -119,14 +305,10 @@ func (lb *LoadBalancer) getWeightedServer(servers []types.UpstreamServer) *types
 
 	for i := range servers {
 		server := &servers[i]
-		originalWeight := server.Weight
-		if originalWeight <= 0 {
-			originalWeight = 1
-		}
 
 		// 增加原始权重到当前权重
-		lb.serverWeights[server.URL] += originalWeight
-		currentWeight := lb.serverWeights[server.URL]
+		currentWeights[server.URL] += serverWeight(*server, model)
+		currentWeight := currentWeights[server.URL]
 
 		if currentWeight > maxCurrentWeight {
 			maxCurrentWeight = currentWeight
@@ -136,7 +318,7 @@ func (lb *LoadBalancer) getWeightedServer(servers []types.UpstreamServer) *types
 
 	if selected != nil {
 		// 选中的服务器减去总权重
-		lb.serverWeights[selected.URL] -= totalWeight
+		currentWeights[selected.URL] -= totalWeight
 	}
 
 	return selected
@@ -148,36 +330,259 @@ func (lb *LoadBalancer) getRandomServer(servers []types.UpstreamServer) *types.U
 		return nil
 	}
 
-	// 使用 crypto/rand 生成更好的随机数
-	n, err := rand.Int(rand.Reader, big.NewInt(int64(len(servers))))
-	if err != nil {
-		// 如果随机数生成失败，回退到轮询
+	lb.serverMutex.Lock()
+	n := lb.randomSource.Intn(len(servers))
+	lb.serverMutex.Unlock()
+
+	if n < 0 || n >= len(servers) {
+		// 随机数生成失败（或来源返回越界的值）时回退到轮询
 		return lb.getRoundRobinServer(servers)
 	}
 
-	return &servers[n.Int64()]
+	return &servers[n]
+}
+
+// SetRandomSource 替换 random 算法的随机数来源（实现 RandomAware），nil 表示恢复默认的 crypto/rand
+func (lb *LoadBalancer) SetRandomSource(source RandomSource) {
+	if source == nil {
+		source = cryptoRandomSource{}
+	}
+
+	lb.serverMutex.Lock()
+	defer lb.serverMutex.Unlock()
+	lb.randomSource = source
+}
+
+// getWeightedLeastConnectionsServer 加权最少连接算法选择服务器（最小化 在途连接数/权重）
+func (lb *LoadBalancer) getWeightedLeastConnectionsServer(servers []types.UpstreamServer, model string) *types.UpstreamServer {
+	if len(servers) == 0 {
+		return nil
+	}
+
+	lb.serverMutex.Lock()
+	defer lb.serverMutex.Unlock()
+
+	var selected *types.UpstreamServer
+	var selectedConns int64
+	var selectedWeight int64
+
+	for i := range servers {
+		server := &servers[i]
+		weight := int64(serverWeight(*server, model))
+		conns := lb.activeConnections[server.URL]
+
+		if selected == nil {
+			selected, selectedConns, selectedWeight = server, conns, weight
+			continue
+		}
+
+		// 交叉相乘比较 conns/weight，避免浮点误差；相同时优先权重更高的服务器
+		lhs := conns * selectedWeight
+		rhs := selectedConns * weight
+		if lhs < rhs || (lhs == rhs && weight > selectedWeight) {
+			selected, selectedConns, selectedWeight = server, conns, weight
+		}
+	}
+
+	return selected
+}
+
+// getBalanceWeightedServer 按剩余余额加权的平滑轮询选择服务器
+// 余额未知的服务器使用配置的静态权重；所有权重都为 0 时回退到轮询
+func (lb *LoadBalancer) getBalanceWeightedServer(servers []types.UpstreamServer) *types.UpstreamServer {
+	if len(servers) == 0 {
+		return nil
+	}
+
+	weights := make([]float64, len(servers))
+	var totalWeight float64
+	for i, server := range servers {
+		weights[i] = lb.balanceWeight(server)
+		totalWeight += weights[i]
+	}
+	if totalWeight <= 0 {
+		return lb.getRoundRobinServer(servers)
+	}
+
+	lb.serverMutex.Lock()
+	defer lb.serverMutex.Unlock()
+
+	var selected *types.UpstreamServer
+	maxCurrentWeight := 0.0
+	for i := range servers {
+		server := &servers[i]
+		lb.balanceWeights[server.URL] += weights[i]
+		if selected == nil || lb.balanceWeights[server.URL] > maxCurrentWeight {
+			maxCurrentWeight = lb.balanceWeights[server.URL]
+			selected = server
+		}
+	}
+
+	lb.balanceWeights[selected.URL] -= totalWeight
+	return selected
+}
+
+// balanceWeight 返回服务器的余额权重（余额为负时按 0 处理）
+func (lb *LoadBalancer) balanceWeight(server types.UpstreamServer) float64 {
+	lb.statusMutex.RLock()
+	provider := lb.balanceProvider
+	lb.statusMutex.RUnlock()
+
+	if provider != nil {
+		if balance, ok := provider.LatestBalance(server.URL); ok {
+			return max(balance, 0)
+		}
+	}
+
+	weight := server.Weight
+	if weight <= 0 {
+		weight = 1
+	}
+	return float64(weight)
+}
+
+// SetBalanceProvider 设置余额数据来源
+func (lb *LoadBalancer) SetBalanceProvider(provider BalanceProvider) {
+	lb.statusMutex.Lock()
+	defer lb.statusMutex.Unlock()
+	lb.balanceProvider = provider
+}
+
+// AcquireConnection 记录一个新的在途连接
+func (lb *LoadBalancer) AcquireConnection(url string) {
+	lb.serverMutex.Lock()
+	defer lb.serverMutex.Unlock()
+	lb.activeConnections[url]++
+}
+
+// SelectAndAcquire 按 opts 选择服务器并记录一个在途连接（实现 ConnectionReserver）
+// 选择时读取的在途连接数在占用前不会被其他 SelectAndAcquire 改变，服务器不会因并发选择超过 max_concurrent
+func (lb *LoadBalancer) SelectAndAcquire(opts SelectOptions) (*types.UpstreamServer, error) {
+	lb.reserveMutex.Lock()
+	defer lb.reserveMutex.Unlock()
+
+	server, err := lb.SelectServerWithOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	lb.AcquireConnection(server.URL)
+	return server, nil
+}
+
+// ReleaseConnection 释放一个在途连接
+func (lb *LoadBalancer) ReleaseConnection(url string) {
+	lb.serverMutex.Lock()
+	defer lb.serverMutex.Unlock()
+	if lb.activeConnections[url] > 0 {
+		lb.activeConnections[url]--
+	}
+}
+
+// GetActiveConnections 获取服务器当前的在途连接数
+func (lb *LoadBalancer) GetActiveConnections(url string) int64 {
+	lb.serverMutex.Lock()
+	defer lb.serverMutex.Unlock()
+	return lb.activeConnections[url]
 }
 
 // MarkServerDown 标记服务器为不可用
 func (lb *LoadBalancer) MarkServerDown(url string) {
+	lb.markServerDown(url, 0, true, DownReasonFailure)
+}
+
+// MarkServerDownFor 标记服务器为不可用，并使用指定的冷却时间
+func (lb *LoadBalancer) MarkServerDownFor(url string, duration time.Duration) {
+	lb.markServerDown(url, duration, true, DownReasonFailure)
+}
+
+// MarkServerDownTransient 标记服务器在 duration 内不可用，不增加失败次数
+func (lb *LoadBalancer) MarkServerDownTransient(url string, duration time.Duration) {
+	lb.MarkServerDownTransientWithReason(url, DownReasonFailure, duration)
+}
+
+// MarkServerDownTransientWithReason 按指定原因标记服务器在 duration 内不可用，不增加失败次数
+func (lb *LoadBalancer) MarkServerDownTransientWithReason(url string, reason DownReason, duration time.Duration) {
+	lb.markServerDown(url, duration, false, reason)
+}
+
+// ForgiveFailures 撤销已计入的 count 次失败，撤销因此触发的 max_failures 禁用，冷却时间不超过 duration
+func (lb *LoadBalancer) ForgiveFailures(url string, count int, duration time.Duration) {
+	lb.statusMutex.Lock()
+	defer lb.statusMutex.Unlock()
+
+	before := lb.failureCount[url]
+	lb.failureCount[url] = max(before-int64(count), 0)
+
+	maxFailures := int64(lb.config.MaxFailures)
+	if lb.disabledServers[url] && maxFailures > 0 && before > maxFailures && lb.failureCount[url] <= maxFailures {
+		delete(lb.disabledServers, url)
+		logger.Info("LOAD", "Server %s no longer disabled: failures during suspected network outage forgiven", url)
+	}
+
+	// 只缩短请求失败导致的冷却（余额不足等原因的服务器不受影响）
+	if !lb.serverStatus[url] && lb.downReasons[url].IsFailure() {
+		if downUntil := time.Now().Add(duration); downUntil.Before(lb.serverDownUntil[url]) {
+			lb.serverDownUntil[url] = downUntil
+		}
+	}
+
+	logger.Info("LOAD", "Forgave %d failures of %s (failures: %d -> %d)", count, url, before, lb.failureCount[url])
+}
+
+// GetFailureCount 获取服务器当前的失败次数
+func (lb *LoadBalancer) GetFailureCount(url string) int64 {
+	lb.statusMutex.RLock()
+	defer lb.statusMutex.RUnlock()
+	return lb.failureCount[url]
+}
+
+// MarkServerDownWithReason 按指定原因标记服务器为不可用
+// 余额不足等非请求失败的原因不计入失败次数，且不会被被动健康检查恢复
+func (lb *LoadBalancer) MarkServerDownWithReason(url string, reason DownReason) {
+	lb.markServerDown(url, 0, reason.IsFailure(), reason)
+}
+
+// MarkServerDownWithReasonFor 按指定原因标记服务器为不可用，并使用指定的冷却时间
+func (lb *LoadBalancer) MarkServerDownWithReasonFor(url string, reason DownReason, duration time.Duration) {
+	lb.markServerDown(url, duration, reason.IsFailure(), reason)
+}
+
+// GetDownReason 获取服务器不可用的原因，服务器可用时返回空字符串
+func (lb *LoadBalancer) GetDownReason(url string) DownReason {
+	lb.statusMutex.RLock()
+	defer lb.statusMutex.RUnlock()
+	return lb.downReasons[url]
+}
+
+// markServerDown 标记服务器为不可用，duration <= 0 时按失败次数计算冷却时间
+// countFailure 为 false 时不增加失败次数，也不会触发 max_failures 禁用
+func (lb *LoadBalancer) markServerDown(url string, duration time.Duration, countFailure bool, reason DownReason) {
 	lb.statusMutex.Lock()
 	defer lb.statusMutex.Unlock()
 
+	wasUp := lb.serverStatus[url]
 	lb.serverStatus[url] = false
+	// 请求失败不覆盖余额不足等需要特定条件才能恢复的原因
+	if wasUp || !reason.IsFailure() || lb.downReasons[url].IsFailure() {
+		lb.downReasons[url] = reason
+	}
 
 	// 增加失败计数
-	lb.failureCount[url]++
+	if countFailure {
+		lb.failureCount[url]++
+	}
 	failures := lb.failureCount[url]
 
+	// 连续失败次数超过上限时永久禁用，不再参与冷却恢复
+	if countFailure && lb.config.MaxFailures > 0 && failures > int64(lb.config.MaxFailures) && !lb.disabledServers[url] {
+		lb.disabledServers[url] = true
+		logger.Error("LOAD", "Server disabled: %s (failures: %d exceeded max_failures: %d)", url, failures, lb.config.MaxFailures)
+	}
+
 	// 动态计算冷却时间（指数退避）
-	cooldownDuration := time.Duration(lb.config.Cooldown) * time.Second
-	if failures > 1 {
-		// 指数退避，但设置上限
-		dynamicCooldown := cooldownDuration * time.Duration(failures)
-		if dynamicCooldown > 10*time.Minute {
-			dynamicCooldown = 10 * time.Minute // 最大 10 分钟
-		}
-		cooldownDuration = dynamicCooldown
+	cooldownDuration := duration
+	if cooldownDuration <= 0 {
+		cooldownDuration = calculateCooldown(lb.config, cooldownFailures(lb.config, failures, lb.availableSince[url], time.Now()))
 	}
 
 	downUntil := time.Now().Add(cooldownDuration)
@@ -185,18 +590,27 @@ func (lb *LoadBalancer) MarkServerDown(url string) {
 	// 记录服务器冷却时间（使用内部字段，不修改共享配置）
 	lb.serverDownUntil[url] = downUntil
 
-	logger.Warning("LOAD", "Server marked down: %s (failures: %d, cooldown: %v)", url, failures, cooldownDuration)
+	logger.Warning("LOAD", "Server marked down: %s (reason: %s, failures: %d, cooldown: %v)", url, reason, failures, cooldownDuration)
+
+	if wasUp {
+		lb.notifyStateChange(url, false)
+	}
 }
 
-// GetAvailableServers 获取所有可用服务器
+// GetAvailableServers 获取所有可用服务器（不包括应急和溢出服务器）
 func (lb *LoadBalancer) GetAvailableServers() []types.UpstreamServer {
+	return lb.availableServers(lb.config.Servers)
+}
+
+// availableServers 返回 servers 中当前可用的服务器
+func (lb *LoadBalancer) availableServers(servers []types.UpstreamServer) []types.UpstreamServer {
 	now := time.Now()
 	var available []types.UpstreamServer
 
 	lb.statusMutex.RLock()
 	defer lb.statusMutex.RUnlock()
 
-	for _, server := range lb.config.Servers {
+	for _, server := range servers {
 		if lb.isServerAvailable(server.URL, now) {
 			available = append(available, server)
 		}
@@ -208,7 +622,40 @@ func (lb *LoadBalancer) GetAvailableServers() []types.UpstreamServer {
 // isServerAvailable 统一的服务器可用性判断逻辑
 func (lb *LoadBalancer) isServerAvailable(url string, now time.Time) bool {
 	// 检查服务器状态和冷却时间
-	return lb.serverStatus[url] && now.After(lb.serverDownUntil[url])
+	return lb.serverStatus[url] && !lb.disabledServers[url] && now.After(lb.serverDownUntil[url])
+}
+
+// IsServerDisabled 判断服务器是否已被永久禁用
+func (lb *LoadBalancer) IsServerDisabled(url string) bool {
+	lb.statusMutex.RLock()
+	defer lb.statusMutex.RUnlock()
+	return lb.disabledServers[url]
+}
+
+// EnableServer 重新启用被禁用的服务器，并清零失败次数
+func (lb *LoadBalancer) EnableServer(url string) {
+	lb.statusMutex.Lock()
+	defer lb.statusMutex.Unlock()
+
+	if !lb.disabledServers[url] {
+		return
+	}
+	delete(lb.disabledServers, url)
+	delete(lb.downReasons, url)
+	lb.failureCount[url] = 0
+	lb.serverStatus[url] = true
+	lb.serverDownUntil[url] = time.Time{}
+	lb.availableSince[url] = time.Now()
+
+	logger.Success("LOAD", "Server re-enabled: %s", url)
+	lb.notifyStateChange(url, true)
+}
+
+// IsServerAvailable 判断服务器当前是否可用（加锁读取最新状态）
+func (lb *LoadBalancer) IsServerAvailable(url string) bool {
+	lb.statusMutex.RLock()
+	defer lb.statusMutex.RUnlock()
+	return lb.isServerAvailable(url, time.Now())
 }
 
 // GetServerStatus 获取服务器状态
@@ -230,17 +677,77 @@ func (lb *LoadBalancer) GetServerDownUntil(url string) time.Time {
 	return lb.serverDownUntil[url]
 }
 
+// DescribeRouting 返回负载均衡的路由计划：服务器按轮询顺序排列，附带生效的权重和预期的流量占比
+// 加权算法按权重分配流量，其他算法平均分配；weighted_balance 的占比由实时余额决定，不计算
+func (lb *LoadBalancer) DescribeRouting() RoutingPlan {
+	plan := RoutingPlan{
+		Mode:             "load_balance",
+		Algorithm:        lb.config.Algorithm,
+		Servers:          make([]RoutingEntry, len(lb.config.Servers)),
+		OverflowServers:  configRoutingEntries(lb.config.OverflowServers),
+		EmergencyServers: configRoutingEntries(lb.config.EmergencyServers),
+	}
+	for i := range plan.OverflowServers {
+		plan.OverflowServers[i].MaxConcurrent = lb.config.OverflowServers[i].MaxConcurrent
+	}
+
+	// 金丝雀只接收 canary_percent 的流量，其余流量在其他服务器间分配
+	canaryShare := 0.0
+	totalWeight, others := 0, 0
+	for i, server := range lb.config.Servers {
+		entry := newRoutingEntry(i+1, server)
+		entry.MaxConcurrent = server.MaxConcurrent
+		entry.Canary = lb.config.Canary != "" && server.URL == lb.config.Canary
+		if entry.Canary {
+			canaryShare = lb.config.CanaryPercent / 100
+			entry.Share = roundShare(canaryShare)
+		} else {
+			totalWeight += entry.Weight
+			others++
+		}
+		plan.Servers[i] = entry
+	}
+
+	for i := range plan.Servers {
+		entry := &plan.Servers[i]
+		if entry.Canary {
+			continue
+		}
+		switch lb.config.Algorithm {
+		case "weighted_balance":
+			// 按实时余额加权，占比由运行时状态决定
+		case "weighted_round_robin", "weighted_least_connections":
+			entry.Share = roundShare((1 - canaryShare) * float64(entry.Weight) / float64(totalWeight))
+		default:
+			entry.Share = roundShare((1 - canaryShare) / float64(others))
+		}
+	}
+	return plan
+}
+
 // RecoverServer 恢复服务器
 func (lb *LoadBalancer) RecoverServer(url string) {
 	lb.statusMutex.Lock()
 	defer lb.statusMutex.Unlock()
 
+	// 被禁用的服务器只能通过 EnableServer 恢复
+	if lb.disabledServers[url] {
+		return
+	}
+
+	wasUp := lb.serverStatus[url]
 	lb.serverStatus[url] = true
+	delete(lb.downReasons, url)
 
 	// 清除冷却时间
 	lb.serverDownUntil[url] = time.Time{}
 
 	logger.Success("LOAD", "Server recovered: %s", url)
+
+	if !wasUp {
+		lb.availableSince[url] = time.Now()
+		lb.notifyStateChange(url, true)
+	}
 }
 
 // MarkServerHealthy 标记服务器为健康
@@ -248,6 +755,16 @@ func (lb *LoadBalancer) MarkServerHealthy(url string) {
 	lb.statusMutex.Lock()
 	defer lb.statusMutex.Unlock()
 
+	// 禁用前已发出的请求成功时不自动恢复
+	if lb.disabledServers[url] {
+		return
+	}
+
+	// 余额不足、手动下线的服务器只能由余额查询或运维恢复，下线前已发出的请求成功时不恢复
+	if !lb.downReasons[url].IsFailure() {
+		return
+	}
+
 	// 重置失败计数
 	if lb.failureCount[url] > 0 {
 		oldFailures := lb.failureCount[url]
@@ -258,8 +775,25 @@ func (lb *LoadBalancer) MarkServerHealthy(url string) {
 	// 确保服务器状态为可用
 	if !lb.serverStatus[url] {
 		lb.serverStatus[url] = true
+		delete(lb.downReasons, url)
 		// 清除冷却时间
 		lb.serverDownUntil[url] = time.Time{}
+		lb.availableSince[url] = time.Now()
 		logger.Success("LOAD", "Server %s auto-recovered from healthy request", url)
+		lb.notifyStateChange(url, true)
+	}
+}
+
+// SetStateListener 设置服务器状态变化监听器
+func (lb *LoadBalancer) SetStateListener(listener StateListener) {
+	lb.statusMutex.Lock()
+	defer lb.statusMutex.Unlock()
+	lb.stateListener = listener
+}
+
+// notifyStateChange 通知状态变化（调用方需持有 statusMutex）
+func (lb *LoadBalancer) notifyStateChange(url string, up bool) {
+	if lb.stateListener != nil {
+		lb.stateListener(url, up, lb.failureCount[url])
 	}
 }
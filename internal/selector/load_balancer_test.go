@@ -1,7 +1,10 @@
 package selector
 
 import (
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"claude-code-lb/internal/testutil"
 	"claude-code-lb/pkg/types"
@@ -111,6 +114,155 @@ func TestLoadBalancerMarkServerDown(t *testing.T) {
 	if downUntil.IsZero() {
 		t.Error("DownUntil should be set for downed server")
 	}
+
+	if lb.IsServerAvailable(testutil.API1ExampleURL) {
+		t.Error("IsServerAvailable should be false for downed server")
+	}
+	if !lb.IsServerAvailable(testutil.API2ExampleURL) {
+		t.Error("IsServerAvailable should be true for healthy server")
+	}
+}
+
+func TestLoadBalancerMarkServerDownBackoff(t *testing.T) {
+	disabled := false
+
+	tests := []struct {
+		name        string
+		backoff     *bool
+		minCooldown time.Duration
+		maxCooldown time.Duration
+	}{
+		{name: "backoff enabled", backoff: nil, minCooldown: 170 * time.Second, maxCooldown: 180 * time.Second},
+		{name: "backoff disabled", backoff: &disabled, minCooldown: 50 * time.Second, maxCooldown: 60 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := types.Config{
+				Algorithm:      "round_robin",
+				Cooldown:       60,
+				BackoffEnabled: tt.backoff,
+				Servers: []types.UpstreamServer{
+					{URL: testutil.API1ExampleURL, Token: testutil.TestToken1},
+				},
+			}
+
+			lb := NewLoadBalancer(config)
+			for i := 0; i < 3; i++ {
+				lb.MarkServerDown(testutil.API1ExampleURL)
+			}
+
+			if lb.failureCount[testutil.API1ExampleURL] != 3 {
+				t.Errorf("Expected failure count 3, got %d", lb.failureCount[testutil.API1ExampleURL])
+			}
+
+			remaining := time.Until(lb.GetServerDownUntil(testutil.API1ExampleURL))
+			if remaining < tt.minCooldown || remaining > tt.maxCooldown {
+				t.Errorf("Expected cooldown between %v and %v, got %v", tt.minCooldown, tt.maxCooldown, remaining)
+			}
+		})
+	}
+}
+
+func TestLoadBalancerWarmupGrace(t *testing.T) {
+	config := types.Config{
+		Algorithm:          "round_robin",
+		Cooldown:           60,
+		WarmupGraceSeconds: 30,
+		Servers: []types.UpstreamServer{
+			{URL: testutil.API1ExampleURL, Token: testutil.TestToken1},
+		},
+	}
+	lb := NewLoadBalancer(config)
+
+	// 恢复后立即再次失败：失败次数照常累计，但冷却时间不按失败次数退避
+	for i := 0; i < 3; i++ {
+		lb.MarkServerDown(testutil.API1ExampleURL)
+		lb.RecoverServer(testutil.API1ExampleURL)
+	}
+	lb.MarkServerDown(testutil.API1ExampleURL)
+	if lb.failureCount[testutil.API1ExampleURL] != 4 {
+		t.Errorf("Expected failure count 4, got %d", lb.failureCount[testutil.API1ExampleURL])
+	}
+	if remaining := time.Until(lb.GetServerDownUntil(testutil.API1ExampleURL)); remaining > 60*time.Second {
+		t.Errorf("Expected base cooldown within warm-up grace, got %v", remaining)
+	}
+
+	// 宽限期过后的失败恢复指数退避
+	lb.RecoverServer(testutil.API1ExampleURL)
+	lb.availableSince[testutil.API1ExampleURL] = time.Now().Add(-time.Minute)
+	lb.MarkServerDown(testutil.API1ExampleURL)
+	if remaining := time.Until(lb.GetServerDownUntil(testutil.API1ExampleURL)); remaining < 290*time.Second {
+		t.Errorf("Expected escalated cooldown after warm-up grace, got %v", remaining)
+	}
+}
+
+func TestLoadBalancerMarkServerDownFor(t *testing.T) {
+	config := types.Config{
+		Algorithm: "round_robin",
+		Cooldown:  60,
+		Servers: []types.UpstreamServer{
+			{URL: testutil.API1ExampleURL, Token: testutil.TestToken1},
+		},
+	}
+
+	lb := NewLoadBalancer(config)
+
+	// 指定的冷却时间覆盖计算出的退避时间
+	lb.MarkServerDownFor(testutil.API1ExampleURL, 5*time.Second)
+	remaining := time.Until(lb.GetServerDownUntil(testutil.API1ExampleURL))
+	if remaining <= 4*time.Second || remaining > 5*time.Second {
+		t.Errorf("Expected cooldown of about 5s, got %v", remaining)
+	}
+	if lb.GetServerStatus()[testutil.API1ExampleURL] {
+		t.Error("Server should be marked as down")
+	}
+
+	// 非正数时退回到计算的退避时间（第二次失败：120s）
+	lb.MarkServerDownFor(testutil.API1ExampleURL, 0)
+	remaining = time.Until(lb.GetServerDownUntil(testutil.API1ExampleURL))
+	if remaining <= 110*time.Second || remaining > 120*time.Second {
+		t.Errorf("Expected computed backoff of about 120s, got %v", remaining)
+	}
+}
+
+func TestLoadBalancerDownReason(t *testing.T) {
+	config := types.Config{
+		Algorithm: "round_robin",
+		Cooldown:  60,
+		Servers: []types.UpstreamServer{
+			{URL: testutil.API1ExampleURL, Token: testutil.TestToken1},
+			{URL: testutil.API2ExampleURL, Token: testutil.TestToken2},
+		},
+	}
+
+	lb := NewLoadBalancer(config)
+
+	lb.MarkServerDown(testutil.API1ExampleURL)
+	if reason := lb.GetDownReason(testutil.API1ExampleURL); reason != DownReasonFailure {
+		t.Errorf("Expected failure reason, got %q", reason)
+	}
+
+	// 请求失败类原因以最近一次为准
+	lb.MarkServerDownWithReasonFor(testutil.API1ExampleURL, DownReasonRateLimited, 5*time.Second)
+	if reason := lb.GetDownReason(testutil.API1ExampleURL); reason != DownReasonRateLimited {
+		t.Errorf("Expected rate_limited reason, got %q", reason)
+	}
+	if remaining := time.Until(lb.GetServerDownUntil(testutil.API1ExampleURL)); remaining > 5*time.Second {
+		t.Errorf("Expected cooldown of at most 5s, got %v", remaining)
+	}
+
+	// 余额不足不计入失败次数，且后续请求失败不会覆盖原因
+	lb.MarkServerDownWithReason(testutil.API2ExampleURL, DownReasonBalance)
+	lb.MarkServerDown(testutil.API2ExampleURL)
+	if reason := lb.GetDownReason(testutil.API2ExampleURL); reason != DownReasonBalance {
+		t.Errorf("Expected balance reason to be kept, got %q", reason)
+	}
+
+	lb.RecoverServer(testutil.API2ExampleURL)
+	if reason := lb.GetDownReason(testutil.API2ExampleURL); reason != "" {
+		t.Errorf("Expected reason to be cleared after recovery, got %q", reason)
+	}
 }
 
 func TestLoadBalancerMarkServerHealthy(t *testing.T) {
@@ -139,6 +291,16 @@ func TestLoadBalancerMarkServerHealthy(t *testing.T) {
 	if lb.failureCount[testutil.API1ExampleURL] != 0 {
 		t.Errorf("Failure count should be reset to 0, got %d", lb.failureCount[testutil.API1ExampleURL])
 	}
+
+	// 余额不足和手动下线的服务器不因在途请求成功而恢复
+	for _, reason := range []DownReason{DownReasonBalance, DownReasonManual} {
+		lb.MarkServerDownWithReason(testutil.API1ExampleURL, reason)
+		lb.MarkServerHealthy(testutil.API1ExampleURL)
+		if lb.IsServerAvailable(testutil.API1ExampleURL) || lb.GetDownReason(testutil.API1ExampleURL) != reason {
+			t.Errorf("Expected server down for %s to stay down after a healthy request", reason)
+		}
+		lb.RecoverServer(testutil.API1ExampleURL)
+	}
 }
 
 func TestLoadBalancerGetAvailableServers(t *testing.T) {
@@ -229,8 +391,78 @@ func TestLoadBalancerNoAvailableServers(t *testing.T) {
 	}
 }
 
+func TestLoadBalancerEmergencyServers(t *testing.T) {
+	config := types.Config{
+		Algorithm: "round_robin",
+		Cooldown:  60,
+		Servers: []types.UpstreamServer{
+			{URL: testutil.API1ExampleURL, Token: testutil.TestToken1},
+			{URL: testutil.API2ExampleURL, Token: testutil.TestToken2},
+		},
+		EmergencyServers: []types.UpstreamServer{
+			{URL: testutil.API3ExampleURL, Token: testutil.TestToken3},
+			{URL: testutil.TestServer1URL, Token: testutil.TestToken1},
+		},
+	}
+
+	lb := NewLoadBalancer(config)
+
+	// 主服务器可用时不使用应急服务器
+	for i := 0; i < 4; i++ {
+		server, err := lb.SelectServer()
+		if err != nil {
+			t.Fatalf("SelectServer failed: %v", err)
+		}
+		if server.URL == testutil.API3ExampleURL || server.URL == testutil.TestServer1URL {
+			t.Fatalf("Emergency server %s selected while primary servers are available", server.URL)
+		}
+	}
+
+	// 应急服务器不出现在可用服务器列表中
+	if available := len(lb.GetAvailableServers()); available != 2 {
+		t.Errorf("Expected 2 available servers, got %d", available)
+	}
+
+	// 所有主服务器不可用时按配置顺序使用应急服务器
+	lb.MarkServerDown(testutil.API1ExampleURL)
+	lb.MarkServerDown(testutil.API2ExampleURL)
+	server, err := lb.SelectServer()
+	if err != nil {
+		t.Fatalf("Expected emergency server, got error: %v", err)
+	}
+	if server.URL != testutil.API3ExampleURL {
+		t.Errorf("Expected first emergency server %s, got %s", testutil.API3ExampleURL, server.URL)
+	}
+
+	// 第一个应急服务器也失败时使用下一个
+	lb.MarkServerDown(testutil.API3ExampleURL)
+	server, err = lb.SelectServer()
+	if err != nil {
+		t.Fatalf("Expected emergency server, got error: %v", err)
+	}
+	if server.URL != testutil.TestServer1URL {
+		t.Errorf("Expected second emergency server %s, got %s", testutil.TestServer1URL, server.URL)
+	}
+
+	// 应急服务器全部不可用时返回错误
+	lb.MarkServerDown(testutil.TestServer1URL)
+	if _, err := lb.SelectServer(); err == nil {
+		t.Error("Expected error when primary and emergency servers are all unavailable")
+	}
+
+	// 主服务器恢复后重新优先使用主服务器
+	lb.RecoverServer(testutil.API1ExampleURL)
+	server, err = lb.SelectServer()
+	if err != nil {
+		t.Fatalf("SelectServer failed: %v", err)
+	}
+	if server.URL != testutil.API1ExampleURL {
+		t.Errorf("Expected recovered primary server %s, got %s", testutil.API1ExampleURL, server.URL)
+	}
+}
+
 func TestLoadBalancerAlgorithms(t *testing.T) {
-	algorithms := []string{"round_robin", "weighted_round_robin", "random"}
+	algorithms := []string{"round_robin", "weighted_round_robin", "random", "weighted_least_connections", "weighted_balance"}
 
 	for _, algorithm := range algorithms {
 		t.Run(algorithm, func(t *testing.T) {
@@ -258,6 +490,514 @@ func TestLoadBalancerAlgorithms(t *testing.T) {
 	}
 }
 
+func TestLoadBalancerWeightedFallbackToRoundRobin(t *testing.T) {
+	lb := NewLoadBalancer(types.Config{
+		Algorithm: "weighted_round_robin",
+		Servers: []types.UpstreamServer{
+			{URL: testutil.API1ExampleURL, Token: testutil.TestToken1, Weight: 1},
+			{URL: testutil.API2ExampleURL, Token: testutil.TestToken2, Weight: 2},
+		},
+	})
+
+	// 所有当前权重都远低于哨兵值，平滑加权轮询选不出服务器
+	lb.serverWeights[testutil.API1ExampleURL] = -1 << 40
+	lb.serverWeights[testutil.API2ExampleURL] = -1 << 40
+	if lb.getWeightedServer(lb.GetAvailableServers(), "") != nil {
+		t.Fatal("Expected weighted state to produce no selection")
+	}
+
+	counts := make(map[string]int)
+	for i := 0; i < 4; i++ {
+		server, err := lb.SelectServer()
+		if err != nil {
+			t.Fatalf("SelectServer() unexpected error: %v", err)
+		}
+		counts[server.URL]++
+	}
+	if counts[testutil.API1ExampleURL] != 2 || counts[testutil.API2ExampleURL] != 2 {
+		t.Errorf("Expected round_robin fallback to split 2/2, got %v", counts)
+	}
+}
+
+// sequenceSource 按顺序返回预设值的确定性随机数来源
+type sequenceSource struct {
+	values []int
+	next   int
+}
+
+func (s *sequenceSource) Intn(n int) int {
+	value := s.values[s.next%len(s.values)]
+	s.next++
+	return value
+}
+
+func TestLoadBalancerRandomSource(t *testing.T) {
+	servers := []types.UpstreamServer{
+		{URL: testutil.API1ExampleURL, Token: testutil.TestToken1},
+		{URL: testutil.API2ExampleURL, Token: testutil.TestToken2},
+		{URL: testutil.API3ExampleURL, Token: testutil.TestToken3},
+	}
+	lb := NewLoadBalancer(types.Config{Algorithm: "random", Servers: servers})
+	lb.SetRandomSource(&sequenceSource{values: []int{2, 0, 0, 1}})
+
+	expected := []string{testutil.API3ExampleURL, testutil.API1ExampleURL, testutil.API1ExampleURL, testutil.API2ExampleURL}
+	for i, want := range expected {
+		server, err := lb.SelectServer()
+		if err != nil {
+			t.Fatalf("SelectServer() unexpected error: %v", err)
+		}
+		if server.URL != want {
+			t.Errorf("Selection %d: expected %s, got %s", i, want, server.URL)
+		}
+	}
+
+	// 来源返回越界的值时回退到轮询
+	lb.SetRandomSource(&sequenceSource{values: []int{-1}})
+	if server, err := lb.SelectServer(); err != nil || server == nil {
+		t.Errorf("Expected round_robin fallback, got %v, %v", server, err)
+	}
+
+	// nil 恢复默认来源；random_source 为 math 时使用 math/rand
+	lb.SetRandomSource(nil)
+	if _, ok := lb.randomSource.(cryptoRandomSource); !ok {
+		t.Errorf("Expected crypto source after reset, got %T", lb.randomSource)
+	}
+	mathLB := NewLoadBalancer(types.Config{Algorithm: "random", RandomSource: "math", Servers: servers})
+	if _, ok := mathLB.randomSource.(mathRandomSource); !ok {
+		t.Errorf("Expected math source, got %T", mathLB.randomSource)
+	}
+	for i := 0; i < 10; i++ {
+		if _, err := mathLB.SelectServer(); err != nil {
+			t.Fatalf("SelectServer() unexpected error: %v", err)
+		}
+	}
+}
+
+func TestLoadBalancerAlgorithmOverride(t *testing.T) {
+	lb := NewLoadBalancer(types.Config{
+		Algorithm: "round_robin",
+		Servers: []types.UpstreamServer{
+			{URL: testutil.API1ExampleURL, Token: testutil.TestToken1, Weight: 3},
+			{URL: testutil.API2ExampleURL, Token: testutil.TestToken2, Weight: 1},
+		},
+	})
+
+	counts := make(map[string]int)
+	for i := 0; i < 8; i++ {
+		server, err := lb.SelectServerWithOptions(SelectOptions{Algorithm: "weighted_round_robin"})
+		if err != nil {
+			t.Fatalf("SelectServerWithOptions() unexpected error: %v", err)
+		}
+		counts[server.URL]++
+	}
+	if counts[testutil.API1ExampleURL] != 6 || counts[testutil.API2ExampleURL] != 2 {
+		t.Errorf("Expected weighted override to split 6/2, got %v", counts)
+	}
+
+	// 空算法使用配置的算法
+	clear(counts)
+	for i := 0; i < 8; i++ {
+		server, _ := lb.SelectServerWithOptions(SelectOptions{})
+		counts[server.URL]++
+	}
+	if counts[testutil.API1ExampleURL] != 4 || counts[testutil.API2ExampleURL] != 4 {
+		t.Errorf("Expected configured round_robin to split 4/4, got %v", counts)
+	}
+}
+
+func TestLoadBalancerRegion(t *testing.T) {
+	lb := NewLoadBalancer(types.Config{
+		Algorithm: "round_robin",
+		Cooldown:  60,
+		Servers: []types.UpstreamServer{
+			{URL: testutil.API1ExampleURL, Token: testutil.TestToken1, Region: "us-east"},
+			{URL: testutil.API2ExampleURL, Token: testutil.TestToken2, Region: "eu-west"},
+			{URL: testutil.API3ExampleURL, Token: testutil.TestToken3, Region: "EU-West"},
+		},
+	})
+
+	// 优先选择同区域的服务器（区域不区分大小写）
+	for i := 0; i < 4; i++ {
+		server, err := lb.SelectServerWithOptions(SelectOptions{Region: "eu-west"})
+		if err != nil {
+			t.Fatalf("SelectServerWithOptions() unexpected error: %v", err)
+		}
+		if server.URL == testutil.API1ExampleURL {
+			t.Fatalf("Expected a server in eu-west, got %s", server.URL)
+		}
+	}
+
+	// 区域内服务器全部不可用时使用其他区域
+	lb.MarkServerDown(testutil.API2ExampleURL)
+	lb.MarkServerDown(testutil.API3ExampleURL)
+	server, err := lb.SelectServerWithOptions(SelectOptions{Region: "eu-west"})
+	if err != nil {
+		t.Fatalf("SelectServerWithOptions() unexpected error: %v", err)
+	}
+	if server.URL != testutil.API1ExampleURL {
+		t.Errorf("Expected cross-region fallback to %s, got %s", testutil.API1ExampleURL, server.URL)
+	}
+}
+
+func TestLoadBalancerCanary(t *testing.T) {
+	lb := NewLoadBalancer(types.Config{
+		Algorithm:     "round_robin",
+		Cooldown:      60,
+		RandomSource:  "math",
+		Canary:        testutil.API3ExampleURL,
+		CanaryPercent: 10,
+		Servers: []types.UpstreamServer{
+			{URL: testutil.API1ExampleURL, Token: testutil.TestToken1},
+			{URL: testutil.API2ExampleURL, Token: testutil.TestToken2},
+			{URL: testutil.API3ExampleURL, Token: testutil.TestToken3},
+		},
+	})
+
+	// 约 10% 的请求发往金丝雀，其余请求在其他服务器间轮询
+	const total = 10000
+	counts := make(map[string]int)
+	for i := 0; i < total; i++ {
+		server, err := lb.SelectServer()
+		if err != nil {
+			t.Fatalf("SelectServer() unexpected error: %v", err)
+		}
+		counts[server.URL]++
+	}
+	if canary := counts[testutil.API3ExampleURL]; canary < total*8/100 || canary > total*12/100 {
+		t.Errorf("Expected about 10%% of requests on canary, got %d/%d", canary, total)
+	}
+	if diff := counts[testutil.API1ExampleURL] - counts[testutil.API2ExampleURL]; diff < -1 || diff > 1 {
+		t.Errorf("Expected remaining requests split evenly, got %v", counts)
+	}
+
+	// 金丝雀不可用时所有请求正常选择
+	lb.MarkServerDown(testutil.API3ExampleURL)
+	for i := 0; i < 100; i++ {
+		if server, _ := lb.SelectServer(); server.URL == testutil.API3ExampleURL {
+			t.Fatal("Expected canary in cooldown not to be selected")
+		}
+	}
+
+	// 只剩金丝雀可用时使用金丝雀
+	lb.RecoverServer(testutil.API3ExampleURL)
+	lb.MarkServerDown(testutil.API1ExampleURL)
+	lb.MarkServerDown(testutil.API2ExampleURL)
+	server, err := lb.SelectServer()
+	if err != nil || server.URL != testutil.API3ExampleURL {
+		t.Errorf("Expected canary as the only available server, got %v, %v", server, err)
+	}
+}
+
+func TestLoadBalancerOverflow(t *testing.T) {
+	lb := NewLoadBalancer(types.Config{
+		Algorithm: "weighted_round_robin",
+		Cooldown:  60,
+		Servers: []types.UpstreamServer{
+			{URL: testutil.API1ExampleURL, Token: testutil.TestToken1, Weight: 3, MaxConcurrent: 2},
+			{URL: testutil.API2ExampleURL, Token: testutil.TestToken2, Weight: 1, MaxConcurrent: 1},
+		},
+		OverflowServers: []types.UpstreamServer{
+			{URL: testutil.API3ExampleURL, Token: testutil.TestToken3, Weight: 1},
+		},
+	})
+
+	// 占满主服务器的并发上限（持有连接不释放），饱和前不使用溢出服务器
+	for i := 0; i < 3; i++ {
+		server, err := lb.SelectServer()
+		if err != nil {
+			t.Fatalf("SelectServer() unexpected error: %v", err)
+		}
+		if server.URL == testutil.API3ExampleURL {
+			t.Fatalf("Expected primary server before saturation, got overflow on request %d", i+1)
+		}
+		lb.AcquireConnection(server.URL)
+	}
+	if got := lb.GetActiveConnections(testutil.API1ExampleURL); got != 2 {
+		t.Fatalf("Expected 2 connections on %s, got %d", testutil.API1ExampleURL, got)
+	}
+
+	// 所有主服务器都饱和时溢出
+	server, err := lb.SelectServer()
+	if err != nil || server.URL != testutil.API3ExampleURL {
+		t.Fatalf("Expected overflow server when primaries are saturated, got %v, %v", server, err)
+	}
+
+	// 主服务器释放连接后不再使用溢出服务器
+	lb.ReleaseConnection(testutil.API2ExampleURL)
+	for i := 0; i < 3; i++ {
+		if server, _ := lb.SelectServer(); server.URL != testutil.API2ExampleURL {
+			t.Fatalf("Expected freed primary %s, got %s", testutil.API2ExampleURL, server.URL)
+		}
+	}
+
+	// 主服务器不可用时同样使用溢出服务器
+	lb.MarkServerDown(testutil.API1ExampleURL)
+	lb.MarkServerDown(testutil.API2ExampleURL)
+	if server, _ := lb.SelectServer(); server.URL != testutil.API3ExampleURL {
+		t.Errorf("Expected overflow server when primaries are down, got %s", server.URL)
+	}
+
+	// 溢出服务器也不可用时，饱和的主服务器继续接收请求
+	lb.RecoverServer(testutil.API1ExampleURL)
+	lb.MarkServerDown(testutil.API3ExampleURL)
+	if server, _ := lb.SelectServer(); server.URL != testutil.API1ExampleURL {
+		t.Errorf("Expected saturated primary without available overflow, got %s", server.URL)
+	}
+}
+
+func TestLoadBalancerSelectAndAcquireRespectsMaxConcurrent(t *testing.T) {
+	lb := NewLoadBalancer(types.Config{
+		Algorithm: "round_robin",
+		Servers: []types.UpstreamServer{
+			{URL: testutil.API1ExampleURL, Token: testutil.TestToken1, MaxConcurrent: 2},
+			{URL: testutil.API2ExampleURL, Token: testutil.TestToken2, MaxConcurrent: 3},
+		},
+		OverflowServers: []types.UpstreamServer{
+			{URL: testutil.API3ExampleURL, Token: testutil.TestToken3},
+		},
+	})
+	limits := map[string]int64{testutil.API1ExampleURL: 2, testutil.API2ExampleURL: 3}
+
+	// 并发选择并持有连接，主服务器的在途连接数任何时候都不应超过 max_concurrent
+	var exceeded atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				server, err := lb.SelectAndAcquire(SelectOptions{})
+				if err != nil {
+					t.Errorf("SelectAndAcquire() unexpected error: %v", err)
+					return
+				}
+				if limit, ok := limits[server.URL]; ok && lb.GetActiveConnections(server.URL) > limit {
+					exceeded.Add(1)
+				}
+				time.Sleep(10 * time.Microsecond)
+				lb.ReleaseConnection(server.URL)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := exceeded.Load(); n > 0 {
+		t.Errorf("Expected max_concurrent never to be exceeded, exceeded %d times", n)
+	}
+	for _, url := range []string{testutil.API1ExampleURL, testutil.API2ExampleURL, testutil.API3ExampleURL} {
+		if conns := lb.GetActiveConnections(url); conns != 0 {
+			t.Errorf("Expected all connections released on %s, got %d", url, conns)
+		}
+	}
+}
+
+func TestLoadBalancerWeightedLeastConnections(t *testing.T) {
+	config := types.Config{
+		Algorithm: "weighted_least_connections",
+		Servers: []types.UpstreamServer{
+			{URL: testutil.API1ExampleURL, Token: testutil.TestToken1, Weight: 3},
+			{URL: testutil.API2ExampleURL, Token: "token2", Weight: 1},
+		},
+	}
+
+	lb := NewLoadBalancer(config)
+
+	// 持有所有连接不释放，权重3的服务器应承载约3倍的连接
+	counts := make(map[string]int)
+	for i := 0; i < 8; i++ {
+		server, err := lb.SelectServer()
+		if err != nil {
+			t.Fatalf("SelectServer failed: %v", err)
+		}
+		lb.AcquireConnection(server.URL)
+		counts[server.URL]++
+	}
+
+	if counts[testutil.API1ExampleURL] != 6 || counts[testutil.API2ExampleURL] != 2 {
+		t.Errorf("Expected 6/2 connection split, got %d/%d",
+			counts[testutil.API1ExampleURL], counts[testutil.API2ExampleURL])
+	}
+	if lb.GetActiveConnections(testutil.API1ExampleURL) != 6 {
+		t.Errorf("Expected 6 active connections on api1, got %d", lb.GetActiveConnections(testutil.API1ExampleURL))
+	}
+
+	// 释放权重3服务器的连接后，流量应重新回到它上面
+	for i := 0; i < 6; i++ {
+		lb.ReleaseConnection(testutil.API1ExampleURL)
+	}
+	server, err := lb.SelectServer()
+	if err != nil {
+		t.Fatalf("SelectServer failed: %v", err)
+	}
+	if server.URL != testutil.API1ExampleURL {
+		t.Errorf("Expected traffic to rebalance to api1, got %s", server.URL)
+	}
+
+	// 释放次数超过获取次数时不应变为负数
+	lb.ReleaseConnection(testutil.API1ExampleURL)
+	if lb.GetActiveConnections(testutil.API1ExampleURL) != 0 {
+		t.Errorf("Expected active connections to stay at 0, got %d", lb.GetActiveConnections(testutil.API1ExampleURL))
+	}
+}
+
+func TestLoadBalancerModelWeights(t *testing.T) {
+	servers := []types.UpstreamServer{
+		{URL: testutil.API1ExampleURL, Token: testutil.TestToken1, Weight: 1, ModelWeights: map[string]int{"claude-3-haiku": 5}},
+		{URL: testutil.API2ExampleURL, Token: testutil.TestToken2, Weight: 1, ModelWeights: map[string]int{"claude-3-opus": 3}},
+	}
+
+	tests := []struct {
+		name      string
+		algorithm string
+		model     string
+		expected  map[string]int // 每 12 个请求的分布
+	}{
+		{name: "haiku prefers api1", algorithm: "weighted_round_robin", model: "claude-3-haiku", expected: map[string]int{testutil.API1ExampleURL: 10, testutil.API2ExampleURL: 2}},
+		{name: "opus prefers api2", algorithm: "weighted_round_robin", model: "claude-3-opus", expected: map[string]int{testutil.API1ExampleURL: 3, testutil.API2ExampleURL: 9}},
+		{name: "unlisted model uses base weight", algorithm: "weighted_round_robin", model: "claude-3-sonnet", expected: map[string]int{testutil.API1ExampleURL: 6, testutil.API2ExampleURL: 6}},
+		{name: "no model uses base weight", algorithm: "weighted_round_robin", expected: map[string]int{testutil.API1ExampleURL: 6, testutil.API2ExampleURL: 6}},
+		{name: "least connections haiku", algorithm: "weighted_least_connections", model: "claude-3-haiku", expected: map[string]int{testutil.API1ExampleURL: 10, testutil.API2ExampleURL: 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lb := NewLoadBalancer(types.Config{Algorithm: tt.algorithm, Servers: servers})
+
+			counts := make(map[string]int)
+			for i := 0; i < 12; i++ {
+				server, err := lb.SelectServerWithOptions(SelectOptions{Model: tt.model})
+				if err != nil {
+					t.Fatalf("SelectServerWithOptions failed: %v", err)
+				}
+				// 持有连接不释放，使加权最少连接算法按权重分配
+				lb.AcquireConnection(server.URL)
+				counts[server.URL]++
+			}
+
+			for url, expected := range tt.expected {
+				if counts[url] != expected {
+					t.Errorf("Expected %d requests on %s, got %v", expected, url, counts)
+				}
+			}
+		})
+	}
+
+	// 不同模型的轮询状态相互独立，交替请求时各自的分布不受影响
+	lb := NewLoadBalancer(types.Config{Algorithm: "weighted_round_robin", Servers: servers})
+	haiku := make(map[string]int)
+	for i := 0; i < 12; i++ {
+		server, _ := lb.SelectServerWithOptions(SelectOptions{Model: "claude-3-haiku"})
+		haiku[server.URL]++
+		lb.SelectServerWithOptions(SelectOptions{Model: "claude-3-opus"})
+	}
+	if haiku[testutil.API1ExampleURL] != 10 {
+		t.Errorf("Expected interleaved haiku requests to keep 10/2 split, got %v", haiku)
+	}
+}
+
+// staticBalanceProvider 返回固定余额的测试数据源
+type staticBalanceProvider map[string]float64
+
+func (p staticBalanceProvider) LatestBalance(url string) (float64, bool) {
+	balance, ok := p[url]
+	return balance, ok
+}
+
+func TestLoadBalancerWeightedBalance(t *testing.T) {
+	config := types.Config{
+		Algorithm: "weighted_balance",
+		Servers: []types.UpstreamServer{
+			{URL: testutil.API1ExampleURL, Token: testutil.TestToken1, Weight: 1},
+			{URL: testutil.API2ExampleURL, Token: testutil.TestToken2, Weight: 1},
+			{URL: testutil.API3ExampleURL, Token: "token3", Weight: 100},
+		},
+	}
+
+	lb := NewLoadBalancer(config)
+
+	selectN := func(n int) map[string]int {
+		counts := make(map[string]int)
+		for i := 0; i < n; i++ {
+			server, err := lb.SelectServer()
+			if err != nil {
+				t.Fatalf("SelectServer failed: %v", err)
+			}
+			counts[server.URL]++
+		}
+		return counts
+	}
+
+	// 没有余额数据时使用静态权重 1:1:100
+	counts := selectN(102)
+	if counts[testutil.API1ExampleURL] != 1 || counts[testutil.API2ExampleURL] != 1 || counts[testutil.API3ExampleURL] != 100 {
+		t.Errorf("Expected static weight distribution 1/1/100, got %v", counts)
+	}
+
+	// 余额 300:100，余额未知的服务器使用静态权重 100，分布为 3:1:1
+	lb.SetBalanceProvider(staticBalanceProvider{
+		testutil.API1ExampleURL: 300,
+		testutil.API2ExampleURL: 100,
+	})
+	counts = selectN(50)
+	if counts[testutil.API1ExampleURL] != 30 || counts[testutil.API2ExampleURL] != 10 || counts[testutil.API3ExampleURL] != 10 {
+		t.Errorf("Expected balance-weighted distribution 30/10/10, got %v", counts)
+	}
+
+	// 余额为 0（或负数）的服务器不再分配流量
+	lb.SetBalanceProvider(staticBalanceProvider{
+		testutil.API1ExampleURL: 0,
+		testutil.API2ExampleURL: -5,
+	})
+	counts = selectN(10)
+	if counts[testutil.API3ExampleURL] != 10 {
+		t.Errorf("Expected all traffic on the server with credit, got %v", counts)
+	}
+}
+
+func TestLoadBalancerStateListener(t *testing.T) {
+	config := types.Config{
+		Algorithm: "round_robin",
+		Cooldown:  60,
+		Servers: []types.UpstreamServer{
+			{URL: testutil.API1ExampleURL, Token: testutil.TestToken1},
+		},
+	}
+
+	lb := NewLoadBalancer(config)
+
+	type transition struct {
+		up       bool
+		failures int64
+	}
+	var transitions []transition
+	lb.SetStateListener(func(url string, up bool, failures int64) {
+		transitions = append(transitions, transition{up: up, failures: failures})
+	})
+
+	lb.MarkServerDown(testutil.API1ExampleURL)
+	lb.MarkServerDown(testutil.API1ExampleURL) // 已经是 down，不是状态变化
+	lb.RecoverServer(testutil.API1ExampleURL)
+	lb.RecoverServer(testutil.API1ExampleURL) // 已经是 up，不是状态变化
+	lb.MarkServerDown(testutil.API1ExampleURL)
+	lb.MarkServerHealthy(testutil.API1ExampleURL)
+	lb.MarkServerHealthy(testutil.API1ExampleURL)
+
+	expected := []transition{
+		{up: false, failures: 1},
+		{up: true, failures: 2},
+		{up: false, failures: 3},
+		{up: true, failures: 0},
+	}
+	if len(transitions) != len(expected) {
+		t.Fatalf("Expected %d transitions, got %d: %+v", len(expected), len(transitions), transitions)
+	}
+	for i := range expected {
+		if transitions[i] != expected[i] {
+			t.Errorf("Transition %d = %+v, want %+v", i, transitions[i], expected[i])
+		}
+	}
+}
+
 func TestLoadBalancerConcurrency(t *testing.T) {
 	config := types.Config{
 		Algorithm: "round_robin",
@@ -301,3 +1041,56 @@ func TestLoadBalancerConcurrency(t *testing.T) {
 
 	// If we get here without hanging or panicking, the test passes
 }
+
+func TestLoadBalancerMaxFailures(t *testing.T) {
+	config := types.Config{
+		Mode:        "load_balance",
+		Algorithm:   "round_robin",
+		Cooldown:    60,
+		MaxFailures: 2,
+		Servers: []types.UpstreamServer{
+			{URL: testutil.API1ExampleURL, Token: testutil.TestToken1, Priority: 1},
+			{URL: testutil.API2ExampleURL, Token: "token2", Priority: 2},
+		},
+	}
+
+	s := NewLoadBalancer(config)
+
+	// 未超过上限时只是临时冷却，可以被恢复
+	for i := 0; i < 2; i++ {
+		s.MarkServerDown(testutil.API1ExampleURL)
+	}
+	if s.IsServerDisabled(testutil.API1ExampleURL) {
+		t.Fatal("Server should not be disabled before exceeding max_failures")
+	}
+	s.RecoverServer(testutil.API1ExampleURL)
+	if !s.IsServerAvailable(testutil.API1ExampleURL) {
+		t.Fatal("Server should be recoverable before exceeding max_failures")
+	}
+
+	// 超过上限后永久禁用，恢复和成功请求都不会重新启用
+	s.MarkServerDown(testutil.API1ExampleURL)
+	if !s.IsServerDisabled(testutil.API1ExampleURL) {
+		t.Fatal("Server should be disabled after exceeding max_failures")
+	}
+	s.RecoverServer(testutil.API1ExampleURL)
+	s.MarkServerHealthy(testutil.API1ExampleURL)
+	if s.IsServerAvailable(testutil.API1ExampleURL) {
+		t.Error("Disabled server should stay unavailable")
+	}
+	for i := 0; i < 5; i++ {
+		server, err := s.SelectServer()
+		if err != nil {
+			t.Fatalf("SelectServer() unexpected error: %v", err)
+		}
+		if server.URL == testutil.API1ExampleURL {
+			t.Fatal("Disabled server should not be selected")
+		}
+	}
+
+	// 手动启用后恢复可用
+	s.EnableServer(testutil.API1ExampleURL)
+	if s.IsServerDisabled(testutil.API1ExampleURL) || !s.IsServerAvailable(testutil.API1ExampleURL) {
+		t.Error("Server should be available after EnableServer")
+	}
+}
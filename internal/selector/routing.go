@@ -0,0 +1,97 @@
+package selector
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"claude-code-lb/pkg/types"
+)
+
+// RoutingPlan 选择器解析配置后实际生效的路由计划（用于启动日志和 /debug/routing）
+type RoutingPlan struct {
+	Mode             string         `json:"mode"`
+	Algorithm        string         `json:"algorithm,omitempty"`         // 选择算法（仅负载均衡模式）
+	Servers          []RoutingEntry `json:"servers"`                     // 按生效的选择顺序排列
+	OverflowServers  []RoutingEntry `json:"overflow_servers,omitempty"`  // 所有服务器都饱和或不可用时使用（仅负载均衡模式）
+	EmergencyServers []RoutingEntry `json:"emergency_servers,omitempty"` // 所有服务器都不可用时按顺序使用
+}
+
+// RoutingEntry 路由计划中的单个服务器
+type RoutingEntry struct {
+	Order         int            `json:"order"`                    // 选择顺序（从 1 开始，fallback 模式同一优先级层级的服务器顺序相同）
+	URL           string         `json:"url"`                      // 服务器地址
+	Weight        int            `json:"weight"`                   // 生效的权重（未配置时为 1）
+	Priority      int            `json:"priority,omitempty"`       // 解析后的优先级（仅 fallback 模式）
+	Share         float64        `json:"share,omitempty"`          // 预期的流量占比（0-1）：负载均衡模式为全部流量中的占比，fallback 模式为所在层级内的占比；由运行时状态决定时为 0
+	Region        string         `json:"region,omitempty"`         // 服务器所在区域
+	MaxConcurrent int            `json:"max_concurrent,omitempty"` // 在途请求上限（仅负载均衡模式）
+	Canary        bool           `json:"canary,omitempty"`         // 是否为金丝雀服务器
+	ModelWeights  map[string]int `json:"model_weights,omitempty"`  // 按模型覆盖的权重
+}
+
+// String 返回单行的路由计划摘要，用于启动日志
+func (p RoutingPlan) String() string {
+	var b strings.Builder
+	b.WriteString("mode=" + p.Mode)
+	if p.Algorithm != "" {
+		b.WriteString(" algorithm=" + p.Algorithm)
+	}
+	for _, entry := range p.Servers {
+		fmt.Fprintf(&b, " | #%d %s weight=%d", entry.Order, entry.URL, entry.Weight)
+		if entry.Priority > 0 {
+			fmt.Fprintf(&b, " priority=%d", entry.Priority)
+		}
+		if entry.Share > 0 {
+			fmt.Fprintf(&b, " share=%.1f%%", entry.Share*100)
+		}
+		if entry.Region != "" {
+			b.WriteString(" region=" + entry.Region)
+		}
+		if entry.MaxConcurrent > 0 {
+			fmt.Fprintf(&b, " max_concurrent=%d", entry.MaxConcurrent)
+		}
+		if entry.Canary {
+			b.WriteString(" canary")
+		}
+	}
+	for _, entry := range p.OverflowServers {
+		fmt.Fprintf(&b, " | overflow #%d %s weight=%d", entry.Order, entry.URL, entry.Weight)
+	}
+	for _, entry := range p.EmergencyServers {
+		fmt.Fprintf(&b, " | emergency #%d %s", entry.Order, entry.URL)
+	}
+	return b.String()
+}
+
+// newRoutingEntry 根据服务器配置创建路由条目（权重未配置时为 1）
+func newRoutingEntry(order int, server types.UpstreamServer) RoutingEntry {
+	weight := server.Weight
+	if weight <= 0 {
+		weight = 1
+	}
+	return RoutingEntry{
+		Order:        order,
+		URL:          server.URL,
+		Weight:       weight,
+		Region:       server.Region,
+		ModelWeights: server.ModelWeights,
+	}
+}
+
+// configRoutingEntries 返回按配置顺序排列的路由条目（用于应急和溢出服务器）
+func configRoutingEntries(servers []types.UpstreamServer) []RoutingEntry {
+	if len(servers) == 0 {
+		return nil
+	}
+	entries := make([]RoutingEntry, len(servers))
+	for i, server := range servers {
+		entries[i] = newRoutingEntry(i+1, server)
+	}
+	return entries
+}
+
+// roundShare 将流量占比保留 4 位小数
+func roundShare(share float64) float64 {
+	return math.Round(share*10000) / 10000
+}
@@ -0,0 +1,112 @@
+package selector
+
+import (
+	"strings"
+	"testing"
+
+	"claude-code-lb/internal/testutil"
+	"claude-code-lb/pkg/types"
+)
+
+func TestLoadBalancerDescribeRouting(t *testing.T) {
+	servers := []types.UpstreamServer{
+		{URL: testutil.API1ExampleURL, Token: testutil.TestToken1, Weight: 3, Region: "us-east"},
+		{URL: testutil.API2ExampleURL, Token: testutil.TestToken2},
+		{URL: testutil.API3ExampleURL, Token: testutil.TestToken3, Weight: 2},
+	}
+
+	tests := []struct {
+		name      string
+		config    types.Config
+		wantShare []float64
+	}{
+		{
+			name:      "weighted shares follow weights",
+			config:    types.Config{Algorithm: "weighted_round_robin", Servers: servers},
+			wantShare: []float64{0.5, 0.1667, 0.3333},
+		},
+		{
+			name:      "round robin shares are equal",
+			config:    types.Config{Algorithm: "round_robin", Servers: servers},
+			wantShare: []float64{0.3333, 0.3333, 0.3333},
+		},
+		{
+			name:      "canary takes canary_percent",
+			config:    types.Config{Algorithm: "round_robin", Canary: testutil.API3ExampleURL, CanaryPercent: 10, Servers: servers},
+			wantShare: []float64{0.45, 0.45, 0.1},
+		},
+		{
+			name:      "weighted balance shares are dynamic",
+			config:    types.Config{Algorithm: "weighted_balance", Servers: servers},
+			wantShare: []float64{0, 0, 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := NewLoadBalancer(tt.config).DescribeRouting()
+			if plan.Mode != "load_balance" || plan.Algorithm != tt.config.Algorithm {
+				t.Errorf("Unexpected mode/algorithm: %s/%s", plan.Mode, plan.Algorithm)
+			}
+			if len(plan.Servers) != len(servers) {
+				t.Fatalf("Expected %d servers, got %d", len(servers), len(plan.Servers))
+			}
+			for i, entry := range plan.Servers {
+				if entry.Order != i+1 || entry.URL != servers[i].URL {
+					t.Errorf("Entry %d: expected #%d %s, got #%d %s", i, i+1, servers[i].URL, entry.Order, entry.URL)
+				}
+				if entry.Share != tt.wantShare[i] {
+					t.Errorf("Entry %d: expected share %v, got %v", i, tt.wantShare[i], entry.Share)
+				}
+			}
+			if plan.Servers[1].Weight != 1 {
+				t.Errorf("Expected unset weight to resolve to 1, got %d", plan.Servers[1].Weight)
+			}
+		})
+	}
+}
+
+func TestFallbackDescribeRouting(t *testing.T) {
+	fs := NewFallbackSelector(types.Config{
+		Mode: "fallback",
+		Servers: []types.UpstreamServer{
+			{URL: testutil.API3ExampleURL, Token: testutil.TestToken3},
+			{URL: testutil.API1ExampleURL, Token: testutil.TestToken1, Priority: 1, Weight: 3},
+			{URL: testutil.API2ExampleURL, Token: testutil.TestToken2, Priority: 1, Weight: 1},
+		},
+		EmergencyServers: []types.UpstreamServer{
+			{URL: testutil.AnthropicTestURL, Token: testutil.TestToken1},
+		},
+	})
+
+	plan := fs.DescribeRouting()
+	if plan.Mode != "fallback" || plan.Algorithm != "" {
+		t.Errorf("Unexpected mode/algorithm: %s/%s", plan.Mode, plan.Algorithm)
+	}
+
+	// 同优先级层级共享顺序并按权重分配，自动分配优先级的服务器排在其后
+	want := []RoutingEntry{
+		{Order: 1, URL: testutil.API1ExampleURL, Weight: 3, Priority: 1, Share: 0.75},
+		{Order: 1, URL: testutil.API2ExampleURL, Weight: 1, Priority: 1, Share: 0.25},
+		{Order: 2, URL: testutil.API3ExampleURL, Weight: 1, Priority: 2, Share: 1},
+	}
+	if len(plan.Servers) != len(want) {
+		t.Fatalf("Expected %d servers, got %+v", len(want), plan.Servers)
+	}
+	for i, entry := range plan.Servers {
+		if entry.Order != want[i].Order || entry.URL != want[i].URL || entry.Weight != want[i].Weight ||
+			entry.Priority != want[i].Priority || entry.Share != want[i].Share {
+			t.Errorf("Entry %d: expected %+v, got %+v", i, want[i], entry)
+		}
+	}
+	if len(plan.EmergencyServers) != 1 || plan.EmergencyServers[0].URL != testutil.AnthropicTestURL {
+		t.Errorf("Expected emergency server in plan, got %+v", plan.EmergencyServers)
+	}
+
+	summary := plan.String()
+	for _, part := range []string{"mode=fallback", "#1 " + testutil.API1ExampleURL + " weight=3 priority=1 share=75.0%", "emergency #1 " + testutil.AnthropicTestURL} {
+		if !strings.Contains(summary, part) {
+			t.Errorf("Expected summary to contain %q, got %s", part, summary)
+		}
+	}
+}
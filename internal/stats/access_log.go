@@ -0,0 +1,118 @@
+package stats
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"claude-code-lb/pkg/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 访问日志格式
+const (
+	AccessLogFormatText = "text" // 人类可读的单行日志（默认）
+	AccessLogFormatJSON = "json" // 每个请求一行 JSON，便于日志系统解析
+)
+
+// Gin 上下文中保存访问日志附加信息的键（由代理处理器写入）
+const (
+	contextKeyServer = "lb.server_url"
+	contextKeyModel  = "lb.model"
+	contextKeyUsage  = "lb.usage"
+)
+
+// requestIDHeader 客户端传入或代理生成的请求 ID 头
+const requestIDHeader = "X-Request-Id"
+
+// AccessRecord 结构化访问日志记录
+type AccessRecord struct {
+	Time                     string `json:"time"`
+	Method                   string `json:"method"`
+	Path                     string `json:"path"`
+	Status                   int    `json:"status"`
+	LatencyMs                int64  `json:"latency_ms"`
+	LatencyBucket            string `json:"latency_bucket"`
+	ClientIP                 string `json:"client_ip"`
+	RequestID                string `json:"request_id,omitempty"`
+	ServerURL                string `json:"server_url,omitempty"`
+	Model                    string `json:"model,omitempty"`
+	InputTokens              int    `json:"input_tokens,omitempty"`
+	OutputTokens             int    `json:"output_tokens,omitempty"`
+	CacheCreationInputTokens int    `json:"cache_creation_input_tokens,omitempty"`
+	CacheReadInputTokens     int    `json:"cache_read_input_tokens,omitempty"`
+}
+
+// SetRequestServer 记录本次请求最终转发到的服务器（多次尝试时以最后一次为准）
+func SetRequestServer(c *gin.Context, serverURL string) {
+	c.Set(contextKeyServer, serverURL)
+}
+
+// SetRequestUsage 记录本次请求的模型和 token 用量
+func SetRequestUsage(c *gin.Context, model string, usage types.ClaudeUsage) {
+	c.Set(contextKeyModel, model)
+	c.Set(contextKeyUsage, usage)
+}
+
+// newAccessRecord 根据请求上下文构造访问日志记录
+func newAccessRecord(c *gin.Context, start time.Time, path string, latency time.Duration, requestID string) AccessRecord {
+	record := AccessRecord{
+		Time:          start.Format(time.RFC3339Nano),
+		Method:        c.Request.Method,
+		Path:          path,
+		Status:        c.Writer.Status(),
+		LatencyMs:     latency.Milliseconds(),
+		LatencyBucket: latencyBucket(latency),
+		ClientIP:      c.ClientIP(),
+		RequestID:     requestID,
+		ServerURL:     c.GetString(contextKeyServer),
+		Model:         c.GetString(contextKeyModel),
+	}
+
+	if value, exists := c.Get(contextKeyUsage); exists {
+		if usage, ok := value.(types.ClaudeUsage); ok {
+			record.InputTokens = usage.InputTokens
+			record.OutputTokens = usage.OutputTokens
+			record.CacheCreationInputTokens = usage.CacheCreationInputTokens
+			record.CacheReadInputTokens = usage.CacheReadInputTokens
+		}
+	}
+
+	return record
+}
+
+// latencyBuckets 访问日志中的延迟分段上限（升序）
+var latencyBuckets = []struct {
+	limit time.Duration
+	label string
+}{
+	{100 * time.Millisecond, "<100ms"},
+	{500 * time.Millisecond, "100ms-500ms"},
+	{time.Second, "500ms-1s"},
+	{5 * time.Second, "1s-5s"},
+	{30 * time.Second, "5s-30s"},
+}
+
+// latencyBucket 返回延迟所属的分段，便于按延迟区间聚合日志
+func latencyBucket(latency time.Duration) string {
+	for _, bucket := range latencyBuckets {
+		if latency < bucket.limit {
+			return bucket.label
+		}
+	}
+	return ">=30s"
+}
+
+// requestID 返回客户端传入的请求 ID，未传入时生成一个随机 ID
+func requestID(c *gin.Context) string {
+	if id := c.GetHeader(requestIDHeader); id != "" {
+		return id
+	}
+
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,70 @@
+package stats
+
+import (
+	"time"
+
+	"claude-code-lb/internal/logger"
+)
+
+// IdleListener 空闲事件回调，idle 为距最后一次请求的时长
+type IdleListener func(idle time.Duration)
+
+// IdleMonitor 通过请求计数跟踪空闲时间，超过 timeout 没有新请求时触发一次空闲事件
+// 只负责通知（供外部自动扩缩容使用），不会停止服务；有新请求后重新计时，再次空闲时再次通知
+type IdleMonitor struct {
+	reporter   *Reporter
+	timeout    time.Duration
+	listener   IdleListener
+	now        func() time.Time
+	lastCount  int64
+	lastActive time.Time
+	notified   bool
+}
+
+// NewIdleMonitor 创建空闲监控器，从创建时开始计时；listener 可以为 nil（只记录日志）
+func NewIdleMonitor(reporter *Reporter, timeout time.Duration, listener IdleListener) *IdleMonitor {
+	m := &IdleMonitor{
+		reporter: reporter,
+		timeout:  timeout,
+		listener: listener,
+		now:      time.Now,
+	}
+	m.lastCount = reporter.RequestCount()
+	m.lastActive = m.now()
+	return m
+}
+
+// Start 定期检查是否空闲（检查间隔为 timeout 的 1/4，1 秒到 1 分钟之间）
+func (m *IdleMonitor) Start() {
+	interval := min(max(m.timeout/4, time.Second), time.Minute)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		m.check()
+	}
+}
+
+// check 检查请求计数是否变化，空闲超过 timeout 且尚未通知时触发空闲事件
+func (m *IdleMonitor) check() {
+	now := m.now()
+	count := m.reporter.RequestCount()
+
+	// 统计被清零时计数变小，清零后没有新请求不算活动
+	if count > m.lastCount || (count < m.lastCount && count > 0) {
+		m.lastActive = now
+		m.notified = false
+	}
+	m.lastCount = count
+
+	idle := now.Sub(m.lastActive)
+	if m.notified || idle < m.timeout {
+		return
+	}
+	m.notified = true
+
+	logger.Warning("IDLE", "No requests served for %v (idle_timeout: %v)", idle.Round(time.Second), m.timeout)
+	if m.listener != nil {
+		m.listener(idle)
+	}
+}
@@ -0,0 +1,86 @@
+package stats
+
+import (
+	"math"
+	"slices"
+)
+
+// DefaultLatencySampleSize 每个延迟样本窗口保留的最近请求数
+const DefaultLatencySampleSize = 1024
+
+// latencySamples 固定容量的环形缓冲区，保存最近的响应时间（毫秒）
+// 内存占用与请求量无关，分位数反映最近一段时间的延迟分布
+type latencySamples struct {
+	values []int64
+	next   int
+	full   bool
+}
+
+func newLatencySamples(size int) *latencySamples {
+	if size <= 0 {
+		size = DefaultLatencySampleSize
+	}
+	return &latencySamples{values: make([]int64, size)}
+}
+
+// add 写入一个样本，缓冲区满时覆盖最旧的样本
+func (s *latencySamples) add(value int64) {
+	s.values[s.next] = value
+	s.next++
+	if s.next == len(s.values) {
+		s.next = 0
+		s.full = true
+	}
+}
+
+// snapshot 返回当前样本的排序副本
+func (s *latencySamples) snapshot() []int64 {
+	count := s.next
+	if s.full {
+		count = len(s.values)
+	}
+	sorted := slices.Clone(s.values[:count])
+	slices.Sort(sorted)
+	return sorted
+}
+
+// DefaultEWMAAlpha 延迟指数加权移动平均的默认平滑系数
+const DefaultEWMAAlpha = 0.3
+
+// updateEWMA 用新样本更新指数加权移动平均，第一个样本直接作为初始值
+// alpha 越大，最近的样本权重越高；旧样本的影响按 (1-alpha)^n 衰减
+func updateEWMA(current float64, initialized bool, sample int64, alpha float64) float64 {
+	if !initialized {
+		return float64(sample)
+	}
+	return alpha*float64(sample) + (1-alpha)*current
+}
+
+// Percentiles 延迟分位数（毫秒）
+type Percentiles struct {
+	P50 int64 `json:"p50_ms"`
+	P95 int64 `json:"p95_ms"`
+	P99 int64 `json:"p99_ms"`
+}
+
+// percentiles 计算 p50/p95/p99，没有样本时返回零值
+func (s *latencySamples) percentiles() Percentiles {
+	sorted := s.snapshot()
+	return Percentiles{
+		P50: percentile(sorted, 50),
+		P95: percentile(sorted, 95),
+		P99: percentile(sorted, 99),
+	}
+}
+
+// percentile 使用最近秩（nearest-rank）法计算已排序样本的分位数
+func percentile(sorted []int64, p float64) int64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
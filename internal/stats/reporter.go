@@ -1,6 +1,9 @@
 package stats
 
 import (
+	"fmt"
+	"math"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -13,29 +16,135 @@ import (
 type Reporter struct {
 	requestCount         int64
 	errorCount           int64
+	successCount         int64
 	totalResponseTime    int64
 	requestCountByServer map[string]int64
 	responseTimeByServer map[string]int64
+	requestCountByModel  map[string]int64
+	latency              *latencySamples                  // 全局最近响应时间样本
+	latencyByServer      map[string]*latencySamples       // 每个服务器最近响应时间样本
+	ewmaByServer         map[string]float64               // 每个服务器响应时间的指数加权移动平均（毫秒）
+	ewmaAlpha            float64                          // 指数加权移动平均的平滑系数
+	accessLogFormat      string                           // 访问日志格式："text"（默认）或 "json"
+	balanceChecks        map[string]*BalanceCheckSnapshot // 每个服务器的余额查询统计
+	retryBudget          func() RetryBudgetSnapshot       // 重试预算状态来源（未配置时为 nil）
+	shadow               func() ShadowSnapshot            // 影子流量统计来源（未配置时为 nil）
+	since                time.Time                        // 统计开始时间（启动或最近一次重置）
 	mutex                sync.Mutex
 }
 
+// BalanceCheckSnapshot 单个服务器的余额查询统计
+type BalanceCheckSnapshot struct {
+	Balance   *float64 `json:"balance"` // 最近一次查询成功的余额，尚未成功时为 null
+	Successes int64    `json:"successes"`
+	Failures  int64    `json:"failures"`
+}
+
+// RetryBudgetSnapshot 重试预算的配置和当前消耗情况
+type RetryBudgetSnapshot struct {
+	PerSecond float64 `json:"per_second"` // 每秒补充的重试次数
+	Burst     int     `json:"burst"`      // 允许的突发次数
+	Available float64 `json:"available"`  // 当前剩余的重试次数
+	Retries   int64   `json:"retries"`    // 已放行的重试次数
+	Throttled int64   `json:"throttled"`  // 因预算耗尽被拒绝的重试次数
+}
+
+// ShadowSnapshot 影子流量的复制和比较结果
+type ShadowSnapshot struct {
+	Server              string  `json:"server"`                 // 影子服务器地址
+	Percent             float64 `json:"percent"`                // 复制比例（0-100）
+	Requests            int64   `json:"requests"`               // 已完成比较的复制请求数
+	Errors              int64   `json:"errors"`                 // 影子服务器连接失败或超时的次数
+	StatusMismatches    int64   `json:"status_mismatches"`      // 影子与主请求状态码不一致的次数
+	AvgLatencyMs        int64   `json:"avg_latency_ms"`         // 影子请求的平均延迟
+	AvgPrimaryLatencyMs int64   `json:"avg_primary_latency_ms"` // 对应主请求的平均延迟
+}
+
+// ServerSnapshot 单个服务器的统计快照
+type ServerSnapshot struct {
+	Requests           int64       `json:"requests"`
+	AvgResponseTimeMs  int64       `json:"avg_response_time_ms"`
+	Latency            Percentiles `json:"latency"`
+	EWMAResponseTimeMs float64     `json:"ewma_response_time_ms"` // 响应时间的指数加权移动平均
+}
+
+// Snapshot 统计快照（用于 /stats 端点）
+type Snapshot struct {
+	Since             time.Time                       `json:"since"` // 统计开始时间（启动或最近一次重置）
+	Requests          int64                           `json:"requests"`
+	Errors            int64                           `json:"errors"`
+	Successes         int64                           `json:"successes"`    // 状态码属于 success_status_codes 的响应数
+	SuccessRate       float64                         `json:"success_rate"` // 成功响应占全部请求的比例（0-1）
+	AvgResponseTimeMs int64                           `json:"avg_response_time_ms"`
+	Latency           Percentiles                     `json:"latency"`
+	Servers           map[string]ServerSnapshot       `json:"servers"`
+	Models            map[string]int64                `json:"models"`
+	BalanceChecks     map[string]BalanceCheckSnapshot `json:"balance_checks,omitempty"`
+	RetryBudget       *RetryBudgetSnapshot            `json:"retry_budget,omitempty"`
+	Shadow            *ShadowSnapshot                 `json:"shadow,omitempty"`
+}
+
 func New() *Reporter {
 	return &Reporter{
 		requestCountByServer: make(map[string]int64),
 		responseTimeByServer: make(map[string]int64),
+		requestCountByModel:  make(map[string]int64),
+		latency:              newLatencySamples(DefaultLatencySampleSize),
+		latencyByServer:      make(map[string]*latencySamples),
+		ewmaByServer:         make(map[string]float64),
+		ewmaAlpha:            DefaultEWMAAlpha,
+		balanceChecks:        make(map[string]*BalanceCheckSnapshot),
+		since:                time.Now(),
 	}
 }
 
+// Reset 清零请求计数、响应时间和按服务器/模型的统计，用于按固定时间窗口观察
+// 余额查询只清零成功/失败次数，保留最近一次查询到的余额
+func (r *Reporter) Reset() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	atomic.StoreInt64(&r.requestCount, 0)
+	atomic.StoreInt64(&r.errorCount, 0)
+	atomic.StoreInt64(&r.successCount, 0)
+	atomic.StoreInt64(&r.totalResponseTime, 0)
+	r.requestCountByServer = make(map[string]int64)
+	r.responseTimeByServer = make(map[string]int64)
+	r.requestCountByModel = make(map[string]int64)
+	r.latency = newLatencySamples(DefaultLatencySampleSize)
+	r.latencyByServer = make(map[string]*latencySamples)
+	r.ewmaByServer = make(map[string]float64)
+	for _, check := range r.balanceChecks {
+		check.Successes = 0
+		check.Failures = 0
+	}
+	r.since = time.Now()
+}
+
 func (r *Reporter) IncrementRequestCount() {
 	atomic.AddInt64(&r.requestCount, 1)
 }
 
+// RequestCount 返回当前统计窗口内的请求数
+func (r *Reporter) RequestCount() int64 {
+	return atomic.LoadInt64(&r.requestCount)
+}
+
 func (r *Reporter) IncrementErrorCount() {
 	atomic.AddInt64(&r.errorCount, 1)
 }
 
+// IncrementSuccessCount 记录一次成功响应（状态码属于 success_status_codes）
+func (r *Reporter) IncrementSuccessCount() {
+	atomic.AddInt64(&r.successCount, 1)
+}
+
 func (r *Reporter) AddResponseTime(responseTime int64) {
 	atomic.AddInt64(&r.totalResponseTime, responseTime)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.latency.add(responseTime)
 }
 
 func (r *Reporter) AddServerStats(serverURL string, responseTime int64) {
@@ -44,18 +153,206 @@ func (r *Reporter) AddServerStats(serverURL string, responseTime int64) {
 
 	r.requestCountByServer[serverURL]++
 	r.responseTimeByServer[serverURL] += responseTime
+
+	samples, exists := r.latencyByServer[serverURL]
+	if !exists {
+		samples = newLatencySamples(DefaultLatencySampleSize)
+		r.latencyByServer[serverURL] = samples
+	}
+	samples.add(responseTime)
+
+	current, initialized := r.ewmaByServer[serverURL]
+	r.ewmaByServer[serverURL] = updateEWMA(current, initialized, responseTime, r.ewmaAlpha)
 }
 
-func (r *Reporter) LogStats() {
+// GetServerEWMA 返回服务器响应时间的指数加权移动平均（毫秒），尚无请求时第二个返回值为 false
+// 与累计平均值不同，旧样本的影响逐渐衰减，适合作为按延迟选择服务器的输入
+func (r *Reporter) GetServerEWMA(serverURL string) (float64, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	value, exists := r.ewmaByServer[serverURL]
+	return value, exists
+}
+
+// AddModelStats 记录某个模型的成功请求数（模型名应已经过别名规范化）
+func (r *Reporter) AddModelStats(model string) {
+	if model == "" {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.requestCountByModel[model]++
+}
+
+// RecordBalanceCheck 记录一次余额查询结果（实现 balance.MetricsRecorder）
+// 查询失败时保留上一次成功查询的余额
+func (r *Reporter) RecordBalanceCheck(serverURL string, balance float64, success bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	check, exists := r.balanceChecks[serverURL]
+	if !exists {
+		check = &BalanceCheckSnapshot{}
+		r.balanceChecks[serverURL] = check
+	}
+
+	if success {
+		check.Successes++
+		check.Balance = &balance
+	} else {
+		check.Failures++
+	}
+}
+
+// SetRetryBudget 设置重试预算状态来源，/stats 中附带其配置和消耗情况
+func (r *Reporter) SetRetryBudget(source func() RetryBudgetSnapshot) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.retryBudget = source
+}
+
+// SetShadow 设置影子流量统计来源，/stats 中附带复制请求的比较结果
+func (r *Reporter) SetShadow(source func() ShadowSnapshot) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.shadow = source
+}
+
+// GetModelStats 返回每个模型的请求数副本
+func (r *Reporter) GetModelStats() map[string]int64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	result := make(map[string]int64, len(r.requestCountByModel))
+	for model, count := range r.requestCountByModel {
+		result[model] = count
+	}
+	return result
+}
+
+// Snapshot 返回当前统计快照
+func (r *Reporter) Snapshot() Snapshot {
 	totalRequests := atomic.LoadInt64(&r.requestCount)
-	totalErrors := atomic.LoadInt64(&r.errorCount)
-	avgResponseTime := int64(0)
+	snapshot := Snapshot{
+		Requests:  totalRequests,
+		Errors:    atomic.LoadInt64(&r.errorCount),
+		Successes: atomic.LoadInt64(&r.successCount),
+		Servers:   make(map[string]ServerSnapshot),
+		Models:    r.GetModelStats(),
+	}
 	if totalRequests > 0 {
-		avgResponseTime = atomic.LoadInt64(&r.totalResponseTime) / totalRequests
+		snapshot.AvgResponseTimeMs = atomic.LoadInt64(&r.totalResponseTime) / totalRequests
+		snapshot.SuccessRate = math.Round(float64(snapshot.Successes)/float64(totalRequests)*1000) / 1000
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	snapshot.Since = r.since
+	snapshot.Latency = r.latency.percentiles()
+	for serverURL, count := range r.requestCountByServer {
+		server := ServerSnapshot{Requests: count}
+		if count > 0 {
+			server.AvgResponseTimeMs = r.responseTimeByServer[serverURL] / count
+		}
+		if samples, exists := r.latencyByServer[serverURL]; exists {
+			server.Latency = samples.percentiles()
+		}
+		server.EWMAResponseTimeMs = math.Round(r.ewmaByServer[serverURL]*10) / 10
+		snapshot.Servers[serverURL] = server
+	}
+
+	if r.retryBudget != nil {
+		budget := r.retryBudget()
+		snapshot.RetryBudget = &budget
+	}
+
+	if r.shadow != nil {
+		shadow := r.shadow()
+		snapshot.Shadow = &shadow
+	}
+
+	if len(r.balanceChecks) > 0 {
+		snapshot.BalanceChecks = make(map[string]BalanceCheckSnapshot, len(r.balanceChecks))
+		for serverURL, check := range r.balanceChecks {
+			snapshot.BalanceChecks[serverURL] = *check
+		}
+	}
+
+	return snapshot
+}
+
+func (r *Reporter) LogStats() {
+	snapshot := r.Snapshot()
+
+	logger.Info("STATS", "Requests: %d | Errors: %d | Success rate: %.1f%% | Avg time: %dms | p50: %dms | p95: %dms | p99: %dms",
+		snapshot.Requests, snapshot.Errors, snapshot.SuccessRate*100, snapshot.AvgResponseTimeMs,
+		snapshot.Latency.P50, snapshot.Latency.P95, snapshot.Latency.P99)
+
+	serverURLs := make([]string, 0, len(snapshot.Servers))
+	for serverURL := range snapshot.Servers {
+		serverURLs = append(serverURLs, serverURL)
+	}
+	slices.Sort(serverURLs)
+	for _, serverURL := range serverURLs {
+		server := snapshot.Servers[serverURL]
+		logger.Info("STATS", "  %s | Requests: %d | Avg time: %dms | EWMA: %.1fms | p50: %dms | p95: %dms | p99: %dms",
+			serverURL, server.Requests, server.AvgResponseTimeMs, server.EWMAResponseTimeMs,
+			server.Latency.P50, server.Latency.P95, server.Latency.P99)
+	}
+
+	balanceURLs := make([]string, 0, len(snapshot.BalanceChecks))
+	for serverURL := range snapshot.BalanceChecks {
+		balanceURLs = append(balanceURLs, serverURL)
+	}
+	slices.Sort(balanceURLs)
+	for _, serverURL := range balanceURLs {
+		check := snapshot.BalanceChecks[serverURL]
+		balance := "unknown"
+		if check.Balance != nil {
+			balance = fmt.Sprintf("%.2f", *check.Balance)
+		}
+		logger.Info("STATS", "  %s | Balance: %s | Checks: %d ok, %d failed",
+			serverURL, balance, check.Successes, check.Failures)
 	}
+}
 
-	logger.Info("STATS", "Requests: %d | Errors: %d | Avg time: %dms",
-		totalRequests, totalErrors, avgResponseTime)
+// Handler 返回统计快照的 JSON 端点
+func (r *Reporter) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(200, r.Snapshot())
+	}
+}
+
+// ResetHandler 清零统计的管理端点，返回重置前的最后一份快照
+func (r *Reporter) ResetHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		snapshot := r.Snapshot()
+		r.Reset()
+		logger.Info("STATS", "Statistics reset via admin endpoint")
+
+		c.JSON(200, gin.H{
+			"previous": snapshot,
+			"time":     time.Now().Format(time.RFC3339),
+		})
+	}
+}
+
+// StartPeriodicReset 每隔 interval 输出一次统计并清零，使统计只反映最近一个时间窗口
+func (r *Reporter) StartPeriodicReset(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		r.LogStats()
+		r.Reset()
+		logger.Info("STATS", "Statistics reset (interval: %v)", interval)
+	}
 }
 
 // StartReporter 定期统计显示
@@ -68,6 +365,19 @@ func (r *Reporter) StartReporter() {
 	}
 }
 
+// SetAccessLogFormat 设置访问日志格式（需在启动服务前调用）
+func (r *Reporter) SetAccessLogFormat(format string) {
+	r.accessLogFormat = format
+}
+
+// SetEWMAAlpha 设置延迟指数加权移动平均的平滑系数（需在启动服务前调用），0 表示使用默认值
+func (r *Reporter) SetEWMAAlpha(alpha float64) {
+	if alpha <= 0 || alpha > 1 {
+		alpha = DefaultEWMAAlpha
+	}
+	r.ewmaAlpha = alpha
+}
+
 // GinLoggerMiddleware Gin 日志中间件
 func (r *Reporter) GinLoggerMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -75,6 +385,13 @@ func (r *Reporter) GinLoggerMiddleware() gin.HandlerFunc {
 		path := c.Request.URL.Path
 		raw := c.Request.URL.RawQuery
 
+		// 结构化日志模式下为每个请求分配 ID，并返回给客户端用于关联日志
+		var id string
+		if r.accessLogFormat == AccessLogFormatJSON {
+			id = requestID(c)
+			c.Header(requestIDHeader, id)
+		}
+
 		// 处理请求
 		c.Next()
 
@@ -95,6 +412,15 @@ func (r *Reporter) GinLoggerMiddleware() gin.HandlerFunc {
 			path = path + "?" + raw
 		}
 
+		if r.accessLogFormat == AccessLogFormatJSON {
+			// 健康检查请求成功时不记录日志，避免日志噪音
+			if statusCode < 400 && (path == "/health" || path == "/ready") {
+				return
+			}
+			logger.Record(newAccessRecord(c, start, path, latency, id))
+			return
+		}
+
 		// 根据状态码选择日志级别
 		if statusCode >= 500 {
 			logger.Error("HTTP", "%s %s | %d | %v | %s", method, path, statusCode, latency, clientIP)
@@ -102,7 +428,7 @@ func (r *Reporter) GinLoggerMiddleware() gin.HandlerFunc {
 			logger.Warning("HTTP", "%s %s | %d | %v | %s", method, path, statusCode, latency, clientIP)
 		} else {
 			// 对于健康检查路径，使用更低级别的日志
-			if path == "/health" {
+			if path == "/health" || path == "/ready" {
 				// 健康检查请求不记录日志，避免日志噪音
 				return
 			}
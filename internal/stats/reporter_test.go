@@ -1,11 +1,18 @@
 package stats
 
 import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"testing"
 	"time"
 
+	"claude-code-lb/pkg/types"
+
 	"github.com/gin-gonic/gin"
 )
 
@@ -153,6 +160,296 @@ func TestAddServerStats(t *testing.T) {
 	}
 }
 
+func TestAddModelStats(t *testing.T) {
+	reporter := New()
+
+	reporter.AddModelStats("claude-3-5-sonnet")
+	reporter.AddModelStats("claude-3-5-sonnet")
+	reporter.AddModelStats("claude-3-haiku")
+	reporter.AddModelStats("")
+
+	modelStats := reporter.GetModelStats()
+	if len(modelStats) != 2 {
+		t.Errorf("Expected 2 models, got %v", modelStats)
+	}
+	if modelStats["claude-3-5-sonnet"] != 2 {
+		t.Errorf("Expected 2 requests for claude-3-5-sonnet, got %d", modelStats["claude-3-5-sonnet"])
+	}
+
+	// 返回的是副本，修改不影响内部状态
+	modelStats["claude-3-haiku"] = 100
+	if reporter.GetModelStats()["claude-3-haiku"] != 1 {
+		t.Error("GetModelStats should return a copy")
+	}
+}
+
+func TestRecordBalanceCheck(t *testing.T) {
+	reporter := New()
+
+	// 没有余额查询时快照中不包含 balance_checks
+	if snapshot := reporter.Snapshot(); snapshot.BalanceChecks != nil {
+		t.Errorf("Expected no balance checks, got %v", snapshot.BalanceChecks)
+	}
+
+	reporter.RecordBalanceCheck("http://api1.local", 0, false)
+	reporter.RecordBalanceCheck("http://api2.local", 42.5, true)
+	reporter.RecordBalanceCheck("http://api2.local", 0, false)
+
+	checks := reporter.Snapshot().BalanceChecks
+	if check := checks["http://api1.local"]; check.Balance != nil || check.Successes != 0 || check.Failures != 1 {
+		t.Errorf("Unexpected api1 balance check stats: %+v", check)
+	}
+
+	// 查询失败时保留上一次成功的余额
+	check := checks["http://api2.local"]
+	if check.Balance == nil || *check.Balance != 42.5 {
+		t.Errorf("Expected last known balance 42.5, got %v", check.Balance)
+	}
+	if check.Successes != 1 || check.Failures != 1 {
+		t.Errorf("Expected 1 success and 1 failure, got %+v", check)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := make([]int64, 100)
+	for i := range sorted {
+		sorted[i] = int64(i + 1)
+	}
+
+	tests := []struct {
+		name     string
+		values   []int64
+		p        float64
+		expected int64
+	}{
+		{"empty", nil, 50, 0},
+		{"single", []int64{42}, 99, 42},
+		{"p50", sorted, 50, 50},
+		{"p95", sorted, 95, 95},
+		{"p99", sorted, 99, 99},
+		{"p99 small sample", []int64{10, 20, 30}, 99, 30},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := percentile(tt.values, tt.p); got != tt.expected {
+				t.Errorf("percentile(%v) = %d, want %d", tt.p, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestLatencySamplesBounded(t *testing.T) {
+	samples := newLatencySamples(10)
+
+	// 写入远超容量的样本，只保留最近 10 个（91..100）
+	for i := 1; i <= 100; i++ {
+		samples.add(int64(i))
+	}
+
+	snapshot := samples.snapshot()
+	if len(snapshot) != 10 {
+		t.Fatalf("Expected 10 samples, got %d", len(snapshot))
+	}
+	if snapshot[0] != 91 || snapshot[9] != 100 {
+		t.Errorf("Expected samples 91..100, got %v", snapshot)
+	}
+
+	p := samples.percentiles()
+	if p.P50 != 95 || p.P99 != 100 {
+		t.Errorf("Unexpected percentiles: %+v", p)
+	}
+}
+
+func TestSnapshotPercentiles(t *testing.T) {
+	reporter := New()
+
+	for i := 1; i <= 100; i++ {
+		reporter.IncrementRequestCount()
+		reporter.AddResponseTime(int64(i))
+		reporter.AddServerStats("http://test-api.local", int64(i))
+	}
+	reporter.AddServerStats("http://test-api2.local", 500)
+
+	snapshot := reporter.Snapshot()
+	if snapshot.Requests != 100 {
+		t.Errorf("Expected 100 requests, got %d", snapshot.Requests)
+	}
+	if snapshot.Latency.P50 != 50 || snapshot.Latency.P95 != 95 || snapshot.Latency.P99 != 99 {
+		t.Errorf("Unexpected overall percentiles: %+v", snapshot.Latency)
+	}
+
+	server := snapshot.Servers["http://test-api.local"]
+	if server.Requests != 100 || server.Latency.P95 != 95 {
+		t.Errorf("Unexpected server snapshot: %+v", server)
+	}
+	if snapshot.Servers["http://test-api2.local"].Latency.P50 != 500 {
+		t.Errorf("Unexpected server2 snapshot: %+v", snapshot.Servers["http://test-api2.local"])
+	}
+}
+
+func TestServerEWMA(t *testing.T) {
+	reporter := New()
+	reporter.SetEWMAAlpha(0.5)
+	serverURL := "http://test-api.local"
+
+	if _, ok := reporter.GetServerEWMA(serverURL); ok {
+		t.Fatal("Expected no EWMA before any request")
+	}
+
+	// 长时间的低延迟之后延迟突然升高，EWMA 应很快接近最近的延迟，而累计平均值几乎不变
+	for i := 0; i < 100; i++ {
+		reporter.AddServerStats(serverURL, 100)
+	}
+	for i := 0; i < 5; i++ {
+		reporter.AddServerStats(serverURL, 1000)
+	}
+
+	ewma, ok := reporter.GetServerEWMA(serverURL)
+	if !ok {
+		t.Fatal("Expected EWMA after requests")
+	}
+	// 100 + 900 * (1 - 0.5^5) = 971.875
+	if math.Abs(ewma-971.875) > 0.001 {
+		t.Errorf("Expected EWMA 971.875, got %g", ewma)
+	}
+
+	server := reporter.Snapshot().Servers[serverURL]
+	if server.AvgResponseTimeMs >= 200 {
+		t.Errorf("Expected cumulative average to stay low, got %d", server.AvgResponseTimeMs)
+	}
+	if server.EWMAResponseTimeMs != 971.9 {
+		t.Errorf("Expected snapshot EWMA 971.9, got %g", server.EWMAResponseTimeMs)
+	}
+
+	// 第一个样本直接作为初始值
+	reporter.AddServerStats("http://test-api2.local", 300)
+	if ewma, _ := reporter.GetServerEWMA("http://test-api2.local"); ewma != 300 {
+		t.Errorf("Expected first sample to initialize EWMA, got %g", ewma)
+	}
+}
+
+func TestReset(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	reporter := New()
+	before := reporter.Snapshot().Since
+	reporter.IncrementRequestCount()
+	reporter.IncrementErrorCount()
+	reporter.AddResponseTime(120)
+	reporter.AddServerStats("http://test-api.local", 120)
+	reporter.AddModelStats("claude-3-sonnet")
+	reporter.RecordBalanceCheck("http://test-api.local", 12.5, true)
+
+	router := gin.New()
+	router.POST("/admin/stats/reset", reporter.ResetHandler())
+
+	req, _ := http.NewRequest("POST", "/admin/stats/reset", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != 200 {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	var response struct {
+		Previous Snapshot `json:"previous"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if response.Previous.Requests != 1 || response.Previous.Errors != 1 {
+		t.Errorf("Expected previous snapshot in response, got %+v", response.Previous)
+	}
+
+	snapshot := reporter.Snapshot()
+	if snapshot.Requests != 0 || snapshot.Errors != 0 || snapshot.AvgResponseTimeMs != 0 {
+		t.Errorf("Expected counters to be reset, got %+v", snapshot)
+	}
+	if len(snapshot.Servers) != 0 || len(snapshot.Models) != 0 || snapshot.Latency.P50 != 0 {
+		t.Errorf("Expected per-server and per-model stats to be reset, got %+v", snapshot)
+	}
+	if _, ok := reporter.GetServerEWMA("http://test-api.local"); ok {
+		t.Error("Expected EWMA to be reset")
+	}
+	check := snapshot.BalanceChecks["http://test-api.local"]
+	if check.Successes != 0 || check.Balance == nil || *check.Balance != 12.5 {
+		t.Errorf("Expected balance check counts reset and balance kept, got %+v", check)
+	}
+	if snapshot.Since.Before(before) {
+		t.Errorf("Expected since to advance on reset, got %v (was %v)", snapshot.Since, before)
+	}
+
+	// 重置后继续正常统计
+	reporter.AddServerStats("http://test-api.local", 80)
+	if server := reporter.Snapshot().Servers["http://test-api.local"]; server.Requests != 1 || server.AvgResponseTimeMs != 80 {
+		t.Errorf("Unexpected server stats after reset: %+v", server)
+	}
+}
+
+func TestResetConcurrency(t *testing.T) {
+	reporter := New()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				reporter.IncrementRequestCount()
+				reporter.AddResponseTime(int64(j))
+				reporter.AddServerStats("http://test-api.local", int64(j))
+				reporter.AddModelStats("claude-3-sonnet")
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for j := 0; j < 50; j++ {
+			reporter.Reset()
+			reporter.Snapshot()
+		}
+	}()
+	wg.Wait()
+
+	reporter.Reset()
+	snapshot := reporter.Snapshot()
+	if snapshot.Requests != 0 || len(snapshot.Servers) != 0 || len(snapshot.Models) != 0 {
+		t.Errorf("Expected empty stats after final reset, got %+v", snapshot)
+	}
+}
+
+func TestStatsHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	reporter := New()
+	reporter.IncrementRequestCount()
+	reporter.AddResponseTime(120)
+	reporter.AddServerStats("http://test-api.local", 120)
+
+	router := gin.New()
+	router.GET("/stats", reporter.Handler())
+
+	req, _ := http.NewRequest("GET", "/stats", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != 200 {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	var snapshot Snapshot
+	if err := json.Unmarshal(w.Body.Bytes(), &snapshot); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if snapshot.Requests != 1 || snapshot.Latency.P99 != 120 {
+		t.Errorf("Unexpected snapshot: %+v", snapshot)
+	}
+	if snapshot.Servers["http://test-api.local"].Latency.P50 != 120 {
+		t.Errorf("Unexpected server snapshot: %+v", snapshot.Servers)
+	}
+}
+
 func TestLogStats(t *testing.T) {
 	reporter := New()
 
@@ -303,6 +600,135 @@ func TestGinLoggerMiddlewareHealthCheck(t *testing.T) {
 	// This is hard to test directly, but the middleware should handle it without error
 }
 
+func TestGinLoggerMiddlewareJSON(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var buf bytes.Buffer
+	originalOutput := log.Writer()
+	log.SetOutput(&buf)
+	defer log.SetOutput(originalOutput)
+
+	reporter := New()
+	reporter.SetAccessLogFormat(AccessLogFormatJSON)
+
+	router := gin.New()
+	router.Use(reporter.GinLoggerMiddleware())
+	router.POST("/v1/messages", func(c *gin.Context) {
+		SetRequestServer(c, "https://api.example.com")
+		SetRequestUsage(c, "claude-3", types.ClaudeUsage{InputTokens: 10, OutputTokens: 20})
+		c.Status(200)
+	})
+
+	req, _ := http.NewRequest("POST", "/v1/messages?beta=true", nil)
+	req.Header.Set("X-Request-Id", "req-123")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Header().Get("X-Request-Id") != "req-123" {
+		t.Errorf("Expected request ID to be echoed, got %q", w.Header().Get("X-Request-Id"))
+	}
+
+	var record AccessRecord
+	if err := json.Unmarshal(bytes.TrimSpace(buf.Bytes()), &record); err != nil {
+		t.Fatalf("Expected a single JSON record, got %q: %v", buf.String(), err)
+	}
+
+	expected := AccessRecord{
+		Method:        "POST",
+		Path:          "/v1/messages?beta=true",
+		Status:        200,
+		LatencyBucket: "<100ms",
+		ClientIP:      record.ClientIP,
+		RequestID:     "req-123",
+		ServerURL:     "https://api.example.com",
+		Model:         "claude-3",
+		InputTokens:   10,
+		OutputTokens:  20,
+		Time:          record.Time,
+		LatencyMs:     record.LatencyMs,
+	}
+	if record != expected {
+		t.Errorf("Unexpected access record:\n got %+v\nwant %+v", record, expected)
+	}
+}
+
+func TestRequestIDGenerated(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request, _ = http.NewRequest("GET", "/", nil)
+
+	first, second := requestID(c), requestID(c)
+	if len(first) != 16 || first == second {
+		t.Errorf("Expected distinct 16-char generated IDs, got %q and %q", first, second)
+	}
+}
+
+func TestLatencyBucket(t *testing.T) {
+	tests := []struct {
+		latency  time.Duration
+		expected string
+	}{
+		{50 * time.Millisecond, "<100ms"},
+		{100 * time.Millisecond, "100ms-500ms"},
+		{700 * time.Millisecond, "500ms-1s"},
+		{3 * time.Second, "1s-5s"},
+		{10 * time.Second, "5s-30s"},
+		{time.Minute, ">=30s"},
+	}
+
+	for _, tt := range tests {
+		if got := latencyBucket(tt.latency); got != tt.expected {
+			t.Errorf("latencyBucket(%v) = %q, expected %q", tt.latency, got, tt.expected)
+		}
+	}
+}
+
+func TestIdleMonitor(t *testing.T) {
+	reporter := New()
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	var events []time.Duration
+	monitor := NewIdleMonitor(reporter, 10*time.Minute, func(idle time.Duration) {
+		events = append(events, idle)
+	})
+	monitor.now = func() time.Time { return now }
+	monitor.lastActive = now
+
+	advance := func(d time.Duration) {
+		now = now.Add(d)
+		monitor.check()
+	}
+
+	// 有请求时重新计时
+	advance(9 * time.Minute)
+	reporter.IncrementRequestCount()
+	advance(time.Minute)
+	advance(9 * time.Minute)
+	if len(events) != 0 {
+		t.Fatalf("Expected no idle event before timeout, got %v", events)
+	}
+
+	// 超时后只通知一次
+	advance(time.Minute)
+	advance(5 * time.Minute)
+	if len(events) != 1 || events[0] != 10*time.Minute {
+		t.Fatalf("Expected one idle event after 10m, got %v", events)
+	}
+
+	// 统计清零不算活动；新请求后再次空闲时再次通知
+	reporter.Reset()
+	advance(time.Minute)
+	if len(events) != 1 {
+		t.Fatalf("Expected reset not to trigger another idle event, got %v", events)
+	}
+	reporter.IncrementRequestCount()
+	advance(time.Minute)
+	advance(10 * time.Minute)
+	if len(events) != 2 {
+		t.Errorf("Expected idle event after new activity went idle, got %v", events)
+	}
+}
+
 func TestReporterConcurrency(t *testing.T) {
 	reporter := New()
 
@@ -1,22 +1,34 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
+	"net/http/pprof"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"claude-code-lb/internal/auth"
 	"claude-code-lb/internal/balance"
 	"claude-code-lb/internal/config"
 	"claude-code-lb/internal/health"
 	"claude-code-lb/internal/logger"
+	"claude-code-lb/internal/notify"
 	"claude-code-lb/internal/proxy"
 	"claude-code-lb/internal/stats"
 
 	"github.com/gin-gonic/gin"
 )
 
+// shutdownTimeout 收到退出信号后等待在途请求完成的最长时间
+const shutdownTimeout = 10 * time.Second
+
 var (
 	version = "dev"
 	commit  = "unknown"
@@ -27,7 +39,8 @@ func main() {
 	// 解析命令行参数
 	var showVersion = flag.Bool("version", false, "Show version information")
 	var showHelp = flag.Bool("help", false, "Show help information")
-	var configFile = flag.String("c", "", "Path to configuration file")
+	var configFile = flag.String("c", "", "Path or http(s):// URL of configuration file")
+	var enablePprof = flag.Bool("pprof", false, "Register pprof profiling endpoints under /debug/pprof/ (overrides enable_pprof)")
 	flag.Parse()
 
 	if *showVersion {
@@ -45,12 +58,22 @@ func main() {
 		fmt.Printf("Options:\n")
 		flag.PrintDefaults()
 		fmt.Printf("\nEnvironment Variables:\n")
-		fmt.Printf("  CONFIG_FILE    Configuration file path (default: config.json)\n")
+		fmt.Printf("  CONFIG_FILE    Configuration file path or http(s):// URL (default: config.json)\n")
+		fmt.Printf("  CONFIG_TOKEN   Bearer token for fetching a remote configuration\n")
+		fmt.Printf("  AUTH_KEYS      Comma-separated client API keys (overrides auth_keys and enables auth)\n")
+		fmt.Printf("  SERVERS        JSON array of upstream servers (overrides servers; config file becomes optional)\n")
 		os.Exit(0)
 	}
 
 	// 加载配置
-	cfg := config.LoadWithPath(*configFile)
+	cfg, err := config.LoadWithPath(*configFile)
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	if *enablePprof {
+		cfg.EnablePprof = true
+	}
 
 	// 设置日志 debug 模式
 	logger.SetDebugMode(cfg.Debug)
@@ -58,14 +81,28 @@ func main() {
 	// 创建负载均衡器
 	balancer := balance.New(cfg)
 
+	// 服务器状态变化 webhook 通知
+	var notifier *notify.WebhookNotifier
+	if cfg.WebhookURL != "" {
+		notifier = notify.NewWebhookNotifier(cfg.WebhookURL, cfg.WebhookFormat)
+		balancer.SetStateListener(notifier.Notify)
+	}
+
 	// 创建统计报告器
 	statsReporter := stats.New()
+	statsReporter.SetAccessLogFormat(cfg.AccessLogFormat)
+	statsReporter.SetEWMAAlpha(cfg.LatencyEWMAAlpha)
 
 	// 创建健康检查器
 	healthChecker := health.NewChecker(cfg, balancer)
 
 	// 创建余额查询器
 	balanceChecker := balance.NewBalanceChecker(cfg, balancer)
+	balancer.SetBalanceProvider(balanceChecker)
+	balanceChecker.SetMetricsRecorder(statsReporter)
+	if notifier != nil {
+		balanceChecker.SetLowBalanceListener(notifier.NotifyLowBalance)
+	}
 
 	// 设置 Gin 为发布模式，关闭调试日志
 	gin.SetMode(gin.ReleaseMode)
@@ -80,25 +117,65 @@ func main() {
 	r.Use(gin.Recovery())
 
 	// 设置信任的代理，允许从上游代理获取真实客户端IP
-	// 只信任常见的内网IP段，防止恶意IP伪造攻击
-	r.SetTrustedProxies([]string{
-		"127.0.0.0/8",    // 回环地址段
-		"172.16.0.0/12",  // Docker默认网段
-		"10.0.0.0/8",     // 私有网段A类
-		"192.168.0.0/16", // 私有网段C类
-	})
+	// 只信任配置的代理（默认为常见内网IP段），防止恶意IP伪造攻击
+	if err := r.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatalf("Failed to set trusted proxies: %v", err)
+	}
+
+	// 客户端密钥集合在所有鉴权路由间共享，收到 SIGHUP 时原子替换
+	authKeys := auth.NewKeySet(cfg.AuthKeys)
+	authMiddleware := auth.MiddlewareWithKeys(cfg, authKeys)
 
 	// 健康检查路由
 	r.GET("/health", health.Handler(cfg, balancer))
+	r.GET("/ready", health.ReadyHandler(cfg, balancer, balanceChecker))
+
+	// 统计和服务器状态端点（与代理路由使用相同的鉴权）
+	r.GET("/stats", authMiddleware, statsReporter.Handler())
+	r.GET("/servers", authMiddleware, health.ServersHandler(cfg, balancer))
+	r.GET("/balances", authMiddleware, health.BalancesHandler(cfg, balanceChecker))
+
+	// 管理和调试接口（只接受 admin_keys，未配置时不注册）
+	if len(cfg.AdminKeys) > 0 {
+		adminMiddleware := auth.AdminMiddleware(cfg.AdminKeys)
+		r.GET("/debug/config", adminMiddleware, health.DebugConfigHandler(cfg))
+		r.GET("/debug/routing", adminMiddleware, health.RoutingHandler(balancer))
+		r.POST("/admin/balances/check", adminMiddleware, health.BalanceCheckHandler(cfg, balanceChecker))
+		r.POST("/admin/stats/reset", adminMiddleware, statsReporter.ResetHandler())
+	}
+
+	// 性能分析接口（仅供运维排查，不经过鉴权，只应在受信任的网络中启用）
+	if cfg.EnablePprof {
+		r.GET("/debug/pprof/*name", pprofHandler)
+		r.POST("/debug/pprof/*name", pprofHandler)
+	}
 
 	// 在需要鉴权的路由上应用鉴权中间件和代理处理
-	r.Any("/v1/*path", auth.Middleware(cfg), proxy.Handler(balancer, statsReporter, cfg.Debug))
+	proxyHandler := proxy.Handler(cfg, balancer, statsReporter)
+	r.Any("/v1/*path", authMiddleware, auth.SignatureMiddleware(cfg), proxyHandler)
+
+	// 带前缀的路由（转发前移除前缀）
+	if prefix := strings.Trim(cfg.PathPrefixStrip, "/"); prefix != "" {
+		r.Any("/"+prefix+"/v1/*path", authMiddleware, auth.SignatureMiddleware(cfg), proxyHandler)
+	}
 
 	// 启动被动健康检查（自动恢复冷却期过期的服务器）
 	go healthChecker.PassiveHealthCheck()
 
 	// 启动统计报告器
 	go statsReporter.StartReporter()
+	if cfg.StatsResetIntervalSeconds > 0 {
+		go statsReporter.StartPeriodicReset(time.Duration(cfg.StatsResetIntervalSeconds) * time.Second)
+	}
+
+	// 空闲通知（只记录日志和发送 webhook，不停止服务）
+	if cfg.IdleTimeoutSeconds > 0 {
+		var idleListener stats.IdleListener
+		if notifier != nil {
+			idleListener = notifier.NotifyIdle
+		}
+		go stats.NewIdleMonitor(statsReporter, time.Duration(cfg.IdleTimeoutSeconds)*time.Second, idleListener).Start()
+	}
 
 	// 启动余额查询器
 	go balanceChecker.Start()
@@ -112,12 +189,121 @@ func main() {
 	logger.Info("BOOT", "Version: %s (commit: %s, built: %s)", version, commit, date)
 	logger.Info("BOOT", "Starting server on port %s", port)
 	logger.Info("BOOT", "Load balancer: %s (%d servers)", cfg.Mode, len(cfg.Servers))
+	if len(cfg.OverflowServers) > 0 {
+		logger.Info("BOOT", "Overflow servers: %d (used only when all servers are saturated or unavailable)", len(cfg.OverflowServers))
+	}
+	if len(cfg.EmergencyServers) > 0 {
+		logger.Info("BOOT", "Emergency servers: %d (used only when all servers are unavailable)", len(cfg.EmergencyServers))
+	}
 	logger.Info("BOOT", "Algorithm: %s | Circuit breaker: %ds | Debug: %t", cfg.Algorithm, cfg.Cooldown, cfg.Debug)
-	logger.Info("BOOT", "Health check: passive (auto-recovery after cooldown)")
+	logger.Info("BOOT", "Routing plan: %s", balancer.DescribeRouting())
+	logger.Info("BOOT", "Health check: passive (auto-recovery after cooldown, checked every %v)", healthChecker.Interval())
+	if cfg.ActiveHealthCheck {
+		logger.Info("BOOT", "  Active probes: enabled (servers in cooldown recover early when a probe succeeds)")
+	}
+	var balanceCheckServers int
+	for _, server := range cfg.Servers {
+		if server.BalanceCheck != "" {
+			balanceCheckServers++
+		}
+	}
+	logger.Info("BOOT", "Balance checks: %d/%d servers", balanceCheckServers, len(cfg.Servers))
+	if cfg.WebhookURL != "" {
+		logger.Info("BOOT", "State change webhook: enabled (format: %s)", cfg.WebhookFormat)
+	}
+	if cfg.IdleTimeoutSeconds > 0 {
+		logger.Info("BOOT", "Idle notification: after %ds without requests", cfg.IdleTimeoutSeconds)
+	}
 	logger.Info("BOOT", "Authentication: %t", cfg.Auth)
 	if cfg.Auth {
 		logger.Info("BOOT", "  Allowed keys: %d", len(cfg.AuthKeys))
 	}
+	if len(cfg.AdminKeys) > 0 {
+		logger.Info("BOOT", "Admin endpoints: enabled (%d admin keys)", len(cfg.AdminKeys))
+	} else {
+		logger.Info("BOOT", "Admin endpoints: disabled (no admin_keys configured)")
+	}
+	if cfg.HMACSecret != "" {
+		logger.Info("BOOT", "Request signature: required (HMAC-SHA256)")
+	}
+	if cfg.StartupProbe {
+		logger.Info("BOOT", "Startup probe: enabled")
+	}
+	if cfg.EnablePprof {
+		logger.Warning("BOOT", "pprof: enabled at /debug/pprof/ (unauthenticated)")
+	}
 	log.Printf("%s==========================================================%s", logger.ColorBold, logger.ColorReset)
-	log.Fatal(r.Run(":" + port))
+
+	// 启动探测：在接收流量前检查上游连通性（不可达时只告警，不阻止启动）
+	if cfg.StartupProbe {
+		health.StartupProbe(cfg, health.DefaultProbeTimeout)
+	}
+
+	srv := &http.Server{
+		Addr:    ":" + port,
+		Handler: r,
+	}
+
+	// 收到 SIGINT/SIGTERM 时优雅退出
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// 收到 SIGHUP 时重新加载客户端密钥，不中断在途连接
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go reloadAuthKeys(reload, *configFile, cfg.Auth, authKeys)
+
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Server error: %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	logger.Info("BOOT", "Shutting down...")
+
+	// 停止后台任务，再等待在途请求完成
+	healthChecker.Stop()
+	balanceChecker.Stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logger.Error("BOOT", "Graceful shutdown failed: %v", err)
+	}
+}
+
+// reloadAuthKeys 每次收到信号时重新加载配置（包括 AUTH_KEYS 环境变量），原子替换允许的客户端密钥
+// 只更新密钥，其他配置需要重启才能生效；加载失败或新配置没有密钥时保留当前密钥
+func reloadAuthKeys(signals <-chan os.Signal, configFile string, authEnabled bool, keys *auth.KeySet) {
+	for range signals {
+		reloaded, err := config.LoadWithPath(configFile)
+		if err != nil {
+			logger.Error("AUTH", "Failed to reload config, keeping %d existing keys: %v", keys.Len(), err)
+			continue
+		}
+		if authEnabled && len(reloaded.AuthKeys) == 0 {
+			logger.Error("AUTH", "Reloaded config has no auth keys, keeping %d existing keys", keys.Len())
+			continue
+		}
+		keys.Store(reloaded.AuthKeys)
+		logger.Info("AUTH", "Reloaded auth keys: %d keys", keys.Len())
+	}
+}
+
+// pprofHandler 将 /debug/pprof/ 下的请求分发到 net/http/pprof 的处理函数
+func pprofHandler(c *gin.Context) {
+	switch strings.TrimPrefix(c.Param("name"), "/") {
+	case "cmdline":
+		pprof.Cmdline(c.Writer, c.Request)
+	case "profile":
+		pprof.Profile(c.Writer, c.Request)
+	case "symbol":
+		pprof.Symbol(c.Writer, c.Request)
+	case "trace":
+		pprof.Trace(c.Writer, c.Request)
+	default:
+		// 首页和 heap、goroutine 等命名 profile
+		pprof.Index(c.Writer, c.Request)
+	}
 }
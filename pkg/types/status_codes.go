@@ -0,0 +1,73 @@
+package types
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultSuccessStatusCodes 未配置 success_status_codes 时视为成功的状态码
+var DefaultSuccessStatusCodes = []string{"200-399"}
+
+// ParseStatusCodeRange 解析单个状态码（"204"）或闭区间（"200-299"），返回范围的上下界
+func ParseStatusCodeRange(spec string) (int, int, error) {
+	lowText, highText, isRange := strings.Cut(strings.TrimSpace(spec), "-")
+	if !isRange {
+		highText = lowText
+	}
+
+	low, err := strconv.Atoi(strings.TrimSpace(lowText))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid status code '%s'", spec)
+	}
+	high, err := strconv.Atoi(strings.TrimSpace(highText))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid status code '%s'", spec)
+	}
+	if low < 100 || high > 599 || low > high {
+		return 0, 0, fmt.Errorf("invalid status code range '%s', must be within 100-599", spec)
+	}
+	return low, high, nil
+}
+
+// StatusCodeRange 状态码闭区间
+type StatusCodeRange struct {
+	Low  int
+	High int
+}
+
+// StatusCodeMatcher 解析后的状态码范围列表，避免每个响应都重新解析配置的字符串
+// 零值等同于 DefaultSuccessStatusCodes
+type StatusCodeMatcher []StatusCodeRange
+
+// defaultSuccessMatcher DefaultSuccessStatusCodes 解析后的范围
+var defaultSuccessMatcher = StatusCodeMatcher{{Low: 200, High: 399}}
+
+// ParseStatusCodes 解析状态码范围列表，specs 为空时返回 DefaultSuccessStatusCodes 对应的范围
+func ParseStatusCodes(specs []string) (StatusCodeMatcher, error) {
+	if len(specs) == 0 {
+		return defaultSuccessMatcher, nil
+	}
+	matcher := make(StatusCodeMatcher, 0, len(specs))
+	for _, spec := range specs {
+		low, high, err := ParseStatusCodeRange(spec)
+		if err != nil {
+			return nil, err
+		}
+		matcher = append(matcher, StatusCodeRange{Low: low, High: high})
+	}
+	return matcher, nil
+}
+
+// Match 判断状态码是否落在任一范围内
+func (m StatusCodeMatcher) Match(code int) bool {
+	if len(m) == 0 {
+		m = defaultSuccessMatcher
+	}
+	for _, r := range m {
+		if code >= r.Low && code <= r.High {
+			return true
+		}
+	}
+	return false
+}
@@ -3,27 +3,204 @@ package types
 import "time"
 
 type UpstreamServer struct {
-	URL                  string    `json:"url"`
-	Weight               int       `json:"weight"`
-	Priority             int       `json:"priority"` // fallback模式下的优先级，数字越小优先级越高
-	Token                string    `json:"token"`
-	BalanceCheck         string    `json:"balance_check"`          // 余额查询命令（可选）
-	BalanceCheckInterval int       `json:"balance_check_interval"` // 余额查询间隔（秒，可选）
-	BalanceThreshold     float64   `json:"balance_threshold"`      // 余额阈值，小于等于此值标记为不可用（可选，默认0）
-	DownUntil            time.Time `json:"-"`                      // 不可用直到这个时间
+	URL                        string         `json:"url"`
+	Name                       string         `json:"name,omitempty"` // 服务器名称（可选，用于 X-Upstream-Server 响应头等展示场景）
+	Weight                     int            `json:"weight"`
+	Priority                   int            `json:"priority"`         // fallback模式下的优先级，数字越小优先级越高
+	Region                     string         `json:"region,omitempty"` // 服务器所在区域（可选，用于按区域优先选择）
+	Token                      string         `json:"token"`
+	Tokens                     []string       `json:"tokens,omitempty"`                        // 额外的备用 token，429 时依次尝试（可选）
+	AuthType                   string         `json:"auth_type,omitempty"`                     // 上游鉴权方式："bearer"（默认，使用 token）或 "basic"
+	Username                   string         `json:"username,omitempty"`                      // basic 鉴权用户名
+	Password                   string         `json:"password,omitempty"`                      // basic 鉴权密码
+	ModelWeights               map[string]int `json:"model_weights,omitempty"`                 // 按请求模型覆盖的权重（可选，未列出的模型使用 weight）
+	BalanceCheck               string         `json:"balance_check"`                           // 余额查询命令（可选）
+	BalanceCheckInterval       int            `json:"balance_check_interval"`                  // 余额查询间隔（秒，可选）
+	BalanceCheckTimeoutSeconds int            `json:"balance_check_timeout_seconds,omitempty"` // 余额查询命令超时（秒，可选，默认30）
+	BalanceCheckRegex          string         `json:"balance_check_regex,omitempty"`           // 从命令输出中提取余额的正则（可选，使用第一个捕获组，默认解析完整输出）
+	BalanceScale               float64        `json:"balance_scale,omitempty"`                 // 查询结果的换算系数，在与阈值比较和记录前相乘（可选，默认1，如 0.01 将分换算为元）
+	BalanceThreshold           float64        `json:"balance_threshold"`                       // 余额阈值，低于（或等于）此值标记为不可用（可选，默认0）
+	BalanceComparison          string         `json:"balance_comparison"`                      // 阈值比较方式："lte"（<=，默认）或 "lt"（<）
+	BalanceWarnThreshold       float64        `json:"balance_warn_threshold,omitempty"`        // 余额预警阈值，低于此值时记录警告并通知，但不标记为不可用（可选，0 表示禁用）
+	RequestTimeoutSeconds      int            `json:"request_timeout_seconds"`                 // 请求超时（秒，可选，覆盖全局配置）
+	MaxConcurrent              int            `json:"max_concurrent,omitempty"`                // 在途请求上限（可选，0 表示不限制；仅负载均衡模式，达到上限时优先选择其他服务器或 overflow_servers）
+	AnthropicVersion           string         `json:"anthropic_version,omitempty"`             // 客户端未携带时补充的 anthropic-version 头（可选，覆盖全局配置）
+	HostHeader                 string         `json:"host_header,omitempty"`                   // 发往该服务器的 Host 头（可选，默认使用 url 中的主机名）
+	ClientCertFile             string         `json:"client_cert_file,omitempty"`              // 双向 TLS 客户端证书（PEM，可选，覆盖全局配置，需同时配置 client_key_file）
+	ClientKeyFile              string         `json:"client_key_file,omitempty"`               // 双向 TLS 客户端私钥（PEM，可选）
+	DownUntil                  time.Time      `json:"-"`                                       // 不可用直到这个时间
 }
 
+// Algorithms 负载均衡模式支持的选择算法
+var Algorithms = []string{"round_robin", "weighted_round_robin", "random", "weighted_least_connections", "weighted_balance"}
+
 // 配置结构
 type Config struct {
 	Port      string           `json:"port"`
 	Mode      string           `json:"mode"`      // "load_balance" 或 "fallback"
-	Algorithm string           `json:"algorithm"` // "round_robin", "weighted_round_robin", "random"
+	Algorithm string           `json:"algorithm"` // "round_robin", "weighted_round_robin", "random", "weighted_least_connections", "weighted_balance"
 	Servers   []UpstreamServer `json:"servers"`
 	Fallback  bool             `json:"fallback"`  // 向后兼容字段
 	Auth      bool             `json:"auth"`      // 是否启用鉴权
 	AuthKeys  []string         `json:"auth_keys"` // 允许的 API Key 列表
 	Cooldown  int              `json:"cooldown"`  // 冷却时间（秒）
 	Debug     bool             `json:"debug"`     // 是否启用调试模式
+
+	// 请求签名（配置 hmac_secret 后要求客户端携带 X-Timestamp 和 X-Signature）
+	HMACSecret         string `json:"hmac_secret,omitempty"`           // 签名密钥（空表示禁用）
+	HMACMaxSkewSeconds int    `json:"hmac_max_skew_seconds,omitempty"` // 时间戳允许的最大偏差（秒，默认300）
+
+	// 性能分析
+	EnablePprof bool `json:"enable_pprof,omitempty"` // 在 /debug/pprof/ 下注册 pprof 性能分析接口（默认关闭，不经过鉴权）
+
+	// 日志
+	AccessLogFormat string `json:"access_log_format,omitempty"` // 访问日志格式："text"（默认）或 "json"
+
+	// 统计
+	LatencyEWMAAlpha          float64  `json:"latency_ewma_alpha,omitempty"`           // 每个服务器延迟指数加权移动平均的平滑系数（0-1，越大越偏重最近的请求，默认0.3）
+	StatsResetIntervalSeconds int      `json:"stats_reset_interval_seconds,omitempty"` // 定期清零统计的间隔（秒，0 表示从不清零）
+	SuccessStatusCodes        []string `json:"success_status_codes,omitempty"`         // 记录为成功的响应状态码或范围（如 "204"、"200-299"，默认 200-399），不影响熔断判断
+
+	// success_status_codes 解析后的匹配器（加载配置时生成，零值等同于默认范围）
+	SuccessStatusMatcher StatusCodeMatcher `json:"-"`
+
+	// 服务器默认值
+	DefaultWeight int    `json:"default_weight,omitempty"` // 未设置 weight 的服务器使用的默认权重（0 表示沿用选择器的默认值 1）
+	DefaultToken  string `json:"default_token,omitempty"`  // 未设置 token（及 tokens）的服务器使用的默认 token
+
+	// 按请求指定算法（负载均衡模式下客户端通过 X-LB-Algorithm 请求头为单个请求指定算法）
+	AllowAlgorithmOverride bool     `json:"allow_algorithm_override,omitempty"` // 是否允许按请求指定算法（默认忽略该请求头）
+	AlgorithmOverrides     []string `json:"algorithm_overrides,omitempty"`      // 允许指定的算法（默认允许所有算法）
+
+	// random 算法的随机数来源："crypto"（默认，crypto/rand）或 "math"（math/rand，开销更低）
+	RandomSource string `json:"random_source,omitempty"`
+
+	// 按区域选择（优先使用请求所在区域的服务器，该区域全部不可用时使用其他区域）
+	RegionHeader  string `json:"region_header,omitempty"`  // 携带客户端区域的请求头（默认 X-LB-Region）
+	DefaultRegion string `json:"default_region,omitempty"` // 请求未携带区域时优先使用的区域（可选）
+
+	TrustedProxies []string `json:"trusted_proxies,omitempty"` // 信任其 X-Forwarded-For 的代理 IP/网段（默认信任内网，[] 表示不信任任何代理）
+
+	// 应急服务器（仅在所有 servers 都不可用时按配置顺序使用）
+	EmergencyServers []UpstreamServer `json:"emergency_servers,omitempty"`
+
+	// fallback 模式下所有服务器都不可用时的紧急重试：在剩余冷却时间不超过最短冷却时间 + 此窗口（秒）的服务器中选择优先级最高的
+	// 0 表示严格选择冷却时间最短的服务器
+	EmergencyPriorityWindowSeconds int `json:"emergency_priority_window_seconds,omitempty"`
+
+	// fallback 模式下服务器恢复后继续使用备用服务器的时间（秒），避免不稳定的主服务器恢复后流量立即切回造成抖动（0 表示立即切回）
+	FailbackDelaySeconds int `json:"failback_delay_seconds,omitempty"`
+
+	// 故障处理
+	BackoffEnabled          *bool   `json:"backoff_enabled,omitempty"`             // 是否按失败次数延长冷却时间（默认启用）
+	RequestTimeoutSeconds   int     `json:"request_timeout_seconds"`               // 上游请求超时（秒，默认60）
+	MaxClientTimeoutMs      int     `json:"max_client_timeout_ms,omitempty"`       // 客户端通过 X-Request-Timeout-Ms 指定超时的上限（毫秒，0 表示忽略该请求头）
+	TryAllServers           bool    `json:"try_all_servers,omitempty"`             // 失败时依次尝试其他可用服务器（每个最多一次）
+	RetryBudgetPerSecond    float64 `json:"retry_budget_per_second,omitempty"`     // 所有请求共享的每秒重试次数上限（try_all_servers 换服务器时消耗，0 表示不限制）
+	RetryBudgetBurst        int     `json:"retry_budget_burst,omitempty"`          // 重试预算允许的突发次数（默认为每秒次数向上取整，至少 1）
+	ExposeUpstreamErrors    bool    `json:"expose_upstream_errors,omitempty"`      // 错误响应中返回上游状态码和错误信息（默认隐藏）
+	AnthropicErrorFormat    bool    `json:"anthropic_error_format,omitempty"`      // 代理自身的错误使用 Anthropic API 的错误格式（默认为 {"error": "..."}）
+	ExposeUpstreamHeader    bool    `json:"expose_upstream_header,omitempty"`      // 在响应头 X-Upstream-Server 中返回处理请求的服务器（默认隐藏）
+	TripOnAuthError         bool    `json:"trip_on_auth_error,omitempty"`          // 上游返回 401/403 时标记服务器为不可用（token 配置错误，默认只记录日志）
+	TreatErrorBodyAsFailure bool    `json:"treat_error_body_as_failure,omitempty"` // 上游返回 200 但响应体为错误 JSON 时按故障处理（默认只记录日志并转发）
+	MaxFailures             int     `json:"max_failures,omitempty"`                // 连续失败次数超过此值时永久禁用服务器（0 表示不限制）
+	RecoveryBatchSize       int     `json:"recovery_batch_size,omitempty"`         // 每轮健康检查最多恢复的服务器数量（0 表示不限制）
+	SlowRequestThresholdMs  int     `json:"slow_request_threshold_ms,omitempty"`   // 上游响应时间超过此值（毫秒）时以警告级别记录（0 表示不检查）
+	QueueWaitSeconds        int     `json:"queue_wait_seconds,omitempty"`          // 没有可用服务器时等待服务器恢复的最长时间（秒，0 表示立即失败）
+
+	// 请求合并（并发的相同请求只转发一次，共享上游响应；流式请求不合并）
+	CoalesceRequests  bool     `json:"coalesce_requests,omitempty"`  // 是否合并并发的相同请求（方法、路径和请求体都相同）
+	CoalesceEndpoints []string `json:"coalesce_endpoints,omitempty"` // 允许合并的端点路径（默认 /v1/models 和 /v1/messages/count_tokens）
+
+	// 上游连接池
+	IdleConnTimeoutSeconds int  `json:"idle_conn_timeout_seconds,omitempty"` // 空闲连接的最长保留时间（秒，默认30）
+	MaxConnsPerHost        int  `json:"max_conns_per_host,omitempty"`        // 每个上游的最大连接数（0 表示不限制）
+	DisableHTTP2           bool `json:"disable_http2,omitempty"`             // 禁用与上游的 HTTP/2 协商（只使用 HTTP/1.1）
+
+	// 上游 TLS（默认校验证书，最低 TLS 1.2）
+	MinTLSVersion      string `json:"min_tls_version,omitempty"`      // 最低 TLS 版本："1.0"、"1.1"、"1.2"（默认）、"1.3"
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"` // 跳过上游证书校验（仅用于开发或内部网络）
+	CACertFile         string `json:"ca_cert_file,omitempty"`         // 额外信任的 CA 证书文件（PEM，在系统根证书之外）
+	ClientCertFile     string `json:"client_cert_file,omitempty"`     // 双向 TLS 客户端证书（PEM，需同时配置 client_key_file）
+	ClientKeyFile      string `json:"client_key_file,omitempty"`      // 双向 TLS 客户端私钥（PEM）
+
+	// 限流冷却（上游返回 429 且未携带 Retry-After 时使用，通常远短于 cooldown；0 表示使用 cooldown 及退避）
+	RateLimitCooldownSeconds int `json:"rate_limit_cooldown_seconds,omitempty"`
+
+	// 影子流量（按比例将请求异步复制到测试上游，只比较状态码和延迟，不影响客户端响应）
+	ShadowServer  *UpstreamServer `json:"shadow_server,omitempty"`  // 接收复制请求的影子服务器
+	ShadowPercent float64         `json:"shadow_percent,omitempty"` // 复制到影子服务器的请求比例（0-100，0 表示禁用）
+
+	// 金丝雀路由（仅负载均衡模式：按比例将请求发往 servers 中的某个新服务器，其余请求在其他服务器中正常选择）
+	Canary        string  `json:"canary,omitempty"`         // 金丝雀服务器的 URL（必须是 servers 中的服务器）
+	CanaryPercent float64 `json:"canary_percent,omitempty"` // 发往金丝雀服务器的请求比例（0-100）
+
+	// 空闲通知（超过该时长没有请求时记录日志并发送 webhook，供外部自动缩容；不会停止服务，0 表示禁用）
+	IdleTimeoutSeconds int `json:"idle_timeout_seconds,omitempty"`
+
+	// 触发故障转移的上游错误响应（5xx、429 等）最多读取的字节数，只用于日志和错误信息（默认 8192）
+	ErrorBodyLogBytes int `json:"error_body_log_bytes,omitempty"`
+
+	// 预热宽限期（秒）：服务器启动或恢复后的这段时间内失败只使用基础冷却时间，不按失败次数退避（0 表示禁用）
+	WarmupGraceSeconds int `json:"warmup_grace_seconds,omitempty"`
+
+	// 管理接口密钥（/admin/* 只接受这些密钥，与 auth_keys 相互独立；未配置时不注册管理接口）
+	AdminKeys []string `json:"admin_keys,omitempty"`
+
+	// 溢出服务器（仅负载均衡模式：所有 servers 都达到 max_concurrent 上限或不可用时，按配置的算法在这些服务器中选择）
+	OverflowServers []UpstreamServer `json:"overflow_servers,omitempty"`
+
+	// 请求体的最大字节数，超过时返回 413（转发前缓冲请求体和 HMAC 签名校验使用同一限制，默认 32 MiB）
+	MaxRequestBodyBytes int64 `json:"max_request_body_bytes,omitempty"`
+
+	// 被动健康检查
+	HealthCheckIntervalSeconds int `json:"health_check_interval_seconds,omitempty"` // 检查冷却到期服务器的间隔（秒，默认5，与冷却时间无关）
+
+	// 主动健康检查（每次检查时探测冷却中的故障服务器，探测成功则提前恢复）
+	ActiveHealthCheck         bool `json:"active_health_check,omitempty"`          // 是否启用主动探测（默认关闭，使用 startup_probe_path 作为探测路径）
+	HealthCheckConcurrency    int  `json:"health_check_concurrency,omitempty"`     // 同时进行的探测数量（默认4）
+	HealthCheckTimeoutSeconds int  `json:"health_check_timeout_seconds,omitempty"` // 单次探测超时（秒，默认5）
+
+	// 错误率熔断（窗口内错误率超过阈值时延长冷却时间，0 表示禁用）
+	ErrorRateThreshold   float64 `json:"error_rate_threshold,omitempty"`
+	ErrorRateMinRequests int     `json:"error_rate_min_requests,omitempty"`
+
+	// 余额查询
+	BalanceCheckImmediate      bool `json:"balance_check_immediate,omitempty"`       // 启动时立即执行首次余额查询（默认在 0~间隔 内随机延迟）
+	MaxConcurrentBalanceChecks int  `json:"max_concurrent_balance_checks,omitempty"` // 同时执行的余额查询命令数量上限（默认4，超出的排队等待）
+
+	// 流式响应
+	MaxStreamDurationSeconds int   `json:"max_stream_duration_seconds,omitempty"` // 单个流式响应的最长转发时间（秒，0 表示不限制）
+	MaxBufferResponseBytes   int64 `json:"max_buffer_response_bytes,omitempty"`   // 非流式响应完整缓冲的最大字节数，超过时直接转发且不解析统计（0 表示不限制）
+
+	// 状态变化通知
+	WebhookURL    string `json:"webhook_url,omitempty"`    // 服务器状态变化通知地址（可选）
+	WebhookFormat string `json:"webhook_format,omitempty"` // 通知格式："json"（默认）、"slack"、"discord"
+
+	// 启动探测
+	StartupProbe     bool   `json:"startup_probe,omitempty"`      // 启动时探测所有上游服务器的连通性
+	StartupProbePath string `json:"startup_probe_path,omitempty"` // 探测路径（可选，未设置时对服务器地址发送 HEAD）
+
+	// 路径重写
+	PathPrefixStrip string `json:"path_prefix_strip,omitempty"` // 转发前从请求路径移除的前缀（如 "/proxy"）
+	PathPrefixAdd   string `json:"path_prefix_add,omitempty"`   // 转发前添加到请求路径的前缀
+
+	// 模型名称规范化（仅用于日志和统计，不修改返回给客户端的响应）
+	ModelAliases map[string]string `json:"model_aliases,omitempty"`
+
+	// 请求头处理
+	AnthropicVersion    string   `json:"anthropic_version,omitempty"`     // 客户端未携带时补充的 anthropic-version 头（如 "2023-06-01"）
+	StripRequestHeaders []string `json:"strip_request_headers,omitempty"` // 转发前从客户端请求中移除的头（不区分大小写）
+	PreserveHost        bool     `json:"preserve_host,omitempty"`         // 转发客户端原始的 Host 头（服务器配置 host_header 时以其为准）
+	ValidateJSONBody    bool     `json:"validate_json_body,omitempty"`    // JSON 请求体无法解析时直接返回 400，不转发到上游
+
+	// 请求头限制（超过时返回 431，不转发到上游，0 表示不限制）
+	MaxRequestHeaders     int `json:"max_request_headers,omitempty"`      // 请求头数量上限（同名头的多个值分别计数）
+	MaxRequestHeaderBytes int `json:"max_request_header_bytes,omitempty"` // 所有请求头名称和值的总字节数上限
+
+	// 响应头处理
+	ForwardHeaderPrefixes []string `json:"forward_header_prefixes,omitempty"` // 失败响应中始终转发的上游头前缀（如速率限制头）
+	StripResponseHeaders  []string `json:"strip_response_headers,omitempty"`  // 返回客户端前从上游响应中移除的头（不区分大小写）
 }
 
 // Claude API 响应结构（用于解析 usage 信息）
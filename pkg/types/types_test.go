@@ -287,3 +287,46 @@ func TestClaudeResponse(t *testing.T) {
 		t.Errorf("Usage.CacheReadInputTokens mismatch: got %d, want %d", unmarshaled.Usage.CacheReadInputTokens, response.Usage.CacheReadInputTokens)
 	}
 }
+
+func TestParseStatusCodeRange(t *testing.T) {
+	tests := []struct {
+		spec      string
+		low, high int
+		wantErr   bool
+	}{
+		{spec: "204", low: 204, high: 204},
+		{spec: "200-299", low: 200, high: 299},
+		{spec: " 300 - 399 ", low: 300, high: 399},
+		{spec: "2xx", wantErr: true},
+		{spec: "299-200", wantErr: true},
+		{spec: "99", wantErr: true},
+		{spec: "500-600", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		low, high, err := ParseStatusCodeRange(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseStatusCodeRange(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && (low != tt.low || high != tt.high) {
+			t.Errorf("ParseStatusCodeRange(%q) = %d-%d, want %d-%d", tt.spec, low, high, tt.low, tt.high)
+		}
+	}
+
+	var zero StatusCodeMatcher
+	if !zero.Match(304) || zero.Match(404) {
+		t.Error("Expected default success codes to be 200-399")
+	}
+
+	matcher, err := ParseStatusCodes([]string{"200", "400-404"})
+	if err != nil {
+		t.Fatalf("ParseStatusCodes failed: %v", err)
+	}
+	if !matcher.Match(200) || !matcher.Match(404) || matcher.Match(204) || matcher.Match(405) {
+		t.Errorf("Unexpected matches for %v", matcher)
+	}
+	if _, err := ParseStatusCodes([]string{"200", "2xx"}); err == nil {
+		t.Error("Expected error for invalid status code")
+	}
+}